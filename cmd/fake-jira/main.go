@@ -0,0 +1,61 @@
+// fake-jira serves a synthetic Jira dataset with the fake Jira
+// server of `jira/jiratest`, e.g. to run a sync on realistic volumes
+// without accessing a real Jira instance:
+//
+//	go run ./cmd/fake-jira -issues 10000 -addr :8080
+//	JIRA_URL=http://localhost:8080 go run *.go reset
+//
+// With `-out <dir>`, the issues are written as Jira JSON files in the
+// directory instead of being served.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+)
+
+func main() {
+	d := jiratest.DefaultDatasetConfig()
+	issues := flag.Int("issues", d.Issues, "number of generated issues")
+	seed := flag.Int64("seed", d.Seed, "seed of the generation")
+	projects := flag.String("projects", strings.Join(d.Projects, ","), "comma-separated project keys")
+	workflow := flag.String("workflow", strings.Join(d.Workflow, ","), "comma-separated statuses of the workflow")
+	forward := flag.Float64("forward", d.ForwardProbability, "probability of a transition to the next status")
+	transitions := flag.Int("transitions", d.MaxTransitions, "maximum number of transitions per issue")
+	comments := flag.Float64("comments", d.MeanComments, "mean number of comments per issue")
+	latency := flag.Duration("latency", 0, "latency of each response")
+	maxResults := flag.Int("max-results", 50, "maximum page size")
+	addr := flag.String("addr", ":8080", "address to listen on")
+	out := flag.String("out", "", "write the issues as JSON files in this directory instead of serving them")
+	flag.Parse()
+
+	d.Issues = *issues
+	d.Seed = *seed
+	d.Projects = strings.Split(*projects, ",")
+	d.Workflow = strings.Split(*workflow, ",")
+	d.ForwardProbability = *forward
+	d.MaxTransitions = *transitions
+	d.MeanComments = *comments
+
+	s := jiratest.NewServer()
+	s.Latency = *latency
+	s.MaxResults = *maxResults
+	start := time.Now()
+	keys := s.Generate(d)
+	log.Printf("Generated %d issues in %s", len(keys), time.Since(start))
+
+	if *out != "" {
+		if err := s.WriteIssues(*out); err != nil {
+			log.Fatalf("error in `WriteIssues`: %s", err)
+		}
+		log.Printf("Wrote %d issues in %s", len(keys), *out)
+		return
+	}
+	log.Printf("Serving on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s))
+}
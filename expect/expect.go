@@ -0,0 +1,236 @@
+// Package expect records the calls received by the fakes used in
+// tests (e.g. `jiratest.FakeClient` and `storetest.FakeStore`) and
+// matches them against expectations.
+//
+// Expectations are unordered by default: a call is matched against
+// the first expectation of the method accepting its arguments, in
+// the order they were set. Expectations marked with `InOrder` must
+// in addition be met in the order they were set, relatively to the
+// other ordered expectations.
+//
+// Unexpected calls, calls out of order and, when the test
+// completes, expectations which were not met are reported as errors
+// of the test. Fakes never stop the test themselves, since they are
+// usually called from other goroutines.
+package expect
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Call is a call received by a fake.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+func (c Call) String() string {
+	args := make([]string, 0, len(c.Args))
+	for _, a := range c.Args {
+		args = append(args, fmt.Sprintf("%v", a))
+	}
+	return fmt.Sprintf("%s(%s)", c.Method, strings.Join(args, ", "))
+}
+
+// Expectation is an expected call of a fake. Fakes define their own
+// expectations embedding `*Expectation`, to add the values to return.
+type Expectation struct {
+	method  string
+	desc    string
+	match   func(args []interface{}) bool
+	ordered bool
+	min     int
+	max     int
+	calls   int
+}
+
+// New returns an expectation of a call of `method` with arguments
+// accepted by `match` (any arguments if nil). `desc` describes the
+// expected arguments in reports (expectations whose arguments are
+// set later can implement `String` instead). The expectation must
+// be called exactly once, see `Times` and `AnyTimes`.
+func New(method string, desc string, match func(args []interface{}) bool) *Expectation {
+	return &Expectation{method: method, desc: desc, match: match, min: 1, max: 1}
+}
+
+func (e *Expectation) expectation() *Expectation { return e }
+
+func (e *Expectation) String() string {
+	return fmt.Sprintf("%s(%s)", e.method, e.desc)
+}
+
+// InOrder requires the expectation to be met after the ordered
+// expectations set before it.
+func (e *Expectation) InOrder() *Expectation {
+	e.ordered = true
+	return e
+}
+
+// Times sets the number of calls expected.
+func (e *Expectation) Times(n int) *Expectation {
+	e.min, e.max = n, n
+	return e
+}
+
+// AnyTimes accepts any number of calls, including none.
+func (e *Expectation) AnyTimes() *Expectation {
+	e.min, e.max = 0, -1
+	return e
+}
+
+func (e *Expectation) met() bool {
+	return e.calls >= e.min
+}
+
+func (e *Expectation) exhausted() bool {
+	return e.max >= 0 && e.calls >= e.max
+}
+
+// Expecter is implemented by the expectations of fakes, which embed
+// `*Expectation`.
+type Expecter interface {
+	expectation() *Expectation
+}
+
+// Set holds the expectations and records the calls of a fake.
+type Set struct {
+	t            testing.TB
+	mu           sync.Mutex
+	expectations []Expecter
+	calls        []Call
+}
+
+// NewSet returns an empty set reporting to `t`. The expectations are
+// verified when the test completes.
+func NewSet(t testing.TB) *Set {
+	s := &Set{t: t}
+	t.Cleanup(s.verify)
+	return s
+}
+
+// Add adds an expectation to the set and returns it.
+func (s *Set) Add(e Expecter) Expecter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expectations = append(s.expectations, e)
+	return e
+}
+
+// Call records the call and returns the expectation it matches. If
+// it matches none, the call is reported as unexpected and nil is
+// returned: the fake should then return zero values, and an error if
+// the method returns one (see `Unexpected`).
+func (s *Set) Call(method string, args ...interface{}) Expecter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := Call{Method: method, Args: args}
+	s.calls = append(s.calls, c)
+	e := s.find(c)
+	if e == nil {
+		s.t.Errorf("unexpected call `%s`, remaining expectations:\n%s", c, s.describePending())
+		return nil
+	}
+	x := e.expectation()
+	if x.ordered {
+		for _, prev := range s.expectations {
+			p := prev.expectation()
+			if p == x {
+				break
+			}
+			if p.ordered && !p.met() {
+				s.t.Errorf("call `%s` was expected after `%s`", c, prev)
+				break
+			}
+		}
+	}
+	x.calls++
+	return e
+}
+
+// Peek returns the expectation the call would match, without
+// recording the call.
+func (s *Set) Peek(method string, args ...interface{}) Expecter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(Call{Method: method, Args: args})
+}
+
+func (s *Set) find(c Call) Expecter {
+	for _, e := range s.expectations {
+		x := e.expectation()
+		if x.method == c.Method && !x.exhausted() && (x.match == nil || x.match(c.Args)) {
+			return e
+		}
+	}
+	return nil
+}
+
+// Calls returns the calls received, of all methods if `methods` is
+// empty.
+func (s *Set) Calls(methods ...string) []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cs []Call
+	for _, c := range s.calls {
+		if len(methods) == 0 || contains(methods, c.Method) {
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+// ExpectationsWereMet returns an error describing the expectations
+// which were not met, or nil.
+func (s *Set) ExpectationsWereMet() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var missing []string
+	for _, e := range s.expectations {
+		if x := e.expectation(); !x.met() {
+			missing = append(missing, fmt.Sprintf("  - `%s` called %d time(s), expected %d", e, x.calls, x.min))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("expectations were not met:\n%s", strings.Join(missing, "\n"))
+	}
+	return nil
+}
+
+func (s *Set) verify() {
+	if err := s.ExpectationsWereMet(); err != nil {
+		s.t.Error(err)
+	}
+}
+
+// describePending describes the expectations which can still be
+// called. The caller must hold the lock.
+func (s *Set) describePending() string {
+	var ds []string
+	for _, e := range s.expectations {
+		if x := e.expectation(); !x.exhausted() {
+			ds = append(ds, fmt.Sprintf("  - `%s`", e))
+		}
+	}
+	if len(ds) == 0 {
+		return "  (none)"
+	}
+	return strings.Join(ds, "\n")
+}
+
+// Unexpected returns the error returned by fakes for unexpected
+// calls.
+func Unexpected(method string) error {
+	return fmt.Errorf("unexpected call of `%s`", method)
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
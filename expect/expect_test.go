@@ -0,0 +1,103 @@
+package expect_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/expect"
+)
+
+// recorder is a `testing.TB` recording the errors and cleanup
+// functions instead of reporting them.
+type recorder struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Error(args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprint(args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recorder) cleanup() {
+	for _, f := range r.cleanups {
+		f()
+	}
+}
+
+func matchArg(v interface{}) func(args []interface{}) bool {
+	return func(args []interface{}) bool { return args[0] == v }
+}
+
+func TestSet_unordered(t *testing.T) {
+	r := &recorder{TB: t}
+	s := expect.NewSet(r)
+	a := s.Add(expect.New("Get", "a", matchArg("a")))
+	b := s.Add(expect.New("Get", "b", matchArg("b")))
+
+	if e := s.Call("Get", "b"); e != b {
+		t.Errorf("expected the call to match `b`, got %v", e)
+	}
+	if e := s.Call("Get", "a"); e != a {
+		t.Errorf("expected the call to match `a`, got %v", e)
+	}
+	if e := s.Call("Get", "a"); e != nil {
+		t.Errorf("expected the second call to be unexpected, got %v", e)
+	}
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "unexpected call `Get(a)`") {
+		t.Errorf("expected the unexpected call to be reported, got %v", r.errors)
+	}
+	if cs := s.Calls("Get"); len(cs) != 3 || cs[0].String() != "Get(b)" {
+		t.Errorf("expected the calls to be recorded, got %v", cs)
+	}
+	r.cleanup()
+	if len(r.errors) != 1 {
+		t.Errorf("expected no unmet expectation, got %v", r.errors)
+	}
+}
+
+func TestSet_inOrder(t *testing.T) {
+	r := &recorder{TB: t}
+	s := expect.NewSet(r)
+	s.Add(expect.New("Get", "a", matchArg("a")).InOrder())
+	s.Add(expect.New("Get", "b", matchArg("b")).InOrder())
+	s.Add(expect.New("Put", "", nil))
+
+	s.Call("Put")
+	s.Call("Get", "b")
+	if len(r.errors) != 1 || !strings.Contains(r.errors[0], "`Get(b)` was expected after `Get(a)`") {
+		t.Errorf("expected the call out of order to be reported, got %v", r.errors)
+	}
+}
+
+func TestSet_times(t *testing.T) {
+	r := &recorder{TB: t}
+	s := expect.NewSet(r)
+	s.Add(expect.New("Get", "", nil).Times(2))
+	s.Add(expect.New("Put", "", nil).AnyTimes())
+	s.Add(expect.New("Delete", "", nil))
+
+	s.Call("Get")
+	for n := 0; n < 3; n++ {
+		s.Call("Put")
+	}
+	err := s.ExpectationsWereMet()
+	if err == nil || !strings.Contains(err.Error(), "`Get()` called 1 time(s), expected 2") ||
+		!strings.Contains(err.Error(), "`Delete()` called 0 time(s), expected 1") || strings.Contains(err.Error(), "Put") {
+		t.Errorf("unexpected error `%v`", err)
+	}
+
+	r.cleanup()
+	if len(r.errors) != 1 {
+		t.Errorf("expected unmet expectations to be reported at cleanup, got %v", r.errors)
+	}
+}
@@ -0,0 +1,292 @@
+// Package forecast forecasts deliveries with Monte Carlo simulations
+// of the daily throughput of the resolved issues.
+//
+// Each simulation run draws, for every day to come, the throughput
+// of a random day of the history. The results of the runs give the
+// number of items done by a date (`HowMany`), or the date by which
+// a number of items are done (`When`), at several confidence levels.
+package forecast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Kinds of forecasts.
+const (
+	KindHowMany = "how-many"
+	KindWhen    = "when"
+)
+
+// Default options.
+const (
+	DefaultHistoryDays = 90
+	DefaultRuns        = 10000
+)
+
+// maxDays bounds the days simulated by a run of `When`.
+const maxDays = 10 * 365
+
+// Confidences are the confidence levels of the forecasts, in percent.
+var Confidences = []int{50, 85, 95}
+
+// Options configures a forecast.
+type Options struct {
+	// Filter selects the issues whose throughput is simulated
+	// (projects, types and tribes) and, for `when` forecasts without
+	// a number of items, the remaining issues (also epic and fix
+	// version).
+	Filter store.IssueFilter
+
+	// HistoryDays is the number of days, before today, of the
+	// throughput history (default: 90).
+	HistoryDays int
+
+	// Runs is the number of simulation runs (default: 10000).
+	Runs int
+
+	// Seed of the random simulations (default: random).
+	Seed int64
+
+	// Now is the time of the forecast (default: now). The first
+	// simulated day is the day of `Now`.
+	Now time.Time
+}
+
+// Result is the result of a forecast.
+type Result struct {
+	Kind        string       `json:"kind"`
+	ForecastAt  time.Time    `json:"forecast_at"`
+	HistoryFrom time.Time    `json:"history_from"`
+	HistoryTo   time.Time    `json:"history_to"`
+	Runs        int          `json:"runs"`
+	Items       *int         `json:"items,omitempty"`
+	Until       *time.Time   `json:"until,omitempty"`
+	Percentiles []Percentile `json:"percentiles"`
+}
+
+// Percentile is the forecast at a confidence level: the number of
+// items done by `Result.Until` (`how-many`), or the date by which
+// `Result.Items` are done (`when`).
+type Percentile struct {
+	Confidence int        `json:"confidence"`
+	Items      *int       `json:"items,omitempty"`
+	Date       *time.Time `json:"date,omitempty"`
+}
+
+// PerformHowMany forecasts how many issues will be done by the day
+// of `until` (included), and inserts the forecast in the store.
+func PerformHowMany(s store.Store, until time.Time, o Options) (Result, error) {
+	o = o.withDefaults()
+	days := int(day(until).Sub(day(o.Now)).Hours()/24) + 1
+	if days < 1 {
+		return Result{}, fmt.Errorf("error in `PerformHowMany`: %s is in the past", until.Format("2006-01-02"))
+	}
+	r, samples, err := history(s, o)
+	if err != nil {
+		return Result{}, fmt.Errorf("error in `PerformHowMany`: %s", err)
+	}
+	u := day(until)
+	r.Kind, r.Until = KindHowMany, &u
+	r.Percentiles = HowMany(samples, days, o.Runs, rand.New(rand.NewSource(o.Seed)))
+	if err := insert(s, o, r); err != nil {
+		return Result{}, fmt.Errorf("error in `PerformHowMany`: %s", err)
+	}
+	return r, nil
+}
+
+// PerformWhen forecasts when `items` issues will be done, and
+// inserts the forecast in the store. If `items` is zero, the
+// unresolved issues selected by `o.Filter` are forecast (e.g. the
+// remaining issues of an epic).
+func PerformWhen(s store.Store, items int, o Options) (Result, error) {
+	o = o.withDefaults()
+	if items == 0 {
+		n, err := s.CountUnresolvedIssues(o.Filter)
+		if err != nil {
+			return Result{}, fmt.Errorf("error in `PerformWhen`: %s", err)
+		}
+		items = n
+	}
+	if items < 1 {
+		return Result{}, fmt.Errorf("error in `PerformWhen`: no items to forecast")
+	}
+	r, samples, err := history(s, o)
+	if err != nil {
+		return Result{}, fmt.Errorf("error in `PerformWhen`: %s", err)
+	}
+	ds, err := When(samples, items, o.Runs, rand.New(rand.NewSource(o.Seed)))
+	if err != nil {
+		return Result{}, fmt.Errorf("error in `PerformWhen`: %s", err)
+	}
+	r.Kind, r.Items = KindWhen, &items
+	for n, c := range Confidences {
+		d := day(o.Now).AddDate(0, 0, ds[n]-1)
+		r.Percentiles = append(r.Percentiles, Percentile{Confidence: c, Date: &d})
+	}
+	if err := insert(s, o, r); err != nil {
+		return Result{}, fmt.Errorf("error in `PerformWhen`: %s", err)
+	}
+	return r, nil
+}
+
+// HowMany simulates the throughput of `days` days and returns the
+// number of items done at each confidence level: with a confidence
+// of c percent, at least the (100 - c)th percentile of the simulated
+// totals are done.
+func HowMany(samples []int, days int, runs int, rng *rand.Rand) []Percentile {
+	totals := make([]int, runs)
+	for n := range totals {
+		for d := 0; d < days; d++ {
+			totals[n] += samples[rng.Intn(len(samples))]
+		}
+	}
+	sort.Ints(totals)
+	ps := make([]Percentile, len(Confidences))
+	for n, c := range Confidences {
+		items := percentile(totals, 100-c)
+		ps[n] = Percentile{Confidence: c, Items: &items}
+	}
+	return ps
+}
+
+// When simulates the throughput until `items` items are done and
+// returns, for each confidence level of `Confidences`, the number of
+// days needed (the cth percentile of the simulated durations, the
+// first day counting as 1). It fails if the history has no
+// throughput.
+func When(samples []int, items int, runs int, rng *rand.Rand) ([]int, error) {
+	total := 0
+	for _, t := range samples {
+		total += t
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no throughput in the history")
+	}
+	durations := make([]int, runs)
+	for n := range durations {
+		done, d := 0, 0
+		for done < items && d < maxDays {
+			done += samples[rng.Intn(len(samples))]
+			d++
+		}
+		durations[n] = d
+	}
+	sort.Ints(durations)
+	ds := make([]int, len(Confidences))
+	for n, c := range Confidences {
+		ds[n] = percentile(durations, c)
+	}
+	return ds, nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.HistoryDays == 0 {
+		o.HistoryDays = DefaultHistoryDays
+	}
+	if o.Runs == 0 {
+		o.Runs = DefaultRuns
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	return o
+}
+
+// history reads the daily throughput of the `o.HistoryDays` days
+// before today from the store, for the projects, types and tribes
+// of the filter. Days without resolved issues count as days
+// without throughput, and issues are counted once, whatever their
+// number of tribes.
+func history(s store.Store, o Options) (Result, []int, error) {
+	to := day(o.Now).AddDate(0, 0, -1)
+	from := to.AddDate(0, 0, 1-o.HistoryDays)
+	f := store.IssueFilter{Projects: o.Filter.Projects, Types: o.Filter.Types, Tribes: o.Filter.Tribes}
+	ts, err := s.GetThroughputDaily(from, to, f)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	samples := make([]int, o.HistoryDays)
+	for _, t := range ts {
+		if n := int(t.Day.Sub(from).Hours() / 24); n >= 0 && n < len(samples) {
+			samples[n] += t.Throughput
+		}
+	}
+	r := Result{ForecastAt: o.Now, HistoryFrom: from, HistoryTo: to, Runs: o.Runs}
+	return r, samples, nil
+}
+
+// insert inserts a row per confidence level of the result in the
+// store.
+func insert(s store.Store, o Options, r Result) error {
+	fs := make([]store.Forecast, len(r.Percentiles))
+	for n, p := range r.Percentiles {
+		fs[n] = store.Forecast{
+			ForecastAt:    r.ForecastAt,
+			Kind:          r.Kind,
+			Filter:        o.Filter,
+			Items:         r.Items,
+			Until:         r.Until,
+			Confidence:    p.Confidence,
+			ForecastItems: p.Items,
+			ForecastDate:  p.Date,
+		}
+	}
+	return s.InsertForecasts(fs)
+}
+
+// percentile returns the `p`th percentile of the sorted values with
+// the nearest-rank method.
+func percentile(sorted []int, p int) int {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// day returns the day of `t` at midnight, in UTC.
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// WriteJSON writes the result as JSON.
+func (r Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the result as a human readable table.
+func (r Result) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	history := fmt.Sprintf("%d runs on the throughput from %s to %s",
+		r.Runs, r.HistoryFrom.Format("2006-01-02"), r.HistoryTo.Format("2006-01-02"))
+	switch r.Kind {
+	case KindHowMany:
+		fmt.Fprintf(tw, "Items done by %s (%s)\n\n", r.Until.Format("2006-01-02"), history)
+		fmt.Fprintf(tw, "CONFIDENCE\tITEMS\t\n")
+		for _, p := range r.Percentiles {
+			fmt.Fprintf(tw, "%d%%\t%d\t\n", p.Confidence, *p.Items)
+		}
+	case KindWhen:
+		fmt.Fprintf(tw, "Completion of %d items (%s)\n\n", *r.Items, history)
+		fmt.Fprintf(tw, "CONFIDENCE\tDATE\t\n")
+		for _, p := range r.Percentiles {
+			fmt.Fprintf(tw, "%d%%\t%s\t\n", p.Confidence, p.Date.Format("2006-01-02"))
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,230 @@
+package forecast_test
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/forecast"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+var now = time.Date(2020, 3, 10, 15, 0, 0, 0, time.UTC)
+
+func str(s string) *string { return &s }
+
+// throughput returns the throughput of the `n`th day before `now`.
+func throughput(n int, t int) store.ThroughputCount {
+	return store.ThroughputCount{Day: time.Date(2020, 3, 10-n, 0, 0, 0, 0, time.UTC), Throughput: t}
+}
+
+func TestHowMany(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ps := forecast.HowMany([]int{2}, 5, 100, rng)
+	if len(ps) != 3 {
+		t.Fatalf("expected 3 percentiles, got %+v", ps)
+	}
+	for _, p := range ps {
+		if p.Items == nil || *p.Items != 10 || p.Date != nil {
+			t.Errorf("expected 10 items at %d%%, got %+v", p.Confidence, p)
+		}
+	}
+
+	// Higher confidences forecast fewer items.
+	ps = forecast.HowMany([]int{0, 1, 2, 3, 4}, 10, 1000, rng)
+	if !(*ps[0].Items >= *ps[1].Items && *ps[1].Items >= *ps[2].Items && *ps[0].Items > *ps[2].Items) {
+		t.Errorf("expected decreasing items, got %d, %d, %d", *ps[0].Items, *ps[1].Items, *ps[2].Items)
+	}
+}
+
+func TestWhen(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ds, err := forecast.When([]int{1}, 5, 100, rng)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for n, d := range ds {
+		if d != 5 {
+			t.Errorf("expected 5 days at %d%%, got %d", forecast.Confidences[n], d)
+		}
+	}
+
+	// Higher confidences forecast more days.
+	ds, _ = forecast.When([]int{0, 1, 2, 3, 4}, 20, 1000, rng)
+	if !(ds[0] <= ds[1] && ds[1] <= ds[2] && ds[0] < ds[2]) {
+		t.Errorf("expected increasing days, got %v", ds)
+	}
+
+	if _, err := forecast.When([]int{0, 0}, 5, 100, rng); err == nil {
+		t.Errorf("expected an error without throughput")
+	}
+}
+
+func TestPerformHowMany(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectGetThroughputDaily().WillReturn([]store.ThroughputCount{throughput(1, 2), throughput(2, 2)})
+	insert := s.ExpectInsertForecasts()
+
+	filter := store.IssueFilter{Projects: []string{"PJ"}, Tribes: []string{"Data"}}
+	r, err := forecast.PerformHowMany(s, time.Date(2020, 3, 12, 0, 0, 0, 0, time.UTC),
+		forecast.Options{Filter: filter, HistoryDays: 2, Runs: 100, Seed: 1, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !r.HistoryFrom.Equal(time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC)) || !r.HistoryTo.Equal(time.Date(2020, 3, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected history from %s to %s", r.HistoryFrom, r.HistoryTo)
+	}
+	// 3 days (today included) of 2 items
+	for _, p := range r.Percentiles {
+		if *p.Items != 6 {
+			t.Errorf("expected 6 items at %d%%, got %d", p.Confidence, *p.Items)
+		}
+	}
+
+	if len(insert.Forecasts) != 3 {
+		t.Fatalf("expected 3 forecasts inserted, got %+v", insert.Forecasts)
+	}
+	f := insert.Forecasts[1]
+	if f.Kind != forecast.KindHowMany || f.Confidence != 85 || *f.ForecastItems != 6 || f.Until.Day() != 12 || f.Items != nil || !f.ForecastAt.Equal(now) {
+		t.Errorf("unexpected forecast %+v", f)
+	}
+
+	if _, err := forecast.PerformHowMany(s, now.AddDate(0, 0, -1), forecast.Options{Now: now}); err == nil {
+		t.Errorf("expected an error for a date in the past")
+	}
+}
+
+func TestPerformWhen(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectCountUnresolvedIssues().WillReturn(4)
+	s.ExpectGetThroughputDaily().WillReturn([]store.ThroughputCount{throughput(1, 1), throughput(2, 1)})
+	insert := s.ExpectInsertForecasts()
+
+	r, err := forecast.PerformWhen(s, 0, forecast.Options{
+		Filter: store.IssueFilter{Epic: "PJ-1"}, HistoryDays: 2, Runs: 100, Seed: 1, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 4 days of 1 item, today included
+	if *r.Items != 4 {
+		t.Errorf("expected the 4 remaining items, got %d", *r.Items)
+	}
+	for _, p := range r.Percentiles {
+		if !p.Date.Equal(time.Date(2020, 3, 13, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected completion on 2020-03-13 at %d%%, got %s", p.Confidence, p.Date)
+		}
+	}
+	if len(insert.Forecasts) != 3 || insert.Forecasts[0].Filter.Epic != "PJ-1" || *insert.Forecasts[0].Items != 4 {
+		t.Errorf("unexpected forecasts %+v", insert.Forecasts)
+	}
+
+	var table bytes.Buffer
+	if err := r.WriteTable(&table); err != nil {
+		t.Fatalf("unexpected error in `WriteTable`: %s", err)
+	}
+	for _, s := range []string{"Completion of 4 items", "85%", "2020-03-13"} {
+		if !strings.Contains(table.String(), s) {
+			t.Errorf("expected `%s` in the table:\n%s", s, table.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := r.WriteJSON(&js); err != nil {
+		t.Fatalf("unexpected error in `WriteJSON`: %s", err)
+	}
+	if !strings.Contains(js.String(), `"confidence": 95`) {
+		t.Errorf("unexpected JSON:\n%s", js.String())
+	}
+}
+
+// issue returns the records of a story of the project, created 10
+// days before `now` and resolved `resolved` days before `now` (not
+// resolved if negative).
+func issue(k string, project string, epics []string, tribes []string, resolved int) store.IssueRecords {
+	is := store.IssueState{
+		Key:       k,
+		Project:   str(project),
+		Type:      str("Story"),
+		Status:    str("Open"),
+		CreatedAt: now.AddDate(0, 0, -10),
+		UpdatedAt: now.AddDate(0, 0, -1),
+		Epics:     epics,
+		Tribes:    tribes,
+	}
+	if resolved >= 0 {
+		at := now.AddDate(0, 0, -resolved)
+		is.ResolvedAt = &at
+	}
+	return store.IssueRecords{Key: k, State: is}
+}
+
+func TestPerformWhen_epicWithoutFilter(t *testing.T) {
+	s := storetest.NewMemoryStore()
+	rs := []store.IssueRecords{
+		issue("PJ-1", "PJ", []string{"PJ-0"}, nil, -1),
+		issue("PJ-2", "PJ", []string{"PJ-0"}, []string{"Data"}, -1),
+		issue("OT-1", "OT", []string{"PJ-0"}, nil, -1),
+		issue("PJ-3", "PJ", nil, nil, -1),
+		// 1 item done on each of the last 2 days
+		issue("PJ-4", "PJ", nil, nil, 1),
+		issue("PJ-5", "PJ", nil, nil, 2),
+	}
+	if err := s.ReplaceIssues(rs); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+
+	// No project, type nor tribe filter: all the issues of the epic
+	// are counted
+	r, err := forecast.PerformWhen(s, 0, forecast.Options{
+		Filter: store.IssueFilter{Epic: "PJ-0"}, HistoryDays: 2, Runs: 100, Seed: 1, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if *r.Items != 3 {
+		t.Errorf("expected the 3 remaining items of the epic, got %d", *r.Items)
+	}
+	for _, p := range r.Percentiles {
+		if !p.Date.Equal(time.Date(2020, 3, 12, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("expected completion on 2020-03-12 at %d%%, got %s", p.Confidence, p.Date)
+		}
+	}
+}
+
+func TestPerformHowMany_severalTribes(t *testing.T) {
+	s := storetest.NewMemoryStore()
+	rs := []store.IssueRecords{
+		// Counted once, not once per tribe
+		issue("PJ-1", "PJ", nil, []string{"Data", "Growth"}, 1),
+		issue("PJ-2", "PJ", nil, []string{"Data"}, 2),
+		issue("PJ-3", "PJ", nil, []string{"Core"}, 2),
+		issue("OT-1", "OT", nil, []string{"Data"}, 1),
+	}
+	if err := s.ReplaceIssues(rs); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+
+	filter := store.IssueFilter{Projects: []string{"PJ"}, Tribes: []string{"Data", "Growth"}}
+	r, err := forecast.PerformHowMany(s, time.Date(2020, 3, 12, 0, 0, 0, 0, time.UTC),
+		forecast.Options{Filter: filter, HistoryDays: 2, Runs: 100, Seed: 1, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 3 days (today included) of 1 item
+	for _, p := range r.Percentiles {
+		if *p.Items != 3 {
+			t.Errorf("expected 3 items at %d%%, got %d", p.Confidence, *p.Items)
+		}
+	}
+}
+
+func TestPerformWhen_noThroughput(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectGetThroughputDaily()
+
+	if _, err := forecast.PerformWhen(s, 3, forecast.Options{Now: now}); err == nil {
+		t.Errorf("expected an error without throughput")
+	}
+}
@@ -1,12 +1,12 @@
-module kaizenizer_source_jira
+module github.com/rchampourlier/kaizenizer-source-jira
 
 go 1.14
 
 require (
-	github.com/Jeffail/tunny v0.0.0-20190930221602-f13eb662a36a
 	github.com/andygrunwald/go-jira v1.12.0
 	github.com/lib/pq v1.7.0
+	github.com/prometheus/client_golang v1.7.0
 	github.com/rchampourlier/golib v0.0.0-20200419184305-761a83ead1dd
-	github.com/rchampourlier/kaizenizer-source-jira v0.0.0-20181108221552-5f61a3612c84
+	github.com/sirupsen/logrus v1.6.0
 	gopkg.in/DATA-DOG/go-sqlmock.v1 v1.3.0
 )
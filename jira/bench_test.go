@@ -0,0 +1,98 @@
+package jira_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+	"github.com/sirupsen/logrus"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// benchmarkIssues is the number of issues of the benchmark datasets.
+const benchmarkIssues = 200
+
+// benchmarkDataset serves a synthetic dataset (see
+// `jiratest.Generate`) and returns a client and mapper for it, and
+// the keys of the issues.
+func benchmarkDataset(b *testing.B) (*client.APIClient, *mapping.Mapper, []string, func()) {
+	b.Helper()
+	logging.Log().Logger.SetLevel(logrus.WarnLevel)
+	js := jiratest.NewServer()
+	js.MaxResults = 100
+	cfg := jiratest.DefaultDatasetConfig()
+	cfg.Issues = benchmarkIssues
+	keys := js.Generate(cfg)
+	srv := httptest.NewServer(js)
+	c, err := client.NewAPIClientForURL(srv.URL)
+	if err != nil {
+		b.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+	m := newMapper(b, c)
+	return c, m, keys, srv.Close
+}
+
+// BenchmarkFetch measures the fetch of an issue (request to the fake
+// server and unmarshalling by `go-jira`).
+func BenchmarkFetch(b *testing.B) {
+	c, _, keys, done := benchmarkDataset(b)
+	defer done()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := c.GetIssue(keys[n%len(keys)]); err != nil {
+			b.Fatalf("unexpected error in `GetIssue`: %s", err)
+		}
+	}
+}
+
+// BenchmarkMap measures the mapping of an issue to its records.
+func BenchmarkMap(b *testing.B) {
+	c, m, keys, done := benchmarkDataset(b)
+	defer done()
+	is := make([]*extJira.Issue, 0, len(keys))
+	for _, k := range keys {
+		i, err := c.GetIssue(k)
+		if err != nil {
+			b.Fatalf("unexpected error in `GetIssue`: %s", err)
+		}
+		is = append(is, i)
+	}
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := is[n%len(is)]
+		ies, err := m.IssueEventsFromIssue(i)
+		if err != nil {
+			b.Fatalf("unexpected error in `IssueEventsFromIssue`: %s", err)
+		}
+		m.IssueStateFromIssue(i)
+		m.IssueStatusPeriodsFromEvents(i, ies)
+		m.UsersFromIssue(i)
+	}
+}
+
+// BenchmarkPerformSync measures a whole sync of the dataset (search,
+// fetch, map and store) in an in-memory store. `issues/s` is the
+// end-to-end throughput.
+func BenchmarkPerformSync(b *testing.B) {
+	c, m, _, done := benchmarkDataset(b)
+	defer done()
+	cfg := jira.DefaultSyncConfig()
+	b.ResetTimer()
+	start := time.Now()
+	for n := 0; n < b.N; n++ {
+		s := storetest.NewMemoryStore()
+		summary, err := jira.PerformSync(context.Background(), c, s, cfg, m)
+		if err != nil || summary.Stored != benchmarkIssues {
+			b.Fatalf("unexpected sync result `%+v` (%v)", summary, err)
+		}
+	}
+	b.ReportMetric(float64(b.N*benchmarkIssues)/time.Since(start).Seconds(), "issues/s")
+}
@@ -1,17 +1,47 @@
 package jira
 
 import (
+	"time"
+
 	"github.com/andygrunwald/go-jira"
 )
 
 // Client is the interface for Jira clients used by the
 // application.
 //
-// It currently has two implementations:
+// Its main implementations are:
 //
 //   - `jira/client.APIClient`, which wraps `go-jira`'s client
-//   - `jira/client.MockClient`, a mock for tests
+//   - `jira/jiratest.FakeClient`, a programmable fake for tests
+//
+// `SearchIssues` sends the keys of the issues matching the query
+// through `issueKeys` and closes it when done, returning the error
+// which stopped the search, if any. If `total` is not nil, it is
+// called with the total number of matching issues as soon as it is
+// known.
+//
+// `CountIssues` returns the number of issues matching the query, and
+// `SearchIssueUpdates` the update times of the matching issues,
+// indexed by issue key.
 type Client interface {
-	SearchIssues(query string, issueKeys chan string)
-	GetIssue(issueKey string) *jira.Issue
+	SearchIssues(query string, issueKeys chan string, total func(int)) error
+	CountIssues(query string) (int, error)
+	SearchIssueUpdates(query string) (map[string]time.Time, error)
+	GetIssue(issueKey string) (*jira.Issue, error)
+	GetStatuses() ([]jira.Status, error)
+	GetFieldSchemas() (map[string]FieldSchema, error)
+}
+
+// FieldSchema describes the type of an issue field, as returned
+// in the `schema` attribute of fields by Jira API (e.g. with
+// `expand=schema` or on the `/field` endpoint).
+//
+// `Type` is the type of the field's value (e.g. `option`,
+// `user`, `number`, `array`). For `array` fields, `Items` is
+// the type of the array's items.
+type FieldSchema struct {
+	Type   string `json:"type"`
+	Items  string `json:"items,omitempty"`
+	Custom string `json:"custom,omitempty"`
+	System string `json:"system,omitempty"`
 }
@@ -1,12 +1,19 @@
 package client
 
 import (
+	"encoding/json"
 	"fmt"
-	"log"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"sort"
 	"time"
 
 	"github.com/andygrunwald/go-jira"
+
+	source "github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
 )
 
 // APIClient represents an interface to Jira API. It embeds
@@ -15,41 +22,117 @@ type APIClient struct {
 	*jira.Client
 }
 
+// jiraBaseURL is the default URL of the Jira instance, overridden by
+// the `JIRA_URL` environment variable.
+const jiraBaseURL = "https://jobteaser.atlassian.net"
+
 // NewAPIClient returns an usable `jira.client` usable to access Jira
 // API. It embeds a `jira.APIClient`.
+//
+// Requests are instrumented (see `metrics.APIRequestDuration`) and
+// rate limited requests are retried after the wait specified by
+// Jira. Requests are throttled by a rate limiter shared by all the
+// client's users, adapting to Jira's rate limiting (see
+// `rateLimiter`), configured by the `JIRA_RATE_LIMIT` and
+// `JIRA_RATE_BURST` environment variables.
+//
+// The Jira instance is specified by `JIRA_URL` (default:
+// `jiraBaseURL`). If `JIRA_RECORD_DIR` is set, the responses are
+// recorded as fixtures in this directory, with credentials scrubbed,
+// so they can be replayed offline with `NewReplayAPIClient`.
 func NewAPIClient() *APIClient {
+	l, err := rateLimiterFromEnv()
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `NewAPIClient`")
+	}
+	username := os.Getenv("JIRA_USERNAME")
+	password := os.Getenv("JIRA_PASSWORD")
+	var t http.RoundTripper = newInstrumentedTransport(nil, l)
+	if dir := os.Getenv("JIRA_RECORD_DIR"); dir != "" {
+		if t, err = newRecordingTransport(t, dir, username, password); err != nil {
+			logging.Log().WithError(err).Fatal("error in `NewAPIClient`")
+		}
+		logging.Log().WithField("dir", dir).Info("Recording Jira API responses")
+	}
 	tp := jira.BasicAuthTransport{
-		Username: os.Getenv("JIRA_USERNAME"),
-		Password: os.Getenv("JIRA_PASSWORD"),
+		Username:  username,
+		Password:  password,
+		Transport: t,
+	}
+	baseURL := os.Getenv("JIRA_URL")
+	if baseURL == "" {
+		baseURL = jiraBaseURL
 	}
-	c, err := jira.NewClient(tp.Client(), "https://jobteaser.atlassian.net")
+	c, err := jira.NewClient(tp.Client(), baseURL)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `NewAPIClient`: %s", err))
+		logging.Log().WithError(err).Fatal("error in `NewAPIClient`")
 	}
 	return &APIClient{c}
 }
 
+// NewAPIClientForURL returns an `APIClient` for the Jira instance at
+// `baseURL`, without credentials nor rate limiting, e.g. for a fake
+// Jira server in tests (see `jira/jiratest`). Rate limited requests
+// are retried as with `NewAPIClient`.
+func NewAPIClientForURL(baseURL string) (*APIClient, error) {
+	hc := &http.Client{Transport: newInstrumentedTransport(nil, nil)}
+	c, err := jira.NewClient(hc, baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error in `NewAPIClientForURL`: %s", err)
+	}
+	return &APIClient{c}, nil
+}
+
+// NewReplayAPIClient returns an `APIClient` responding with the
+// fixtures recorded in `dir` (see `NewAPIClient` and
+// `JIRA_RECORD_DIR`) instead of accessing the network. Requests
+// without fixture fail.
+//
+// Since the responses go through `go-jira` as real ones do, it's
+// used to test the whole sync (search pagination, unmarshalling,
+// mapping) against realistic payloads.
+func NewReplayAPIClient(dir string) (*APIClient, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("error in `NewReplayAPIClient`: %s", err)
+	}
+	hc := &http.Client{Transport: &replayTransport{dir: dir}}
+	c, err := jira.NewClient(hc, jiraBaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error in `NewReplayAPIClient`: %s", err)
+	}
+	return &APIClient{c}, nil
+}
+
 // SearchIssues perform a search on Jira API using the specified
 // JQL `query` and sends the keys of the issues in the response
-// through the `issueKeys` channel.
-func (c *APIClient) SearchIssues(query string, issueKeys chan string) {
+// through the `issueKeys` channel, which is closed when the search
+// ends or fails. The total number of issues returned by Jira with
+// the first page is passed to `total`.
+func (c *APIClient) SearchIssues(query string, issueKeys chan string, total func(int)) error {
+	defer close(issueKeys)
 	jso := jira.SearchOptions{
 		MaxResults: 100,
 		StartAt:    0,
 	}
 	for {
+		start := time.Now()
 		pIssues, res, err := c.Issue.Search(query, &jso)
 		if err != nil {
-			// TODO: instead of crashing, should handle the error and retry
-			log.Fatalln(fmt.Errorf("error in `searchIssues`: %s", err))
+			return fmt.Errorf("error in `SearchIssues`: %s", err)
+		}
+		logging.Phase(logging.PhaseSearch).WithFields(logging.Since(start)).WithFields(logging.Fields{
+			"start_at":    res.StartAt,
+			"total":       res.Total,
+			"max_results": res.MaxResults,
+		}).Debug("Searched issues")
+		if jso.StartAt == 0 && total != nil {
+			total(res.Total)
 		}
-		log.Printf("Search: StartAt=%d Total=%d MaxResults=%d\n", res.StartAt, res.Total, res.MaxResults)
 		jso.MaxResults = res.MaxResults
 		jso.StartAt += res.MaxResults
 		if len(pIssues) == 0 {
-			log.Printf("Search: done\n")
-			close(issueKeys)
-			break
+			logging.Phase(logging.PhaseSearch).WithField("total", res.Total).Info("Search done")
+			return nil
 		}
 		for _, pi := range pIssues {
 			issueKeys <- pi.Key
@@ -57,26 +140,247 @@ func (c *APIClient) SearchIssues(query string, issueKeys chan string) {
 	}
 }
 
+// CountIssues returns the number of issues matching the JQL
+// `query`, as returned by a single-result search.
+func (c *APIClient) CountIssues(query string) (int, error) {
+	start := time.Now()
+	_, res, err := c.Issue.Search(query, &jira.SearchOptions{
+		MaxResults: 1,
+		Fields:     []string{"key"},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error in `CountIssues`: %s", err)
+	}
+	logging.Phase(logging.PhaseSearch).WithFields(logging.Since(start)).
+		WithField("total", res.Total).
+		Debug("Counted issues")
+	return res.Total, nil
+}
+
+// SearchIssueUpdates performs a search on Jira API using the JQL
+// `query` and returns the update time of the matching issues,
+// indexed by issue key. Only the `updated` field is fetched.
+func (c *APIClient) SearchIssueUpdates(query string) (map[string]time.Time, error) {
+	jso := jira.SearchOptions{
+		MaxResults: 100,
+		StartAt:    0,
+		Fields:     []string{"updated"},
+	}
+	us := make(map[string]time.Time)
+	for {
+		start := time.Now()
+		pIssues, res, err := c.Issue.Search(query, &jso)
+		if err != nil {
+			return nil, fmt.Errorf("error in `SearchIssueUpdates`: %s", err)
+		}
+		logging.Phase(logging.PhaseSearch).WithFields(logging.Since(start)).WithFields(logging.Fields{
+			"start_at":    res.StartAt,
+			"total":       res.Total,
+			"max_results": res.MaxResults,
+		}).Debug("Searched issue updates")
+		if len(pIssues) == 0 {
+			return us, nil
+		}
+		for _, pi := range pIssues {
+			if pi.Fields != nil {
+				us[pi.Key] = time.Time(pi.Fields.Updated)
+			}
+		}
+		jso.MaxResults = res.MaxResults
+		jso.StartAt += res.MaxResults
+	}
+}
+
 // GetIssue fetches the issue specified by the key from the Jira
 // API using `go-jira` and returns a `jira.Issue`.
-func (c *APIClient) GetIssue(issueKey string) *jira.Issue {
-	i, r, err := c.Issue.Get(issueKey, &jira.GetQueryOptions{
-		Expand:       "names,schema,changelog",
-		FieldsByKeys: true,
+//
+// Jira only embeds the first page of the changelog and comments of
+// an issue. If they are truncated, the other pages are fetched (see
+// `getChangelog` and `getCommentPages`).
+func (c *APIClient) GetIssue(issueKey string) (*jira.Issue, error) {
+	start := time.Now()
+	u := fmt.Sprintf("rest/api/2/issue/%s?expand=%s&fieldsByKeys=true",
+		url.PathEscape(issueKey), url.QueryEscape("names,schema,changelog"))
+	var i jira.Issue
+	var totals issueTotals
+	if err := c.get(u, &i, &totals); err != nil {
+		return nil, fmt.Errorf("error in `GetIssue`: %s", err)
+	}
+	if i.Fields == nil {
+		return nil, fmt.Errorf("error in `GetIssue`: issue `%s` has no fields", issueKey)
+	}
+	if i.Changelog != nil && totals.Changelog.Total > len(i.Changelog.Histories) {
+		if err := c.getChangelog(&i, totals.Changelog.Total); err != nil {
+			return nil, fmt.Errorf("error in `GetIssue`: %s", err)
+		}
+	}
+	if i.Fields.Comments != nil && totals.Fields.Comment.Total > len(i.Fields.Comments.Comments) {
+		if err := c.getCommentPages(&i, totals.Fields.Comment.Total); err != nil {
+			return nil, fmt.Errorf("error in `GetIssue`: %s", err)
+		}
+	}
+	logging.Issue(issueKey, logging.PhaseFetch).WithFields(logging.Since(start)).
+		WithField("updated_at", time.Time(i.Fields.Updated)).
+		Debug("Fetched issue")
+	return &i, nil
+}
+
+// issueTotals are the totals of the changelog and comments of an
+// issue, which `go-jira` doesn't decode.
+type issueTotals struct {
+	Fields struct {
+		Comment struct {
+			Total int `json:"total"`
+		} `json:"comment"`
+	} `json:"fields"`
+	Changelog struct {
+		Total int `json:"total"`
+	} `json:"changelog"`
+}
+
+// getChangelog fetches the whole changelog of the issue
+// (`/issue/{key}/changelog` endpoint) and merges it with the
+// embedded histories. The endpoint doesn't return the histories in
+// the same order as the embedded ones, and pages may overlap if the
+// issue changes in between, so the histories are deduplicated by ID
+// and sorted by time descending, as embedded in the issue.
+func (c *APIClient) getChangelog(i *jira.Issue, total int) error {
+	seen := make(map[string]bool)
+	var hs []jira.ChangelogHistory
+	add := func(h jira.ChangelogHistory) {
+		if !seen[h.Id] {
+			seen[h.Id] = true
+			hs = append(hs, h)
+		}
+	}
+	for _, h := range i.Changelog.Histories {
+		add(h)
+	}
+	for start := 0; start < total; {
+		var page struct {
+			Values []jira.ChangelogHistory `json:"values"`
+			IsLast bool                    `json:"isLast"`
+		}
+		u := fmt.Sprintf("rest/api/2/issue/%s/changelog?startAt=%d&maxResults=100", url.PathEscape(i.Key), start)
+		if err := c.get(u, &page); err != nil {
+			return err
+		}
+		for _, h := range page.Values {
+			add(h)
+		}
+		start += len(page.Values)
+		if len(page.Values) == 0 || page.IsLast {
+			break
+		}
+	}
+
+	created := make(map[string]time.Time, len(hs))
+	for _, h := range hs {
+		t, err := time.Parse(jiraTimeLayout, h.Created)
+		if err != nil {
+			return fmt.Errorf("history `%s` has an invalid creation time `%s`", h.Id, h.Created)
+		}
+		created[h.Id] = t
+	}
+	sort.SliceStable(hs, func(a, b int) bool {
+		return created[hs[a].Id].After(created[hs[b].Id])
 	})
+	i.Changelog.Histories = hs
+	return nil
+}
+
+// getCommentPages appends the comments of the pages following the
+// embedded ones (`/issue/{key}/comment` endpoint).
+func (c *APIClient) getCommentPages(i *jira.Issue, total int) error {
+	for len(i.Fields.Comments.Comments) < total {
+		var page struct {
+			Comments []*jira.Comment `json:"comments"`
+		}
+		u := fmt.Sprintf("rest/api/2/issue/%s/comment?startAt=%d&maxResults=100", url.PathEscape(i.Key), len(i.Fields.Comments.Comments))
+		if err := c.get(u, &page); err != nil {
+			return err
+		}
+		if len(page.Comments) == 0 {
+			break
+		}
+		i.Fields.Comments.Comments = append(i.Fields.Comments.Comments, page.Comments...)
+	}
+	return nil
+}
+
+// jiraTimeLayout is the layout of times in Jira API responses.
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// get performs a GET request on the Jira API endpoint `u` and
+// decodes the JSON response in each of `vs`.
+func (c *APIClient) get(u string, vs ...interface{}) error {
+	req, err := c.NewRequest("GET", u, nil)
 	if err != nil {
-		// TODO: instead of crashing, should handle the error and retry
-		log.Fatalln(fmt.Errorf("error in `GetIssue` for `%s`: %s -- response: %v", issueKey, err, r))
+		return err
 	}
-	log.Printf("Fetched issue %s (updated: %s)\n", issueKey, time.Time(i.Fields.Updated))
-	return i
+	res, err := c.Do(req, nil)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		if res != nil {
+			return fmt.Errorf("HTTP %d: %s", res.StatusCode, jira.NewJiraError(res, err))
+		}
+		return err
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if err := json.Unmarshal(b, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetStatuses fetches all workflow statuses from the Jira API
+// (`/status` endpoint).
+func (c *APIClient) GetStatuses() ([]jira.Status, error) {
+	ss, _, err := c.Status.GetAllStatuses()
+	if err != nil {
+		return nil, fmt.Errorf("error in `GetStatuses`: %s", err)
+	}
+	logging.Log().WithField("count", len(ss)).Info("Fetched statuses")
+	return ss, nil
+}
+
+// GetFieldSchemas fetches the schemas of all issue fields from the
+// Jira API (`/field` endpoint) and returns them indexed by field ID.
+//
+// `go-jira`'s `jira.Field` doesn't decode the whole schema (e.g. the
+// type of array items), so the response is decoded here.
+func (c *APIClient) GetFieldSchemas() (map[string]source.FieldSchema, error) {
+	req, err := c.NewRequest("GET", "rest/api/2/field", nil)
+	if err != nil {
+		return nil, fmt.Errorf("error in `GetFieldSchemas`: %s", err)
+	}
+	var fields []struct {
+		ID     string             `json:"id"`
+		Schema source.FieldSchema `json:"schema"`
+	}
+	if _, err := c.Do(req, &fields); err != nil {
+		return nil, fmt.Errorf("error in `GetFieldSchemas`: %s", err)
+	}
+	schemas := make(map[string]source.FieldSchema)
+	for _, f := range fields {
+		schemas[f.ID] = f.Schema
+	}
+	logging.Log().WithField("count", len(schemas)).Info("Fetched field schemas")
+	return schemas, nil
 }
 
 // ExploreRawIssue prints the raw data fetched from Jira.
 // This can be used to get the structure of an issue to
 // implement new features.
 func (c *APIClient) ExploreRawIssue(issueKey string) {
-	i := NewAPIClient().GetIssue(issueKey)
+	i := c.mustGetIssue(issueKey)
 	fmt.Printf("issue:\n")
 	fmt.Println(i)
 	fmt.Println("---")
@@ -100,9 +404,18 @@ func (c *APIClient) ExploreRawIssue(issueKey string) {
 // retrieve the custom fields IDs by fetching an issue with
 // identifiable values for these fields.
 func (c *APIClient) ExploreCustomFields(issueKey string) {
-	i := NewAPIClient().GetIssue(issueKey)
+	i := c.mustGetIssue(issueKey)
 	customFields := i.Fields.Unknowns
 	for n, v := range customFields {
 		fmt.Printf("%s -> %s\n", n, v)
 	}
 }
+
+// mustGetIssue fetches the specified issue, exiting if it fails.
+func (c *APIClient) mustGetIssue(issueKey string) *jira.Issue {
+	i, err := c.GetIssue(issueKey)
+	if err != nil {
+		logging.Issue(issueKey, logging.PhaseFetch).WithError(err).Fatal("error in `mustGetIssue`")
+	}
+	return i
+}
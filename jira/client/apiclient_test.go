@@ -0,0 +1,73 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// changelogServer serves the issue `PJ-1` with the `embedded`
+// histories (ID and creation time) and a total of `total`, and the
+// `all` histories, oldest first, on the changelog endpoint.
+func changelogServer(embedded, all [][2]string, total int) *httptest.Server {
+	histories := func(hs [][2]string) string {
+		js := make([]string, 0, len(hs))
+		for _, h := range hs {
+			js = append(js, fmt.Sprintf(`{"id":"%s","created":"%s","items":[]}`, h[0], h[1]))
+		}
+		return "[" + strings.Join(js, ",") + "]"
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/2/issue/PJ-1":
+			fmt.Fprintf(w, `{"key":"PJ-1","fields":{"summary":"Summary"},"changelog":{"startAt":0,"total":%d,"histories":%s}}`,
+				total, histories(embedded))
+		case "/rest/api/2/issue/PJ-1/changelog":
+			fmt.Fprintf(w, `{"startAt":0,"total":%d,"isLast":true,"values":%s}`, total, histories(all))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestAPIClient_GetIssue_changelog(t *testing.T) {
+	h1 := [2]string{"1", "2020-06-01T10:00:00.000+0000"}
+	h2 := [2]string{"2", "2020-06-02T10:00:00.000+0000"}
+	h3 := [2]string{"3", "2020-06-03T10:00:00.000+0000"}
+	srv := changelogServer([][2]string{h3, h2}, [][2]string{h1, h2, h3}, 3)
+	defer srv.Close()
+	c, err := NewAPIClientForURL(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+
+	// The embedded histories are also on the changelog endpoint
+	i, err := c.GetIssue("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssue`: %s", err)
+	}
+	var ids []string
+	for _, h := range i.Changelog.Histories {
+		ids = append(ids, h.Id)
+	}
+	if strings.Join(ids, ",") != "3,2,1" {
+		t.Errorf("expected histories `3,2,1`, got `%s`", strings.Join(ids, ","))
+	}
+}
+
+func TestAPIClient_GetIssue_invalidHistoryTime(t *testing.T) {
+	h1 := [2]string{"1", "yesterday"}
+	h2 := [2]string{"2", "2020-06-02T10:00:00.000+0000"}
+	srv := changelogServer([][2]string{h2}, [][2]string{h1, h2}, 2)
+	defer srv.Close()
+	c, err := NewAPIClientForURL(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+
+	if _, err := c.GetIssue("PJ-1"); err == nil || !strings.Contains(err.Error(), "yesterday") {
+		t.Errorf("expected an invalid creation time error, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/andygrunwald/go-jira"
+
+	source "github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// CacheClient wraps a `jira.Client` to cache the fetched issues as
+// JSON files in a directory (one `<issue key>.json` file per
+// issue). Issues found in the directory are read from it instead of
+// being fetched. The other requests are passed to the wrapped
+// client.
+//
+// It is used to run `diff` repeatedly on the same issues while
+// changing the mapper.
+type CacheClient struct {
+	source.Client
+	dir string
+}
+
+// NewCacheClient returns a `CacheClient` wrapping `c` and caching
+// issues in `dir`, which is created if it doesn't exist.
+func NewCacheClient(c source.Client, dir string) (*CacheClient, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &CacheClient{Client: c, dir: dir}, nil
+}
+
+// GetIssue reads the specified issue from the cache, or fetches it
+// with the wrapped client and writes it to the cache.
+func (c *CacheClient) GetIssue(issueKey string) (*jira.Issue, error) {
+	path := filepath.Join(c.dir, filepath.Base(issueKey)+".json")
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		var i jira.Issue
+		if err := json.Unmarshal(b, &i); err != nil {
+			return nil, fmt.Errorf("error in `GetIssue` reading cache `%s`: %s", path, err)
+		}
+		return &i, nil
+	case !os.IsNotExist(err):
+		return nil, fmt.Errorf("error in `GetIssue` reading cache `%s`: %s", path, err)
+	}
+
+	i, err := c.Client.GetIssue(issueKey)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = json.Marshal(i); err == nil {
+		err = ioutil.WriteFile(path, b, 0644)
+	}
+	if err != nil {
+		logging.Issue(issueKey, logging.PhaseFetch).WithError(err).Warn("Failed to cache issue")
+	}
+	return i, nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+)
+
+func TestCacheClient_GetIssue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cacheclient")
+	if err != nil {
+		t.Fatalf("unexpected error in `TempDir`: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	mc := jiratest.NewFakeClient(t)
+	c, err := NewCacheClient(mc, dir)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewCacheClient`: %s", err)
+	}
+	updated := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	mc.ExpectGetIssue("PJ-1").WillRespondWithIssue(&jira.Issue{
+		Key: "PJ-1",
+		Fields: &jira.IssueFields{
+			Summary:  "Summary",
+			Updated:  jira.Time(updated),
+			Unknowns: map[string]interface{}{"customfield_1": "value"},
+		},
+	})
+
+	// The first call fetches the issue, the second one reads it
+	// from the cache (the fake would report an unexpected fetch).
+	for n := 0; n < 2; n++ {
+		i, err := c.GetIssue("PJ-1")
+		if err != nil {
+			t.Fatalf("unexpected error in `GetIssue` (call %d): %s", n, err)
+		}
+		if i.Key != "PJ-1" || i.Fields.Summary != "Summary" || !time.Time(i.Fields.Updated).Equal(updated) {
+			t.Errorf("unexpected issue (call %d): %+v", n, i)
+		}
+		if i.Fields.Unknowns["customfield_1"] != "value" {
+			t.Errorf("unexpected custom fields (call %d): %v", n, i.Fields.Unknowns)
+		}
+	}
+}
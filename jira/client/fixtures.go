@@ -0,0 +1,221 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fixture is an HTTP exchange with the Jira API recorded by
+// `recordingTransport` and replayed by `replayTransport`, saved as a
+// JSON file.
+//
+// Only the method and URL of the request are recorded, and only the
+// response headers used by the client (see `fixtureHeaders`), so
+// fixtures don't contain credentials (e.g. the `Authorization`
+// request header or session cookies).
+type fixture struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	// Body is set for JSON response bodies, so fixtures are readable
+	// and can be edited. Other bodies are kept in `Text`.
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// fixtureHeaders are the response headers kept in fixtures.
+var fixtureHeaders = []string{
+	"Content-Type",
+	"Retry-After",
+	"X-RateLimit-Limit",
+	"X-RateLimit-Remaining",
+	"X-RateLimit-NearLimit",
+}
+
+// redacted replaces the credentials found in recorded bodies.
+const redacted = "REDACTED"
+
+// recordingTransport is an `http.RoundTripper` saving the responses
+// of the wrapped transport as fixtures (see `fixture`) in a
+// directory, one file per request (see `fixtureName`). Recording
+// the same request again overwrites its fixture.
+//
+// The `secrets` (e.g. the username and password of the client) are
+// replaced by `redacted` if they appear in a response body.
+type recordingTransport struct {
+	transport http.RoundTripper
+	dir       string
+	secrets   []string
+}
+
+func newRecordingTransport(t http.RoundTripper, dir string, secrets ...string) (*recordingTransport, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error in `newRecordingTransport`: %s", err)
+	}
+	rt := recordingTransport{transport: t, dir: dir}
+	for _, s := range secrets {
+		// Too short values would redact unrelated parts of the bodies
+		if len(s) >= 4 {
+			rt.secrets = append(rt.secrets, s)
+		}
+	}
+	return &rt, nil
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, err := fixtureName(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	f := fixture{
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Status: res.StatusCode,
+		Header: make(http.Header),
+	}
+	for _, h := range fixtureHeaders {
+		if v, ok := res.Header[h]; ok {
+			f.Header[h] = v
+		}
+	}
+	for _, s := range t.secrets {
+		b = bytes.Replace(b, []byte(s), []byte(redacted), -1)
+	}
+	if json.Valid(b) {
+		f.Body = b
+	} else {
+		f.Text = string(b)
+	}
+	if err := writeFixture(filepath.Join(t.dir, name), f); err != nil {
+		return nil, fmt.Errorf("error in `RoundTrip` recording `%s %s`: %s", req.Method, f.URL, err)
+	}
+	return res, nil
+}
+
+// writeFixture writes the fixture through a temporary file, so
+// concurrent recordings of the same request don't mix.
+func writeFixture(path string, f fixture) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(f); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".fixture-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// replayTransport is an `http.RoundTripper` responding to requests
+// with the fixtures of a directory recorded by `recordingTransport`,
+// without any network access. A request without fixture fails.
+//
+// Fixtures are found by method, path and query (see
+// `fixtureName`), so they can be replayed whatever the host of the
+// client.
+type replayTransport struct {
+	dir string
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, err := fixtureName(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(filepath.Join(t.dir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no fixture `%s` for `%s %s`", name, req.Method, req.URL.RequestURI())
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("error in `RoundTrip` reading fixture `%s`: %s", name, err)
+	}
+	body := []byte(f.Text)
+	if len(f.Body) > 0 {
+		body = f.Body
+	}
+	if f.Header == nil {
+		f.Header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        f.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+var unsafeFixtureChars = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// fixtureName returns the name of the fixture file of the request:
+// the method and path, readable (e.g. `get_issue_PJ-1`), followed by
+// a hash of the method, path, sorted query parameters and body which
+// distinguishes requests to the same path (e.g. the pages of a
+// search).
+func fixtureName(req *http.Request) (string, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", fmt.Errorf("request `%s %s` has a body which can't be read again", req.Method, req.URL.Path)
+		}
+		rc, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		body, err = ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %s?%s\n", req.Method, req.URL.Path, req.URL.Query().Encode())
+	h.Write(body)
+
+	path := endpointPath(req.URL.Path)
+	readable := strings.Trim(unsafeFixtureChars.ReplaceAllString(path, "_"), "_")
+	return fmt.Sprintf("%s_%s_%s.json", strings.ToLower(req.Method), readable, hex.EncodeToString(h.Sum(nil))[:10]), nil
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andygrunwald/go-jira"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixtures")
+	if err != nil {
+		t.Fatalf("unexpected error in `TempDir`: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != "robot" || p != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "atlassian.xsrf.token=token")
+		w.Write([]byte(`{"key": "PJ-1", "fields": {"summary": "Reported by robot"}}`))
+	}))
+	defer srv.Close()
+
+	rt, err := newRecordingTransport(http.DefaultTransport, dir, "robot", "s3cr3t")
+	if err != nil {
+		t.Fatalf("unexpected error in `newRecordingTransport`: %s", err)
+	}
+	tp := jira.BasicAuthTransport{Username: "robot", Password: "s3cr3t", Transport: rt}
+	jc, err := jira.NewClient(tp.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewClient`: %s", err)
+	}
+	i, err := (&APIClient{jc}).GetIssue("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssue` (recording): %s", err)
+	}
+	// The recorded client gets the real response
+	if i.Fields.Summary != "Reported by robot" {
+		t.Errorf("unexpected summary `%s`", i.Fields.Summary)
+	}
+
+	// Credentials are scrubbed from the fixture
+	paths, _ := filepath.Glob(filepath.Join(dir, "get_issue_PJ-1_*.json"))
+	if len(paths) != 1 {
+		t.Fatalf("expected a fixture for the issue, got %v", paths)
+	}
+	b, _ := ioutil.ReadFile(paths[0])
+	for _, secret := range []string{"robot", "s3cr3t", "Authorization", "xsrf"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("fixture contains `%s`:\n%s", secret, b)
+		}
+	}
+
+	// The replayed client doesn't access the network
+	srv.Close()
+	c, err := NewReplayAPIClient(dir)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewReplayAPIClient`: %s", err)
+	}
+	i, err = c.GetIssue("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssue` (replay): %s", err)
+	}
+	if i.Key != "PJ-1" || i.Fields.Summary != "Reported by REDACTED" {
+		t.Errorf("unexpected replayed issue `%+v`", i)
+	}
+	if _, err := c.GetIssue("PJ-2"); err == nil || !strings.Contains(err.Error(), "no fixture") {
+		t.Errorf("expected a missing fixture error, got `%v`", err)
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	name := func(u string) string {
+		req, _ := http.NewRequest("GET", u, nil)
+		n, err := fixtureName(req)
+		if err != nil {
+			t.Fatalf("unexpected error in `fixtureName`: %s", err)
+		}
+		return n
+	}
+	n := name("https://jira.example.com/rest/api/2/search?jql=project+%3D+PJ&startAt=1")
+	if !strings.HasPrefix(n, "get_search_") || !strings.HasSuffix(n, ".json") {
+		t.Errorf("unexpected fixture name `%s`", n)
+	}
+	// The host and the order of the query parameters don't matter
+	if n != name("http://localhost/rest/api/2/search?startAt=1&jql=project+%3D+PJ") {
+		t.Errorf("expected the same fixture name for the same request")
+	}
+	if n == name("https://jira.example.com/rest/api/2/search?jql=project+%3D+PJ&startAt=2") {
+		t.Errorf("expected different fixture names for different queries")
+	}
+}
@@ -0,0 +1,243 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+)
+
+// Default configuration of the rate limiter, overridden by the
+// `JIRA_RATE_LIMIT` and `JIRA_RATE_BURST` environment variables.
+const (
+	defaultRateLimit = 10.0
+	defaultRateBurst = 10
+)
+
+// Adaptation of the rate limiter's rate (see `rateLimiter`)
+const (
+	// rateLimitedFactor is applied to the rate when a request is
+	// rate limited.
+	rateLimitedFactor = 0.5
+	// nearLimitFactor is applied to the rate when the API reports
+	// the rate limit is near.
+	nearLimitFactor = 0.8
+	// minRateRatio is the minimum rate, relative to the configured
+	// rate.
+	minRateRatio = 0.05
+	// recoveryStep is the rate recovered, relative to the configured
+	// rate, every `recoveryInterval` without rate limiting signal.
+	recoveryStep     = 0.1
+	recoveryInterval = 5 * time.Second
+	// nearLimitRemaining is the ratio of remaining requests of the
+	// `X-RateLimit-Remaining` and `X-RateLimit-Limit` headers under
+	// which the rate limit is considered near.
+	nearLimitRemaining = 0.1
+	// slowDownInterval is the minimum interval between two slow
+	// downs, so the responses of concurrent requests hitting the
+	// rate limit together slow down the rate only once.
+	slowDownInterval = time.Second
+)
+
+// rateLimiter is a token bucket limiting the rate of the requests
+// sent to the Jira API by all the workers sharing an `APIClient`.
+//
+// Its rate adapts to the API's rate limiting: it is halved when a
+// request is rate limited (and all requests wait for the
+// `Retry-After` delay), reduced when the rate limit headers report
+// the limit is near, and increased back to the configured rate
+// while no such signal is received.
+type rateLimiter struct {
+	mu          sync.Mutex
+	maxRate     float64 // configured rate, in requests per second
+	rate        float64 // current rate
+	burst       float64
+	tokens      float64
+	last        time.Time // last refill of the tokens
+	lastSlowed  time.Time // last change of the rate
+	pausedUntil time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		maxRate: rate,
+		rate:    rate,
+		burst:   float64(burst),
+		tokens:  float64(burst),
+		now:     time.Now,
+		sleep:   sleepContext,
+	}
+	l.last = l.now()
+	metrics.RateLimit.Set(rate)
+	return l
+}
+
+// rateLimiterFromEnv returns a rate limiter configured by the
+// `JIRA_RATE_LIMIT` (requests per second, default: 10) and
+// `JIRA_RATE_BURST` (default: 10) environment variables. Returns nil
+// if `JIRA_RATE_LIMIT` is 0, which disables the rate limiter.
+func rateLimiterFromEnv() (*rateLimiter, error) {
+	rate := defaultRateLimit
+	if v := os.Getenv("JIRA_RATE_LIMIT"); v != "" {
+		var err error
+		if rate, err = strconv.ParseFloat(v, 64); err != nil || rate < 0 {
+			return nil, fmt.Errorf("invalid JIRA_RATE_LIMIT `%s`: must be a number of requests per second", v)
+		}
+	}
+	if rate == 0 {
+		return nil, nil
+	}
+	burst := defaultRateBurst
+	if v := os.Getenv("JIRA_RATE_BURST"); v != "" {
+		var err error
+		if burst, err = strconv.Atoi(v); err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid JIRA_RATE_BURST `%s`: must be a positive integer", v)
+		}
+	}
+	return newRateLimiter(rate, burst), nil
+}
+
+// wait blocks until a request can be sent, or `ctx` is done.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	start := l.now()
+	defer func() {
+		metrics.ThrottleWaitSeconds.Add(l.now().Sub(start).Seconds())
+	}()
+	for {
+		d := l.reserve()
+		if d == 0 {
+			return nil
+		}
+		if err := l.sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve takes a token and returns 0 if one is available, or
+// returns the time to wait before trying again.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Before(l.pausedUntil) {
+		return l.pausedUntil.Sub(now)
+	}
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// pause suspends all requests for `d`, e.g. as specified by the
+// `Retry-After` header of a rate limited response.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := l.now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// observe adapts the rate to the rate limiting signals of the
+// response.
+func (l *rateLimiter) observe(res *http.Response) {
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests:
+		l.slowDown(rateLimitedFactor)
+	case nearLimit(res):
+		l.slowDown(nearLimitFactor)
+	default:
+		l.recover()
+	}
+}
+
+func (l *rateLimiter) slowDown(factor float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.lastSlowed.IsZero() && l.now().Sub(l.lastSlowed) < slowDownInterval {
+		return
+	}
+	l.setRate(l.rate * factor)
+	l.lastSlowed = l.now()
+	logging.Log().WithField("rate", l.rate).Debug("Slowing down Jira API requests")
+}
+
+func (l *rateLimiter) recover() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate >= l.maxRate || l.now().Sub(l.lastSlowed) < recoveryInterval {
+		return
+	}
+	l.setRate(l.rate + l.maxRate*recoveryStep)
+	l.lastSlowed = l.now()
+}
+
+// setRate sets the rate, bounded by the minimum and configured
+// rates. The caller must hold the lock.
+func (l *rateLimiter) setRate(rate float64) {
+	// Refill at the previous rate before changing it
+	now := l.now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	minRate := l.maxRate * minRateRatio
+	switch {
+	case rate < minRate:
+		rate = minRate
+	case rate > l.maxRate:
+		rate = l.maxRate
+	}
+	l.rate = rate
+	metrics.RateLimit.Set(rate)
+}
+
+// nearLimit returns true if the rate limit headers of the response
+// report the rate limit is near: `X-RateLimit-NearLimit` is true or
+// `X-RateLimit-Remaining` is under `nearLimitRemaining` of
+// `X-RateLimit-Limit`.
+func nearLimit(res *http.Response) bool {
+	if strings.EqualFold(res.Header.Get("X-RateLimit-NearLimit"), "true") {
+		return true
+	}
+	remaining, err := strconv.Atoi(res.Header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return false
+	}
+	limit, err := strconv.Atoi(res.Header.Get("X-RateLimit-Limit"))
+	if err != nil || limit <= 0 {
+		return false
+	}
+	return float64(remaining) < float64(limit)*nearLimitRemaining
+}
+
+// sleepContext waits for `d` or until `ctx` is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter returns a rate limiter whose clock only
+// advances when it sleeps, and the list of its sleeps.
+func newTestRateLimiter(rate float64, burst int) (*rateLimiter, *[]time.Duration) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var sleeps []time.Duration
+	l := newRateLimiter(rate, burst)
+	l.now = func() time.Time { return now }
+	l.last = now
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		now = now.Add(d)
+		return nil
+	}
+	return l, &sleeps
+}
+
+func TestRateLimiter_wait(t *testing.T) {
+	l, sleeps := newTestRateLimiter(2, 2)
+	for i := 0; i < 3; i++ {
+		if err := l.wait(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	// The burst is consumed by the 2 first requests, the third
+	// waits for a token at 2 requests per second.
+	if len(*sleeps) != 1 || (*sleeps)[0] != 500*time.Millisecond {
+		t.Errorf("expected a single wait of 500ms, got %v", *sleeps)
+	}
+}
+
+func TestRateLimiter_pause(t *testing.T) {
+	l, sleeps := newTestRateLimiter(10, 10)
+	l.pause(2 * time.Second)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(*sleeps) != 1 || (*sleeps)[0] != 2*time.Second {
+		t.Errorf("expected a single wait of 2s, got %v", *sleeps)
+	}
+}
+
+func TestRateLimiter_waitCancelled(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	l.pause(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.wait(ctx); err != context.Canceled {
+		t.Errorf("expected `context.Canceled`, got `%v`", err)
+	}
+}
+
+func TestRateLimiter_observe(t *testing.T) {
+	l, _ := newTestRateLimiter(10, 10)
+	rateLimited := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	ok := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+
+	// Concurrent rate limited responses slow down only once
+	l.observe(rateLimited)
+	l.observe(rateLimited)
+	if l.rate != 5 {
+		t.Errorf("expected rate to be halved to 5, got %v", l.rate)
+	}
+
+	// The rate recovers gradually without rate limiting signals
+	l.observe(ok)
+	if l.rate != 5 {
+		t.Errorf("expected rate not to recover yet, got %v", l.rate)
+	}
+	l.sleep(context.Background(), recoveryInterval)
+	l.observe(ok)
+	if l.rate != 6 {
+		t.Errorf("expected rate to recover to 6, got %v", l.rate)
+	}
+
+	// Near the limit, the rate is reduced
+	l.sleep(context.Background(), slowDownInterval)
+	nearLimit := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	nearLimit.Header.Set("X-RateLimit-Limit", "100")
+	nearLimit.Header.Set("X-RateLimit-Remaining", "5")
+	l.observe(nearLimit)
+	if math.Abs(l.rate-6*nearLimitFactor) > 1e-9 {
+		t.Errorf("expected rate to be reduced to %v, got %v", 6*nearLimitFactor, l.rate)
+	}
+
+	// The rate never goes under the minimum
+	for i := 0; i < 20; i++ {
+		l.sleep(context.Background(), slowDownInterval)
+		l.observe(rateLimited)
+	}
+	if l.rate != 10*minRateRatio {
+		t.Errorf("expected rate to be the minimum %v, got %v", 10*minRateRatio, l.rate)
+	}
+}
+
+func TestNearLimit(t *testing.T) {
+	tests := []struct {
+		headers  map[string]string
+		expected bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"X-RateLimit-NearLimit": "true"}, true},
+		{map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "50"}, false},
+		{map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "9"}, true},
+	}
+	for _, test := range tests {
+		res := &http.Response{Header: http.Header{}}
+		for k, v := range test.headers {
+			res.Header.Set(k, v)
+		}
+		if got := nearLimit(res); got != test.expected {
+			t.Errorf("expected %v for headers %v, got %v", test.expected, test.headers, got)
+		}
+	}
+}
+
+func TestRateLimiterFromEnv(t *testing.T) {
+	os.Setenv("JIRA_RATE_LIMIT", "0")
+	defer os.Unsetenv("JIRA_RATE_LIMIT")
+	if l, err := rateLimiterFromEnv(); l != nil || err != nil {
+		t.Errorf("expected the rate limiter to be disabled, got %v, %v", l, err)
+	}
+
+	os.Setenv("JIRA_RATE_LIMIT", "2.5")
+	l, err := rateLimiterFromEnv()
+	if err != nil || l.maxRate != 2.5 || l.burst != defaultRateBurst {
+		t.Errorf("unexpected rate limiter %+v, %v", l, err)
+	}
+
+	os.Setenv("JIRA_RATE_LIMIT", "fast")
+	if _, err := rateLimiterFromEnv(); err == nil {
+		t.Errorf("expected an error for an invalid rate")
+	}
+}
+
+func TestInstrumentedTransport_rateLimitedWithLimiter(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	l, sleeps := newTestRateLimiter(10, 10)
+	tr := newInstrumentedTransport(nil, l)
+	tr.sleep = func(d time.Duration) { t.Errorf("unexpected sleep of the transport") }
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL + "/rest/api/2/search")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || calls != 2 {
+		t.Errorf("expected status 200 after a retry, got %d after %d calls", res.StatusCode, calls)
+	}
+	// The retry waits for the pause of the limiter
+	if len(*sleeps) != 1 || (*sleeps)[0] != 2*time.Second {
+		t.Errorf("expected the limiter to wait 2s, got %v", *sleeps)
+	}
+	if l.rate != 5 {
+		t.Errorf("expected rate to be halved, got %v", l.rate)
+	}
+}
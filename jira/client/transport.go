@@ -0,0 +1,114 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+)
+
+// maxRateLimitRetries is the number of times a request is retried
+// when it is rate limited by the Jira API.
+const maxRateLimitRetries = 3
+
+// defaultRateLimitWait is the time waited before retrying a rate
+// limited request if the response doesn't specify it.
+const defaultRateLimitWait = 5 * time.Second
+
+// instrumentedTransport is an `http.RoundTripper` observing the
+// latency of Jira API requests and waiting before retrying rate
+// limited requests (`429 Too Many Requests`), as specified by the
+// `Retry-After` header.
+//
+// If `limiter` is set, requests are throttled by it, and the wait
+// before retrying a rate limited request applies to all requests.
+type instrumentedTransport struct {
+	transport http.RoundTripper
+	limiter   *rateLimiter
+	sleep     func(time.Duration)
+}
+
+func newInstrumentedTransport(t http.RoundTripper, l *rateLimiter) *instrumentedTransport {
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	return &instrumentedTransport{transport: t, limiter: l, sleep: time.Sleep}
+}
+
+// RoundTrip implements `http.RoundTripper`.
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	endpoint := endpointLabel(req.URL.Path)
+	for retry := 0; ; retry++ {
+		if t.limiter != nil {
+			if err := t.limiter.wait(req.Context()); err != nil {
+				return nil, err
+			}
+		}
+		start := time.Now()
+		res, err := t.transport.RoundTrip(req)
+		metrics.APIRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.Errors.WithLabelValues(metrics.ErrorKindAPI).Inc()
+			return res, err
+		}
+		if t.limiter != nil {
+			t.limiter.observe(res)
+		}
+		if res.StatusCode != http.StatusTooManyRequests || retry >= maxRateLimitRetries || !rewindable(req) {
+			return res, nil
+		}
+		res.Body.Close()
+		wait := retryAfter(res)
+		metrics.ObserveRateLimitWait(wait)
+		if t.limiter != nil {
+			t.limiter.pause(wait)
+		} else {
+			t.sleep(wait)
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// rewindable returns true if the request can be sent again.
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// retryAfter returns the wait specified by the `Retry-After` header
+// of the response (in seconds), or `defaultRateLimitWait`.
+func retryAfter(res *http.Response) time.Duration {
+	if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second
+	}
+	return defaultRateLimitWait
+}
+
+// endpointLabel returns the endpoint of a Jira REST API path, used
+// to label metrics without the high cardinality of the full path
+// (e.g. `/rest/api/2/issue/PJ-1` is `issue`).
+func endpointLabel(path string) string {
+	path = endpointPath(path)
+	if i := strings.Index(path, "/"); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" {
+		return "other"
+	}
+	return path
+}
+
+// endpointPath returns the path relative to the Jira REST API
+// (e.g. `/rest/api/2/issue/PJ-1` is `issue/PJ-1`).
+func endpointPath(path string) string {
+	path = strings.TrimPrefix(path, "/")
+	for _, prefix := range []string{"rest/api/2/", "rest/api/3/", "rest/agile/1.0/"} {
+		path = strings.TrimPrefix(path, prefix)
+	}
+	return path
+}
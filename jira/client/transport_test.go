@@ -0,0 +1,52 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	tests := map[string]string{
+		"/rest/api/2/issue/PJ-1": "issue",
+		"/rest/api/2/search":     "search",
+		"rest/api/2/field":       "field",
+		"/":                      "other",
+	}
+	for path, expected := range tests {
+		if got := endpointLabel(path); got != expected {
+			t.Errorf("expected `%s` for `%s`, got `%s`", expected, path, got)
+		}
+	}
+}
+
+func TestInstrumentedTransport_rateLimited(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var waits []time.Duration
+	tr := newInstrumentedTransport(nil, nil)
+	tr.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL + "/rest/api/2/search")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 after retry, got %d", res.StatusCode)
+	}
+	if calls != 2 || len(waits) != 1 || waits[0] != 2*time.Second {
+		t.Errorf("expected 1 wait of 2s and 2 calls, got waits %v and %d calls", waits, calls)
+	}
+}
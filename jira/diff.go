@@ -0,0 +1,314 @@
+package jira
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Statuses of an issue in a `DiffReport`
+const (
+	// DiffUnchanged is for issues whose mapped records are the
+	// same as the stored ones.
+	DiffUnchanged = "unchanged"
+	// DiffChanged is for issues whose mapped records differ from
+	// the stored ones.
+	DiffChanged = "changed"
+	// DiffNew is for issues which are not in the store.
+	DiffNew = "new"
+	// DiffFailed is for issues which could not be fetched or
+	// mapped.
+	DiffFailed = "failed"
+)
+
+// Kinds of event differences, used as `FieldDiff.Field` for events
+// which are only stored or only mapped.
+const (
+	diffEventAdded   = "event.added"
+	diffEventRemoved = "event.removed"
+)
+
+// DiffReport is the result of `PerformDiff`. `FieldChanges` counts
+// the changes by field over all compared issues (e.g.
+// `state.Status`, `event.CommentBody`, `event.added`). `Issues` only
+// contains the issues which are not unchanged.
+type DiffReport struct {
+	Compared     int            `json:"compared"`
+	Unchanged    int            `json:"unchanged"`
+	Changed      int            `json:"changed"`
+	New          int            `json:"new"`
+	Failed       int            `json:"failed"`
+	FieldChanges map[string]int `json:"field_changes"`
+	Issues       []IssueDiff    `json:"issues"`
+}
+
+// IssueDiff contains the differences between the records mapped
+// from an issue and the stored ones.
+type IssueDiff struct {
+	IssueKey string      `json:"issue_key"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Fields   []FieldDiff `json:"fields,omitempty"`
+}
+
+// FieldDiff is a difference on a field of the issue's state or of
+// one of its events. For events, `Event` identifies the event by
+// its kind, time and author.
+//
+// Pointers are dereferenced and times are compared (and reported)
+// as their wall clock in UTC, since the store drops time zones.
+type FieldDiff struct {
+	Field  string      `json:"field"`
+	Event  string      `json:"event,omitempty"`
+	Stored interface{} `json:"stored"`
+	Mapped interface{} `json:"mapped"`
+}
+
+// Transformer is implemented by stores which transform the records
+// before writing them (e.g. `privacy.Store`). `PerformDiff` applies
+// the transformation to the mapped records before comparing them
+// with the stored ones.
+type Transformer interface {
+	TransformIssueStateAndEvents(is store.IssueState, ies []store.IssueEvent) (store.IssueState, []store.IssueEvent)
+}
+
+// PerformDiff fetches the specified issues, maps them with `m` and
+// compares the resulting state and events, field by field, with
+// the records in the store. Nothing is written to the store.
+//
+// It is used to check the impact of a mapper change before running
+// a sync.
+func PerformDiff(c Client, s store.Store, m Mapper, issueKeys []string) DiffReport {
+	r := DiffReport{FieldChanges: map[string]int{}}
+	for _, k := range issueKeys {
+		d := diffIssue(c, s, m, k)
+		r.Compared++
+		switch d.Status {
+		case DiffUnchanged:
+			r.Unchanged++
+			continue
+		case DiffChanged:
+			r.Changed++
+		case DiffNew:
+			r.New++
+		case DiffFailed:
+			r.Failed++
+		}
+		for _, fd := range d.Fields {
+			r.FieldChanges[fd.Field]++
+		}
+		r.Issues = append(r.Issues, d)
+	}
+	return r
+}
+
+// SearchIssueKeys returns the keys of the issues matching the JQL
+// query, up to `limit` keys if it is positive.
+func SearchIssueKeys(c Client, query string, limit int) ([]string, error) {
+	issueKeys := make(chan string, 100)
+	searchErr := make(chan error, 1)
+	go func() { searchErr <- c.SearchIssues(query, issueKeys, nil) }()
+	var ks []string
+	for k := range issueKeys {
+		// The channel must be drained for the search to end
+		if limit <= 0 || len(ks) < limit {
+			ks = append(ks, k)
+		}
+	}
+	if err := <-searchErr; err != nil {
+		return nil, fmt.Errorf("error in `SearchIssueKeys`: %s", err)
+	}
+	return ks, nil
+}
+
+func diffIssue(c Client, s store.Store, m Mapper, issueKey string) IssueDiff {
+	d := IssueDiff{IssueKey: issueKey}
+	i, err := c.GetIssue(issueKey)
+	if err != nil {
+		d.Status = DiffFailed
+		d.Error = err.Error()
+		return d
+	}
+	mi, err := mapIssue(m, i)
+	if err != nil {
+		d.Status = DiffFailed
+		d.Error = err.Error()
+		return d
+	}
+	if t, ok := s.(Transformer); ok {
+		mi.state, mi.events = t.TransformIssueStateAndEvents(mi.state, mi.events)
+	}
+
+	is, ies, err := s.GetIssueStateAndEvents(issueKey)
+	if err != nil {
+		logging.Issue(issueKey, logging.PhaseStore).WithError(err).Fatal("error in `GetIssueStateAndEvents`")
+	}
+	if is == nil {
+		d.Status = DiffNew
+		return d
+	}
+
+	d.Fields = diffStructs("state", "", *is, mi.state)
+	d.Fields = append(d.Fields, diffEvents(ies, mi.events)...)
+	d.Status = DiffUnchanged
+	if len(d.Fields) > 0 {
+		d.Status = DiffChanged
+	}
+	return d
+}
+
+// diffEvents matches the stored and mapped events by kind, time
+// and author, and returns the differences of the matched events
+// and the events which were added or removed.
+func diffEvents(stored []store.IssueEvent, mapped []store.IssueEvent) []FieldDiff {
+	var fds []FieldDiff
+	unmatched := make(map[string][]store.IssueEvent)
+	for _, ie := range stored {
+		id := eventID(ie)
+		unmatched[id] = append(unmatched[id], ie)
+	}
+	for _, mie := range mapped {
+		id := eventID(mie)
+		if len(unmatched[id]) == 0 {
+			fds = append(fds, FieldDiff{Field: diffEventAdded, Event: id, Mapped: mie})
+			continue
+		}
+		sie := unmatched[id][0]
+		unmatched[id] = unmatched[id][1:]
+		fds = append(fds, diffStructs("event", id, sie, mie)...)
+	}
+	for _, ie := range stored {
+		id := eventID(ie)
+		if len(unmatched[id]) > 0 {
+			fds = append(fds, FieldDiff{Field: diffEventRemoved, Event: id, Stored: unmatched[id][0]})
+			unmatched[id] = unmatched[id][1:]
+		}
+	}
+	return fds
+}
+
+func eventID(ie store.IssueEvent) string {
+	return fmt.Sprintf("%s@%s by %s", ie.EventKind, wallClock(ie.EventTime).Format(time.RFC3339Nano), ie.EventAuthor)
+}
+
+// diffStructs compares the fields of two structs of the same type.
+func diffStructs(prefix string, event string, stored interface{}, mapped interface{}) []FieldDiff {
+	var fds []FieldDiff
+	sv, mv := reflect.ValueOf(stored), reflect.ValueOf(mapped)
+	for i := 0; i < sv.NumField(); i++ {
+		s, m := diffValue(sv.Field(i)), diffValue(mv.Field(i))
+		if reflect.DeepEqual(s, m) {
+			continue
+		}
+		fds = append(fds, FieldDiff{
+			Field:  prefix + "." + sv.Type().Field(i).Name,
+			Event:  event,
+			Stored: s,
+			Mapped: m,
+		})
+	}
+	return fds
+}
+
+// diffValue returns the value of a field normalized for comparison:
+// pointers are dereferenced, times are converted to their wall
+// clock in UTC, and empty slices are nil (as stored).
+func diffValue(v reflect.Value) interface{} {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return wallClock(x)
+	case []string:
+		if len(x) == 0 {
+			return nil
+		}
+	}
+	return v.Interface()
+}
+
+// wallClock returns the wall clock of `t` in UTC, truncated to
+// the precision of the store, as it is read from the store.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC).
+		Truncate(time.Microsecond)
+}
+
+// WriteJSON writes the report as JSON.
+func (r DiffReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes a human readable summary of the report: the
+// counts of issues and field changes, and the differences of each
+// issue.
+func (r DiffReport) WriteText(w io.Writer) error {
+	p := &errWriter{w: w}
+	p.printf("Compared: %d, unchanged: %d, changed: %d, new: %d, failed: %d\n",
+		r.Compared, r.Unchanged, r.Changed, r.New, r.Failed)
+	if len(r.FieldChanges) > 0 {
+		p.printf("\nChanges by field:\n")
+		fields := make([]string, 0, len(r.FieldChanges))
+		for f := range r.FieldChanges {
+			fields = append(fields, f)
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			p.printf("  %-32s %d\n", f, r.FieldChanges[f])
+		}
+	}
+	for _, d := range r.Issues {
+		p.printf("\n%s (%s)\n", d.IssueKey, d.Status)
+		if d.Error != "" {
+			p.printf("  error: %s\n", d.Error)
+		}
+		for _, fd := range d.Fields {
+			switch {
+			case fd.Field == diffEventAdded || fd.Field == diffEventRemoved:
+				p.printf("  %s: %s\n", fd.Field, fd.Event)
+			case fd.Event != "":
+				p.printf("  %s [%s]: %s -> %s\n", fd.Field, fd.Event, diffText(fd.Stored), diffText(fd.Mapped))
+			default:
+				p.printf("  %s: %s -> %s\n", fd.Field, diffText(fd.Stored), diffText(fd.Mapped))
+			}
+		}
+	}
+	return p.err
+}
+
+func diffText(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return fmt.Sprintf("%q", x)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+// errWriter keeps the first error of successive writes.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *errWriter) printf(format string, a ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, a...)
+}
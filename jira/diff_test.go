@@ -0,0 +1,110 @@
+package jira_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// diffMapperMock maps each issue to the state and events specified
+// for its key.
+type diffMapperMock struct {
+	mapperMock
+	states map[string]store.IssueState
+	events map[string][]store.IssueEvent
+}
+
+func (m *diffMapperMock) IssueEventsFromIssue(i *extJira.Issue) ([]store.IssueEvent, error) {
+	return m.events[i.Key], nil
+}
+
+func (m *diffMapperMock) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	return m.states[i.Key]
+}
+
+func TestPerformDiff(t *testing.T) {
+	paris := time.FixedZone("Paris", 2*3600)
+	mappedTime := time.Date(2020, 6, 1, 12, 0, 0, 123456789, paris)
+	// The store drops the time zone and keeps microseconds
+	storedTime := time.Date(2020, 6, 1, 12, 0, 0, 123456000, time.UTC)
+	done, closed := "Done", "Closed"
+
+	m := &diffMapperMock{
+		states: map[string]store.IssueState{
+			"PJ-1": store.IssueState{Key: "PJ-1", CreatedAt: mappedTime, Status: &done, Labels: []string{}},
+			"PJ-2": store.IssueState{Key: "PJ-2", CreatedAt: mappedTime, Status: &closed},
+			"PJ-3": store.IssueState{Key: "PJ-3"},
+		},
+		events: map[string][]store.IssueEvent{
+			"PJ-1": []store.IssueEvent{
+				store.IssueEvent{IssueKey: "PJ-1", EventTime: mappedTime, EventKind: "created", EventAuthor: "a"},
+			},
+			"PJ-2": []store.IssueEvent{
+				store.IssueEvent{IssueKey: "PJ-2", EventTime: mappedTime, EventKind: "created", EventAuthor: "a"},
+				store.IssueEvent{IssueKey: "PJ-2", EventTime: mappedTime, EventKind: "status_changed", EventAuthor: "a", StatusChangeTo: &closed},
+			},
+		},
+	}
+
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+	for _, k := range []string{"PJ-1", "PJ-2", "PJ-3"} {
+		c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{Key: k})
+	}
+	c.ExpectGetIssue("PJ-4").WillRespondWithError(fmt.Errorf("not found"))
+	s.ExpectGetIssueStateAndEvents("PJ-1").WillReturn(
+		&store.IssueState{Key: "PJ-1", CreatedAt: storedTime, Status: &done},
+		[]store.IssueEvent{
+			store.IssueEvent{IssueKey: "PJ-1", EventTime: storedTime, EventKind: "created", EventAuthor: "a"},
+		},
+	)
+	s.ExpectGetIssueStateAndEvents("PJ-2").WillReturn(
+		&store.IssueState{Key: "PJ-2", CreatedAt: storedTime, Status: &done},
+		[]store.IssueEvent{
+			store.IssueEvent{IssueKey: "PJ-2", EventTime: storedTime, EventKind: "created", EventAuthor: "a"},
+		},
+	)
+	s.ExpectGetIssueStateAndEvents("PJ-3").WillReturn(nil, nil)
+
+	r := jira.PerformDiff(c, s, m, []string{"PJ-1", "PJ-2", "PJ-3", "PJ-4"})
+
+	if r.Compared != 4 || r.Unchanged != 1 || r.Changed != 1 || r.New != 1 || r.Failed != 1 {
+		t.Errorf("unexpected counts in report `%+v`\n", r)
+	}
+	expectedFieldChanges := map[string]int{"state.Status": 1, "event.added": 1}
+	if len(r.FieldChanges) != len(expectedFieldChanges) {
+		t.Errorf("unexpected field changes `%v`, expected `%v`\n", r.FieldChanges, expectedFieldChanges)
+	}
+	for f, n := range expectedFieldChanges {
+		if r.FieldChanges[f] != n {
+			t.Errorf("unexpected field changes `%v`, expected `%v`\n", r.FieldChanges, expectedFieldChanges)
+		}
+	}
+	if len(r.Issues) != 3 || r.Issues[0].IssueKey != "PJ-2" || r.Issues[2].Error == "" {
+		t.Errorf("unexpected issues `%+v`\n", r.Issues)
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error in `WriteText`: %s\n", err)
+	}
+	for _, expected := range []string{
+		"Compared: 4, unchanged: 1, changed: 1, new: 1, failed: 1",
+		`state.Status: "Done" -> "Closed"`,
+		"event.added: status_changed@2020-06-01T12:00:00.123456Z by a",
+		"PJ-4 (failed)",
+	} {
+		if !strings.Contains(b.String(), expected) {
+			t.Errorf("expected text output to contain `%s`, got:\n%s", expected, b.String())
+		}
+	}
+}
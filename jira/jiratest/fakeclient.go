@@ -0,0 +1,287 @@
+package jiratest
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/expect"
+	source "github.com/rchampourlier/kaizenizer-source-jira/jira"
+)
+
+// FakeClient is a programmable fake of `jira.Client`. Its responses
+// are set with expectations (e.g. `ExpectGetIssue("PJ-1")`), which
+// are unordered unless marked with `InOrder` (see the `expect`
+// package).
+//
+// Unexpected calls are reported as errors of the test, and respond
+// with zero values (and an error for methods returning one).
+// Expectations which were not met are reported when the test
+// completes.
+type FakeClient struct {
+	t testing.TB
+	*expect.Set
+}
+
+// NewFakeClient returns a `FakeClient` without expectations,
+// reporting to `t`.
+func NewFakeClient(t testing.TB) *FakeClient {
+	return &FakeClient{t: t, Set: expect.NewSet(t)}
+}
+
+// SearchIssues sends the issue keys of the expectation matching the
+// query through the `issueKeys` channel, then closes it and returns
+// the error of the expectation.
+func (c *FakeClient) SearchIssues(query string, issueKeys chan string, total func(int)) error {
+	defer close(issueKeys)
+	e, _ := c.Call("SearchIssues", query).(*ExpectedSearchIssues)
+	if e == nil {
+		return expect.Unexpected("SearchIssues")
+	}
+	if total != nil {
+		total(len(e.issueKeys))
+	}
+	for _, ik := range e.issueKeys {
+		issueKeys <- ik
+	}
+	return e.err
+}
+
+// CountIssues returns the count of the expectation matching the
+// query.
+func (c *FakeClient) CountIssues(query string) (int, error) {
+	e, _ := c.Call("CountIssues", query).(*ExpectedCountIssues)
+	if e == nil {
+		return 0, expect.Unexpected("CountIssues")
+	}
+	return e.count, e.err
+}
+
+// SearchIssueUpdates returns the update times of the expectation
+// matching the query.
+func (c *FakeClient) SearchIssueUpdates(query string) (map[string]time.Time, error) {
+	e, _ := c.Call("SearchIssueUpdates", query).(*ExpectedSearchIssueUpdates)
+	if e == nil {
+		return nil, expect.Unexpected("SearchIssueUpdates")
+	}
+	return e.updates, e.err
+}
+
+// GetIssue returns the issue or error of the expectation for the
+// issue key.
+func (c *FakeClient) GetIssue(issueKey string) (*jira.Issue, error) {
+	e, _ := c.Call("GetIssue", issueKey).(*ExpectedGetIssue)
+	if e == nil {
+		return nil, expect.Unexpected("GetIssue")
+	}
+	return e.issue, e.err
+}
+
+// GetStatuses returns the statuses of the expectation.
+func (c *FakeClient) GetStatuses() ([]jira.Status, error) {
+	e, _ := c.Call("GetStatuses").(*ExpectedGetStatuses)
+	if e == nil {
+		return nil, expect.Unexpected("GetStatuses")
+	}
+	return e.statuses, e.err
+}
+
+// GetFieldSchemas returns the schemas of the expectation.
+func (c *FakeClient) GetFieldSchemas() (map[string]source.FieldSchema, error) {
+	e, _ := c.Call("GetFieldSchemas").(*ExpectedGetFieldSchemas)
+	if e == nil {
+		return nil, expect.Unexpected("GetFieldSchemas")
+	}
+	return e.schemas, e.err
+}
+
+// matchQuery returns a matcher of queries matching entirely the
+// regular expression.
+func (c *FakeClient) matchQuery(re string) func(args []interface{}) bool {
+	r, err := regexp.Compile("^(?:" + re + ")$")
+	if err != nil {
+		c.t.Errorf("invalid query expectation `%s`: %s", re, err)
+		return func([]interface{}) bool { return false }
+	}
+	return func(args []interface{}) bool {
+		return r.MatchString(args[0].(string))
+	}
+}
+
+func matchKey(k string) func(args []interface{}) bool {
+	return func(args []interface{}) bool {
+		return args[0] == k
+	}
+}
+
+// ============
+// Expectations
+// ============
+
+// ExpectedSearchIssues is an expectation for `SearchIssues`.
+type ExpectedSearchIssues struct {
+	*expect.Expectation
+	issueKeys []string
+	err       error
+}
+
+// ExpectSearchIssues sets an expectation of a `SearchIssues` call
+// with a query entirely matching the regular expression.
+func (c *FakeClient) ExpectSearchIssues(query string) *ExpectedSearchIssues {
+	e := &ExpectedSearchIssues{Expectation: expect.New("SearchIssues", fmt.Sprintf("`%s`", query), c.matchQuery(query))}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithIssueKeys sets the issue keys found by the search.
+func (e *ExpectedSearchIssues) WillRespondWithIssueKeys(issueKeys []string) *ExpectedSearchIssues {
+	e.issueKeys = issueKeys
+	return e
+}
+
+// WillRespondWithError sets the error returned once the issue keys
+// are sent, as if the search failed on a later page.
+func (e *ExpectedSearchIssues) WillRespondWithError(err error) *ExpectedSearchIssues {
+	e.err = err
+	return e
+}
+
+// ExpectedCountIssues is an expectation for `CountIssues`.
+type ExpectedCountIssues struct {
+	*expect.Expectation
+	count int
+	err   error
+}
+
+// ExpectCountIssues sets an expectation of a `CountIssues` call
+// with a query entirely matching the regular expression.
+func (c *FakeClient) ExpectCountIssues(query string) *ExpectedCountIssues {
+	e := &ExpectedCountIssues{Expectation: expect.New("CountIssues", fmt.Sprintf("`%s`", query), c.matchQuery(query))}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithCount sets the count to return.
+func (e *ExpectedCountIssues) WillRespondWithCount(count int) *ExpectedCountIssues {
+	e.count = count
+	return e
+}
+
+// WillRespondWithError sets the error to return.
+func (e *ExpectedCountIssues) WillRespondWithError(err error) *ExpectedCountIssues {
+	e.err = err
+	return e
+}
+
+// ExpectedSearchIssueUpdates is an expectation for
+// `SearchIssueUpdates`.
+type ExpectedSearchIssueUpdates struct {
+	*expect.Expectation
+	updates map[string]time.Time
+	err     error
+}
+
+// ExpectSearchIssueUpdates sets an expectation of a
+// `SearchIssueUpdates` call with a query entirely matching the
+// regular expression.
+func (c *FakeClient) ExpectSearchIssueUpdates(query string) *ExpectedSearchIssueUpdates {
+	e := &ExpectedSearchIssueUpdates{Expectation: expect.New("SearchIssueUpdates", fmt.Sprintf("`%s`", query), c.matchQuery(query))}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithUpdates sets the update times to return, indexed
+// by issue key.
+func (e *ExpectedSearchIssueUpdates) WillRespondWithUpdates(updates map[string]time.Time) *ExpectedSearchIssueUpdates {
+	e.updates = updates
+	return e
+}
+
+// WillRespondWithError sets the error to return.
+func (e *ExpectedSearchIssueUpdates) WillRespondWithError(err error) *ExpectedSearchIssueUpdates {
+	e.err = err
+	return e
+}
+
+// ExpectedGetIssue is an expectation for `GetIssue`.
+type ExpectedGetIssue struct {
+	*expect.Expectation
+	issue *jira.Issue
+	err   error
+}
+
+// ExpectGetIssue sets an expectation of a `GetIssue` call for the
+// issue key.
+func (c *FakeClient) ExpectGetIssue(issueKey string) *ExpectedGetIssue {
+	e := &ExpectedGetIssue{Expectation: expect.New("GetIssue", fmt.Sprintf("`%s`", issueKey), matchKey(issueKey))}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithIssue sets the issue to return.
+func (e *ExpectedGetIssue) WillRespondWithIssue(issue *jira.Issue) *ExpectedGetIssue {
+	e.issue = issue
+	return e
+}
+
+// WillRespondWithError sets the error to return.
+func (e *ExpectedGetIssue) WillRespondWithError(err error) *ExpectedGetIssue {
+	e.err = err
+	return e
+}
+
+// ExpectedGetStatuses is an expectation for `GetStatuses`.
+type ExpectedGetStatuses struct {
+	*expect.Expectation
+	statuses []jira.Status
+	err      error
+}
+
+// ExpectGetStatuses sets an expectation of a `GetStatuses` call.
+func (c *FakeClient) ExpectGetStatuses() *ExpectedGetStatuses {
+	e := &ExpectedGetStatuses{Expectation: expect.New("GetStatuses", "", nil)}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithStatuses sets the statuses to return.
+func (e *ExpectedGetStatuses) WillRespondWithStatuses(statuses []jira.Status) *ExpectedGetStatuses {
+	e.statuses = statuses
+	return e
+}
+
+// WillRespondWithError sets the error to return.
+func (e *ExpectedGetStatuses) WillRespondWithError(err error) *ExpectedGetStatuses {
+	e.err = err
+	return e
+}
+
+// ExpectedGetFieldSchemas is an expectation for `GetFieldSchemas`.
+type ExpectedGetFieldSchemas struct {
+	*expect.Expectation
+	schemas map[string]source.FieldSchema
+	err     error
+}
+
+// ExpectGetFieldSchemas sets an expectation of a `GetFieldSchemas`
+// call.
+func (c *FakeClient) ExpectGetFieldSchemas() *ExpectedGetFieldSchemas {
+	e := &ExpectedGetFieldSchemas{Expectation: expect.New("GetFieldSchemas", "", nil)}
+	c.Add(e)
+	return e
+}
+
+// WillRespondWithFieldSchemas sets the schemas to return.
+func (e *ExpectedGetFieldSchemas) WillRespondWithFieldSchemas(schemas map[string]source.FieldSchema) *ExpectedGetFieldSchemas {
+	e.schemas = schemas
+	return e
+}
+
+// WillRespondWithError sets the error to return.
+func (e *ExpectedGetFieldSchemas) WillRespondWithError(err error) *ExpectedGetFieldSchemas {
+	e.err = err
+	return e
+}
@@ -0,0 +1,336 @@
+package jiratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+)
+
+// DatasetConfig configures the synthetic issues created by
+// `Generate`. Zero values are replaced by the defaults of
+// `DefaultDatasetConfig`.
+type DatasetConfig struct {
+	// Issues is the number of generated issues.
+	Issues int
+	// Projects are the keys of the projects, issues being spread
+	// evenly between them.
+	Projects []string
+	// Users is the number of users reporting, being assigned and
+	// commenting issues.
+	Users int
+	// Seed makes the generation reproducible: the same configuration
+	// and seed generate the same issues.
+	Seed int64
+
+	// Start is the creation time of the first issue, and
+	// `CreationInterval` the mean interval between two creations.
+	Start            time.Time
+	CreationInterval time.Duration
+
+	// Workflow is the ordered list of the statuses of the issues.
+	// Statuses unknown to the server are added, in the `To Do`
+	// category for the first one, `Done` for the last one and `In
+	// Progress` for the others.
+	Workflow []string
+	// ForwardProbability is the probability of a transition to go to
+	// the next status of the workflow rather than back to the
+	// previous one.
+	ForwardProbability float64
+	// MaxTransitions is the maximum number of transitions of an
+	// issue. Issues stop transitioning when they reach the last
+	// status of the workflow.
+	MaxTransitions int
+	// MeanStatusDuration is the mean time spent in a status
+	// (exponentially distributed).
+	MeanStatusDuration time.Duration
+
+	// MeanComments is the mean number of comments of an issue
+	// (Poisson distributed).
+	MeanComments float64
+
+	// CustomFields are the custom fields set on the issues.
+	CustomFields []CustomFieldConfig
+}
+
+// CustomFieldConfig configures a custom field of generated issues.
+type CustomFieldConfig struct {
+	ID   string
+	Name string
+	// Kind is the type of the field's values: `option`, `user`,
+	// `string` or `number`.
+	Kind string
+	// Values are the possible values of `option` and `string`
+	// fields.
+	Values []string
+	// FillRate is the ratio of issues with a value for the field
+	// (default: 1).
+	FillRate float64
+}
+
+// DefaultDatasetConfig returns the default configuration of
+// generated datasets: 1000 issues in 2 projects, with the default
+// workflow and some of the custom fields used by the mapper.
+func DefaultDatasetConfig() DatasetConfig {
+	return DatasetConfig{
+		Issues:             1000,
+		Projects:           []string{"PJ", "OT"},
+		Users:              20,
+		Seed:               1,
+		Start:              time.Date(2019, 1, 1, 9, 0, 0, 0, time.UTC),
+		CreationInterval:   4 * time.Hour,
+		Workflow:           []string{"Open", "In Progress", "In Review", "Done"},
+		ForwardProbability: 0.8,
+		MaxTransitions:     8,
+		MeanStatusDuration: 36 * time.Hour,
+		MeanComments:       3,
+		CustomFields: []CustomFieldConfig{
+			{ID: "customfield_10600", Name: "Developer Backend", Kind: "user", FillRate: 0.6},
+			{ID: "customfield_12100", Name: "Tribe", Kind: "option", Values: []string{"Data", "Growth", "Platform"}, FillRate: 0.9},
+			{ID: "customfield_11101", Name: "Bug Cause", Kind: "option", Values: []string{"Regression", "Specification", "Third party"}, FillRate: 0.2},
+		},
+	}
+}
+
+// withDefaults returns the configuration with its zero values
+// replaced by the defaults.
+func (cfg DatasetConfig) withDefaults() DatasetConfig {
+	d := DefaultDatasetConfig()
+	if cfg.Issues == 0 {
+		cfg.Issues = d.Issues
+	}
+	if len(cfg.Projects) == 0 {
+		cfg.Projects = d.Projects
+	}
+	if cfg.Users == 0 {
+		cfg.Users = d.Users
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = d.Start
+	}
+	if cfg.CreationInterval == 0 {
+		cfg.CreationInterval = d.CreationInterval
+	}
+	if len(cfg.Workflow) == 0 {
+		cfg.Workflow = d.Workflow
+	}
+	if cfg.ForwardProbability == 0 {
+		cfg.ForwardProbability = d.ForwardProbability
+	}
+	if cfg.MaxTransitions == 0 {
+		cfg.MaxTransitions = d.MaxTransitions
+	}
+	if cfg.MeanStatusDuration == 0 {
+		cfg.MeanStatusDuration = d.MeanStatusDuration
+	}
+	return cfg
+}
+
+// Generate adds `cfg.Issues` synthetic issues to the server and
+// returns their keys.
+//
+// Each issue is created at random intervals from `cfg.Start`, then
+// transitions through the workflow (forward or backward), is
+// assigned when it leaves its initial status and receives comments
+// during its life. The server's clock is set after the last change.
+func (s *Server) Generate(cfg DatasetConfig) []string {
+	cfg = cfg.withDefaults()
+	r := rand.New(rand.NewSource(cfg.Seed))
+	exp := func(mean time.Duration) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workflow := s.addWorkflow(cfg.Workflow)
+	for _, cf := range cfg.CustomFields {
+		s.addField(Field{ID: cf.ID, Name: cf.Name, Schema: customFieldSchema(cf.Kind)})
+	}
+	users := make([]User, cfg.Users)
+	for n := range users {
+		users[n] = User{AccountID: fmt.Sprintf("user-%d", n+1), DisplayName: fmt.Sprintf("User %d", n+1)}
+	}
+	user := func() User { return users[r.Intn(len(users))] }
+
+	keys := make([]string, 0, cfg.Issues)
+	created := cfg.Start
+	last := s.now
+	for n := 0; n < cfg.Issues; n++ {
+		created = created.Add(exp(cfg.CreationInterval)).Truncate(time.Millisecond)
+		project := cfg.Projects[n%len(cfg.Projects)]
+		s.projectSeq[project]++
+		i := &Issue{
+			ID:          s.nextID(),
+			Key:         fmt.Sprintf("%s-%d", project, s.projectSeq[project]),
+			Project:     project,
+			Type:        []string{"Story", "Story", "Bug", "Task"}[r.Intn(4)],
+			Summary:     fmt.Sprintf("Generated issue %d", n+1),
+			Description: "Generated by `jiratest.Generate`.",
+			Priority:    []string{"Low", "Medium", "Medium", "High"}[r.Intn(4)],
+			Status:      workflow[0].Name,
+			Reporter:    user(),
+			Labels:      []string{},
+			Created:     created,
+			Updated:     created,
+		}
+
+		// Transitions
+		t := created
+		pos := 0
+		for tr := 0; tr < cfg.MaxTransitions && pos < len(workflow)-1; tr++ {
+			t = t.Add(exp(cfg.MeanStatusDuration)).Truncate(time.Millisecond)
+			next := pos + 1
+			if pos > 0 && r.Float64() >= cfg.ForwardProbability {
+				next = pos - 1
+			}
+			author := user()
+			items := []HistoryItem{{
+				Field: "status",
+				From:  workflow[pos].ID, FromString: workflow[pos].Name,
+				To: workflow[next].ID, ToString: workflow[next].Name,
+			}}
+			if i.Assignee == nil {
+				a := user()
+				i.Assignee = &a
+				items = append(items, HistoryItem{Field: "assignee", To: a.AccountID, ToString: a.DisplayName})
+			}
+			i.Histories = append(i.Histories, History{ID: s.nextID(), Author: author, Created: t, Items: items})
+			pos = next
+		}
+		i.Status = workflow[pos].Name
+		i.Updated = t
+		if pos == len(workflow)-1 {
+			resolved := t
+			i.Resolved = &resolved
+		}
+
+		// Comments, during the life of the issue
+		life := i.Updated.Sub(created) + cfg.MeanStatusDuration
+		for c := poisson(r, cfg.MeanComments); c > 0; c-- {
+			ct := created.Add(time.Duration(r.Int63n(int64(life) + 1))).Truncate(time.Millisecond)
+			i.Comments = append(i.Comments, Comment{ID: s.nextID(), Author: user(), Body: fmt.Sprintf("Comment on %s", i.Key), Created: ct})
+			if ct.After(i.Updated) {
+				i.Updated = ct
+			}
+		}
+		sort.Slice(i.Comments, func(a, b int) bool { return i.Comments[a].Created.Before(i.Comments[b].Created) })
+
+		// Custom fields
+		for _, cf := range cfg.CustomFields {
+			rate := cf.FillRate
+			if rate == 0 {
+				rate = 1
+			}
+			if r.Float64() >= rate {
+				continue
+			}
+			if i.CustomFields == nil {
+				i.CustomFields = make(map[string]interface{})
+			}
+			i.CustomFields[cf.ID] = customFieldValue(r, cf, user)
+		}
+
+		s.issues[i.Key] = i
+		keys = append(keys, i.Key)
+		if i.Updated.After(last) {
+			last = i.Updated
+		}
+	}
+	s.now = last.Add(time.Minute)
+	return keys
+}
+
+// addWorkflow returns the statuses of the workflow, adding the
+// unknown ones. The caller must hold the lock.
+func (s *Server) addWorkflow(names []string) []Status {
+	sts := make([]Status, 0, len(names))
+	for n, name := range names {
+		st, ok := s.status(name)
+		if !ok {
+			st = Status{ID: strconv.Itoa(20000 + len(s.statuses)), Name: name, Category: "In Progress"}
+			switch n {
+			case 0:
+				st.Category = "To Do"
+			case len(names) - 1:
+				st.Category = "Done"
+			}
+			s.addStatus(st)
+		}
+		sts = append(sts, st)
+	}
+	return sts
+}
+
+func customFieldSchema(kind string) jira.FieldSchema {
+	switch kind {
+	case "option":
+		return jira.FieldSchema{Type: "option", Custom: "com.atlassian.jira.plugin.system.customfieldtypes:select"}
+	case "user":
+		return jira.FieldSchema{Type: "user", Custom: "com.atlassian.jira.plugin.system.customfieldtypes:userpicker"}
+	case "number":
+		return jira.FieldSchema{Type: "number", Custom: "com.atlassian.jira.plugin.system.customfieldtypes:float"}
+	}
+	return jira.FieldSchema{Type: "string", Custom: "com.atlassian.jira.plugin.system.customfieldtypes:textfield"}
+}
+
+func customFieldValue(r *rand.Rand, cf CustomFieldConfig, user func() User) interface{} {
+	value := func() string {
+		if len(cf.Values) == 0 {
+			return fmt.Sprintf("%s value %d", cf.Name, r.Intn(10))
+		}
+		return cf.Values[r.Intn(len(cf.Values))]
+	}
+	switch cf.Kind {
+	case "option":
+		return map[string]interface{}{"value": value()}
+	case "user":
+		u := user()
+		return userJSON(&u)
+	case "number":
+		return float64(r.Intn(13))
+	}
+	return value()
+}
+
+// poisson returns a Poisson distributed number of mean `mean`.
+func poisson(r *rand.Rand, mean float64) int {
+	l := math.Exp(-mean)
+	k := 0
+	for p := r.Float64(); p > l; p *= r.Float64() {
+		k++
+	}
+	return k
+}
+
+// WriteIssues writes the issues of the server in `dir`, one
+// `<key>.json` file per issue, as returned by Jira's issue endpoint
+// with the whole changelog and comments embedded. The files can be
+// read by `client.CacheClient` (e.g. `diff --cache <dir>`).
+func (s *Server) WriteIssues(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	embedded := s.EmbeddedPageSize
+	s.EmbeddedPageSize = math.MaxInt32
+	defer func() { s.EmbeddedPageSize = embedded }()
+	for _, i := range s.sortedIssues() {
+		b, err := json.Marshal(s.issueJSON(i, nil, true))
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, i.Key+".json"), b, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
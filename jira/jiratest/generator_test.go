@@ -0,0 +1,76 @@
+package jiratest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+)
+
+func TestServer_Generate(t *testing.T) {
+	cfg := jiratest.DatasetConfig{Issues: 50, Seed: 42}
+	a, b := jiratest.NewServer(), jiratest.NewServer()
+	keys := a.Generate(cfg)
+	if len(keys) != 50 {
+		t.Fatalf("expected 50 keys, got %d", len(keys))
+	}
+	if bKeys := b.Generate(cfg); !reflect.DeepEqual(keys, bKeys) {
+		t.Fatalf("expected the same keys with the same seed, got %v and %v", keys, bKeys)
+	}
+
+	workflow := map[string]bool{"Open": true, "In Progress": true, "In Review": true, "Done": true}
+	for _, k := range keys {
+		i, j := a.Issue(k), b.Issue(k)
+		if !reflect.DeepEqual(i, j) {
+			t.Fatalf("expected the same issue `%s` with the same seed", k)
+		}
+		if !workflow[i.Status] {
+			t.Errorf("expected `%s` to be in a status of the workflow, got `%s`", k, i.Status)
+		}
+		if (i.Status == "Done") != (i.Resolved != nil) {
+			t.Errorf("expected `%s` to be resolved iff done (status `%s`)", k, i.Status)
+		}
+		if len(i.Histories) > 0 && i.Assignee == nil {
+			t.Errorf("expected `%s` to be assigned after a transition", k)
+		}
+		if i.Updated.Before(i.Created) {
+			t.Errorf("expected `%s` to be updated after its creation", k)
+		}
+	}
+
+	c := a.Generate(jiratest.DatasetConfig{Issues: 50, Seed: 43})
+	if reflect.DeepEqual(a.Issue(c[0]).Histories, b.Issue(keys[0]).Histories) && reflect.DeepEqual(a.Issue(c[1]).Histories, b.Issue(keys[1]).Histories) {
+		t.Errorf("expected different issues with a different seed")
+	}
+}
+
+func TestServer_WriteIssues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jiratest")
+	must(t, err)
+	defer os.RemoveAll(dir)
+
+	js := jiratest.NewServer()
+	js.EmbeddedPageSize = 1
+	keys := js.Generate(jiratest.DatasetConfig{Issues: 5, Seed: 1})
+	must(t, js.WriteIssues(dir))
+
+	cc, err := client.NewCacheClient(nil, dir)
+	must(t, err)
+	for _, k := range keys {
+		i, err := cc.GetIssue(k)
+		must(t, err)
+		want := js.Issue(k)
+		if i.Key != k {
+			t.Errorf("expected key `%s`, got `%s`", k, i.Key)
+		}
+		if i.Changelog == nil || len(i.Changelog.Histories) != len(want.Histories) {
+			t.Errorf("expected the whole changelog of `%s` (%d histories)", k, len(want.Histories))
+		}
+		if len(i.Fields.Comments.Comments) != len(want.Comments) {
+			t.Errorf("expected the %d comments of `%s`, got %d", len(want.Comments), k, len(i.Fields.Comments.Comments))
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package jiratest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// query is a parsed JQL query. Only a subset of JQL is supported:
+// clauses on the fields of `clauseValues` joined by `AND`, with the
+// `=`, `!=`, `>`, `>=`, `<`, `<=`, `IN` and `NOT IN` operators,
+// followed by an optional `ORDER BY` on `created`, `updated`,
+// `resolved` or `key`. Without `ORDER BY`, issues are sorted by
+// creation.
+type query struct {
+	clauses []clause
+	orders  []order
+}
+
+type clause struct {
+	field  string
+	op     string
+	values []string
+}
+
+type order struct {
+	field string
+	desc  bool
+}
+
+// clauseValues returns the values of the issue's field compared by
+// clauses (several for multi-valued fields, none if empty).
+func clauseValues(i *Issue, field string) ([]string, bool) {
+	switch field {
+	case "project":
+		return []string{i.Project}, true
+	case "key", "issuekey":
+		return []string{i.Key}, true
+	case "status":
+		return []string{i.Status}, true
+	case "type", "issuetype":
+		return []string{i.Type}, true
+	case "priority":
+		return []string{i.Priority}, true
+	case "assignee":
+		if i.Assignee == nil {
+			return nil, true
+		}
+		return []string{i.Assignee.AccountID}, true
+	case "reporter":
+		return []string{i.Reporter.AccountID}, true
+	case "labels":
+		return i.Labels, true
+	case "sprint":
+		vs := make([]string, 0, len(i.Sprints))
+		for _, id := range i.Sprints {
+			vs = append(vs, strconv.Itoa(id))
+		}
+		return vs, true
+	}
+	return nil, false
+}
+
+// timeValue returns the time of the issue's date field.
+func timeValue(i *Issue, field string) (*time.Time, bool) {
+	switch field {
+	case "created":
+		return &i.Created, true
+	case "updated":
+		return &i.Updated, true
+	case "resolved", "resolutiondate":
+		return i.Resolved, true
+	}
+	return nil, false
+}
+
+// jqlTimeLayouts are the accepted layouts of times in JQL queries,
+// in UTC.
+var jqlTimeLayouts = []string{"2006/1/2 15:4", "2006-1-2 15:4", "2006/1/2", "2006-1-2"}
+
+func parseJQLTime(s string) (time.Time, error) {
+	for _, l := range jqlTimeLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("date value `%s` for field is invalid", s)
+}
+
+// parseQuery parses the JQL query.
+func parseQuery(jql string) (*query, error) {
+	ts, err := tokenize(jql)
+	if err != nil {
+		return nil, err
+	}
+	var q query
+	n := 0
+	next := func() string {
+		if n >= len(ts) {
+			return ""
+		}
+		n++
+		return ts[n-1]
+	}
+	peek := func() string {
+		if n >= len(ts) {
+			return ""
+		}
+		return ts[n]
+	}
+	for n < len(ts) && !strings.EqualFold(peek(), "ORDER") {
+		if len(q.clauses) > 0 {
+			if !strings.EqualFold(next(), "AND") {
+				return nil, fmt.Errorf("only `AND` is supported between clauses")
+			}
+		}
+		c := clause{field: strings.ToLower(next()), op: strings.ToUpper(next())}
+		if c.op == "NOT" {
+			if !strings.EqualFold(next(), "IN") {
+				return nil, fmt.Errorf("expected `IN` after `NOT`")
+			}
+			c.op = "NOT IN"
+		}
+		switch c.op {
+		case "=", "!=", ">", ">=", "<", "<=":
+			c.values = []string{next()}
+		case "IN", "NOT IN":
+			if next() != "(" {
+				return nil, fmt.Errorf("expected `(` after `%s`", c.op)
+			}
+			for {
+				c.values = append(c.values, next())
+				sep := next()
+				if sep == ")" {
+					break
+				}
+				if sep != "," {
+					return nil, fmt.Errorf("expected `,` or `)` in list")
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported operator `%s`", c.op)
+		}
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+		q.clauses = append(q.clauses, c)
+	}
+	if n < len(ts) {
+		next()
+		if !strings.EqualFold(next(), "BY") {
+			return nil, fmt.Errorf("expected `BY` after `ORDER`")
+		}
+		for n < len(ts) {
+			o := order{field: strings.ToLower(next())}
+			switch o.field {
+			case "created", "updated", "resolved", "key":
+			default:
+				return nil, fmt.Errorf("unsupported order field `%s`", o.field)
+			}
+			switch strings.ToUpper(peek()) {
+			case "DESC":
+				o.desc = true
+				next()
+			case "ASC":
+				next()
+			}
+			q.orders = append(q.orders, o)
+			if peek() == "," {
+				next()
+			}
+		}
+	}
+	return &q, nil
+}
+
+func (c clause) validate() error {
+	if _, ok := timeValue(&Issue{}, c.field); ok {
+		for _, v := range c.values {
+			if _, err := parseJQLTime(v); err != nil && !strings.EqualFold(v, "EMPTY") {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, ok := clauseValues(&Issue{}, c.field); !ok {
+		return fmt.Errorf("field `%s` is not supported", c.field)
+	}
+	switch c.op {
+	case "=", "!=", "IN", "NOT IN":
+		return nil
+	}
+	return fmt.Errorf("operator `%s` is not supported for field `%s`", c.op, c.field)
+}
+
+// matches returns true if the issue matches all clauses.
+func (q *query) matches(i *Issue) bool {
+	for _, c := range q.clauses {
+		if !c.matches(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c clause) matches(i *Issue) bool {
+	if t, ok := timeValue(i, c.field); ok {
+		if strings.EqualFold(c.values[0], "EMPTY") {
+			return (t == nil) == (c.op == "=")
+		}
+		if t == nil {
+			return false
+		}
+		v, _ := parseJQLTime(c.values[0])
+		switch c.op {
+		case "=":
+			return t.Equal(v)
+		case "!=":
+			return !t.Equal(v)
+		case ">":
+			return t.After(v)
+		case ">=":
+			return !t.Before(v)
+		case "<":
+			return t.Before(v)
+		case "<=":
+			return !t.After(v)
+		}
+		return false
+	}
+	ivs, _ := clauseValues(i, c.field)
+	found := false
+	for _, v := range c.values {
+		if strings.EqualFold(v, "EMPTY") && len(ivs) == 0 {
+			found = true
+		}
+		for _, iv := range ivs {
+			if strings.EqualFold(iv, v) {
+				found = true
+			}
+		}
+	}
+	if c.op == "!=" || c.op == "NOT IN" {
+		return !found
+	}
+	return found
+}
+
+// sort sorts the issues as specified by the query.
+func (q *query) sort(is []*Issue) {
+	sort.SliceStable(is, func(a, b int) bool {
+		for _, o := range q.orders {
+			c := compareIssues(is[a], is[b], o.field)
+			if o.desc {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
+
+func compareIssues(a *Issue, b *Issue, field string) int {
+	if field == "key" {
+		pa, na := splitKey(a.Key)
+		pb, nb := splitKey(b.Key)
+		switch {
+		case pa != pb:
+			return strings.Compare(pa, pb)
+		case na < nb:
+			return -1
+		case na > nb:
+			return 1
+		}
+		return 0
+	}
+	ta, _ := timeValue(a, field)
+	tb, _ := timeValue(b, field)
+	switch {
+	case ta == nil && tb == nil:
+		return 0
+	case ta == nil:
+		return 1
+	case tb == nil:
+		return -1
+	case ta.Before(*tb):
+		return -1
+	case ta.After(*tb):
+		return 1
+	}
+	return 0
+}
+
+func splitKey(k string) (string, int) {
+	i := strings.LastIndex(k, "-")
+	if i < 0 {
+		return k, 0
+	}
+	n, _ := strconv.Atoi(k[i+1:])
+	return k[:i], n
+}
+
+// tokenize splits the JQL query into words, quoted strings (without
+// quotes), operators, parentheses and commas.
+func tokenize(jql string) ([]string, error) {
+	var ts []string
+	rs := []rune(jql)
+	for n := 0; n < len(rs); {
+		r := rs[n]
+		switch {
+		case unicode.IsSpace(r):
+			n++
+		case r == '"' || r == '\'':
+			end := n + 1
+			var b strings.Builder
+			for ; end < len(rs) && rs[end] != r; end++ {
+				if rs[end] == '\\' && end+1 < len(rs) {
+					end++
+				}
+				b.WriteRune(rs[end])
+			}
+			if end >= len(rs) {
+				return nil, fmt.Errorf("unterminated string in query")
+			}
+			ts = append(ts, b.String())
+			n = end + 1
+		case r == '(' || r == ')' || r == ',':
+			ts = append(ts, string(r))
+			n++
+		case strings.ContainsRune("=!<>~", r):
+			end := n + 1
+			if end < len(rs) && rs[end] == '=' {
+				end++
+			}
+			ts = append(ts, string(rs[n:end]))
+			n = end
+		default:
+			end := n
+			for end < len(rs) && !unicode.IsSpace(rs[end]) && !strings.ContainsRune("\"'(),=!<>~", rs[end]) {
+				end++
+			}
+			ts = append(ts, string(rs[n:end]))
+			n = end
+		}
+	}
+	return ts, nil
+}
@@ -0,0 +1,461 @@
+package jiratest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+)
+
+// User is a Jira user of the fake server.
+type User struct {
+	AccountID   string
+	DisplayName string
+}
+
+// Status is a workflow status of the fake server. `Category` is
+// the name of its category (`To Do`, `In Progress` or `Done`).
+type Status struct {
+	ID       string
+	Name     string
+	Category string
+}
+
+// DefaultStatuses are the statuses of a new server.
+var DefaultStatuses = []Status{
+	{ID: "1", Name: "Open", Category: "To Do"},
+	{ID: "3", Name: "In Progress", Category: "In Progress"},
+	{ID: "10000", Name: "In Review", Category: "In Progress"},
+	{ID: "10001", Name: "Done", Category: "Done"},
+}
+
+// Field is an issue field of the fake server, returned by the
+// `/field` endpoint.
+type Field struct {
+	ID     string
+	Name   string
+	Schema jira.FieldSchema
+}
+
+// DefaultFields are the fields of a new server.
+var DefaultFields = []Field{
+	{ID: "summary", Name: "Summary", Schema: jira.FieldSchema{Type: "string", System: "summary"}},
+	{ID: "labels", Name: "Labels", Schema: jira.FieldSchema{Type: "array", Items: "string", System: "labels"}},
+	{ID: "assignee", Name: "Assignee", Schema: jira.FieldSchema{Type: "user", System: "assignee"}},
+	{ID: SprintField, Name: "Sprint", Schema: jira.FieldSchema{Type: "array", Items: "json", Custom: "com.pyxis.greenhopper.jira:gh-sprint"}},
+}
+
+// SprintField is the ID of the custom field containing the sprints
+// of an issue.
+const SprintField = "customfield_10020"
+
+// Issue is an issue of the fake server.
+//
+// The changelog (`Histories`) and `Comments` are in chronological
+// order.
+type Issue struct {
+	ID          string
+	Key         string
+	Project     string
+	Type        string
+	Summary     string
+	Description string
+	Priority    string
+	Status      string // name of the status
+	Reporter    User
+	Assignee    *User
+	Labels      []string
+	Created     time.Time
+	Updated     time.Time
+	Resolved    *time.Time
+	Comments    []Comment
+	Histories   []History
+	Sprints     []int
+	// CustomFields are added to the issue's fields as is (e.g.
+	// `customfield_10600`).
+	CustomFields map[string]interface{}
+}
+
+// Comment is a comment of an issue.
+type Comment struct {
+	ID      string
+	Author  User
+	Body    string
+	Created time.Time
+}
+
+// History is an entry of the changelog of an issue.
+type History struct {
+	ID      string
+	Author  User
+	Created time.Time
+	Items   []HistoryItem
+}
+
+// HistoryItem is a field change of a changelog entry. Empty `From`
+// or `To` values are sent as null.
+type HistoryItem struct {
+	Field      string
+	From       string
+	FromString string
+	To         string
+	ToString   string
+}
+
+// Sprint is a sprint of a board.
+type Sprint struct {
+	ID      int
+	BoardID int
+	Name    string
+	State   string // `future`, `active` or `closed`
+}
+
+// NewIssue describes an issue created with `CreateIssue`. Zero
+// values are replaced by defaults: `Story` type, `Medium` priority,
+// first status of the server.
+type NewIssue struct {
+	Type        string
+	Summary     string
+	Description string
+	Priority    string
+	Status      string
+	Reporter    User
+	Assignee    *User
+	Labels      []string
+}
+
+// Now returns the time of the server's clock. The clock advances by
+// a minute after each change of an issue, so each change has a
+// distinct update time.
+func (s *Server) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.now
+}
+
+// Advance advances the server's clock by `d`.
+func (s *Server) Advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = s.now.Add(d)
+}
+
+// tick returns the time of a change and advances the clock. The
+// caller must hold the lock.
+func (s *Server) tick() time.Time {
+	t := s.now
+	s.now = s.now.Add(time.Minute)
+	return t
+}
+
+// nextID returns a new numeric ID, unique on the server. The caller
+// must hold the lock.
+func (s *Server) nextID() string {
+	s.lastID++
+	return strconv.Itoa(s.lastID)
+}
+
+// CreateIssue creates an issue in the project and returns its key.
+func (s *Server) CreateIssue(project string, ni NewIssue) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projectSeq[project]++
+	t := s.tick()
+	i := &Issue{
+		ID:          s.nextID(),
+		Key:         fmt.Sprintf("%s-%d", project, s.projectSeq[project]),
+		Project:     project,
+		Type:        ni.Type,
+		Summary:     ni.Summary,
+		Description: ni.Description,
+		Priority:    ni.Priority,
+		Status:      ni.Status,
+		Reporter:    ni.Reporter,
+		Assignee:    ni.Assignee,
+		Labels:      ni.Labels,
+		Created:     t,
+		Updated:     t,
+	}
+	if i.Type == "" {
+		i.Type = "Story"
+	}
+	if i.Priority == "" {
+		i.Priority = "Medium"
+	}
+	if i.Status == "" {
+		i.Status = s.statuses[0].Name
+	}
+	s.issues[i.Key] = i
+	return i.Key
+}
+
+// Issue returns a copy of the issue, or nil if it doesn't exist.
+func (s *Server) Issue(key string) *Issue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.issue(key)
+	if err != nil {
+		return nil
+	}
+	c := *i
+	return &c
+}
+
+// Keys returns the keys of the issues, sorted by creation.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	is := s.sortedIssues()
+	ks := make([]string, 0, len(is))
+	for _, i := range is {
+		ks = append(ks, i.Key)
+	}
+	return ks
+}
+
+// issue returns the issue, following moves. The caller must hold the
+// lock.
+func (s *Server) issue(key string) (*Issue, error) {
+	if k, ok := s.moved[key]; ok {
+		key = k
+	}
+	i, ok := s.issues[key]
+	if !ok {
+		return nil, fmt.Errorf("issue `%s` does not exist", key)
+	}
+	return i, nil
+}
+
+// change applies `f` to the issue, records the changelog items it
+// returns (if any) and updates the issue's update time.
+func (s *Server) change(key string, author User, f func(i *Issue, t time.Time) ([]HistoryItem, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.issue(key)
+	if err != nil {
+		return err
+	}
+	t := s.tick()
+	items, err := f(i, t)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		i.Histories = append(i.Histories, History{ID: s.nextID(), Author: author, Created: t, Items: items})
+	}
+	i.Updated = t
+	return nil
+}
+
+// Edit applies `edit` to the issue (e.g. to change its summary or
+// labels), without changelog entry, and updates it.
+func (s *Server) Edit(key string, edit func(i *Issue)) error {
+	return s.change(key, User{}, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		edit(i)
+		return nil, nil
+	})
+}
+
+// Transition changes the status of the issue. The issue is resolved
+// when it enters a status of the `Done` category, and unresolved when
+// it leaves it.
+func (s *Server) Transition(key string, status string, author User) error {
+	return s.change(key, author, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		from, ok := s.status(i.Status)
+		if !ok {
+			return nil, fmt.Errorf("unknown status `%s`", i.Status)
+		}
+		to, ok := s.status(status)
+		if !ok {
+			return nil, fmt.Errorf("unknown status `%s`", status)
+		}
+		i.Status = to.Name
+		if to.Category == "Done" {
+			i.Resolved = &t
+		} else {
+			i.Resolved = nil
+		}
+		return []HistoryItem{{Field: "status", From: from.ID, FromString: from.Name, To: to.ID, ToString: to.Name}}, nil
+	})
+}
+
+// Assign changes the assignee of the issue (nil to unassign).
+func (s *Server) Assign(key string, assignee *User, author User) error {
+	return s.change(key, author, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		item := HistoryItem{Field: "assignee"}
+		if i.Assignee != nil {
+			item.From, item.FromString = i.Assignee.AccountID, i.Assignee.DisplayName
+		}
+		if assignee != nil {
+			item.To, item.ToString = assignee.AccountID, assignee.DisplayName
+		}
+		i.Assignee = assignee
+		return []HistoryItem{item}, nil
+	})
+}
+
+// AddComment adds a comment to the issue.
+func (s *Server) AddComment(key string, body string, author User) error {
+	return s.change(key, author, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		i.Comments = append(i.Comments, Comment{ID: s.nextID(), Author: author, Body: body, Created: t})
+		return nil, nil
+	})
+}
+
+// Move moves the issue to another project and returns its new key.
+// As with Jira, the issue can still be fetched with its previous
+// key.
+func (s *Server) Move(key string, project string, author User) (string, error) {
+	var newKey string
+	err := s.change(key, author, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		s.projectSeq[project]++
+		newKey = fmt.Sprintf("%s-%d", project, s.projectSeq[project])
+		items := []HistoryItem{
+			{Field: "project", From: i.Project, FromString: i.Project, To: project, ToString: project},
+			{Field: "Key", FromString: i.Key, ToString: newKey},
+		}
+		delete(s.issues, i.Key)
+		for old, k := range s.moved {
+			if k == i.Key {
+				s.moved[old] = newKey
+			}
+		}
+		s.moved[i.Key] = newKey
+		i.Key = newKey
+		i.Project = project
+		s.issues[newKey] = i
+		return items, nil
+	})
+	return newKey, err
+}
+
+// Delete deletes the issue.
+func (s *Server) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, err := s.issue(key)
+	if err != nil {
+		return err
+	}
+	delete(s.issues, i.Key)
+	for old, k := range s.moved {
+		if k == i.Key {
+			delete(s.moved, old)
+		}
+	}
+	return nil
+}
+
+// AddSprint adds a sprint to the board (created if needed) and
+// returns its ID.
+func (s *Server) AddSprint(boardID int, name string, state string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, _ := strconv.Atoi(s.nextID())
+	s.sprints = append(s.sprints, Sprint{ID: id, BoardID: boardID, Name: name, State: state})
+	return id
+}
+
+// AddToSprint adds the issue to the sprint.
+func (s *Server) AddToSprint(key string, sprintID int, author User) error {
+	return s.change(key, author, func(i *Issue, t time.Time) ([]HistoryItem, error) {
+		sp, ok := s.sprint(sprintID)
+		if !ok {
+			return nil, fmt.Errorf("sprint `%d` does not exist", sprintID)
+		}
+		from := s.sprintNames(i.Sprints)
+		i.Sprints = append(i.Sprints, sprintID)
+		return []HistoryItem{{
+			Field:      "Sprint",
+			FromString: from,
+			To:         strconv.Itoa(sp.ID),
+			ToString:   s.sprintNames(i.Sprints),
+		}}, nil
+	})
+}
+
+// AddStatus adds a workflow status, replacing the status with the
+// same name if any.
+func (s *Server) AddStatus(st Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addStatus(st)
+}
+
+func (s *Server) addStatus(st Status) {
+	for n := range s.statuses {
+		if s.statuses[n].Name == st.Name {
+			s.statuses[n] = st
+			return
+		}
+	}
+	s.statuses = append(s.statuses, st)
+}
+
+// AddField adds an issue field, replacing the field with the same
+// ID if any.
+func (s *Server) AddField(f Field) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addField(f)
+}
+
+func (s *Server) addField(f Field) {
+	for n := range s.fields {
+		if s.fields[n].ID == f.ID {
+			s.fields[n] = f
+			return
+		}
+	}
+	s.fields = append(s.fields, f)
+}
+
+// status returns the status with the specified name. The caller must
+// hold the lock.
+func (s *Server) status(name string) (Status, bool) {
+	for _, st := range s.statuses {
+		if st.Name == name {
+			return st, true
+		}
+	}
+	return Status{}, false
+}
+
+// sprint returns the sprint with the specified ID. The caller must
+// hold the lock.
+func (s *Server) sprint(id int) (Sprint, bool) {
+	for _, sp := range s.sprints {
+		if sp.ID == id {
+			return sp, true
+		}
+	}
+	return Sprint{}, false
+}
+
+func (s *Server) sprintNames(ids []int) string {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if sp, ok := s.sprint(id); ok {
+			names = append(names, sp.Name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// sortedIssues returns the issues sorted by ID. The caller must hold
+// the lock.
+func (s *Server) sortedIssues() []*Issue {
+	is := make([]*Issue, 0, len(s.issues))
+	for _, i := range s.issues {
+		is = append(is, i)
+	}
+	sort.Slice(is, func(a, b int) bool {
+		ia, _ := strconv.Atoi(is[a].ID)
+		ib, _ := strconv.Atoi(is[b].ID)
+		return ia < ib
+	})
+	return is
+}
@@ -0,0 +1,583 @@
+// Package jiratest provides a fake Jira server for end-to-end
+// tests, to use with `net/http/httptest`:
+//
+//	js := jiratest.NewServer()
+//	srv := httptest.NewServer(js)
+//	defer srv.Close()
+//	c, _ := client.NewAPIClientForURL(srv.URL)
+//
+// The server keeps an in-memory model of issues, which tests change
+// between sync runs (see `CreateIssue`, `Transition`, `Move`,
+// `Delete`...). It implements the subset of the Jira REST API used
+// by the application:
+//
+//   - `/rest/api/2/search`, with a subset of JQL (see `query`),
+//   - `/rest/api/2/issue/{key}`, with the first page of the
+//     changelog and comments embedded,
+//   - `/rest/api/2/issue/{key}/changelog` for the whole changelog
+//     (oldest entries first) and `/rest/api/2/issue/{key}/comment`
+//     for the next pages of comments,
+//   - `/rest/api/2/field` and `/rest/api/2/status`,
+//   - `/rest/agile/1.0/board`, `/rest/agile/1.0/board/{id}/sprint`,
+//     `/rest/agile/1.0/sprint/{id}` and
+//     `/rest/agile/1.0/sprint/{id}/issue`.
+//
+// Faults (rate limiting, server errors, truncated payloads) can be
+// injected with `InjectFault`.
+package jiratest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jiraTimeLayout is the layout of times in Jira API responses.
+const jiraTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// Server is a fake Jira server, implementing `http.Handler`. Its
+// zero value is not usable, use `NewServer`.
+type Server struct {
+	// MaxResults is the maximum size of the pages returned by the
+	// search, changelog, comment and sprint endpoints (default: 50).
+	// Requested page sizes above it are reduced, as Jira does.
+	MaxResults int
+	// EmbeddedPageSize is the number of changelog entries and
+	// comments embedded in an issue (default: 100). The next ones are
+	// fetched from the changelog and comment endpoints.
+	EmbeddedPageSize int
+	// Latency is waited before responding to each request, to
+	// simulate the latency of a real Jira server.
+	Latency time.Duration
+
+	mu         sync.Mutex
+	now        time.Time
+	lastID     int
+	issues     map[string]*Issue
+	moved      map[string]string // previous key => current key
+	projectSeq map[string]int
+	statuses   []Status
+	fields     []Field
+	sprints    []Sprint
+	faults     []*fault
+	requests   map[string]int
+}
+
+// NewServer returns a fake Jira server without issues, with the
+// `DefaultStatuses`, whose clock starts on 2020-01-01 (UTC).
+func NewServer() *Server {
+	return &Server{
+		MaxResults:       50,
+		EmbeddedPageSize: 100,
+		now:              time.Date(2020, 1, 1, 9, 0, 0, 0, time.UTC),
+		lastID:           10000,
+		issues:           make(map[string]*Issue),
+		moved:            make(map[string]string),
+		projectSeq:       make(map[string]int),
+		statuses:         append([]Status{}, DefaultStatuses...),
+		fields:           append([]Field{}, DefaultFields...),
+		requests:         make(map[string]int),
+	}
+}
+
+// Fault describes a fault injected in the responses of the server.
+type Fault struct {
+	// Path is a regular expression matched against the request path
+	// (e.g. `/issue/`). Empty matches all requests.
+	Path string
+	// Status is the status of the response (e.g. 429, 503). The
+	// normal status is used if zero.
+	Status int
+	// RetryAfter is the `Retry-After` header (in seconds) of 429
+	// responses.
+	RetryAfter int
+	// Truncate sends only the first half of the normal response body.
+	Truncate bool
+	// Times is the number of matching requests affected by the
+	// fault, 1 if zero. -1 affects all of them.
+	Times int
+}
+
+type fault struct {
+	Fault
+	path *regexp.Regexp
+	left int
+}
+
+// InjectFault injects a fault in the next responses to the matching
+// requests. Faults are applied in the order they were injected, one
+// per request.
+func (s *Server) InjectFault(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ft := &fault{Fault: f, path: regexp.MustCompile(f.Path), left: f.Times}
+	if ft.left == 0 {
+		ft.left = 1
+	}
+	s.faults = append(s.faults, ft)
+}
+
+// Requests returns the number of requests received for the path
+// (e.g. `/rest/api/2/search`), including the faulty ones.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// takeFault returns the fault to apply to the request, if any.
+func (s *Server) takeFault(path string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[path]++
+	for n, f := range s.faults {
+		if !f.path.MatchString(path) {
+			continue
+		}
+		if f.left > 0 {
+			f.left--
+		}
+		if f.left == 0 {
+			s.faults = append(s.faults[:n], s.faults[n+1:]...)
+		}
+		return &f.Fault
+	}
+	return nil
+}
+
+var (
+	issuePath           = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)$`)
+	issueChangelogPath  = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/changelog$`)
+	issueCommentPath    = regexp.MustCompile(`^/rest/api/2/issue/([^/]+)/comment$`)
+	boardSprintPath     = regexp.MustCompile(`^/rest/agile/1.0/board/(\d+)/sprint$`)
+	sprintPath          = regexp.MustCompile(`^/rest/agile/1.0/sprint/(\d+)$`)
+	sprintIssuePath     = regexp.MustCompile(`^/rest/agile/1.0/sprint/(\d+)/issue$`)
+	errIssueDoesntExist = "Issue does not exist or you do not have permission to see it."
+)
+
+// ServeHTTP implements `http.Handler`.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		time.Sleep(s.Latency)
+	}
+	f := s.takeFault(r.URL.Path)
+	if f != nil && f.Status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+		writeError(w, f.Status, "Rate limit exceeded.")
+		return
+	}
+	if f != nil && f.Status >= 500 {
+		writeError(w, f.Status, http.StatusText(f.Status))
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Only GET requests are supported.")
+		return
+	}
+
+	status, body := s.route(r)
+	b, err := json.Marshal(body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if f != nil && f.Status != 0 {
+		status = f.Status
+	}
+	if f != nil && f.Truncate {
+		b = b[:len(b)/2]
+	}
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// route returns the status and body of the response to the request.
+func (s *Server) route(r *http.Request) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := r.URL.Path
+	q := r.URL.Query()
+	switch {
+	case p == "/rest/api/2/search":
+		return s.search(q.Get("jql"), q)
+	case p == "/rest/api/2/status":
+		return http.StatusOK, s.statusesJSON()
+	case p == "/rest/api/2/field":
+		return http.StatusOK, s.fieldsJSON()
+	case p == "/rest/agile/1.0/board":
+		return http.StatusOK, s.boardsJSON(q)
+	}
+	if m := issuePath.FindStringSubmatch(p); m != nil {
+		i, err := s.issue(m[1])
+		if err != nil {
+			return errorBody(http.StatusNotFound, errIssueDoesntExist)
+		}
+		return http.StatusOK, s.issueJSON(i, fieldsParam(q), strings.Contains(q.Get("expand"), "changelog"))
+	}
+	if m := issueChangelogPath.FindStringSubmatch(p); m != nil {
+		i, err := s.issue(m[1])
+		if err != nil {
+			return errorBody(http.StatusNotFound, errIssueDoesntExist)
+		}
+		start, max := s.page(q)
+		hs := historiesJSON(i.Histories)
+		// Unlike the embedded changelog, the endpoint returns the
+		// oldest entries first
+		for a, b := 0, len(hs)-1; a < b; a, b = a+1, b-1 {
+			hs[a], hs[b] = hs[b], hs[a]
+		}
+		bounds, isLast := pageOf(len(hs), start, max)
+		return http.StatusOK, map[string]interface{}{
+			"startAt": start, "maxResults": max, "total": len(hs), "isLast": isLast,
+			"values": hs[bounds[0]:bounds[1]],
+		}
+	}
+	if m := issueCommentPath.FindStringSubmatch(p); m != nil {
+		i, err := s.issue(m[1])
+		if err != nil {
+			return errorBody(http.StatusNotFound, errIssueDoesntExist)
+		}
+		start, max := s.page(q)
+		return http.StatusOK, commentsJSON(i.Comments, start, max)
+	}
+	if m := boardSprintPath.FindStringSubmatch(p); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		var sps []interface{}
+		for _, sp := range s.sprints {
+			if sp.BoardID == id {
+				sps = append(sps, sprintJSON(sp))
+			}
+		}
+		start, max := s.page(q)
+		bounds, isLast := pageOf(len(sps), start, max)
+		return http.StatusOK, map[string]interface{}{
+			"startAt": start, "maxResults": max, "isLast": isLast,
+			"values": append([]interface{}{}, sps[bounds[0]:bounds[1]]...),
+		}
+	}
+	if m := sprintPath.FindStringSubmatch(p); m != nil {
+		id, _ := strconv.Atoi(m[1])
+		sp, ok := s.sprint(id)
+		if !ok {
+			return errorBody(http.StatusNotFound, "Sprint does not exist.")
+		}
+		return http.StatusOK, sprintJSON(sp)
+	}
+	if m := sprintIssuePath.FindStringSubmatch(p); m != nil {
+		if _, ok := s.sprint(atoi(m[1])); !ok {
+			return errorBody(http.StatusNotFound, "Sprint does not exist.")
+		}
+		jql := "sprint = " + m[1]
+		if extra := q.Get("jql"); extra != "" {
+			jql += " AND " + extra
+		}
+		return s.search(jql, q)
+	}
+	return errorBody(http.StatusNotFound, "Not found.")
+}
+
+// search returns the page of the issues matching the JQL query.
+func (s *Server) search(jql string, q map[string][]string) (int, interface{}) {
+	pq, err := parseQuery(jql)
+	if err != nil {
+		return errorBody(http.StatusBadRequest, fmt.Sprintf("Error in the JQL Query: %s.", err))
+	}
+	var is []*Issue
+	for _, i := range s.sortedIssues() {
+		if pq.matches(i) {
+			is = append(is, i)
+		}
+	}
+	pq.sort(is)
+	start, max := s.page(q)
+	bounds, _ := pageOf(len(is), start, max)
+	fields := fieldsParam(q)
+	issues := make([]interface{}, 0, bounds[1]-bounds[0])
+	for _, i := range is[bounds[0]:bounds[1]] {
+		issues = append(issues, s.issueJSON(i, fields, false))
+	}
+	return http.StatusOK, map[string]interface{}{
+		"expand":     "schema,names",
+		"startAt":    start,
+		"maxResults": max,
+		"total":      len(is),
+		"issues":     issues,
+	}
+}
+
+// page returns the `startAt` and `maxResults` parameters of the
+// request, `maxResults` being limited to `MaxResults`.
+func (s *Server) page(q map[string][]string) (int, int) {
+	get := func(k string) string {
+		if vs := q[k]; len(vs) > 0 {
+			return vs[0]
+		}
+		return ""
+	}
+	start := atoi(get("startAt"))
+	if start < 0 {
+		start = 0
+	}
+	max := atoi(get("maxResults"))
+	if max <= 0 || max > s.MaxResults {
+		max = s.MaxResults
+	}
+	return start, max
+}
+
+// pageOf returns the bounds of the page in a list of `n` elements,
+// and whether it's the last page.
+func pageOf(n int, start int, max int) ([2]int, bool) {
+	from := start
+	if from > n {
+		from = n
+	}
+	to := from + max
+	if to > n {
+		to = n
+	}
+	return [2]int{from, to}, to >= n
+}
+
+// fieldsParam returns the fields requested by the `fields`
+// parameter, or nil for all fields.
+func fieldsParam(q map[string][]string) map[string]bool {
+	vs := q["fields"]
+	if len(vs) == 0 || vs[0] == "" || vs[0] == "*all" {
+		return nil
+	}
+	fs := make(map[string]bool)
+	for _, f := range strings.Split(vs[0], ",") {
+		fs[strings.TrimSpace(f)] = true
+	}
+	return fs
+}
+
+func (s *Server) issueJSON(i *Issue, fields map[string]bool, changelog bool) map[string]interface{} {
+	self := fmt.Sprintf("https://jira.example.com/rest/api/2/issue/%s", i.ID)
+	fs := map[string]interface{}{
+		"summary":     i.Summary,
+		"description": i.Description,
+		"issuetype":   map[string]interface{}{"name": i.Type, "subtask": false},
+		"project":     map[string]interface{}{"key": i.Project, "name": i.Project},
+		"priority":    map[string]interface{}{"name": i.Priority},
+		"labels":      nonNil(i.Labels),
+		"components":  []interface{}{},
+		"fixVersions": []interface{}{},
+		"created":     i.Created.Format(jiraTimeLayout),
+		"updated":     i.Updated.Format(jiraTimeLayout),
+		"reporter":    userJSON(&i.Reporter),
+		"assignee":    userJSON(i.Assignee),
+		"comment":     commentsJSON(i.Comments, 0, s.EmbeddedPageSize),
+		SprintField:   s.issueSprintsJSON(i),
+	}
+	if i.Resolved != nil {
+		fs["resolutiondate"] = i.Resolved.Format(jiraTimeLayout)
+	} else {
+		fs["resolutiondate"] = nil
+	}
+	if st, ok := s.status(i.Status); ok {
+		fs["status"] = statusJSON(st)
+	}
+	for k, v := range i.CustomFields {
+		fs[k] = v
+	}
+	if fields != nil {
+		for k := range fs {
+			if !fields[k] {
+				delete(fs, k)
+			}
+		}
+	}
+	j := map[string]interface{}{
+		"id":     i.ID,
+		"self":   self,
+		"key":    i.Key,
+		"fields": fs,
+	}
+	if changelog {
+		hs := historiesJSON(i.Histories)
+		bounds, _ := pageOf(len(hs), 0, s.EmbeddedPageSize)
+		j["changelog"] = map[string]interface{}{
+			"startAt":    0,
+			"maxResults": bounds[1],
+			"total":      len(hs),
+			"histories":  hs[bounds[0]:bounds[1]],
+		}
+	}
+	return j
+}
+
+func commentsJSON(cs []Comment, start int, max int) map[string]interface{} {
+	bounds, _ := pageOf(len(cs), start, max)
+	js := make([]interface{}, 0, bounds[1]-bounds[0])
+	for _, c := range cs[bounds[0]:bounds[1]] {
+		js = append(js, map[string]interface{}{
+			"id":      c.ID,
+			"author":  userJSON(&c.Author),
+			"body":    c.Body,
+			"created": c.Created.Format(jiraTimeLayout),
+			"updated": c.Created.Format(jiraTimeLayout),
+		})
+	}
+	return map[string]interface{}{
+		"startAt":    start,
+		"maxResults": max,
+		"total":      len(cs),
+		"comments":   js,
+	}
+}
+
+// historiesJSON returns the changelog entries, the most recent
+// first, as embedded in issues.
+func historiesJSON(hs []History) []interface{} {
+	js := make([]interface{}, 0, len(hs))
+	for n := len(hs) - 1; n >= 0; n-- {
+		h := hs[n]
+		items := make([]interface{}, 0, len(h.Items))
+		for _, it := range h.Items {
+			items = append(items, map[string]interface{}{
+				"field":      it.Field,
+				"fieldtype":  "jira",
+				"from":       nullable(it.From),
+				"fromString": nullable(it.FromString),
+				"to":         nullable(it.To),
+				"toString":   nullable(it.ToString),
+			})
+		}
+		js = append(js, map[string]interface{}{
+			"id":      h.ID,
+			"author":  userJSON(&h.Author),
+			"created": h.Created.Format(jiraTimeLayout),
+			"items":   items,
+		})
+	}
+	return js
+}
+
+func (s *Server) issueSprintsJSON(i *Issue) interface{} {
+	if len(i.Sprints) == 0 {
+		return nil
+	}
+	js := make([]interface{}, 0, len(i.Sprints))
+	for _, id := range i.Sprints {
+		if sp, ok := s.sprint(id); ok {
+			js = append(js, sprintJSON(sp))
+		}
+	}
+	return js
+}
+
+func (s *Server) statusesJSON() []interface{} {
+	js := make([]interface{}, 0, len(s.statuses))
+	for _, st := range s.statuses {
+		js = append(js, statusJSON(st))
+	}
+	return js
+}
+
+func (s *Server) boardsJSON(q map[string][]string) map[string]interface{} {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, sp := range s.sprints {
+		if !seen[sp.BoardID] {
+			seen[sp.BoardID] = true
+			ids = append(ids, sp.BoardID)
+		}
+	}
+	start, max := s.page(q)
+	bounds, isLast := pageOf(len(ids), start, max)
+	bs := make([]interface{}, 0, bounds[1]-bounds[0])
+	for _, id := range ids[bounds[0]:bounds[1]] {
+		bs = append(bs, map[string]interface{}{"id": id, "name": fmt.Sprintf("Board %d", id), "type": "scrum"})
+	}
+	return map[string]interface{}{
+		"startAt": start, "maxResults": max, "total": len(ids), "isLast": isLast,
+		"values": bs,
+	}
+}
+
+func statusJSON(st Status) map[string]interface{} {
+	keys := map[string]string{"To Do": "new", "In Progress": "indeterminate", "Done": "done"}
+	return map[string]interface{}{
+		"id":   st.ID,
+		"name": st.Name,
+		"statusCategory": map[string]interface{}{
+			"key":  keys[st.Category],
+			"name": st.Category,
+		},
+	}
+}
+
+func sprintJSON(sp Sprint) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            sp.ID,
+		"name":          sp.Name,
+		"state":         sp.State,
+		"originBoardId": sp.BoardID,
+	}
+}
+
+func userJSON(u *User) interface{} {
+	if u == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"accountId":   u.AccountID,
+		"displayName": u.DisplayName,
+		"active":      true,
+	}
+}
+
+func (s *Server) fieldsJSON() []interface{} {
+	js := make([]interface{}, 0, len(s.fields))
+	for _, f := range s.fields {
+		js = append(js, map[string]interface{}{
+			"id":     f.ID,
+			"name":   f.Name,
+			"custom": strings.HasPrefix(f.ID, "customfield_"),
+			"schema": f.Schema,
+		})
+	}
+	return js
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nonNil(ss []string) []string {
+	if ss == nil {
+		return []string{}
+	}
+	return ss
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func errorBody(status int, msg string) (int, interface{}) {
+	return status, map[string]interface{}{
+		"errorMessages": []string{msg},
+		"errors":        map[string]string{},
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	_, body := errorBody(status, msg)
+	b, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(b)
+}
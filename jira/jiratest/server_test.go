@@ -0,0 +1,226 @@
+package jiratest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+)
+
+var alice = jiratest.User{AccountID: "alice", DisplayName: "Alice"}
+
+// get performs a GET request on the server and decodes the response.
+func get(t *testing.T, srv *httptest.Server, path string, v interface{}) int {
+	t.Helper()
+	res, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("unexpected error in `Get`: %s", err)
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatalf("unexpected error decoding `%s`: %s", path, err)
+	}
+	return res.StatusCode
+}
+
+type searchResult struct {
+	StartAt    int `json:"startAt"`
+	MaxResults int `json:"maxResults"`
+	Total      int `json:"total"`
+	Issues     []struct {
+		Key    string                 `json:"key"`
+		Fields map[string]interface{} `json:"fields"`
+	} `json:"issues"`
+}
+
+func (r searchResult) keys() string {
+	ks := make([]string, 0, len(r.Issues))
+	for _, i := range r.Issues {
+		ks = append(ks, i.Key)
+	}
+	return strings.Join(ks, ",")
+}
+
+func TestServer_search(t *testing.T) {
+	js := jiratest.NewServer()
+	js.MaxResults = 2
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	pj1 := js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	js.CreateIssue("OT", jiratest.NewIssue{Reporter: alice})
+	pj3 := js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	must(t, js.Transition(pj1, "Done", alice))
+	must(t, js.Transition(pj3, "Done", alice))
+
+	tests := []struct {
+		jql      string
+		startAt  int
+		total    int
+		expected string
+	}{
+		{`project = PJ ORDER BY created DESC`, 0, 3, "PJ-3,PJ-2"},
+		{`project = PJ ORDER BY created DESC`, 2, 3, "PJ-1"},
+		{`project = "PJ" AND status IN ("Done") ORDER BY updated ASC`, 0, 2, "PJ-1,PJ-3"},
+		{`status != Done AND key NOT IN (PJ-2)`, 0, 1, "OT-1"},
+		{`updated > '2020/1/1 9:3' ORDER BY key`, 0, 2, "PJ-1,PJ-3"},
+		{`created >= "2020-01-01" AND created < "2020-01-02" AND resolved = EMPTY`, 0, 2, "PJ-2,OT-1"},
+	}
+	for _, test := range tests {
+		var r searchResult
+		q := url.Values{"jql": {test.jql}, "startAt": {strconv.Itoa(test.startAt)}, "maxResults": {"100"}}
+		if status := get(t, srv, "/rest/api/2/search?"+q.Encode(), &r); status != http.StatusOK {
+			t.Fatalf("unexpected status %d for `%s`", status, test.jql)
+		}
+		if r.Total != test.total || r.MaxResults != 2 || r.keys() != test.expected {
+			t.Errorf("unexpected result for `%s`: %d issues `%s`, expected %d `%s`", test.jql, r.Total, r.keys(), test.total, test.expected)
+		}
+	}
+
+	// Only the requested fields are returned
+	var r searchResult
+	get(t, srv, "/rest/api/2/search?"+url.Values{"jql": {"key = PJ-1"}, "fields": {"updated"}}.Encode(), &r)
+	if len(r.Issues) != 1 || len(r.Issues[0].Fields) != 1 || r.Issues[0].Fields["updated"] == nil {
+		t.Errorf("expected only the `updated` field, got `%+v`", r.Issues)
+	}
+
+	// Invalid queries are rejected as Jira does
+	var e struct {
+		ErrorMessages []string `json:"errorMessages"`
+	}
+	if status := get(t, srv, "/rest/api/2/search?jql="+url.QueryEscape("summary ~ foo"), &e); status != http.StatusBadRequest || len(e.ErrorMessages) != 1 {
+		t.Errorf("expected a 400 error, got %d `%v`", status, e.ErrorMessages)
+	}
+}
+
+func TestServer_issue(t *testing.T) {
+	js := jiratest.NewServer()
+	js.MaxResults = 2
+	js.EmbeddedPageSize = 2
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+	c, err := client.NewAPIClientForURL(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+
+	k := js.CreateIssue("PJ", jiratest.NewIssue{Summary: "Summary", Reporter: alice})
+	for _, st := range []string{"In Progress", "In Review", "Done", "Open", "Done"} {
+		must(t, js.Transition(k, st, alice))
+	}
+	for _, body := range []string{"1", "2", "3", "4", "5"} {
+		must(t, js.AddComment(k, body, alice))
+	}
+
+	// The changelog and comments are completed from their endpoints
+	i, err := c.GetIssue(k)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssue`: %s", err)
+	}
+	if i.Fields.Summary != "Summary" || i.Fields.Status.Name != "Done" || time.Time(i.Fields.Resolutiondate).IsZero() {
+		t.Errorf("unexpected fields `%+v`", i.Fields)
+	}
+	if len(i.Changelog.Histories) != 5 || i.Changelog.Histories[0].Items[0].ToString != "Done" || i.Changelog.Histories[4].Items[0].ToString != "In Progress" {
+		t.Errorf("unexpected changelog `%+v`", i.Changelog.Histories)
+	}
+	if len(i.Fields.Comments.Comments) != 5 || i.Fields.Comments.Comments[4].Body != "5" {
+		t.Errorf("unexpected comments `%+v`", i.Fields.Comments.Comments)
+	}
+	if n := js.Requests("/rest/api/2/issue/PJ-1/changelog"); n != 3 {
+		t.Errorf("expected the whole changelog to be requested in 3 pages, got %d", n)
+	}
+
+	// A moved issue can be fetched with its previous key
+	nk, err := js.Move(k, "OT", alice)
+	must(t, err)
+	if i, err := c.GetIssue(k); err != nil || i.Key != nk || i.Fields.Project.Name != "OT" {
+		t.Errorf("unexpected moved issue `%+v` (%v)", i, err)
+	}
+
+	must(t, js.Delete(nk))
+	if _, err := c.GetIssue(nk); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error for a deleted issue, got `%v`", err)
+	}
+}
+
+func TestServer_faults(t *testing.T) {
+	js := jiratest.NewServer()
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+	c, err := client.NewAPIClientForURL(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+	k := js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	path := "/rest/api/2/issue/" + k
+
+	// Rate limited requests are retried by the client
+	js.InjectFault(jiratest.Fault{Path: "/issue/", Status: http.StatusTooManyRequests, Times: 2})
+	if _, err := c.GetIssue(k); err != nil {
+		t.Errorf("unexpected error after rate limiting: %s", err)
+	}
+	if n := js.Requests(path); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+
+	js.InjectFault(jiratest.Fault{Path: "/issue/", Status: http.StatusBadGateway})
+	if _, err := c.GetIssue(k); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected a 502 error, got `%v`", err)
+	}
+
+	js.InjectFault(jiratest.Fault{Path: "/issue/", Truncate: true})
+	if _, err := c.GetIssue(k); err == nil {
+		t.Errorf("expected an error for a truncated payload")
+	}
+
+	// Faults are consumed
+	if _, err := c.GetIssue(k); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestServer_sprints(t *testing.T) {
+	js := jiratest.NewServer()
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	k := js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	js.CreateIssue("PJ", jiratest.NewIssue{Reporter: alice})
+	s1 := js.AddSprint(1, "Sprint 1", "closed")
+	js.AddSprint(1, "Sprint 2", "active")
+	must(t, js.AddToSprint(k, s1, alice))
+
+	var sprints struct {
+		IsLast bool `json:"isLast"`
+		Values []struct {
+			ID    int    `json:"id"`
+			Name  string `json:"name"`
+			State string `json:"state"`
+		} `json:"values"`
+	}
+	get(t, srv, "/rest/agile/1.0/board/1/sprint", &sprints)
+	if !sprints.IsLast || len(sprints.Values) != 2 || sprints.Values[0].ID != s1 || sprints.Values[1].State != "active" {
+		t.Errorf("unexpected sprints `%+v`", sprints)
+	}
+
+	var r searchResult
+	get(t, srv, "/rest/agile/1.0/sprint/"+strconv.Itoa(s1)+"/issue", &r)
+	if r.keys() != k || r.Issues[0].Fields[jiratest.SprintField] == nil {
+		t.Errorf("unexpected sprint issues `%+v`", r.Issues)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -7,7 +7,12 @@ import (
 
 // Mapper transforms Jira issues into states and events
 // records.
+//
+// `IssueEventsFromIssue` returns an error if the issue can't be
+// mapped (e.g. invalid comment or changelog time).
 type Mapper interface {
-	IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent
+	IssueEventsFromIssue(i *extJira.Issue) ([]store.IssueEvent, error)
 	IssueStateFromIssue(i *extJira.Issue) store.IssueState
+	IssueStatusPeriodsFromEvents(i *extJira.Issue, ies []store.IssueEvent) []store.IssueStatusPeriod
+	UsersFromIssue(i *extJira.Issue) []store.User
 }
@@ -0,0 +1,227 @@
+package mapping
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Custom fields are decoded by `go-jira` as generic JSON values
+// (`map[string]interface{}`, `[]interface{}`, `string`, `float64`...)
+// in `Fields.Unknowns`. The functions in this file convert these
+// values to strings according to the field's schema, without ever
+// panicking: a value that doesn't have the expected shape is
+// reported as a warning for the issue and ignored.
+//
+// Supported schema types are:
+//
+// - `option`: a single select (`{"value": "..."}`)
+// - `option-with-child`: a cascading select, mapped to
+//   `"<parent> / <child>"`
+// - `user`: a user picker, mapped to the user's account ID
+// - `number`, `date`, `datetime`, `string`
+// - `array` of any of the above (e.g. multi-select, multi-user
+//   picker, labels)
+//
+// When the schema of a field is unknown, the type is guessed from
+// the value's shape.
+
+// Schema types of custom field values.
+const (
+	schemaTypeArray           = "array"
+	schemaTypeOption          = "option"
+	schemaTypeOptionWithChild = "option-with-child"
+	schemaTypeUser            = "user"
+	schemaTypeNumber          = "number"
+	schemaTypeDate            = "date"
+	schemaTypeDatetime        = "datetime"
+	schemaTypeString          = "string"
+)
+
+// customFieldStrings returns the values of the specified custom field
+// as strings, e.g. the options of a multi-select or the single value
+// of a select. Returns nil if the field is not set or its value has
+// an unexpected shape.
+func (m *Mapper) customFieldStrings(i *extJira.Issue, field string) []string {
+	if i.Fields == nil {
+		return nil
+	}
+	v := i.Fields.Unknowns[field]
+	if v == nil {
+		return nil
+	}
+	schema, ok := m.fieldSchemas[field]
+	if !ok {
+		schema = guessSchema(v)
+	}
+	values, err := valuesWithSchema(v, schema)
+	if err != nil {
+		warn(i, field, err)
+		return nil
+	}
+	return values
+}
+
+// valuesWithSchema converts `v` to a list of strings according to
+// the specified schema.
+func valuesWithSchema(v interface{}, schema jira.FieldSchema) ([]string, error) {
+	if schema.Type != schemaTypeArray {
+		s, err := valueWithType(v, schema.Type)
+		if err != nil || s == nil {
+			return nil, err
+		}
+		return []string{*s}, nil
+	}
+
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array, got %T", v)
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		itemType := schema.Items
+		if itemType == "" {
+			itemType = guessSchema(item).Type
+		}
+		s, err := valueWithType(item, itemType)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			values = append(values, *s)
+		}
+	}
+	return values, nil
+}
+
+// valueWithType converts a single (non-array) value `v` to a string
+// according to the specified schema type. Returns nil if `v` is nil
+// (e.g. an empty option).
+func valueWithType(v interface{}, t string) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch t {
+	case schemaTypeOption:
+		return optionValue(v)
+
+	case schemaTypeOptionWithChild:
+		parent, err := optionValue(v)
+		if err != nil || parent == nil {
+			return parent, err
+		}
+		child, err := optionValue(v.(map[string]interface{})["child"])
+		if err != nil || child == nil {
+			return parent, err
+		}
+		s := *parent + " / " + *child
+		return &s, nil
+
+	case schemaTypeUser:
+		return userValue(v)
+
+	case schemaTypeNumber:
+		n, ok := v.(float64)
+		if !ok {
+			return nil, fmt.Errorf("expected a number, got %T", v)
+		}
+		s := strconv.FormatFloat(n, 'f', -1, 64)
+		return &s, nil
+
+	case schemaTypeDate:
+		return timeValue(v, "2006-01-02")
+
+	case schemaTypeDatetime:
+		return timeValue(v, "2006-01-02T15:04:05.000-0700")
+
+	case schemaTypeString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", v)
+		}
+		return &s, nil
+
+	default:
+		guessed := guessSchema(v).Type
+		if guessed == schemaTypeArray {
+			return nil, fmt.Errorf("unexpected array for type `%s`", t)
+		}
+		return valueWithType(v, guessed)
+	}
+}
+
+// optionValue returns the `value` of an option object
+// (`{"value": "..."}`). Returns nil if `v` is nil.
+func optionValue(v interface{}) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an option object, got %T", v)
+	}
+	s, ok := o["value"].(string)
+	if !ok {
+		return nil, fmt.Errorf("expected option to have a string `value`, got %T", o["value"])
+	}
+	return &s, nil
+}
+
+// errNotAUser is reported when a value expected to be a user is not
+// a user object.
+var errNotAUser = errors.New("expected a user object with an `accountId`, `name` or `key`")
+
+// userValue returns the account ID of a user object (see
+// `accountID`).
+func userValue(v interface{}) (*string, error) {
+	id := accountID(userFromValue(v))
+	if id == nil {
+		return nil, errNotAUser
+	}
+	return id, nil
+}
+
+// timeValue checks `v` is a string in the specified layout and
+// returns it.
+func timeValue(v interface{}, layout string) (*string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a date string, got %T", v)
+	}
+	if _, err := time.Parse(layout, s); err != nil {
+		return nil, fmt.Errorf("expected a date with layout `%s`, got `%s`", layout, s)
+	}
+	return &s, nil
+}
+
+// guessSchema returns the schema corresponding to the shape of `v`.
+// It's used for fields whose schema is unknown.
+func guessSchema(v interface{}) jira.FieldSchema {
+	switch tv := v.(type) {
+	case []interface{}:
+		return jira.FieldSchema{Type: schemaTypeArray}
+	case float64:
+		return jira.FieldSchema{Type: schemaTypeNumber}
+	case map[string]interface{}:
+		if _, ok := tv["child"]; ok {
+			return jira.FieldSchema{Type: schemaTypeOptionWithChild}
+		}
+		if _, ok := tv["value"]; ok {
+			return jira.FieldSchema{Type: schemaTypeOption}
+		}
+		return jira.FieldSchema{Type: schemaTypeUser}
+	default:
+		return jira.FieldSchema{Type: schemaTypeString}
+	}
+}
+
+// warn reports a warning for the specified issue and field.
+func warn(i *extJira.Issue, field string, err error) {
+	logging.Issue(i.Key, logging.PhaseMap).WithField("field", field).WithError(err).Warn("Unexpected custom field value")
+}
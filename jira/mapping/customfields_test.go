@@ -0,0 +1,71 @@
+package mapping_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+// Custom fields are tested through `IssueStateFromIssue`, using
+// the `Tribes` field (`customfield_12100`).
+func TestIssueStateFromIssue_customFields(t *testing.T) {
+	field := "customfield_12100"
+	tests := []struct {
+		name     string
+		schema   *jira.FieldSchema
+		value    interface{}
+		expected []string
+	}{
+		{"nil", &jira.FieldSchema{Type: "option"}, nil, nil},
+		{"option", &jira.FieldSchema{Type: "option"}, map[string]interface{}{"value": "Tribe A"}, []string{"Tribe A"}},
+		{"option without schema", nil, map[string]interface{}{"value": "Tribe A"}, []string{"Tribe A"}},
+		{"multi-option", &jira.FieldSchema{Type: "array", Items: "option"}, []interface{}{
+			map[string]interface{}{"value": "Tribe A"},
+			map[string]interface{}{"value": "Tribe B"},
+		}, []string{"Tribe A", "Tribe B"}},
+		{"cascading select", &jira.FieldSchema{Type: "option-with-child"}, map[string]interface{}{
+			"value": "Tribe A",
+			"child": map[string]interface{}{"value": "Squad 1"},
+		}, []string{"Tribe A / Squad 1"}},
+		{"user", &jira.FieldSchema{Type: "user"}, map[string]interface{}{"accountId": "5b10a2844c20165700ede21g", "displayName": "John Doe"}, []string{"5b10a2844c20165700ede21g"}},
+		{"user without account ID", &jira.FieldSchema{Type: "user"}, map[string]interface{}{"name": "jdoe"}, []string{"jdoe"}},
+		{"multi-user", &jira.FieldSchema{Type: "array", Items: "user"}, []interface{}{
+			map[string]interface{}{"accountId": "5b10a2844c20165700ede21g"},
+		}, []string{"5b10a2844c20165700ede21g"}},
+		{"number", &jira.FieldSchema{Type: "number"}, float64(3.5), []string{"3.5"}},
+		{"date", &jira.FieldSchema{Type: "date"}, "2018-07-14", []string{"2018-07-14"}},
+		{"datetime", &jira.FieldSchema{Type: "datetime"}, "2018-07-14T10:00:00.000+0200", []string{"2018-07-14T10:00:00.000+0200"}},
+		{"string", &jira.FieldSchema{Type: "string"}, "Tribe A", []string{"Tribe A"}},
+		{"array of strings", &jira.FieldSchema{Type: "array", Items: "string"}, []interface{}{"Tribe A"}, []string{"Tribe A"}},
+		{"empty array", &jira.FieldSchema{Type: "array", Items: "option"}, []interface{}{}, []string{}},
+
+		// Unexpected shapes are ignored (and reported as warnings)
+		{"option with string", &jira.FieldSchema{Type: "option"}, "Tribe A", nil},
+		{"option with null value", &jira.FieldSchema{Type: "option"}, map[string]interface{}{"value": nil}, nil},
+		{"array with object", &jira.FieldSchema{Type: "array", Items: "option"}, map[string]interface{}{"value": "Tribe A"}, nil},
+		{"user without identifier", &jira.FieldSchema{Type: "user"}, map[string]interface{}{"displayName": "John Doe"}, nil},
+		{"number with string", &jira.FieldSchema{Type: "number"}, "3", nil},
+		{"invalid date", &jira.FieldSchema{Type: "date"}, "14/07/2018", nil},
+		{"unknown type with array", &jira.FieldSchema{Type: "any"}, []interface{}{[]interface{}{}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schemas := map[string]jira.FieldSchema{}
+			if tt.schema != nil {
+				schemas[field] = *tt.schema
+			}
+			m := mapping.NewMapper(nil, schemas)
+			i := mockIssue(issueMockDef{"PJ-1", time.Now(), nil, "Open", []changelogMockDef{}})
+			i.Fields.Unknowns = map[string]interface{}{field: tt.value}
+
+			s := m.IssueStateFromIssue(i)
+			if !reflect.DeepEqual(s.Tribes, tt.expected) {
+				t.Errorf("expected tribes %#v, got %#v", tt.expected, s.Tribes)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package mapping
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// FlowConfig classifies statuses, by name, as active (work is done
+// on the issue, e.g. `In Progress`) or waiting (e.g. `Ready for
+// Review`), to compute the flow efficiency of issues. Other statuses
+// (e.g. backlog or done statuses) are not part of the cycle.
+type FlowConfig struct {
+	Active  []string
+	Waiting []string
+}
+
+// FlowConfigFromEnv returns the flow configuration specified by the
+// `FLOW_ACTIVE_STATUSES` and `FLOW_WAITING_STATUSES` environment
+// variables (comma-separated status names). It fails if a status is
+// both active and waiting.
+func FlowConfigFromEnv() (FlowConfig, error) {
+	var c FlowConfig
+	for name, v := range map[string]*[]string{
+		"FLOW_ACTIVE_STATUSES":  &c.Active,
+		"FLOW_WAITING_STATUSES": &c.Waiting,
+	} {
+		for _, s := range strings.Split(os.Getenv(name), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*v = append(*v, s)
+			}
+		}
+	}
+	for _, a := range c.Active {
+		for _, w := range c.Waiting {
+			if a == w {
+				return c, fmt.Errorf("invalid flow configuration: status `%s` is both active and waiting", a)
+			}
+		}
+	}
+	return c, nil
+}
+
+// WithFlowConfig sets the flow configuration used to classify the
+// status periods of issues (see `IssueStatusPeriodsFromEvents`),
+// and returns the mapper.
+func (m *Mapper) WithFlowConfig(c FlowConfig) *Mapper {
+	m.activities = make(map[string]string, len(c.Active)+len(c.Waiting))
+	for _, s := range c.Active {
+		m.activities[s] = store.ActivityActive
+	}
+	for _, s := range c.Waiting {
+		m.activities[s] = store.ActivityWaiting
+	}
+	return m
+}
+
+// activity returns the activity of the status, or nil if it is not
+// classified.
+func (m *Mapper) activity(status string) *string {
+	if a, ok := m.activities[status]; ok {
+		return &a
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package mapping_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/golib/matchers"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+func TestFlowConfigFromEnv(t *testing.T) {
+	os.Setenv("FLOW_ACTIVE_STATUSES", "In Dev, In Review")
+	os.Setenv("FLOW_WAITING_STATUSES", "Ready for Review")
+	defer os.Unsetenv("FLOW_ACTIVE_STATUSES")
+	defer os.Unsetenv("FLOW_WAITING_STATUSES")
+	c, err := mapping.FlowConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := mapping.FlowConfig{Active: []string{"In Dev", "In Review"}, Waiting: []string{"Ready for Review"}}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("expected %+v, got %+v", expected, c)
+	}
+
+	os.Setenv("FLOW_WAITING_STATUSES", "In Review")
+	if _, err := mapping.FlowConfigFromEnv(); err == nil {
+		t.Errorf("expected an error for a status both active and waiting")
+	}
+}
+
+func TestIssueStatusPeriodsFromEvents_flow(t *testing.T) {
+	refTime := time.Now()
+	m := mapping.NewMapper(nil, nil).WithFlowConfig(mapping.FlowConfig{
+		Active:  []string{"In Dev"},
+		Waiting: []string{"In Review"},
+	})
+	i := mockIssue(issueMockDef{
+		"PJ-1",
+		refTime,
+		nil,
+		"In Review",
+		[]changelogMockDef{
+			changelogMockDef{"status", "In Dev", "In Review", refTime.Add(2 * time.Hour)},
+			changelogMockDef{"status", "Open", "In Dev", refTime.Add(1 * time.Hour)},
+		},
+	})
+
+	periods := m.IssueStatusPeriodsFromEvents(i, issueEvents(t, m, i))
+	if len(periods) != 3 {
+		t.Fatalf("expected 3 periods, got %+v", periods)
+	}
+	matchers.MatchStringPtr(t, "period.Activity", nil, periods[0].Activity, i.Key)
+	matchers.MatchStringPtr(t, "period.Activity", strAddr(store.ActivityActive), periods[1].Activity, i.Key)
+	matchers.MatchStringPtr(t, "period.Activity", strAddr(store.ActivityWaiting), periods[2].Activity, i.Key)
+}
@@ -1,12 +1,13 @@
 package mapping
 
 import (
-	"log"
+	"fmt"
 	"sort"
 	"time"
 
 	extJira "github.com/andygrunwald/go-jira"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 )
 
@@ -14,7 +15,32 @@ import (
 // Using an interface and a type with methods is only used to
 // enable dependency-injection for the synchronization functions
 // so they can be tested in isolation from the mapping.
-type Mapper struct{}
+//
+// The zero value is usable, but the mapper will only be able
+// to resolve the category of an issue's current status and will
+// guess the types of custom fields from their values. Use
+// `NewMapper` to resolve categories for all known statuses and
+// use the fields' schemas.
+type Mapper struct {
+	statuses     map[string]store.Status
+	fieldSchemas map[string]jira.FieldSchema
+	activities   map[string]string
+}
+
+// NewMapper returns a `Mapper` using the specified statuses
+// (as fetched from Jira's `/status` endpoint) to resolve
+// status categories, and the specified field schemas (indexed
+// by field ID) to extract custom fields values.
+func NewMapper(statuses []store.Status, fieldSchemas map[string]jira.FieldSchema) *Mapper {
+	m := Mapper{
+		statuses:     make(map[string]store.Status),
+		fieldSchemas: fieldSchemas,
+	}
+	for _, s := range statuses {
+		m.statuses[s.ID] = s
+	}
+	return &m
+}
 
 // IssueEventsFromIssue generates and returns the `IssueEvent`
 // records corresponding to the passed issue.
@@ -25,13 +51,17 @@ type Mapper struct{}
 // - `status_changed`: for each status change in the issue's changelogs
 // - `assignee_changed`: idem, for assignee changes
 // - `comment_added`: for each comment in the issue
-func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
+//
+// Returns an error if the time of a comment or changelog can't be
+// parsed.
+func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) ([]store.IssueEvent, error) {
 	issueEvents := make([]store.IssueEvent, 0)
 
 	issueEvents = append(issueEvents, store.IssueEvent{
 		EventTime:          time.Time(i.Fields.Created),
 		EventKind:          "created",
-		EventAuthor:        requiredString(reporterName(i)),
+		EventAuthor:        requiredString(accountID(i.Fields.Reporter)),
+		EventAuthorName:    displayName(i.Fields.Reporter),
 		IssueKey:           i.Key,
 		CommentBody:        nil,
 		StatusChangeFrom:   nil,
@@ -42,10 +72,15 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 
 	if i.Fields.Comments != nil {
 		for _, c := range i.Fields.Comments.Comments {
+			t, err := parseTime(c.Created)
+			if err != nil {
+				return nil, fmt.Errorf("comment `%s`: %s", c.ID, err)
+			}
 			issueEvents = append(issueEvents, store.IssueEvent{
-				EventTime:        parseTime(c.Created),
+				EventTime:        t,
 				EventKind:        "comment_added",
-				EventAuthor:      c.Author.Name,
+				EventAuthor:      requiredString(accountID(&c.Author)),
+				EventAuthorName:  displayName(&c.Author),
 				IssueKey:         i.Key,
 				CommentBody:      &c.Body,
 				StatusChangeFrom: nil,
@@ -67,57 +102,83 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 			// *descending* but the implementation is simpler if we
 			// process them in *ascending* order.
 			h := i.Changelog.Histories[len(i.Changelog.Histories)-k-1]
+			t, err := parseTime(h.Created)
+			if err != nil {
+				return nil, fmt.Errorf("changelog `%s`: %s", h.Id, err)
+			}
 
 			for _, cli := range h.Items {
 				switch cli.Field {
 				case "status":
 					from := cli.FromString
 					to := cli.ToString
+					fromID := changelogItemID(cli.From)
+					toID := changelogItemID(cli.To)
+					fromCategory := m.statusCategory(i, fromID, from)
+					toCategory := m.statusCategory(i, toID, to)
 					if !hasChangelogOnStatus {
 						// first changelog on status
 						// => generate additional event with initial status
 						issueEvents = append(issueEvents, store.IssueEvent{
-							EventTime:        time.Time(i.Fields.Created),
-							EventKind:        "status_changed",
-							EventAuthor:      h.Author.Name,
-							IssueKey:         i.Key,
-							StatusChangeFrom: nil,
-							StatusChangeTo:   &from,
+							EventTime:              time.Time(i.Fields.Created),
+							EventKind:              "status_changed",
+							EventAuthor:            requiredString(accountID(&h.Author)),
+							EventAuthorName:        displayName(&h.Author),
+							IssueKey:               i.Key,
+							StatusChangeFrom:       nil,
+							StatusChangeTo:         &from,
+							StatusChangeToID:       fromID,
+							StatusChangeToCategory: fromCategory,
 						})
 					}
 					hasChangelogOnStatus = true
 					issueEvents = append(issueEvents, store.IssueEvent{
-						EventTime:        parseTime(h.Created),
-						EventKind:        "status_changed",
-						EventAuthor:      h.Author.Name,
-						IssueKey:         i.Key,
-						StatusChangeFrom: &from,
-						StatusChangeTo:   &to,
+						EventTime:                t,
+						EventKind:                "status_changed",
+						EventAuthor:              requiredString(accountID(&h.Author)),
+						EventAuthorName:          displayName(&h.Author),
+						IssueKey:                 i.Key,
+						StatusChangeFrom:         &from,
+						StatusChangeTo:           &to,
+						StatusChangeFromID:       fromID,
+						StatusChangeToID:         toID,
+						StatusChangeFromCategory: fromCategory,
+						StatusChangeToCategory:   toCategory,
 					})
 
 				case "assignee":
-					from := cli.FromString
-					to := cli.ToString
+					// `From` and `To` contain the account IDs (or names
+					// for Jira instances without account IDs), the
+					// `...String` attributes contain display names.
+					from := changelogItemID(cli.From)
+					to := changelogItemID(cli.To)
+					fromName := optionalString(cli.FromString)
+					toName := optionalString(cli.ToString)
 					if !hasChangelogOnAssignee {
 						// first changelog on assignee
 						// => generate additional event with initial assignee
 						issueEvents = append(issueEvents, store.IssueEvent{
-							EventTime:          time.Time(i.Fields.Created),
-							EventKind:          "assignee_changed",
-							EventAuthor:        h.Author.Name,
-							IssueKey:           i.Key,
-							AssigneeChangeFrom: nil,
-							AssigneeChangeTo:   &from,
+							EventTime:            time.Time(i.Fields.Created),
+							EventKind:            "assignee_changed",
+							EventAuthor:          requiredString(accountID(&h.Author)),
+							EventAuthorName:      displayName(&h.Author),
+							IssueKey:             i.Key,
+							AssigneeChangeFrom:   nil,
+							AssigneeChangeTo:     from,
+							AssigneeChangeToName: fromName,
 						})
 					}
 					hasChangelogOnAssignee = true
 					issueEvents = append(issueEvents, store.IssueEvent{
-						EventTime:          parseTime(h.Created),
-						EventKind:          "assignee_changed",
-						EventAuthor:        h.Author.Name,
-						IssueKey:           i.Key,
-						AssigneeChangeFrom: &from,
-						AssigneeChangeTo:   &to,
+						EventTime:              t,
+						EventKind:              "assignee_changed",
+						EventAuthor:            requiredString(accountID(&h.Author)),
+						EventAuthorName:        displayName(&h.Author),
+						IssueKey:               i.Key,
+						AssigneeChangeFrom:     from,
+						AssigneeChangeTo:       to,
+						AssigneeChangeFromName: fromName,
+						AssigneeChangeToName:   toName,
 					})
 				default:
 					continue
@@ -128,68 +189,115 @@ func (m *Mapper) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
 
 	// If there was no `status_changed` event created, and the issue has a status,
 	// add a `status_changed` event for the initial status.
-	if !hasChangelogOnStatus {
-		author := "N/A"
-		if rn := reporterName(i); rn != nil {
-			author = *rn
-		}
+	if !hasChangelogOnStatus && i.Fields.Status != nil {
 		issueEvents = append(issueEvents, store.IssueEvent{
-			EventTime:        time.Time(i.Fields.Created),
-			EventKind:        "status_changed",
-			EventAuthor:      author,
-			IssueKey:         i.Key,
-			StatusChangeFrom: nil,
-			StatusChangeTo:   &(i.Fields.Status.Name),
+			EventTime:              time.Time(i.Fields.Created),
+			EventKind:              "status_changed",
+			EventAuthor:            requiredString(accountID(i.Fields.Reporter)),
+			EventAuthorName:        displayName(i.Fields.Reporter),
+			IssueKey:               i.Key,
+			StatusChangeFrom:       nil,
+			StatusChangeTo:         &(i.Fields.Status.Name),
+			StatusChangeToID:       statusID(i),
+			StatusChangeToCategory: m.statusCategory(i, statusID(i), i.Fields.Status.Name),
 		})
 	}
 
 	// Do the same for the assignee.
 	// NB: an issue may have no assignee.
 	if !hasChangelogOnAssignee && i.Fields.Assignee != nil {
-		author := "N/A"
-		if rn := reporterName(i); rn != nil {
-			author = *rn
-		}
 		issueEvents = append(issueEvents, store.IssueEvent{
-			EventTime:          time.Time(i.Fields.Created),
-			EventKind:          "assignee_changed",
-			EventAuthor:        author,
-			IssueKey:           i.Key,
-			AssigneeChangeFrom: nil,
-			AssigneeChangeTo:   &(i.Fields.Assignee.Name),
+			EventTime:            time.Time(i.Fields.Created),
+			EventKind:            "assignee_changed",
+			EventAuthor:          requiredString(accountID(i.Fields.Reporter)),
+			EventAuthorName:      displayName(i.Fields.Reporter),
+			IssueKey:             i.Key,
+			AssigneeChangeFrom:   nil,
+			AssigneeChangeTo:     accountID(i.Fields.Assignee),
+			AssigneeChangeToName: displayName(i.Fields.Assignee),
 		})
 	}
 
 	sort.Sort(store.IssueEventsByTime(issueEvents))
-	return issueEvents
+	return issueEvents, nil
 }
 
-// IssueStateFromIssue creates a `store.IssueState` from a Jira issue
+// IssueStateFromIssue creates a `store.IssueState` from a Jira issue.
+//
+// Missing fields (e.g. an issue without priority) are mapped to nil.
 func (m *Mapper) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	developerBackend := m.customFieldUser(i, "customfield_10600")
+	developerFrontend := m.customFieldUser(i, "customfield_12403")
+	reviewer := m.customFieldUser(i, "customfield_10601")
+	productOwner := m.customFieldUser(i, "customfield_11200")
 	return store.IssueState{
-		CreatedAt:         time.Time(i.Fields.Created),
-		UpdatedAt:         time.Time(i.Fields.Updated),
-		Key:               i.Key,
-		Project:           &i.Fields.Project.Name,
-		Status:            &i.Fields.Status.Name,
-		ResolvedAt:        resolvedAt(i),
-		Priority:          &i.Fields.Priority.Name,
-		Summary:           &i.Fields.Summary,
-		Description:       &i.Fields.Description,
-		Type:              &i.Fields.Type.Name,
-		Labels:            labels(i),
-		Reporter:          reporterName(i),
-		Assignee:          assigneeName(i),
-		DeveloperBackend:  userNameFromCustomField(i, "customfield_10600"),
-		DeveloperFrontend: userNameFromCustomField(i, "customfield_12403"),
-		Reviewer:          userNameFromCustomField(i, "customfield_10601"),
-		ProductOwner:      userNameFromCustomField(i, "customfield_11200"),
-		BugCause:          valueFromCustomField(i, "customfield_11101"),
-		Epic:              epicFromCustomField(i),
-		Tribe:             valueFromCustomField(i, "customfield_12100"),
-		Components:        components(i),
-		FixVersions:       fixVersions(i),
+		CreatedAt:             time.Time(i.Fields.Created),
+		UpdatedAt:             time.Time(i.Fields.Updated),
+		Key:                   i.Key,
+		Project:               &i.Fields.Project.Name,
+		Status:                statusName(i),
+		StatusID:              statusID(i),
+		StatusCategory:        m.statusCategory(i, statusID(i), requiredString(statusName(i))),
+		ResolvedAt:            resolvedAt(i),
+		Priority:              priority(i),
+		Summary:               &i.Fields.Summary,
+		Description:           &i.Fields.Description,
+		Type:                  &i.Fields.Type.Name,
+		Labels:                labels(i),
+		Reporter:              accountID(i.Fields.Reporter),
+		ReporterName:          displayName(i.Fields.Reporter),
+		Assignee:              accountID(i.Fields.Assignee),
+		AssigneeName:          displayName(i.Fields.Assignee),
+		DeveloperBackend:      userAccountID(developerBackend),
+		DeveloperBackendName:  userDisplayName(developerBackend),
+		DeveloperFrontend:     userAccountID(developerFrontend),
+		DeveloperFrontendName: userDisplayName(developerFrontend),
+		Reviewer:              userAccountID(reviewer),
+		ReviewerName:          userDisplayName(reviewer),
+		ProductOwner:          userAccountID(productOwner),
+		ProductOwnerName:      userDisplayName(productOwner),
+		BugCauses:             m.customFieldStrings(i, "customfield_11101"),
+		Epics:                 m.customFieldStrings(i, "customfield_10009"),
+		Tribes:                m.customFieldStrings(i, "customfield_12100"),
+		Components:            components(i),
+		FixVersions:           fixVersions(i),
+	}
+}
+
+// IssueStatusPeriodsFromEvents generates the `IssueStatusPeriod`
+// records for the passed issue from its `status_changed` events,
+// as returned by `IssueEventsFromIssue`.
+//
+// Each `status_changed` event closes the period of the previous
+// status (if any) and opens a new one. The last period is left
+// open (no `ExitedAt` nor `Duration`) since it corresponds to the
+// issue's current status.
+//
+// The status ID and category are taken from the event. The activity
+// of the status is set according to the flow configuration (see
+// `WithFlowConfig`).
+func (m *Mapper) IssueStatusPeriodsFromEvents(i *extJira.Issue, ies []store.IssueEvent) []store.IssueStatusPeriod {
+	periods := make([]store.IssueStatusPeriod, 0)
+	for _, ie := range ies {
+		if ie.EventKind != "status_changed" || ie.StatusChangeTo == nil {
+			continue
+		}
+		if n := len(periods); n > 0 {
+			exitedAt := ie.EventTime
+			duration := exitedAt.Sub(periods[n-1].EnteredAt)
+			periods[n-1].ExitedAt = &exitedAt
+			periods[n-1].Duration = &duration
+		}
+		periods = append(periods, store.IssueStatusPeriod{
+			IssueKey:       ie.IssueKey,
+			Status:         *ie.StatusChangeTo,
+			StatusID:       ie.StatusChangeToID,
+			StatusCategory: ie.StatusChangeToCategory,
+			Activity:       m.activity(*ie.StatusChangeTo),
+			EnteredAt:      ie.EventTime,
+		})
 	}
+	return periods
 }
 
 // requiredString returns a string for the specified string pointer,
@@ -201,97 +309,97 @@ func requiredString(s *string) string {
 	return *s
 }
 
-// Returns the issue's reporter name or nil if there is no
-// reporter.
-func reporterName(i *extJira.Issue) *string {
-	if i.Fields.Reporter == nil {
+// statusCategory returns the category name of the status specified
+// by its ID and name.
+//
+// The category is looked up in the mapper's statuses using the ID.
+// If the status is unknown, the category of the issue's current
+// status is used if the status matches it (by ID, or by name if
+// there is no ID). Returns nil if the category can't be resolved.
+func (m *Mapper) statusCategory(i *extJira.Issue, id *string, name string) *string {
+	if id != nil {
+		if s, ok := m.statuses[*id]; ok {
+			return &s.Category
+		}
+	}
+	if i.Fields.Status == nil || i.Fields.Status.StatusCategory.Name == "" {
 		return nil
 	}
-	return &i.Fields.Reporter.Name
+	current := i.Fields.Status
+	if (id != nil && *id == current.ID) || (id == nil && name == current.Name) {
+		return &current.StatusCategory.Name
+	}
+	return nil
 }
 
-func assigneeName(i *extJira.Issue) *string {
-	if i.Fields.Assignee == nil {
+// Returns the name of the issue's current status or nil if it has
+// no status.
+func statusName(i *extJira.Issue) *string {
+	if i.Fields.Status == nil {
 		return nil
 	}
-	return &i.Fields.Assignee.Name
+	return &i.Fields.Status.Name
 }
 
-func components(i *extJira.Issue) *string {
-	var components string
-	for _, c := range i.Fields.Components {
-		components = components + c.Name
+// Returns the ID of the issue's current status or nil if it has
+// no status or the ID is unknown.
+func statusID(i *extJira.Issue) *string {
+	if i.Fields.Status == nil || i.Fields.Status.ID == "" {
+		return nil
 	}
-	return &components
+	return &i.Fields.Status.ID
 }
 
-func labels(i *extJira.Issue) *string {
-	var labels string
-	for _, l := range i.Fields.Labels {
-		labels = labels + l
+// changelogItemID returns the ID contained in the `From` or `To`
+// attribute of a changelog item, or nil if there is none.
+func changelogItemID(v interface{}) *string {
+	id, ok := v.(string)
+	if !ok || id == "" {
+		return nil
 	}
-	return &labels
+	return &id
 }
 
-func resolvedAt(i *extJira.Issue) *time.Time {
-	t := time.Time(i.Fields.Resolutiondate)
-	if t.IsZero() {
-		return nil
+func components(i *extJira.Issue) []string {
+	components := make([]string, 0, len(i.Fields.Components))
+	for _, c := range i.Fields.Components {
+		components = append(components, c.Name)
 	}
-	return &t
+	return components
 }
 
-func fixVersions(i *extJira.Issue) *string {
-	var fixVersions string
-	for _, fv := range i.Fields.FixVersions {
-		fixVersions = fixVersions + fv.Name
-	}
-	return &fixVersions
+func labels(i *extJira.Issue) []string {
+	labels := make([]string, 0, len(i.Fields.Labels))
+	return append(labels, i.Fields.Labels...)
 }
 
-func userNameFromCustomField(i *extJira.Issue, field string) *string {
-	cf := i.Fields.Unknowns[field]
-	if cf == nil {
+func priority(i *extJira.Issue) *string {
+	if i.Fields.Priority == nil {
 		return nil
 	}
-	name := cf.(map[string]interface{})["name"].(string)
-	return &name
+	return &i.Fields.Priority.Name
 }
 
-func epicFromCustomField(i *extJira.Issue) *string {
-	cf := i.Fields.Unknowns["customfield_10009"]
-	if cf == nil {
-		return nil
-	}
-	e, ok := cf.(*string)
-	if !ok {
+func resolvedAt(i *extJira.Issue) *time.Time {
+	t := time.Time(i.Fields.Resolutiondate)
+	if t.IsZero() {
 		return nil
 	}
-	return e
+	return &t
 }
 
-func valueFromCustomField(i *extJira.Issue, field string) *string {
-	cf := i.Fields.Unknowns[field]
-	if cf == nil {
-		return nil
-	}
-	cfMap, ok := cf.(map[string]interface{})
-	if !ok {
-		log.Printf("customfield `%s` / issue %s\n", field, i.Key) // to give some context
-		_ = cf.(map[string]interface{})                           // to make it fail with explicit message about type issue
-	}
-	cfValue, ok := cfMap["value"].(string)
-	if !ok {
-		log.Printf("customfield `%s` / issue %s\n", field, i.Key)
-		_ = cfMap["value"].(string)
+func fixVersions(i *extJira.Issue) []string {
+	fixVersions := make([]string, 0, len(i.Fields.FixVersions))
+	for _, fv := range i.Fields.FixVersions {
+		fixVersions = append(fixVersions, fv.Name)
 	}
-	return &cfValue
+	return fixVersions
 }
 
-func parseTime(s string) time.Time {
+func parseTime(s string) (time.Time, error) {
 	t, err := time.Parse("2006-01-02T15:04:05.000-0700", s)
 	if err != nil {
-		log.Fatalf("failed to parse time `%s`", s)
+		return t, fmt.Errorf("failed to parse time `%s`", s)
 	}
-	return t
+	return t, nil
 }
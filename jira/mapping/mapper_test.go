@@ -36,7 +36,7 @@ func TestIssueEventsFromIssue(t *testing.T) {
 	assigneeName := "assignee"
 	reporterName := "reporter"
 	refTime := time.Now()
-	m := mapping.Mapper{}
+	m := &mapping.Mapper{}
 
 	t.Run("issue without changelog", func(t *testing.T) {
 		key := "PJ-1"
@@ -48,7 +48,7 @@ func TestIssueEventsFromIssue(t *testing.T) {
 			[]changelogMockDef{},
 		}
 		i := mockIssue(def)
-		resultEvents := m.IssueEventsFromIssue(i)
+		resultEvents := issueEvents(t, m, i)
 
 		// Expects following events:
 		//   - `created`
@@ -102,7 +102,7 @@ func TestIssueEventsFromIssue(t *testing.T) {
 			},
 		}
 		i := mockIssue(def)
-		resultEvents := m.IssueEventsFromIssue(i)
+		resultEvents := issueEvents(t, m, i)
 		resultEventsMap := groupAndSortEvents(resultEvents)
 
 		// Expects following events:
@@ -166,7 +166,7 @@ func TestIssueEventsFromIssue(t *testing.T) {
 			},
 		}
 		i := mockIssue(def)
-		resultEvents := m.IssueEventsFromIssue(i)
+		resultEvents := issueEvents(t, m, i)
 		resultEventsMap := groupAndSortEvents(resultEvents)
 
 		// Expects following events:
@@ -214,7 +214,7 @@ func TestIssueStateFromIssue(t *testing.T) {
 	key := "PJ-1"
 	assigneeName := "assignee"
 	refTime := time.Now()
-	m := mapping.Mapper{}
+	m := &mapping.Mapper{}
 	def := issueMockDef{
 		key,
 		refTime,
@@ -223,13 +223,112 @@ func TestIssueStateFromIssue(t *testing.T) {
 		[]changelogMockDef{},
 	}
 	i := mockIssue(def)
+	i.Fields.Labels = []string{"bug", "regression"}
+	i.Fields.Components = []*extJira.Component{&extJira.Component{Name: "API"}, &extJira.Component{Name: "Web"}}
 
 	resultState := m.IssueStateFromIssue(i)
 	et := refTime.Add(-time.Hour)
 	if !resultState.CreatedAt.Equal(et) {
 		t.Errorf("expected CreatedAt to be `%s`, got `%s`", et, resultState.CreatedAt)
 	}
+	matchers.MatchStringSlices(t, "state.Labels", []string{"bug", "regression"}, resultState.Labels, i.Key)
+	matchers.MatchStringSlices(t, "state.Components", []string{"API", "Web"}, resultState.Components, i.Key)
+	matchers.MatchStringSlices(t, "state.FixVersions", []string{}, resultState.FixVersions, i.Key)
 	// TODO: implement other expectations
+
+	t.Run("issue without priority", func(t *testing.T) {
+		i.Fields.Priority = nil
+		matchers.MatchStringPtr(t, "state.Priority", nil, m.IssueStateFromIssue(i).Priority, i.Key)
+	})
+}
+
+func TestIssueEventsFromIssue_invalidTime(t *testing.T) {
+	m := &mapping.Mapper{}
+	i := mockIssue(issueMockDef{"PJ-1", time.Now(), nil, "Open", []changelogMockDef{}})
+	i.Fields.Comments = &extJira.Comments{Comments: []*extJira.Comment{
+		&extJira.Comment{ID: "1", Created: "yesterday"},
+	}}
+	if _, err := m.IssueEventsFromIssue(i); err == nil {
+		t.Errorf("expected an error for an invalid comment time")
+	}
+}
+
+func TestIssueStatusPeriodsFromEvents(t *testing.T) {
+	refTime := time.Now()
+	m := &mapping.Mapper{}
+	def := issueMockDef{
+		"PJ-1",
+		refTime,
+		nil,
+		"In Review",
+		[]changelogMockDef{
+			changelogMockDef{"status", "In Dev", "In Review", refTime.Add(2 * time.Hour)},
+			changelogMockDef{"status", "Open", "In Dev", refTime.Add(1 * time.Hour)},
+		},
+	}
+	i := mockIssue(def)
+	i.Fields.Status.ID = "In Review"
+	i.Fields.Status.StatusCategory = extJira.StatusCategory{Name: "In Progress"}
+
+	periods := m.IssueStatusPeriodsFromEvents(i, issueEvents(t, m, i))
+
+	// Expects following periods:
+	//   - `Open` from creation to changelog #1
+	//   - `In Dev` from changelog #1 to changelog #2
+	//   - `In Review` from changelog #2, still open
+	matchers.MatchInt(t, "count of periods", 3, len(periods), i.Key)
+
+	p := periods[0]
+	matchers.MatchString(t, "period.Status", "Open", p.Status, i.Key)
+	matchers.MatchStringPtr(t, "period.StatusCategory", nil, p.StatusCategory, i.Key)
+	matchers.MatchTimeApprox(t, "period.EnteredAt", refTime.Add(-time.Hour), p.EnteredAt, 1, i.Key)
+	matchers.MatchTimeApprox(t, "period.ExitedAt", refTime.Add(time.Hour), *p.ExitedAt, 1, i.Key)
+	if d := p.Duration.Round(time.Second); d != 2*time.Hour {
+		t.Errorf("expected period.Duration to be `%s`, got `%s`", 2*time.Hour, d)
+	}
+
+	p = periods[1]
+	matchers.MatchString(t, "period.Status", "In Dev", p.Status, i.Key)
+	matchers.MatchTimeApprox(t, "period.EnteredAt", refTime.Add(time.Hour), p.EnteredAt, 1, i.Key)
+	matchers.MatchTimeApprox(t, "period.ExitedAt", refTime.Add(2*time.Hour), *p.ExitedAt, 1, i.Key)
+
+	p = periods[2]
+	matchers.MatchString(t, "period.Status", "In Review", p.Status, i.Key)
+	matchers.MatchStringPtr(t, "period.StatusID", strAddr("In Review"), p.StatusID, i.Key)
+	matchers.MatchStringPtr(t, "period.StatusCategory", strAddr("In Progress"), p.StatusCategory, i.Key)
+	if p.ExitedAt != nil || p.Duration != nil {
+		t.Errorf("expected last period to be open, got ExitedAt=`%v` Duration=`%v`", p.ExitedAt, p.Duration)
+	}
+}
+
+func TestIssueEventsFromIssue_statusCategories(t *testing.T) {
+	refTime := time.Now()
+	m := mapping.NewMapper([]store.Status{
+		store.Status{ID: "Open", Name: "Open", Category: "To Do"},
+		store.Status{ID: "In Dev", Name: "In Dev", Category: "In Progress"},
+	}, nil)
+	def := issueMockDef{
+		"PJ-1",
+		refTime,
+		nil,
+		"In Dev",
+		[]changelogMockDef{
+			changelogMockDef{"status", "Open", "In Dev", refTime.Add(1 * time.Hour)},
+		},
+	}
+	i := mockIssue(def)
+	resultEventsMap := groupAndSortEvents(issueEvents(t, m, i))
+	matchers.MatchInt(t, "count of `status_changed` events", 2, len(resultEventsMap["status_changed"]), i.Key)
+
+	re := resultEventsMap["status_changed"][0]
+	matchers.MatchStringPtr(t, "event.StatusChangeToID", strAddr("Open"), re.StatusChangeToID, i.Key)
+	matchers.MatchStringPtr(t, "event.StatusChangeToCategory", strAddr("To Do"), re.StatusChangeToCategory, i.Key)
+
+	re = resultEventsMap["status_changed"][1]
+	matchers.MatchStringPtr(t, "event.StatusChangeFromID", strAddr("Open"), re.StatusChangeFromID, i.Key)
+	matchers.MatchStringPtr(t, "event.StatusChangeFromCategory", strAddr("To Do"), re.StatusChangeFromCategory, i.Key)
+	matchers.MatchStringPtr(t, "event.StatusChangeToID", strAddr("In Dev"), re.StatusChangeToID, i.Key)
+	matchers.MatchStringPtr(t, "event.StatusChangeToCategory", strAddr("In Progress"), re.StatusChangeToCategory, i.Key)
 }
 
 // mockIssue mocks a Jira issue. It returns the mocked `extJira.Issue` as well
@@ -282,6 +381,16 @@ func mockIssue(def issueMockDef) *extJira.Issue {
 	return i
 }
 
+// issueEvents returns the events mapped from the issue, failing the
+// test if the mapping fails.
+func issueEvents(t *testing.T, m *mapping.Mapper, i *extJira.Issue) []store.IssueEvent {
+	ies, err := m.IssueEventsFromIssue(i)
+	if err != nil {
+		t.Fatalf("unexpected error in `IssueEventsFromIssue`: %s", err)
+	}
+	return ies
+}
+
 func strAddr(s string) *string {
 	return &s
 }
@@ -0,0 +1,222 @@
+package mapping
+
+import (
+	"sort"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// personCustomFields lists the custom fields containing a person,
+// which must be collected by `UsersFromIssue` even if their schema
+// is unknown.
+var personCustomFields = []string{
+	"customfield_10600", // developer backend
+	"customfield_12403", // developer frontend
+	"customfield_10601", // reviewer
+	"customfield_11200", // product owner
+}
+
+// UsersFromIssue returns the users referenced by the passed issue
+// (reporter, creator, assignee, comments and changelogs authors,
+// and users in custom fields), without duplicates and sorted by
+// account ID.
+func (m *Mapper) UsersFromIssue(i *extJira.Issue) []store.User {
+	users := make(map[string]store.User)
+	add := func(u *store.User) {
+		if u == nil {
+			return
+		}
+		// The same user may appear several times with more or
+		// less information (e.g. email hidden in comments), so
+		// the known values are merged.
+		if prev, ok := users[u.AccountID]; ok {
+			if u.DisplayName == nil {
+				u.DisplayName = prev.DisplayName
+			}
+			if u.Email == nil {
+				u.Email = prev.Email
+			}
+			if u.TimeZone == nil {
+				u.TimeZone = prev.TimeZone
+			}
+			u.Active = u.Active || prev.Active
+		}
+		users[u.AccountID] = *u
+	}
+
+	add(storeUser(i.Fields.Reporter))
+	add(storeUser(i.Fields.Creator))
+	add(storeUser(i.Fields.Assignee))
+	if i.Fields.Comments != nil {
+		for _, c := range i.Fields.Comments.Comments {
+			add(storeUser(&c.Author))
+		}
+	}
+	if i.Changelog != nil {
+		for _, h := range i.Changelog.Histories {
+			add(storeUser(&h.Author))
+		}
+	}
+	for field := range i.Fields.Unknowns {
+		if m.isPersonCustomField(field) {
+			for _, u := range m.customFieldUsers(i, field) {
+				add(&u)
+			}
+		}
+	}
+
+	result := make([]store.User, 0, len(users))
+	for _, u := range users {
+		result = append(result, u)
+	}
+	sort.Slice(result, func(a, b int) bool { return result[a].AccountID < result[b].AccountID })
+	return result
+}
+
+// customFieldUser returns the user contained in the specified custom
+// field, or nil if the field is empty or doesn't contain a user.
+//
+// If the field contains several users (e.g. multi-user picker), only
+// the first one is returned.
+func (m *Mapper) customFieldUser(i *extJira.Issue, field string) *store.User {
+	users := m.customFieldUsers(i, field)
+	if len(users) == 0 {
+		return nil
+	}
+	return &users[0]
+}
+
+// customFieldUsers returns the users contained in the specified
+// custom field. Values which are not user objects are reported as
+// warnings and ignored.
+func (m *Mapper) customFieldUsers(i *extJira.Issue, field string) []store.User {
+	v := i.Fields.Unknowns[field]
+	values, ok := v.([]interface{})
+	if !ok {
+		values = []interface{}{v}
+	}
+	users := make([]store.User, 0, len(values))
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+		u := storeUser(userFromValue(value))
+		if u == nil {
+			warn(i, field, errNotAUser)
+			continue
+		}
+		users = append(users, *u)
+	}
+	return users
+}
+
+// isPersonCustomField returns true if the specified field contains
+// persons, according to its schema or to `personCustomFields`.
+func (m *Mapper) isPersonCustomField(field string) bool {
+	if schema, ok := m.fieldSchemas[field]; ok {
+		if schema.Type == schemaTypeUser || (schema.Type == schemaTypeArray && schema.Items == schemaTypeUser) {
+			return true
+		}
+	}
+	for _, f := range personCustomFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// storeUser returns a `store.User` for the passed Jira user, or nil
+// if the user is nil or can't be identified.
+func storeUser(u *extJira.User) *store.User {
+	id := accountID(u)
+	if id == nil {
+		return nil
+	}
+	return &store.User{
+		AccountID:   *id,
+		DisplayName: displayName(u),
+		Email:       optionalString(u.EmailAddress),
+		Active:      u.Active,
+		TimeZone:    optionalString(u.TimeZone),
+	}
+}
+
+// userFromValue returns a Jira user from a custom field value, or
+// nil if the value is not a user object.
+func userFromValue(v interface{}) *extJira.User {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	str := func(k string) string {
+		s, _ := o[k].(string)
+		return s
+	}
+	active, _ := o["active"].(bool)
+	return &extJira.User{
+		AccountID:    str("accountId"),
+		Name:         str("name"),
+		Key:          str("key"),
+		DisplayName:  str("displayName"),
+		EmailAddress: str("emailAddress"),
+		TimeZone:     str("timeZone"),
+		Active:       active,
+	}
+}
+
+// accountID returns the identifier of the passed user: its account
+// ID, or its name (or key) if the Jira instance doesn't provide
+// account IDs. Returns nil if the user is nil or has none of them.
+func accountID(u *extJira.User) *string {
+	if u == nil {
+		return nil
+	}
+	for _, id := range []string{u.AccountID, u.Name, u.Key} {
+		if id != "" {
+			return &id
+		}
+	}
+	return nil
+}
+
+// displayName returns the display name of the passed user, falling
+// back to its name. Returns nil if the user is nil or has none of
+// them.
+func displayName(u *extJira.User) *string {
+	if u == nil {
+		return nil
+	}
+	if u.DisplayName != "" {
+		return optionalString(u.DisplayName)
+	}
+	return optionalString(u.Name)
+}
+
+// userAccountID returns the account ID of the passed user, or nil if
+// the user is nil.
+func userAccountID(u *store.User) *string {
+	if u == nil {
+		return nil
+	}
+	return &u.AccountID
+}
+
+// userDisplayName returns the display name of the passed user, or nil
+// if the user is nil.
+func userDisplayName(u *store.User) *string {
+	if u == nil {
+		return nil
+	}
+	return u.DisplayName
+}
+
+// optionalString returns a pointer to `s`, or nil if `s` is empty.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
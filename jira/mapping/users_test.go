@@ -0,0 +1,59 @@
+package mapping_test
+
+import (
+	"testing"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+	"github.com/rchampourlier/golib/matchers"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+)
+
+func TestUsersFromIssue(t *testing.T) {
+	refTime := time.Now()
+	m := &mapping.Mapper{}
+	def := issueMockDef{
+		"PJ-1",
+		refTime,
+		nil,
+		"Open",
+		[]changelogMockDef{
+			changelogMockDef{"status", "Open", "In Dev", refTime.Add(1 * time.Hour)},
+		},
+	}
+	i := mockIssue(def)
+	i.Fields.Reporter = &extJira.User{AccountID: "id-reporter", DisplayName: "Reporter", EmailAddress: "reporter@example.com", Active: true}
+	i.Fields.Assignee = &extJira.User{AccountID: "id-assignee", DisplayName: "Assignee"}
+	i.Fields.Comments = &extJira.Comments{Comments: []*extJira.Comment{
+		&extJira.Comment{Author: extJira.User{AccountID: "id-reporter", DisplayName: "Reporter"}, Created: timeAsStr(refTime)},
+	}}
+	i.Fields.Unknowns = map[string]interface{}{
+		"customfield_10601": map[string]interface{}{"accountId": "id-reviewer", "displayName": "Reviewer"},
+	}
+
+	users := m.UsersFromIssue(i)
+
+	// Expects the reporter (once), the assignee, the changelog's
+	// author (identified by its name) and the reviewer.
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.AccountID)
+	}
+	matchers.MatchStringSlices(t, "users' account IDs", []string{"id-assignee", "id-reporter", "id-reviewer", "status_change_author"}, ids, i.Key)
+	matchers.MatchStringPtr(t, "user.Email", strAddr("reporter@example.com"), users[1].Email, i.Key)
+
+	s := m.IssueStateFromIssue(i)
+	matchers.MatchStringPtr(t, "state.Reporter", strAddr("id-reporter"), s.Reporter, i.Key)
+	matchers.MatchStringPtr(t, "state.ReporterName", strAddr("Reporter"), s.ReporterName, i.Key)
+	matchers.MatchStringPtr(t, "state.Reviewer", strAddr("id-reviewer"), s.Reviewer, i.Key)
+	matchers.MatchStringPtr(t, "state.ReviewerName", strAddr("Reviewer"), s.ReviewerName, i.Key)
+
+	events := groupAndSortEvents(issueEvents(t, m, i))
+	e := events["comment_added"][0]
+	matchers.MatchString(t, "event.EventAuthor", "id-reporter", e.EventAuthor, i.Key)
+	matchers.MatchStringPtr(t, "event.EventAuthorName", strAddr("Reporter"), e.EventAuthorName, i.Key)
+	e = events["assignee_changed"][0]
+	matchers.MatchStringPtr(t, "event.AssigneeChangeTo", strAddr("id-assignee"), e.AssigneeChangeTo, i.Key)
+	matchers.MatchStringPtr(t, "event.AssigneeChangeToName", strAddr("Assignee"), e.AssigneeChangeToName, i.Key)
+}
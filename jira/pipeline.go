@@ -0,0 +1,397 @@
+package jira
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// SyncConfig configures the stages of the sync pipeline (see
+// `pipeline`).
+type SyncConfig struct {
+	// FetchWorkers is the number of issues fetched concurrently.
+	FetchWorkers int
+	// MapWorkers is the number of issues mapped concurrently.
+	MapWorkers int
+	// StoreWorkers is the number of concurrent writes to the store.
+	StoreWorkers int
+	// BufferSize is the capacity of the channels between stages.
+	// When a stage is full, the previous one waits (e.g. the search
+	// waits for the fetches).
+	BufferSize int
+	// BatchSize is the maximum number of issues written to the store
+	// in a single write.
+	BatchSize int
+}
+
+// DefaultSyncConfig returns the default sync configuration.
+func DefaultSyncConfig() SyncConfig {
+	return SyncConfig{
+		FetchWorkers: 10,
+		MapWorkers:   2,
+		StoreWorkers: 2,
+		BufferSize:   100,
+		BatchSize:    20,
+	}
+}
+
+// SyncConfigFromEnv returns the default sync configuration, with
+// the values overridden by the `SYNC_FETCH_WORKERS`,
+// `SYNC_MAP_WORKERS`, `SYNC_STORE_WORKERS`, `SYNC_BUFFER_SIZE` and
+// `SYNC_BATCH_SIZE` environment variables, if set.
+func SyncConfigFromEnv() (SyncConfig, error) {
+	cfg := DefaultSyncConfig()
+	for name, v := range map[string]*int{
+		"SYNC_FETCH_WORKERS": &cfg.FetchWorkers,
+		"SYNC_MAP_WORKERS":   &cfg.MapWorkers,
+		"SYNC_STORE_WORKERS": &cfg.StoreWorkers,
+		"SYNC_BUFFER_SIZE":   &cfg.BufferSize,
+		"SYNC_BATCH_SIZE":    &cfg.BatchSize,
+	} {
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid %s `%s`: must be a positive integer", name, s)
+		}
+		*v = n
+	}
+	return cfg, nil
+}
+
+// singleIssueConfig is the configuration used to sync a single
+// issue.
+var singleIssueConfig = SyncConfig{
+	FetchWorkers: 1,
+	MapWorkers:   1,
+	StoreWorkers: 1,
+	BufferSize:   1,
+	BatchSize:    1,
+}
+
+// source sends the keys of the issues to sync into the pipeline
+// with `emit`, which fails if the pipeline was stopped.
+type source func(ctx context.Context, emit func(issueKey string) error) error
+
+// searchSource returns a source sending the keys of the issues
+// matching the JQL query. The total of the search is set on `pr`.
+// A failing search stops the pipeline.
+func searchSource(c Client, query string, pr *Progress) source {
+	return func(ctx context.Context, emit func(string) error) error {
+		found := make(chan string, 100)
+		searchErr := make(chan error, 1)
+		go func() { searchErr <- c.SearchIssues(query, found, pr.setTotal) }()
+		for k := range found {
+			if err := emit(k); err != nil {
+				// Let the search end
+				go func() {
+					for range found {
+					}
+				}()
+				return err
+			}
+		}
+		err := <-searchErr
+		if err != nil {
+			metrics.Errors.WithLabelValues(metrics.ErrorKindSearch).Inc()
+		}
+		return err
+	}
+}
+
+// keysSource returns a source sending the specified keys.
+func keysSource(issueKeys []string, pr *Progress) source {
+	return func(ctx context.Context, emit func(string) error) error {
+		pr.setTotal(len(issueKeys))
+		for _, k := range issueKeys {
+			if err := emit(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// fetchedIssue is an issue passed from the fetch to the map stage.
+type fetchedIssue struct {
+	key        string
+	issue      *extJira.Issue
+	payloadRef *string
+}
+
+// mappedIssue contains the records mapped from an issue, passed
+// from the map to the store stage.
+type mappedIssue struct {
+	key        string
+	payloadRef *string
+	users      []store.User
+	state      store.IssueState
+	events     []store.IssueEvent
+	periods    []store.IssueStatusPeriod
+}
+
+func (mi mappedIssue) records() store.IssueRecords {
+	return store.IssueRecords{
+		Key:           mi.key,
+		State:         mi.state,
+		Events:        mi.events,
+		StatusPeriods: mi.periods,
+		Users:         mi.users,
+	}
+}
+
+// pipeline synchronizes issues through successive stages connected
+// by bounded channels:
+//
+//   - search: the source sends the keys of the issues to sync,
+//   - fetch: `FetchWorkers` workers fetch the issues,
+//   - map: `MapWorkers` workers map them to records,
+//   - store: `StoreWorkers` workers write the records in batches of
+//     up to `BatchSize` issues.
+//
+// An issue failing at any stage is quarantined (see `quarantine`)
+// and the others continue. Errors which prevent the sync from going
+// on (e.g. the store failing to record a failure) cancel the shared
+// context, which stops all stages.
+type pipeline struct {
+	c   Client
+	s   store.Store
+	m   Mapper
+	cfg SyncConfig
+	pr  *Progress
+
+	cancel  context.CancelFunc
+	errOnce sync.Once
+	err     error
+}
+
+// run runs the pipeline on the keys sent by `src` until all issues
+// are processed, or the pipeline is stopped by an error or the
+// cancellation of `ctx`. Returns the error which stopped it, if
+// any.
+func (p *pipeline) run(ctx context.Context, src source) error {
+	ctx, p.cancel = context.WithCancel(ctx)
+	defer p.cancel()
+
+	keys := make(chan string, p.cfg.BufferSize)
+	fetched := make(chan fetchedIssue, p.cfg.BufferSize)
+	mapped := make(chan mappedIssue, p.cfg.BufferSize)
+
+	go func() {
+		defer close(keys)
+		emit := func(k string) error {
+			p.pr.incDiscovered()
+			metrics.PoolQueueDepth.Inc()
+			select {
+			case keys <- k:
+				return nil
+			case <-ctx.Done():
+				metrics.PoolQueueDepth.Dec()
+				return ctx.Err()
+			}
+		}
+		if err := src(ctx, emit); err != nil {
+			p.fail(err)
+		}
+	}()
+	stage(p.cfg.FetchWorkers, func() { p.fetch(ctx, keys, fetched) }, func() { close(fetched) })
+	stage(p.cfg.MapWorkers, func() { p.mapIssues(ctx, fetched, mapped) }, func() { close(mapped) })
+
+	stored := make(chan struct{})
+	stage(p.cfg.StoreWorkers, func() { p.store(ctx, mapped) }, func() { close(stored) })
+	<-stored
+
+	if p.err == nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return p.err
+}
+
+// stage starts `n` workers running `work` and calls `done` once
+// they all returned.
+func stage(n int, work func(), done func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			work()
+		}()
+	}
+	go func() {
+		wg.Wait()
+		done()
+	}()
+}
+
+// fail stops the pipeline with the passed error. Only the first
+// error is kept.
+func (p *pipeline) fail(err error) {
+	p.errOnce.Do(func() {
+		p.err = err
+		p.cancel()
+	})
+}
+
+func (p *pipeline) fetch(ctx context.Context, keys <-chan string, fetched chan<- fetchedIssue) {
+	for k := range keys {
+		metrics.PoolQueueDepth.Dec()
+		if ctx.Err() != nil {
+			continue // drain
+		}
+		i, err := p.c.GetIssue(k)
+		if err != nil {
+			p.quarantine(k, store.SyncStageFetch, nil, err)
+			continue
+		}
+		metrics.IssuesFetched.Inc()
+		p.pr.incFetched()
+		fi := fetchedIssue{key: k, issue: i, payloadRef: &i.Self}
+		if i.Self == "" {
+			fi.payloadRef = nil
+		}
+		select {
+		case fetched <- fi:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (p *pipeline) mapIssues(ctx context.Context, fetched <-chan fetchedIssue, mapped chan<- mappedIssue) {
+	for fi := range fetched {
+		if ctx.Err() != nil {
+			continue // drain
+		}
+		mi, err := mapIssue(p.m, fi.issue)
+		if err != nil {
+			p.quarantine(fi.key, store.SyncStageMap, fi.payloadRef, err)
+			continue
+		}
+		metrics.IssuesMapped.Inc()
+		mi.key = fi.key
+		mi.payloadRef = fi.payloadRef
+		select {
+		case mapped <- mi:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// store writes the mapped issues in batches made of the issues
+// available when a write starts, up to `BatchSize`, so issues are
+// not delayed waiting for a batch to fill up.
+func (p *pipeline) store(ctx context.Context, mapped <-chan mappedIssue) {
+	for mi := range mapped {
+		if ctx.Err() != nil {
+			continue // drain
+		}
+		batch := []mappedIssue{mi}
+	fill:
+		for len(batch) < p.cfg.BatchSize {
+			select {
+			case mi, ok := <-mapped:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, mi)
+			default:
+				break fill
+			}
+		}
+		p.storeBatch(batch)
+	}
+}
+
+// storeBatch writes the batch of issues. If the batch fails, the
+// issues are written one by one so only the failing ones are
+// quarantined.
+func (p *pipeline) storeBatch(batch []mappedIssue) {
+	start := time.Now()
+	rs := make([]store.IssueRecords, 0, len(batch))
+	for _, mi := range batch {
+		rs = append(rs, mi.records())
+	}
+	err := p.s.ReplaceIssues(rs)
+	if err == nil {
+		for _, mi := range batch {
+			p.stored(mi, start)
+		}
+		return
+	}
+	if len(batch) == 1 {
+		p.quarantine(batch[0].key, store.SyncStageStore, batch[0].payloadRef, err)
+		return
+	}
+	logging.Phase(logging.PhaseStore).WithError(err).WithField("batch_size", len(batch)).
+		Warn("Batch write failed, writing issues one by one")
+	for _, mi := range batch {
+		p.storeBatch([]mappedIssue{mi})
+	}
+}
+
+func (p *pipeline) stored(mi mappedIssue, start time.Time) {
+	metrics.IssuesStored.Inc()
+	p.pr.incStored()
+	logging.Issue(mi.key, logging.PhaseStore).WithFields(logging.Since(start)).Debug("Stored issue")
+	metrics.ObserveHighWaterMark(mi.state.UpdatedAt)
+}
+
+// errorKinds are the kinds of the errors counted by `metrics.Errors`
+// for the failures of each sync stage.
+var errorKinds = map[string]string{
+	store.SyncStageFetch: metrics.ErrorKindFetch,
+	store.SyncStageMap:   metrics.ErrorKindMap,
+	store.SyncStageStore: metrics.ErrorKindStore,
+}
+
+// quarantine records the failure to sync the specified issue at the
+// specified stage in the store, so the rest of the run can continue.
+// If the failure can't be recorded, the pipeline is stopped.
+func (p *pipeline) quarantine(issueKey string, stage string, payloadRef *string, err error) {
+	metrics.Errors.WithLabelValues(errorKinds[stage]).Inc()
+	p.pr.incFailed()
+	logging.Issue(issueKey, stage).WithError(err).Error("Issue sync failed")
+
+	f := store.SyncFailure{
+		IssueKey:   issueKey,
+		Stage:      stage,
+		Error:      err.Error(),
+		PayloadRef: payloadRef,
+		RunID:      logging.RunID(),
+		FailedAt:   time.Now(),
+	}
+	if err := p.s.UpsertSyncFailure(f); err != nil {
+		p.fail(fmt.Errorf("error in `UpsertSyncFailure` for issue `%s`: %s", issueKey, err))
+	}
+}
+
+// mapIssue maps the passed issue to its records, setting the flow
+// efficiency of the state from the status periods. A panic of the
+// mapper (e.g. on an unexpected missing field) is returned as an
+// error.
+func mapIssue(m Mapper, i *extJira.Issue) (mi mappedIssue, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("mapper panicked: %v", r)
+		}
+	}()
+	if mi.events, err = m.IssueEventsFromIssue(i); err != nil {
+		return
+	}
+	mi.users = m.UsersFromIssue(i)
+	mi.state = m.IssueStateFromIssue(i)
+	mi.periods = m.IssueStatusPeriodsFromEvents(i, mi.events)
+	mi.state.SetFlowEfficiency(mi.periods)
+	return
+}
@@ -0,0 +1,104 @@
+package jira_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+func TestPerformSync_stoppedByError(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3"})
+
+	// PJ-1 fails to be fetched and the failure can't be recorded,
+	// so the other issues must not be fetched (the fake client
+	// would report an unexpected `GetIssue`).
+	c.ExpectGetIssue("PJ-1").WillRespondWithError(fmt.Errorf("timeout"))
+	s.ExpectUpsertSyncFailure().WithIssueKey("PJ-1").WithStage(store.SyncStageFetch).
+		WillReturnError(fmt.Errorf("connection lost"))
+
+	cfg := jira.DefaultSyncConfig()
+	cfg.FetchWorkers = 1
+	errors := metrics.Errors.WithLabelValues(metrics.ErrorKindFetch)
+	before := testutil.ToFloat64(errors)
+	summary, err := jira.PerformSync(context.Background(), c, s, cfg, &mapperMock{})
+	if err == nil {
+		t.Fatalf("expected the sync to be stopped by an error")
+	}
+	if summary.Fetched != 0 || summary.Failed != 1 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if n := testutil.ToFloat64(errors) - before; n != 1 {
+		t.Errorf("expected 1 fetch error counted, got %v", n)
+	}
+}
+
+func TestPerformSync_searchError(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	// The daily tables must not be refreshed (the fake store would
+	// report an unexpected `RefreshCFDDaily`).
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithError(fmt.Errorf("HTTP 503"))
+
+	errors := metrics.Errors.WithLabelValues(metrics.ErrorKindSearch)
+	before := testutil.ToFloat64(errors)
+	_, err := jira.PerformSync(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Errorf("expected the search error, got %v", err)
+	}
+	if n := testutil.ToFloat64(errors) - before; n != 1 {
+		t.Errorf("expected 1 search error counted, got %v", n)
+	}
+}
+
+func TestPerformRetryFailures_cancelled(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	s.ExpectGetSyncFailures().WillReturn([]store.SyncFailure{
+		store.SyncFailure{IssueKey: "PJ-1", Stage: store.SyncStageFetch},
+		store.SyncFailure{IssueKey: "PJ-2", Stage: store.SyncStageFetch},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	summary, err := jira.PerformRetryFailures(ctx, c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err != context.Canceled {
+		t.Errorf("expected `context.Canceled`, got `%v`", err)
+	}
+	if summary.Fetched != 0 || summary.Stored != 0 {
+		t.Errorf("expected no issue to be processed, got %+v", summary)
+	}
+}
+
+func TestSyncConfigFromEnv(t *testing.T) {
+	os.Setenv("SYNC_BATCH_SIZE", "5")
+	defer os.Unsetenv("SYNC_BATCH_SIZE")
+	cfg, err := jira.SyncConfigFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := jira.DefaultSyncConfig()
+	expected.BatchSize = 5
+	if cfg != expected {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+
+	os.Setenv("SYNC_BATCH_SIZE", "0")
+	if _, err := jira.SyncConfigFromEnv(); err == nil {
+		t.Errorf("expected an error for an invalid value")
+	}
+}
@@ -0,0 +1,225 @@
+package jira
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// Progress displays, set by the `PROGRESS` environment variable
+const (
+	// ProgressAuto displays a progress bar if stderr is a terminal,
+	// and logs progress summaries otherwise (default).
+	ProgressAuto = "auto"
+	// ProgressBar displays a progress bar on stderr.
+	ProgressBar = "bar"
+	// ProgressLog logs progress summaries.
+	ProgressLog = "log"
+)
+
+// progressBarInterval is the refresh interval of the progress bar.
+const progressBarInterval = 500 * time.Millisecond
+
+// progressBarWidth is the number of characters of the progress bar.
+const progressBarWidth = 30
+
+// Progress tracks the progress of a sync run: the issues
+// discovered by the search, fetched, stored and failed. It is safe
+// for concurrent use.
+type Progress struct {
+	start      time.Time
+	end        atomic.Value // time.Time, set when the run is done
+	total      int64
+	discovered int64
+	fetched    int64
+	stored     int64
+	failed     int64
+}
+
+// ProgressSnapshot is the state of a `Progress` at a given time,
+// as served by the daemon's status endpoint.
+type ProgressSnapshot struct {
+	StartedAt  time.Time `json:"started_at"`
+	Done       bool      `json:"done"`
+	Elapsed    float64   `json:"elapsed_seconds"`
+	Total      int64     `json:"total"`
+	Discovered int64     `json:"discovered"`
+	Fetched    int64     `json:"fetched"`
+	Stored     int64     `json:"stored"`
+	Failed     int64     `json:"failed"`
+
+	// Rate is the number of issues processed (stored or failed)
+	// per second.
+	Rate float64 `json:"rate"`
+
+	// ETA is the estimated number of seconds until all issues are
+	// processed, or nil if it can't be estimated yet.
+	ETA *float64 `json:"eta_seconds,omitempty"`
+}
+
+var lastProgress struct {
+	sync.RWMutex
+	p *Progress
+}
+
+// newProgress returns a new `Progress`, which becomes the one
+// returned by `LastProgress`.
+func newProgress() *Progress {
+	p := &Progress{start: time.Now()}
+	lastProgress.Lock()
+	defer lastProgress.Unlock()
+	lastProgress.p = p
+	return p
+}
+
+// LastProgress returns the progress of the running sync, or of the
+// last one if none is running. Returns nil if no sync was run.
+func LastProgress() *Progress {
+	lastProgress.RLock()
+	defer lastProgress.RUnlock()
+	return lastProgress.p
+}
+
+func (p *Progress) setTotal(n int) { atomic.StoreInt64(&p.total, int64(n)) }
+func (p *Progress) incDiscovered() { atomic.AddInt64(&p.discovered, 1) }
+func (p *Progress) incFetched()    { atomic.AddInt64(&p.fetched, 1) }
+func (p *Progress) incStored()     { atomic.AddInt64(&p.stored, 1) }
+func (p *Progress) incFailed()     { atomic.AddInt64(&p.failed, 1) }
+func (p *Progress) finish()        { p.end.Store(time.Now()) }
+func (p *Progress) isDone() bool   { return p.end.Load() != nil }
+
+func (p *Progress) endOrNow() time.Time {
+	if end, ok := p.end.Load().(time.Time); ok {
+		return end
+	}
+	return time.Now()
+}
+
+// Snapshot returns the current state of the progress.
+func (p *Progress) Snapshot() ProgressSnapshot {
+	s := ProgressSnapshot{
+		StartedAt:  p.start,
+		Done:       p.isDone(),
+		Elapsed:    p.endOrNow().Sub(p.start).Seconds(),
+		Total:      atomic.LoadInt64(&p.total),
+		Discovered: atomic.LoadInt64(&p.discovered),
+		Fetched:    atomic.LoadInt64(&p.fetched),
+		Stored:     atomic.LoadInt64(&p.stored),
+		Failed:     atomic.LoadInt64(&p.failed),
+	}
+	// The search may discover more issues than its initial total
+	// if issues are created during the sync.
+	if s.Discovered > s.Total {
+		s.Total = s.Discovered
+	}
+	processed := s.Stored + s.Failed
+	if s.Elapsed > 0 {
+		s.Rate = float64(processed) / s.Elapsed
+	}
+	if !s.Done && s.Rate > 0 && s.Total > 0 {
+		eta := float64(s.Total-processed) / s.Rate
+		s.ETA = &eta
+	}
+	return s
+}
+
+// fields returns the progress as log fields.
+func (s ProgressSnapshot) fields() logging.Fields {
+	f := logging.Fields{
+		"total":      s.Total,
+		"discovered": s.Discovered,
+		"fetched":    s.Fetched,
+		"stored":     s.Stored,
+		"failed":     s.Failed,
+		"rate":       s.Rate,
+	}
+	if s.ETA != nil {
+		f["eta_seconds"] = *s.ETA
+	}
+	return f
+}
+
+// bar renders the progress as a single line progress bar.
+func (s ProgressSnapshot) bar() string {
+	processed := s.Stored + s.Failed
+	ratio := 0.0
+	if s.Total > 0 {
+		ratio = float64(processed) / float64(s.Total)
+	}
+	filled := int(ratio * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	eta := "-"
+	if s.ETA != nil {
+		eta = (time.Duration(*s.ETA) * time.Second).String()
+	}
+	return fmt.Sprintf("[%s%s] %3.0f%% %d/%d (failed: %d) %.1f/s ETA %s",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", progressBarWidth-filled),
+		ratio*100, processed, s.Total, s.Failed, s.Rate, eta)
+}
+
+// report displays the progress until the returned function is
+// called, either as a progress bar or as periodic log summaries
+// (see `ProgressAuto`). The returned function marks the progress as
+// done.
+func (p *Progress) report() (stop func()) {
+	if progressDisplay() == ProgressBar {
+		render := func() { fmt.Fprintf(os.Stderr, "\r%s", p.Snapshot().bar()) }
+		return p.every(progressBarInterval, render, func() {
+			render()
+			fmt.Fprintln(os.Stderr)
+		})
+	}
+	return p.every(logging.ProgressInterval, func() {
+		logging.Phase(logging.PhaseSync).WithFields(p.Snapshot().fields()).Info("Sync progress")
+	}, func() {})
+}
+
+// every calls `tick` every `interval` until the returned function
+// is called, which then marks the progress as done and calls
+// `last`.
+func (p *Progress) every(interval time.Duration, tick func(), last func()) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				tick()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		p.finish()
+		last()
+	}
+}
+
+// progressDisplay returns the progress display specified by the
+// `PROGRESS` environment variable, resolving `ProgressAuto`.
+func progressDisplay() string {
+	switch os.Getenv("PROGRESS") {
+	case ProgressBar:
+		return ProgressBar
+	case ProgressLog:
+		return ProgressLog
+	}
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		return ProgressBar
+	}
+	return ProgressLog
+}
@@ -1,13 +1,14 @@
 package jira
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"sync"
 	"time"
 
-	"github.com/Jeffail/tunny"
+	extJira "github.com/andygrunwald/go-jira"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 )
 
@@ -22,54 +23,31 @@ import (
 // - For each updated issue, the records already in the store are
 //   dropped (e.g. the issue's state and events) so they can be
 //   recreated.
-func PerformIncrementalSync(c Client, store store.Store, poolSize int, m Mapper) {
-	beforeSync := time.Now()
-	log.Printf("Incremental sync starting\n")
-
-	// Using a chan of issue keys and a wait group for synchronization
-	issueKeys := make(chan string, 100)
-
-	// Using a WaitGroup to synchronize issue fetches and wait
-	// until all are done (even if all searches have been done)
-	var wg sync.WaitGroup
-
-	// Initialize a pool of workers to fetch and process issues.
-	// The pool's function fetch the issue specified by `key` and processes
-	// it.
-	p := tunny.NewFunc(poolSize, func(key interface{}) interface{} {
-		defer wg.Done()
-
-		i := c.GetIssue(key.(string))
-		store.ReplaceIssueStateAndEvents(key.(string), m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
-		return nil
-	})
-	defer p.Close()
-
-	// Start a routine to retrieve fetched issue keys from the `issueKeys`
-	// chan and run a pool job for each of them.
-	go func() {
-		for issueKey := range issueKeys {
-			wg.Add(1)
-			go p.Process(issueKey)
-		}
-		wg.Done() // Done when all `issueKeys` have been sent for processing
-	}()
-
-	// Search issues (fetch issue keys)
-	restartFromUpdatedAt := store.GetRestartFromUpdatedAt(poolSize * 3)
+// - The daily tables are then refreshed from the same point (see
+//   `PerformDailyRefresh`): since status changes, creations and
+//   resolutions update issues, earlier days of their current slices
+//   are not affected. Other changes of the slice of an issue (its
+//   type or tribes) are only reflected in earlier days by the next
+//   full rebuild (e.g. `PerformSync`).
+//
+// Issues deleted in Jira, or moved to another project (which
+// changes their key), are not returned by the search and stay in
+// the store. `PerformVerify` reports them as orphaned, and deletes
+// them with `VerifyOptions.Delete`.
+func PerformIncrementalSync(ctx context.Context, c Client, s store.Store, cfg SyncConfig, m Mapper) (ProgressSnapshot, error) {
+	logging.Phase(logging.PhaseSync).Info("Incremental sync starting")
+	restartFromUpdatedAt := s.GetRestartFromUpdatedAt(cfg.FetchWorkers * 3)
+	metrics.ObserveHighWaterMark(*restartFromUpdatedAt)
 	q := fmt.Sprintf("updated > '%d/%d/%d %d:%d' ORDER BY updated ASC",
 		restartFromUpdatedAt.Year(),
 		restartFromUpdatedAt.Month(),
 		restartFromUpdatedAt.Day(),
 		restartFromUpdatedAt.Hour(),
 		restartFromUpdatedAt.Minute())
-	c.SearchIssues(q, issueKeys)
-	wg.Add(1) // Adding a job to wait for the processing of `issueKeys`
-
-	// Wait until all fetches are done
-	wg.Wait()
-
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+	summary, err := runPipeline(ctx, c, s, cfg, m, func(pr *Progress) source {
+		return searchSource(c, q, pr)
+	})
+	return refreshDailyTables(s, *restartFromUpdatedAt, summary, err)
 }
 
 // PerformSync fetches issue identifiers from the attached Jira instance
@@ -78,52 +56,154 @@ func PerformIncrementalSync(c Client, store store.Store, poolSize int, m Mapper)
 //
 // Each fetched issue is then processed to generate `IssueState` and
 // `IssueEvent` records that are stored in the application's store.
-func PerformSync(c Client, store store.Store, poolSize int, m Mapper) {
-	beforeSync := time.Now()
-	log.Printf("Sync starting\n")
-
-	// Using a chan of issue keys and a wait group for synchronization
-	issueKeys := make(chan string, 100)
-
-	// Using a WaitGroup to synchronize issue fetches and wait
-	// until all are done (even if all searches have been done)
-	var wg sync.WaitGroup
+// The stages are run concurrently as configured by `cfg` (see
+// `pipeline`).
+//
+// An issue which fails to be fetched, mapped or stored doesn't stop
+// the sync: the failure is recorded in the store (see
+// `store.SyncFailure`) and can be retried with
+// `PerformRetryFailures`. The returned summary counts the failed
+// issues. An error is returned if the sync was stopped before all
+// issues were processed.
+//
+// Once the issues are synchronized, the daily tables are rebuilt
+// (see `PerformDailyRefresh`).
+func PerformSync(ctx context.Context, c Client, s store.Store, cfg SyncConfig, m Mapper) (ProgressSnapshot, error) {
+	logging.Phase(logging.PhaseSync).Info("Sync starting")
+	summary, err := runPipeline(ctx, c, s, cfg, m, func(pr *Progress) source {
+		return searchSource(c, "ORDER BY updated ASC", pr)
+	})
+	return refreshDailyTables(s, time.Time{}, summary, err)
+}
 
-	// Initialize a pool of workers to fetch issues
-	p := tunny.NewFunc(poolSize, func(key interface{}) interface{} {
-		defer wg.Done()
+// PerformSyncForIssueKey is the same as `PerformSync` but for a single
+// issue specified by its key.
+func PerformSyncForIssueKey(ctx context.Context, c Client, s store.Store, issueKey string, m Mapper) (ProgressSnapshot, error) {
+	logging.Issue(issueKey, logging.PhaseSync).Info("Sync starting")
+	summary, err := runPipeline(ctx, c, s, singleIssueConfig, m, func(pr *Progress) source {
+		return keysSource([]string{issueKey}, pr)
+	})
+	return refreshDailyTables(s, time.Time{}, summary, err)
+}
 
-		i := c.GetIssue(key.(string))
-		store.ReplaceIssueStateAndEvents(key.(string), m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
-		return nil
+// PerformRetryFailures synchronizes again the issues whose sync
+// failed (see `PerformSync`). Issues which fail again stay
+// quarantined, with their attempts count incremented.
+func PerformRetryFailures(ctx context.Context, c Client, s store.Store, cfg SyncConfig, m Mapper) (ProgressSnapshot, error) {
+	fs, err := s.GetSyncFailures()
+	if err != nil {
+		return ProgressSnapshot{}, fmt.Errorf("error in `PerformRetryFailures`: %s", err)
+	}
+	logging.Phase(logging.PhaseSync).WithField("total", len(fs)).Info("Retry of failures starting")
+	keys := make([]string, 0, len(fs))
+	for _, f := range fs {
+		keys = append(keys, f.IssueKey)
+	}
+	summary, err := runPipeline(ctx, c, s, cfg, m, func(pr *Progress) source {
+		return keysSource(keys, pr)
 	})
-	defer p.Close()
+	return refreshDailyTables(s, time.Time{}, summary, err)
+}
 
-	go func() {
-		for issueKey := range issueKeys {
-			wg.Add(1)
-			go p.Process(issueKey)
-		}
-		wg.Done() // Done when all `issueKeys` have been sent for processing
-	}()
+// runPipeline runs a sync pipeline configured by `cfg` on the keys
+// sent by the source, reporting its progress.
+func runPipeline(ctx context.Context, c Client, s store.Store, cfg SyncConfig, m Mapper, src func(pr *Progress) source) (ProgressSnapshot, error) {
+	beforeSync := time.Now()
+	pr := newProgress()
+	stopProgress := pr.report()
+	p := &pipeline{c: c, s: s, m: m, cfg: cfg, pr: pr}
+	err := p.run(ctx, src(pr))
+	stopProgress()
+	return done(pr, beforeSync, err), err
+}
 
-	c.SearchIssues("ORDER BY updated ASC", issueKeys)
-	wg.Add(1) // Adding a job to wait for the processing of `issueKeys`
+// PerformDailyRefresh refreshes the daily tables built from the
+// synchronized issues, from the day of `from` until today:
+// `jira_cfd_daily` (counts of issues by project and status, to build
+// cumulative flow diagrams) and `jira_flow_daily` (arrivals,
+// throughput and WIP by project, type and tribe). The whole tables
+// are rebuilt if `from` is the zero time.
+//
+// Issues synchronized individually (e.g. retried failures) may have
+// changed at any time, so their syncs rebuild the whole tables.
+func PerformDailyRefresh(s store.Store, from time.Time) error {
+	before := time.Now()
+	if err := s.RefreshCFDDaily(from, before); err != nil {
+		return fmt.Errorf("error in `PerformDailyRefresh`: %s", err)
+	}
+	if err := s.RefreshFlowDaily(from, before); err != nil {
+		return fmt.Errorf("error in `PerformDailyRefresh`: %s", err)
+	}
+	logging.Phase(logging.PhaseStore).WithFields(logging.Since(before)).WithField("from", from).Info("Daily tables refreshed")
+	return nil
+}
 
-	// Wait until all fetches are done
-	wg.Wait()
+// refreshDailyTables refreshes the daily tables once the pipeline is
+// done, unless it was stopped by an error.
+func refreshDailyTables(s store.Store, from time.Time, summary ProgressSnapshot, err error) (ProgressSnapshot, error) {
+	if err != nil {
+		return summary, err
+	}
+	if err := PerformDailyRefresh(s, from); err != nil {
+		logging.Phase(logging.PhaseStore).WithError(err).Error("Daily tables refresh failed")
+		return summary, err
+	}
+	return summary, nil
+}
 
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+// PerformStatusesSync fetches all workflow statuses from the
+// attached Jira instance and replaces the statuses in the store.
+//
+// The fetched statuses are returned so they can be used by the
+// mapper to resolve status IDs and categories.
+func PerformStatusesSync(c Client, s store.Store) ([]store.Status, error) {
+	ss, err := FetchStatuses(c)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ReplaceStatuses(ss); err != nil {
+		return nil, fmt.Errorf("error in `PerformStatusesSync`: %s", err)
+	}
+	return ss, nil
 }
 
-// PerformSyncForIssueKey is the same as `PerformSync` but for a single
-// issue specified by its key.
-func PerformSyncForIssueKey(c Client, store store.Store, issueKey string, m Mapper) {
-	beforeSync := time.Now()
-	log.Printf("Sync for issue `%s` starting\n", issueKey)
+// FetchStatuses fetches all workflow statuses from the attached
+// Jira instance, without storing them.
+func FetchStatuses(c Client) ([]store.Status, error) {
+	jss, err := c.GetStatuses()
+	if err != nil {
+		return nil, fmt.Errorf("error in `FetchStatuses`: %s", err)
+	}
+	ss := make([]store.Status, 0, len(jss))
+	for _, js := range jss {
+		ss = append(ss, storeStatus(js))
+	}
+	return ss, nil
+}
 
-	i := c.GetIssue(issueKey)
-	store.ReplaceIssueStateAndEvents(issueKey, m.IssueStateFromIssue(i), m.IssueEventsFromIssue(i))
+// done logs the summary of a sync run and returns it. The last
+// successful sync time is only updated if no issue failed and the
+// run was not stopped by an error.
+func done(pr *Progress, beforeSync time.Time, err error) ProgressSnapshot {
+	s := pr.Snapshot()
+	entry := logging.Phase(logging.PhaseSync).WithFields(logging.Since(beforeSync)).WithFields(s.fields())
+	switch {
+	case err != nil:
+		entry.WithError(err).Error("Sync stopped")
+		return s
+	case s.Failed > 0:
+		entry.Warn("Sync done with failures")
+		return s
+	}
+	metrics.LastSuccessfulSync.SetToCurrentTime()
+	entry.Info("Sync done")
+	return s
+}
 
-	log.Printf("Sync done in %f minutes\n", time.Since(beforeSync).Minutes())
+func storeStatus(s extJira.Status) store.Status {
+	return store.Status{
+		ID:       s.ID,
+		Name:     s.Name,
+		Category: s.StatusCategory.Name,
+	}
 }
@@ -0,0 +1,158 @@
+package jira_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// TestPerformIncrementalSync_converges changes issues of a fake Jira
+// server between syncs, with faults injected, and checks the store
+// ends up consistent with the server.
+func TestPerformIncrementalSync_converges(t *testing.T) {
+	alice := jiratest.User{AccountID: "alice", DisplayName: "Alice"}
+	bob := jiratest.User{AccountID: "bob", DisplayName: "Bob"}
+
+	js := jiratest.NewServer()
+	// Small pages so the search, changelog and comments are paginated
+	js.MaxResults = 2
+	js.EmbeddedPageSize = 2
+	srv := httptest.NewServer(js)
+	defer srv.Close()
+
+	pj1 := js.CreateIssue("PJ", jiratest.NewIssue{Summary: "First", Reporter: alice})
+	pj2 := js.CreateIssue("PJ", jiratest.NewIssue{Summary: "Second", Reporter: alice, Type: "Bug"})
+	pj3 := js.CreateIssue("PJ", jiratest.NewIssue{Summary: "Third", Reporter: bob})
+	must(t, js.Assign(pj1, &bob, alice))
+	for _, st := range []string{"In Progress", "In Review", "Done"} {
+		must(t, js.Transition(pj1, st, bob))
+	}
+	for _, body := range []string{"One", "Two", "Three"} {
+		must(t, js.AddComment(pj1, body, alice))
+	}
+
+	c, err := client.NewAPIClientForURL(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewAPIClientForURL`: %s", err)
+	}
+	m := newMapper(t, c)
+	s := storetest.NewMemoryStore()
+	cfg := jira.DefaultSyncConfig()
+
+	if _, err := jira.PerformSync(context.Background(), c, s, cfg, m); err != nil {
+		t.Fatalf("unexpected error in `PerformSync`: %s", err)
+	}
+	assertConverged(t, js, s)
+
+	js.Advance(time.Hour)
+	must(t, js.Transition(pj2, "In Progress", alice))
+	must(t, js.AddComment(pj3, "Four", bob))
+	pj4 := js.CreateIssue("PJ", jiratest.NewIssue{Summary: "Fourth", Reporter: bob})
+	ot1, err := js.Move(pj1, "OT", alice)
+	must(t, err)
+	must(t, js.Delete(pj3))
+
+	js.InjectFault(jiratest.Fault{Path: "/search", Status: http.StatusTooManyRequests})
+	js.InjectFault(jiratest.Fault{Path: "/issue/" + pj2, Status: http.StatusServiceUnavailable})
+	js.InjectFault(jiratest.Fault{Path: "/issue/" + pj4, Truncate: true})
+
+	summary, err := jira.PerformIncrementalSync(context.Background(), c, s, cfg, m)
+	if err != nil {
+		t.Fatalf("unexpected error in `PerformIncrementalSync`: %s", err)
+	}
+	if fs, _ := s.GetSyncFailures(); summary.Failed != 2 || len(fs) != 2 {
+		t.Errorf("expected the issues with faults to fail, got `%+v`", summary)
+	}
+	if _, ok := s.Records(ot1); !ok {
+		t.Errorf("expected moved issue `%s` to be stored", ot1)
+	}
+
+	if _, err := jira.PerformRetryFailures(context.Background(), c, s, cfg, m); err != nil {
+		t.Fatalf("unexpected error in `PerformRetryFailures`: %s", err)
+	}
+	if fs, _ := s.GetSyncFailures(); len(fs) != 0 {
+		t.Errorf("expected no failure left, got `%+v`", fs)
+	}
+
+	// The moved and deleted issues are only removed by `verify`
+	for _, k := range []string{pj1, pj3} {
+		if _, ok := s.Records(k); !ok {
+			t.Errorf("expected moved or deleted issue `%s` to be kept by the sync", k)
+		}
+	}
+	r := jira.PerformVerify(c, s, nil, jira.VerifyOptions{Delete: true, Until: js.Now()})
+	if strings.Join(r.Orphaned, ",") != pj1+","+pj3 || r.Deleted != 2 {
+		t.Errorf("expected moved and deleted issues to be deleted, got `%+v`", r)
+	}
+	assertConverged(t, js, s)
+}
+
+// assertConverged checks the issues of the fake server, and only
+// them, are stored up to date.
+func assertConverged(t *testing.T, js *jiratest.Server, s *storetest.MemoryStore) {
+	t.Helper()
+	want := js.Keys()
+	sort.Strings(want)
+	if got := s.Keys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("expected stored issues `%s`, got `%s`", strings.Join(want, ","), strings.Join(got, ","))
+	}
+	for _, k := range js.Keys() {
+		i := js.Issue(k)
+		r, ok := s.Records(k)
+		if !ok {
+			t.Errorf("issue `%s` is not stored", k)
+			continue
+		}
+		if !r.State.UpdatedAt.Equal(i.Updated) || *r.State.Status != i.Status {
+			t.Errorf("issue `%s` is stale: stored `%s` updated at %s, expected `%s` updated at %s",
+				k, *r.State.Status, r.State.UpdatedAt, i.Status, i.Updated)
+		}
+		counts := make(map[string]int)
+		for _, ie := range r.Events {
+			counts[ie.EventKind]++
+		}
+		statusChanges := 1
+		for _, h := range i.Histories {
+			for _, it := range h.Items {
+				if it.Field == "status" {
+					statusChanges++
+				}
+			}
+		}
+		if counts["comment_added"] != len(i.Comments) || counts["status_changed"] != statusChanges {
+			t.Errorf("unexpected events for issue `%s`: %v", k, counts)
+		}
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// newMapper returns a mapper resolving the statuses and using the
+// field schemas fetched from `c`.
+func newMapper(tb testing.TB, c jira.Client) *mapping.Mapper {
+	tb.Helper()
+	ss, err := jira.FetchStatuses(c)
+	if err != nil {
+		tb.Fatalf("unexpected error in `FetchStatuses`: %s", err)
+	}
+	schemas, err := c.GetFieldSchemas()
+	if err != nil {
+		tb.Fatalf("unexpected error in `GetFieldSchemas`: %s", err)
+	}
+	return mapping.NewMapper(ss, schemas)
+}
@@ -0,0 +1,64 @@
+package jira_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// TestPerformSync_replay runs a sync against Jira API responses
+// recorded in `testdata/replay` (see `client.NewReplayAPIClient`),
+// through `go-jira` and the mapper.
+func TestPerformSync_replay(t *testing.T) {
+	c, err := client.NewReplayAPIClient("testdata/replay")
+	if err != nil {
+		t.Fatalf("unexpected error in `NewReplayAPIClient`: %s", err)
+	}
+	s := storetest.NewMemoryStore()
+	m := newMapper(t, c)
+
+	summary, err := jira.PerformSync(context.Background(), c, s, jira.DefaultSyncConfig(), m)
+	if err != nil {
+		t.Fatalf("unexpected error in `PerformSync`: %s", err)
+	}
+	if summary.Total != 2 || summary.Stored != 2 || summary.Failed != 0 {
+		fs, _ := s.GetSyncFailures()
+		t.Fatalf("unexpected summary `%+v` (failures: %+v)", summary, fs)
+	}
+
+	r, _ := s.Records("PJ-1")
+	is := r.State
+	if *is.Status != "Done" || *is.StatusCategory != "Done" || is.ResolvedAt == nil ||
+		*is.AssigneeName != "Bob Durand" || *is.DeveloperBackendName != "Bob Durand" ||
+		len(is.Tribes) != 1 || is.Tribes[0] != "Data" || is.Labels[0] != "reporting" {
+		t.Errorf("unexpected state for PJ-1: %+v", is)
+	}
+	kinds := make([]string, 0, len(r.Events))
+	for _, ie := range r.Events {
+		kinds = append(kinds, ie.EventKind)
+	}
+	sort.Strings(kinds)
+	expected := []string{"assignee_changed", "assignee_changed", "comment_added", "created", "status_changed", "status_changed", "status_changed"}
+	if len(kinds) != len(expected) {
+		t.Fatalf("unexpected events for PJ-1: %v", kinds)
+	}
+	for n := range kinds {
+		if kinds[n] != expected[n] {
+			t.Errorf("unexpected events for PJ-1: %v", kinds)
+			break
+		}
+	}
+	if len(r.StatusPeriods) != 3 || *r.StatusPeriods[1].StatusCategory != "In Progress" {
+		t.Errorf("unexpected status periods for PJ-1: %+v", r.StatusPeriods)
+	}
+
+	r, _ = s.Records("PJ-2")
+	is = r.State
+	if *is.Status != "Open" || *is.StatusCategory != "To Do" || is.Assignee != nil || is.ResolvedAt != nil {
+		t.Errorf("unexpected state for PJ-2: %+v", is)
+	}
+}
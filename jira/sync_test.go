@@ -1,36 +1,44 @@
 package jira_test
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	extJira "github.com/andygrunwald/go-jira"
 
 	"github.com/rchampourlier/kaizenizer-source-jira/jira"
-	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
 )
 
-// TODO: expectations should be checked (ensure they have been
-// verified)
-
 type mapperMock struct{}
 
-func (m *mapperMock) IssueEventsFromIssue(i *extJira.Issue) []store.IssueEvent {
-	return []store.IssueEvent{store.IssueEvent{}}
+func (m *mapperMock) IssueEventsFromIssue(i *extJira.Issue) ([]store.IssueEvent, error) {
+	return []store.IssueEvent{store.IssueEvent{}}, nil
 }
 
 func (m *mapperMock) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
 	return store.IssueState{}
 }
 
+func (m *mapperMock) IssueStatusPeriodsFromEvents(i *extJira.Issue, ies []store.IssueEvent) []store.IssueStatusPeriod {
+	return []store.IssueStatusPeriod{store.IssueStatusPeriod{}}
+}
+
+func (m *mapperMock) UsersFromIssue(i *extJira.Issue) []store.User {
+	return nil
+}
+
 func TestPerformIncrementalSync(t *testing.T) {
 	refTime := time.Now()
 	issueKeys := []string{"PJ-1", "PJ-2", "PJ-3"}
 
-	c := client.NewMockClient(t)
-	s := NewMockStore(t)
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
 
 	s.ExpectGetRestartFromUpdatedAt().WillReturn(refTime)
 
@@ -56,17 +64,22 @@ func TestPerformIncrementalSync(t *testing.T) {
 			WithIssueKey(k).
 			WithIssueState(&store.IssueState{}).
 			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
 			WillReturnError(nil)
 	}
 
-	jira.PerformIncrementalSync(c, s, 10, &mapperMock{})
+	// refresh the daily tables from the restart point
+	s.ExpectRefreshCFDDaily(refTime)
+	s.ExpectRefreshFlowDaily(refTime)
+
+	jira.PerformIncrementalSync(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
 }
 
 func TestPerformSync(t *testing.T) {
 	issueKeys := []string{"PJ-1", "PJ-2", "PJ-3"}
 
-	c := client.NewMockClient(t)
-	s := NewMockStore(t)
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
 
 	// Perform a search with `updated > 'max issue_updated_at'`
 	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys(issueKeys)
@@ -82,17 +95,27 @@ func TestPerformSync(t *testing.T) {
 			WithIssueKey(k).
 			WithIssueState(&store.IssueState{}).
 			WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+			WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
 			WillReturnError(nil)
 	}
 
-	jira.PerformSync(c, s, 10, &mapperMock{})
+	// rebuild the daily tables
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	jira.PerformSync(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+
+	p := jira.LastProgress().Snapshot()
+	if !p.Done || p.Total != 3 || p.Discovered != 3 || p.Fetched != 3 || p.Stored != 3 || p.Failed != 0 || p.ETA != nil {
+		t.Errorf("unexpected progress after sync: %+v", p)
+	}
 }
 
 func TestPerformSyncForIssueKey(t *testing.T) {
 	k := "PJ-1"
 
-	c := client.NewMockClient(t)
-	s := NewMockStore(t)
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
 
 	c.ExpectGetIssue(k).WillRespondWithIssue(&extJira.Issue{})
 
@@ -102,9 +125,166 @@ func TestPerformSyncForIssueKey(t *testing.T) {
 		WithIssueKey(k).
 		WithIssueState(&store.IssueState{}).
 		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
+		WillReturnError(nil)
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	jira.PerformSyncForIssueKey(context.Background(), c, s, k, &mapperMock{})
+}
+
+func TestPerformSync_failures(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys([]string{"PJ-1", "PJ-2", "PJ-3"})
+
+	// PJ-1 is synchronized
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
 		WillReturnError(nil)
 
-	jira.PerformSyncForIssueKey(c, s, k, &mapperMock{})
+	// PJ-2 fails to be fetched
+	c.ExpectGetIssue("PJ-2").WillRespondWithError(fmt.Errorf("timeout"))
+	s.ExpectUpsertSyncFailure().WithIssueKey("PJ-2").WithStage(store.SyncStageFetch)
+
+	// PJ-3 fails to be stored
+	c.ExpectGetIssue("PJ-3").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-3").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
+		WillReturnError(fmt.Errorf("connection lost"))
+	s.ExpectUpsertSyncFailure().WithIssueKey("PJ-3").WithStage(store.SyncStageStore)
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	summary, err := jira.PerformSync(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if summary.Stored != 1 || summary.Failed != 2 {
+		t.Errorf("expected 1 stored and 2 failed issues, got %+v", summary)
+	}
+}
+
+// panickingMapperMock is a mapper panicking when mapping the
+// issue's state.
+type panickingMapperMock struct {
+	mapperMock
+}
+
+func (m *panickingMapperMock) IssueStateFromIssue(i *extJira.Issue) store.IssueState {
+	var p *extJira.Priority
+	return store.IssueState{Priority: &p.Name}
+}
+
+func TestPerformSyncForIssueKey_mapperPanic(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{Self: "https://jira/rest/api/2/issue/1"})
+	s.ExpectUpsertSyncFailure().WithIssueKey("PJ-1").WithStage(store.SyncStageMap)
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	summary, err := jira.PerformSyncForIssueKey(context.Background(), c, s, "PJ-1", &panickingMapperMock{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if summary.Failed != 1 {
+		t.Errorf("expected the issue to fail, got %+v", summary)
+	}
+}
+
+func TestPerformRetryFailures(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	s.ExpectGetSyncFailures().WillReturn([]store.SyncFailure{
+		store.SyncFailure{IssueKey: "PJ-1", Stage: store.SyncStageFetch},
+	})
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{})
+	s.ExpectReplaceIssueStateAndEvents().
+		WithIssueKey("PJ-1").
+		WithIssueState(&store.IssueState{}).
+		WithIssueEvents([]*store.IssueEvent{&store.IssueEvent{}}).
+		WithIssueStatusPeriods([]*store.IssueStatusPeriod{&store.IssueStatusPeriod{}}).
+		WillReturnError(nil)
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	summary, err := jira.PerformRetryFailures(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if summary.Total != 1 || summary.Stored != 1 || summary.Failed != 0 {
+		t.Errorf("expected the failure to be retried successfully, got %+v", summary)
+	}
+}
+
+func TestPerformRetryFailures_storeError(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	s.ExpectGetSyncFailures().WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := jira.PerformRetryFailures(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("expected the store error, got %v", err)
+	}
+}
+
+func TestPerformSync_dailyRefreshFailure(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectSearchIssues("ORDER BY updated ASC").WillRespondWithIssueKeys(nil)
+	s.ExpectRefreshCFDDaily(time.Time{}).WillReturnError(fmt.Errorf("connection lost"))
+
+	_, err := jira.PerformSync(context.Background(), c, s, jira.DefaultSyncConfig(), &mapperMock{})
+	if err == nil || !strings.Contains(err.Error(), "connection lost") {
+		t.Errorf("expected the refresh error, got %v", err)
+	}
+}
+
+func TestPerformStatusesSync(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectGetStatuses().WillRespondWithStatuses([]extJira.Status{
+		extJira.Status{ID: "1", Name: "Open", StatusCategory: extJira.StatusCategory{Name: "To Do"}},
+		extJira.Status{ID: "3", Name: "In Progress", StatusCategory: extJira.StatusCategory{Name: "In Progress"}},
+	})
+	expected := []store.Status{
+		store.Status{ID: "1", Name: "Open", Category: "To Do"},
+		store.Status{ID: "3", Name: "In Progress", Category: "In Progress"},
+	}
+	s.ExpectReplaceStatuses().WithStatuses(expected).WillReturnError(nil)
+
+	ss, err := jira.PerformStatusesSync(c, s)
+	if err != nil {
+		t.Fatalf("unexpected error in `PerformStatusesSync`: %s", err)
+	}
+	if len(ss) != len(expected) {
+		t.Errorf("expected %d statuses to be returned, got %d", len(expected), len(ss))
+	}
+}
+
+func TestPerformStatusesSync_fetchError(t *testing.T) {
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	c.ExpectGetStatuses().WillRespondWithError(fmt.Errorf("unavailable"))
+
+	if _, err := jira.PerformStatusesSync(c, s); err == nil {
+		t.Errorf("expected an error, got nil")
+	}
 }
 
 func timeAsStr(t time.Time) string {
@@ -0,0 +1,313 @@
+package jira
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// VerifyOptions configures `PerformVerify`.
+type VerifyOptions struct {
+	// Sample is the number of stored issues, randomly selected,
+	// which are spot-checked by re-mapping them (see
+	// `PerformDiff`). No issue is spot-checked if zero.
+	Sample int
+
+	// Full compares the issues of every project month, not only
+	// those of the months whose counts differ. It detects stale
+	// issues in months whose counts match, at the cost of a search
+	// per month.
+	Full bool
+
+	// Enqueue enqueues the missing and stale issues for re-sync, as
+	// failures of the `store.SyncStageVerify` stage (see
+	// `PerformRetryFailures`).
+	Enqueue bool
+
+	// Delete deletes the orphaned issues from the store, then
+	// rebuilds the daily tables (see `PerformDailyRefresh`). Issues
+	// the synchronizing user can't see anymore are orphaned too, so
+	// it must be explicitly requested.
+	Delete bool
+
+	// Until is the end of the checked period. Defaults to the
+	// current time if zero.
+	Until time.Time
+}
+
+// VerifyReport is the result of `PerformVerify`.
+//
+// `Missing` issues are in Jira but not in the store, `Stale` ones
+// were updated in Jira after their stored `issue_updated_at`, and
+// `Orphaned` ones are in the store but not in Jira (e.g. deleted or
+// moved to another project).
+type VerifyReport struct {
+	MonthsChecked int          `json:"months_checked"`
+	CountMismatch []MonthCount `json:"count_mismatches"`
+	Missing       []string     `json:"missing"`
+	Stale         []string     `json:"stale"`
+	Orphaned      []string     `json:"orphaned"`
+	Enqueued      int          `json:"enqueued"`
+	Deleted       int          `json:"deleted"`
+	Sample        DiffReport   `json:"sample"`
+	stale         map[string]bool
+}
+
+// MonthCount is the number of issues of a project created during a
+// month, in Jira and in the store.
+type MonthCount struct {
+	Project string `json:"project"`
+	Month   string `json:"month"`
+	Jira    int    `json:"jira"`
+	Store   int    `json:"store"`
+}
+
+// Consistent returns true if no difference was found between Jira
+// and the store.
+func (r VerifyReport) Consistent() bool {
+	return len(r.CountMismatch) == 0 &&
+		len(r.Missing) == 0 &&
+		len(r.Stale) == 0 &&
+		len(r.Orphaned) == 0 &&
+		r.Sample.Changed == 0 &&
+		r.Sample.New == 0 &&
+		r.Sample.Failed == 0
+}
+
+// PerformVerify checks the store is consistent with Jira:
+//
+//   - The number of issues by project and month of creation is
+//     compared with the count returned by a JQL query, from the
+//     first stored month of each project until now.
+//   - For the months whose counts differ (or all months with
+//     `Full`), the issue keys and update times are compared to find
+//     the missing, stale and orphaned issues.
+//   - A random sample of stored issues is re-mapped and compared
+//     with the stored records (see `PerformDiff`). Sampled issues
+//     whose update time changed are reported as stale.
+//
+// Nothing is written to the store, unless `Enqueue` or `Delete` is
+// set.
+func PerformVerify(c Client, s store.Store, m Mapper, opts VerifyOptions) VerifyReport {
+	until := opts.Until
+	if until.IsZero() {
+		until = time.Now()
+	}
+	logging.Phase(logging.PhaseSync).Info("Verify starting")
+
+	cs, err := s.CountIssuesByProjectAndMonth()
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `CountIssuesByProjectAndMonth`")
+	}
+	r := VerifyReport{stale: make(map[string]bool)}
+	for _, mc := range monthCounts(cs, until) {
+		r.MonthsChecked++
+		from := mc.Month
+		to := from.AddDate(0, 1, 0)
+		q := monthQuery(mc.Project, from, to)
+		n, err := c.CountIssues(q)
+		if err != nil {
+			logging.Phase(logging.PhaseSearch).WithError(err).Fatal("error in `PerformVerify`")
+		}
+		if n != mc.Count {
+			r.CountMismatch = append(r.CountMismatch, MonthCount{
+				Project: mc.Project,
+				Month:   from.Format("2006-01"),
+				Jira:    n,
+				Store:   mc.Count,
+			})
+		} else if !opts.Full {
+			continue
+		}
+		r.compareMonth(c, s, q, mc.Project, from, to)
+	}
+
+	if opts.Sample > 0 {
+		ks, err := s.SampleIssueKeys(opts.Sample)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `SampleIssueKeys`")
+		}
+		r.Sample = PerformDiff(c, s, m, ks)
+		for _, d := range r.Sample.Issues {
+			for _, fd := range d.Fields {
+				if fd.Field == "state.UpdatedAt" && !r.stale[d.IssueKey] {
+					r.Stale = append(r.Stale, d.IssueKey)
+					r.stale[d.IssueKey] = true
+				}
+			}
+		}
+	}
+	sort.Strings(r.Missing)
+	sort.Strings(r.Stale)
+	sort.Strings(r.Orphaned)
+
+	if opts.Enqueue {
+		r.enqueue(s)
+	}
+	if opts.Delete {
+		r.delete(s)
+	}
+	logging.Phase(logging.PhaseSync).WithFields(logging.Fields{
+		"months_checked": r.MonthsChecked,
+		"mismatches":     len(r.CountMismatch),
+		"missing":        len(r.Missing),
+		"stale":          len(r.Stale),
+		"orphaned":       len(r.Orphaned),
+		"enqueued":       r.Enqueued,
+		"deleted":        r.Deleted,
+	}).Info("Verify done")
+	return r
+}
+
+// compareMonth compares the keys and update times of the issues of
+// the project month in Jira and in the store.
+func (r *VerifyReport) compareMonth(c Client, s store.Store, q string, project string, from time.Time, to time.Time) {
+	jus, err := c.SearchIssueUpdates(q)
+	if err != nil {
+		logging.Phase(logging.PhaseSearch).WithError(err).Fatal("error in `PerformVerify`")
+	}
+	sus, err := s.GetIssuesUpdatedAt(project, from, to)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `GetIssuesUpdatedAt`")
+	}
+	for k, ju := range jus {
+		su, ok := sus[k]
+		switch {
+		case !ok:
+			r.Missing = append(r.Missing, k)
+		case wallClock(ju).After(wallClock(su)):
+			r.Stale = append(r.Stale, k)
+			r.stale[k] = true
+		}
+	}
+	for k := range sus {
+		if _, ok := jus[k]; !ok {
+			r.Orphaned = append(r.Orphaned, k)
+		}
+	}
+}
+
+// enqueue records the missing and stale issues as sync failures so
+// they are synchronized by `PerformRetryFailures`. Orphaned issues
+// are only reported since they can't be fetched anymore.
+func (r *VerifyReport) enqueue(s store.Store) {
+	enqueue := func(k string, reason string) {
+		f := store.SyncFailure{
+			IssueKey: k,
+			Stage:    store.SyncStageVerify,
+			Error:    reason,
+			RunID:    logging.RunID(),
+			FailedAt: time.Now(),
+		}
+		if err := s.UpsertSyncFailure(f); err != nil {
+			logging.Issue(k, logging.PhaseStore).WithError(err).Error("error in `UpsertSyncFailure`")
+			return
+		}
+		r.Enqueued++
+	}
+	for _, k := range r.Missing {
+		enqueue(k, "missing from the store")
+	}
+	for _, k := range r.Stale {
+		enqueue(k, "stale in the store")
+	}
+}
+
+// delete deletes the orphaned issues from the store and rebuilds the
+// daily tables, since the deleted issues are counted in earlier days.
+func (r *VerifyReport) delete(s store.Store) {
+	if len(r.Orphaned) == 0 {
+		return
+	}
+	if err := s.DeleteIssues(r.Orphaned); err != nil {
+		logging.Phase(logging.PhaseStore).WithError(err).Error("error in `DeleteIssues`")
+		return
+	}
+	r.Deleted = len(r.Orphaned)
+	if err := PerformDailyRefresh(s, time.Time{}); err != nil {
+		logging.Phase(logging.PhaseStore).WithError(err).Error("Daily tables refresh failed")
+	}
+}
+
+// monthCounts returns the stored counts for every month from the
+// first stored month of each project until the month of `until`,
+// including the months without stored issues.
+func monthCounts(cs []store.IssueCount, until time.Time) []store.IssueCount {
+	byProject := make(map[string]map[time.Time]int)
+	first := make(map[string]time.Time)
+	for _, c := range cs {
+		month := monthStart(c.Month)
+		if byProject[c.Project] == nil {
+			byProject[c.Project] = make(map[time.Time]int)
+		}
+		byProject[c.Project][month] += c.Count
+		if f, ok := first[c.Project]; !ok || month.Before(f) {
+			first[c.Project] = month
+		}
+	}
+	projects := make([]string, 0, len(byProject))
+	for p := range byProject {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+
+	last := monthStart(until)
+	var mcs []store.IssueCount
+	for _, p := range projects {
+		for month := first[p]; !month.After(last); month = month.AddDate(0, 1, 0) {
+			mcs = append(mcs, store.IssueCount{Project: p, Month: month, Count: byProject[p][month]})
+		}
+	}
+	return mcs
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthQuery returns the JQL query for the issues of the project
+// created in [from, to).
+func monthQuery(project string, from time.Time, to time.Time) string {
+	return fmt.Sprintf(`project = "%s" AND created >= "%s" AND created < "%s"`,
+		strings.Replace(project, `"`, `\"`, -1),
+		from.Format("2006-01-02"),
+		to.Format("2006-01-02"))
+}
+
+// WriteJSON writes the report as JSON.
+func (r VerifyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes a human readable summary of the report.
+func (r VerifyReport) WriteText(w io.Writer) error {
+	p := &errWriter{w: w}
+	p.printf("Months checked: %d, count mismatches: %d\n", r.MonthsChecked, len(r.CountMismatch))
+	for _, mc := range r.CountMismatch {
+		p.printf("  %s %s: %d in Jira, %d in the store\n", mc.Project, mc.Month, mc.Jira, mc.Store)
+	}
+	p.printf("Missing: %d %s\n", len(r.Missing), strings.Join(r.Missing, " "))
+	p.printf("Stale: %d %s\n", len(r.Stale), strings.Join(r.Stale, " "))
+	p.printf("Orphaned: %d %s\n", len(r.Orphaned), strings.Join(r.Orphaned, " "))
+	if r.Enqueued > 0 {
+		p.printf("Enqueued for re-sync: %d (run `retry-failures`)\n", r.Enqueued)
+	}
+	if r.Deleted > 0 {
+		p.printf("Deleted from the store: %d\n", r.Deleted)
+	}
+	if r.Sample.Compared > 0 {
+		p.printf("\nSample:\n")
+		if p.err == nil {
+			p.err = r.Sample.WriteText(w)
+		}
+	}
+	return p.err
+}
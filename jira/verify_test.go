@@ -0,0 +1,139 @@
+package jira_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	extJira "github.com/andygrunwald/go-jira"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/jira"
+	"github.com/rchampourlier/kaizenizer-source-jira/jira/jiratest"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+func TestPerformVerify(t *testing.T) {
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	feb := jan.AddDate(0, 1, 0)
+	mar := jan.AddDate(0, 2, 0)
+	updatedAt := time.Date(2020, 3, 10, 10, 0, 0, 0, time.UTC)
+
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	s.ExpectCountIssuesByProjectAndMonth().WillReturn([]store.IssueCount{
+		store.IssueCount{Project: "PJ", Month: jan, Count: 2},
+		store.IssueCount{Project: "PJ", Month: mar, Count: 2},
+	})
+
+	// January: same counts, not compared further
+	c.ExpectCountIssues(`project = "PJ" AND created >= "2020-01-01" AND created < "2020-02-01"`).WillRespondWithCount(2)
+
+	// February: no stored issue
+	febQuery := `project = "PJ" AND created >= "2020-02-01" AND created < "2020-03-01"`
+	c.ExpectCountIssues(febQuery).WillRespondWithCount(1)
+	c.ExpectSearchIssueUpdates(febQuery).WillRespondWithUpdates(map[string]time.Time{"PJ-5": updatedAt})
+	s.ExpectGetIssuesUpdatedAt("PJ", feb).WillReturn(map[string]time.Time{})
+
+	// March: a stale, a missing and an orphaned issue
+	marQuery := `project = "PJ" AND created >= "2020-03-01" AND created < "2020-04-01"`
+	c.ExpectCountIssues(marQuery).WillRespondWithCount(3)
+	c.ExpectSearchIssueUpdates(marQuery).WillRespondWithUpdates(map[string]time.Time{
+		"PJ-6": updatedAt,
+		"PJ-7": updatedAt,
+		"PJ-9": updatedAt,
+	})
+	s.ExpectGetIssuesUpdatedAt("PJ", mar).WillReturn(map[string]time.Time{
+		"PJ-6": updatedAt.Add(-time.Hour),
+		"PJ-8": updatedAt,
+		"PJ-9": updatedAt,
+	})
+
+	// Spot-check of an unchanged issue
+	s.ExpectSampleIssueKeys().WillReturn([]string{"PJ-1"})
+	c.ExpectGetIssue("PJ-1").WillRespondWithIssue(&extJira.Issue{Key: "PJ-1"})
+	s.ExpectGetIssueStateAndEvents("PJ-1").WillReturn(&store.IssueState{}, []store.IssueEvent{store.IssueEvent{}})
+
+	for _, k := range []string{"PJ-5", "PJ-6", "PJ-7"} {
+		s.ExpectUpsertSyncFailure().WithIssueKey(k).WithStage(store.SyncStageVerify)
+	}
+
+	r := jira.PerformVerify(c, s, &mapperMock{}, jira.VerifyOptions{
+		Sample:  1,
+		Enqueue: true,
+		Until:   time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC),
+	})
+
+	if r.MonthsChecked != 3 || len(r.CountMismatch) != 2 {
+		t.Errorf("unexpected counts in report `%+v`\n", r)
+	}
+	if strings.Join(r.Missing, ",") != "PJ-5,PJ-7" {
+		t.Errorf("unexpected missing issues `%v`\n", r.Missing)
+	}
+	if strings.Join(r.Stale, ",") != "PJ-6" {
+		t.Errorf("unexpected stale issues `%v`\n", r.Stale)
+	}
+	if strings.Join(r.Orphaned, ",") != "PJ-8" {
+		t.Errorf("unexpected orphaned issues `%v`\n", r.Orphaned)
+	}
+	if r.Enqueued != 3 || r.Sample.Unchanged != 1 {
+		t.Errorf("unexpected report `%+v`\n", r)
+	}
+	if r.Consistent() {
+		t.Errorf("expected report to be inconsistent")
+	}
+
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error in `WriteText`: %s\n", err)
+	}
+	if !strings.Contains(b.String(), "PJ 2020-02: 1 in Jira, 0 in the store") {
+		t.Errorf("unexpected text output:\n%s", b.String())
+	}
+}
+
+func TestPerformVerify_delete(t *testing.T) {
+	jan := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	updatedAt := time.Date(2020, 1, 10, 10, 0, 0, 0, time.UTC)
+
+	c := jiratest.NewFakeClient(t)
+	s := storetest.NewFakeStore(t)
+
+	s.ExpectCountIssuesByProjectAndMonth().WillReturn([]store.IssueCount{
+		store.IssueCount{Project: "PJ", Month: jan, Count: 3},
+	})
+
+	// PJ-2 was deleted and PJ-3 moved to another project
+	janQuery := `project = "PJ" AND created >= "2020-01-01" AND created < "2020-02-01"`
+	c.ExpectCountIssues(janQuery).WillRespondWithCount(1)
+	c.ExpectSearchIssueUpdates(janQuery).WillRespondWithUpdates(map[string]time.Time{"PJ-1": updatedAt})
+	s.ExpectGetIssuesUpdatedAt("PJ", jan).WillReturn(map[string]time.Time{
+		"PJ-1": updatedAt,
+		"PJ-2": updatedAt,
+		"PJ-3": updatedAt,
+	})
+
+	// Their counts in earlier days are removed by rebuilding the
+	// whole daily tables
+	s.ExpectDeleteIssues([]string{"PJ-2", "PJ-3"})
+	s.ExpectRefreshCFDDaily(time.Time{})
+	s.ExpectRefreshFlowDaily(time.Time{})
+
+	r := jira.PerformVerify(c, s, nil, jira.VerifyOptions{
+		Delete: true,
+		Until:  time.Date(2020, 1, 15, 0, 0, 0, 0, time.UTC),
+	})
+
+	if strings.Join(r.Orphaned, ",") != "PJ-2,PJ-3" || r.Deleted != 2 {
+		t.Errorf("unexpected report `%+v`\n", r)
+	}
+	var b bytes.Buffer
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("unexpected error in `WriteText`: %s\n", err)
+	}
+	if !strings.Contains(b.String(), "Deleted from the store: 2") {
+		t.Errorf("unexpected text output:\n%s", b.String())
+	}
+}
@@ -0,0 +1,143 @@
+// Package logging provides the leveled, structured logger used by
+// the client, the sync and the store.
+//
+// Log entries are JSON objects (one per line) by default. Entries
+// related to a sync run carry the run's ID (`run_id`), and entries
+// related to an issue carry its key (`issue_key`), the processing
+// phase (`phase`) and, when relevant, the duration of the operation
+// in milliseconds (`duration_ms`).
+//
+// The logger is configured by the following environment variables
+// (see `Configure`):
+//
+// - `LOG_LEVEL`: `debug`, `info` (default), `warn` or `error`
+// - `LOG_FORMAT`: `json` (default) or `text`
+// - `LOG_PROGRESS_INTERVAL`: the interval between progress
+//   summaries during syncs (default: 30s)
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Field names
+const (
+	FieldRunID    = "run_id"
+	FieldIssueKey = "issue_key"
+	FieldPhase    = "phase"
+	FieldDuration = "duration_ms"
+)
+
+// Phases, used as values of `FieldPhase`
+const (
+	PhaseSearch = "search"
+	PhaseFetch  = "fetch"
+	PhaseMap    = "map"
+	PhaseStore  = "store"
+	PhaseSync   = "sync"
+)
+
+// Fields is a set of fields of a log entry.
+type Fields = logrus.Fields
+
+// ProgressInterval is the interval between progress summaries
+// logged during syncs.
+var ProgressInterval = 30 * time.Second
+
+var logger = newLogger()
+
+var current = struct {
+	sync.RWMutex
+	runID string
+	entry *logrus.Entry
+}{entry: logrus.NewEntry(logger)}
+
+func newLogger() *logrus.Logger {
+	l := logrus.New()
+	l.SetOutput(os.Stderr)
+	l.SetFormatter(&logrus.JSONFormatter{})
+	return l
+}
+
+// Configure configures the logger according to the `LOG_LEVEL`,
+// `LOG_FORMAT` and `LOG_PROGRESS_INTERVAL` environment variables.
+func Configure() error {
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		level, err := logrus.ParseLevel(v)
+		if err != nil {
+			return fmt.Errorf("invalid LOG_LEVEL `%s`", v)
+		}
+		logger.SetLevel(level)
+	}
+	switch v := os.Getenv("LOG_FORMAT"); v {
+	case "", "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	case "text":
+		logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT `%s`", v)
+	}
+	if v := os.Getenv("LOG_PROGRESS_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid LOG_PROGRESS_INTERVAL `%s`", v)
+		}
+		ProgressInterval = d
+	}
+	return nil
+}
+
+// StartRun generates a new run ID, which is added to all entries
+// logged until the next call, and returns it.
+func StartRun() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+
+	current.Lock()
+	defer current.Unlock()
+	current.runID = id
+	current.entry = logrus.NewEntry(logger).WithField(FieldRunID, id)
+	return id
+}
+
+// RunID returns the ID of the current run, or an empty string if
+// no run was started.
+func RunID() string {
+	current.RLock()
+	defer current.RUnlock()
+	return current.runID
+}
+
+// Log returns the entry to log with, carrying the current run ID
+// if a run was started.
+func Log() *logrus.Entry {
+	current.RLock()
+	defer current.RUnlock()
+	return current.entry
+}
+
+// Issue returns an entry for the specified issue and phase.
+func Issue(issueKey string, phase string) *logrus.Entry {
+	return Log().WithFields(Fields{
+		FieldIssueKey: issueKey,
+		FieldPhase:    phase,
+	})
+}
+
+// Phase returns an entry for the specified phase.
+func Phase(phase string) *logrus.Entry {
+	return Log().WithField(FieldPhase, phase)
+}
+
+// Since returns the fields with the duration elapsed since `start`.
+func Since(start time.Time) Fields {
+	return Fields{FieldDuration: time.Since(start).Milliseconds()}
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIssue(t *testing.T) {
+	var buf bytes.Buffer
+	logger.SetOutput(&buf)
+	defer logger.SetOutput(os.Stderr)
+
+	runID := StartRun()
+	Issue("PJ-1", PhaseFetch).WithFields(Since(time.Now())).Info("Fetched issue")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("expected a JSON entry, got `%s`", buf.String())
+	}
+	expected := map[string]interface{}{
+		FieldRunID:    runID,
+		FieldIssueKey: "PJ-1",
+		FieldPhase:    PhaseFetch,
+		"level":       "info",
+		"msg":         "Fetched issue",
+	}
+	for k, v := range expected {
+		if entry[k] != v {
+			t.Errorf("expected `%s` to be `%v`, got `%v`", k, v, entry[k])
+		}
+	}
+	if _, ok := entry[FieldDuration]; !ok {
+		t.Errorf("expected entry to have `%s`", FieldDuration)
+	}
+}
+
+func TestConfigure_invalid(t *testing.T) {
+	for _, v := range []string{"LOG_LEVEL", "LOG_FORMAT", "LOG_PROGRESS_INTERVAL"} {
+		os.Setenv(v, "invalid")
+		if err := Configure(); err == nil {
+			t.Errorf("expected an error for an invalid `%s`", v)
+		}
+		os.Unsetenv(v)
+	}
+}
@@ -1,25 +1,50 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/rchampourlier/kaizenizer-source-jira/forecast"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/client"
 	"github.com/rchampourlier/kaizenizer-source-jira/jira/mapping"
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+	"github.com/rchampourlier/kaizenizer-source-jira/metrics"
+	"github.com/rchampourlier/kaizenizer-source-jira/privacy"
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 )
 
-const poolSize = 10
-
 // MaxOpenConns defines the maximum number of open connections
 // to the DB.
 const MaxOpenConns = 5 // for Heroku Postgres
 
+// Default configuration of the `daemon` action, overridden by the
+// `METRICS_ADDR` and `SYNC_INTERVAL` environment variables.
+const (
+	defaultMetricsAddr  = ":9090"
+	defaultSyncInterval = 15 * time.Minute
+)
+
 // Main program
 //
+// Logs are written to stderr as JSON lines. See the `logging`
+// package for the environment variables configuring them.
+//
+// If the `PRIVACY_HMAC_KEY` environment variable is set, the privacy
+// mode is applied to the records written to the store (see the
+// `privacy` package).
+//
 // ### reset
 //
 // Initializes the connected database. Drops the existing tables if
@@ -31,12 +56,125 @@ const MaxOpenConns = 5 // for Heroku Postgres
 // Performs an incremental sync, only fetching issues updated after
 // the maximum `updated_at` of issues already stored in the application.
 //
-// NB: the incremental sync will fail if started from an empty database.
+// Before synchronizing issues, all sync actions refresh the workflow
+// statuses stored in `jira_statuses`, which are used to resolve the
+// status categories of issues and events.
+//
+// NB: started from an empty database, the incremental sync fetches
+// all issues, as the restart point is then the zero time (see
+// `store.PGStore.GetRestartFromUpdatedAt`).
+//
+// After synchronizing issues, all sync actions refresh the daily
+// tables `jira_cfd_daily` (counts of issues by project and status)
+// and `jira_flow_daily` (arrivals, throughput and WIP by project,
+// type and tribe), see `jira.PerformDailyRefresh`: the incremental
+// sync from its restart point, the other actions rebuilding the
+// whole tables.
+//
+// The sync pipeline is configured by the `SYNC_FETCH_WORKERS`,
+// `SYNC_MAP_WORKERS`, `SYNC_STORE_WORKERS`, `SYNC_BUFFER_SIZE` and
+// `SYNC_BATCH_SIZE` environment variables (see `jira.SyncConfig`).
+// Requests to the Jira API are rate limited by `JIRA_RATE_LIMIT`
+// (requests per second) and `JIRA_RATE_BURST`, shared by all
+// workers. A sync is stopped on SIGINT or SIGTERM.
 //
 // ### sync-issue <issue key>
 //
 // Synchronizes only the issue specified by the passed key.
 //
+// ### retry-failures
+//
+// Synchronizes again the issues whose sync failed. Issues failing
+// to be fetched, mapped or stored don't stop a sync: they are
+// recorded in `jira_sync_failures` and the sync action exits with
+// a non-zero status once done.
+//
+// ### diff [--jql <query> | --sample <n>] [--limit <n>] [--format text|json] [--cache <dir>]
+//
+// Fetches the issues matching the JQL query (or a random sample of
+// `n` stored issues, default: 100), maps them and compares the
+// resulting states and events, field by field, with the stored
+// ones. Nothing is written to the store. Prints a summary (`text`,
+// default) or the whole diff as JSON.
+//
+// With `--cache`, fetched issues are cached in the directory and
+// read from it on the next runs, so the mapper can be changed and
+// the diff run again on the same issues.
+//
+// ### verify [--sample <n>] [--full] [--enqueue] [--delete] [--format text|json]
+//
+// Checks the store is consistent with Jira: compares the number of
+// issues by project and month of creation with JQL counts, lists
+// the missing, stale and orphaned issues of the months whose counts
+// differ (of all months with `--full`), and spot-checks a random
+// sample of `n` stored issues (default: 20) as `diff` does. With
+// `--enqueue`, missing and stale issues are enqueued for
+// `retry-failures`. With `--delete`, orphaned issues (deleted or
+// moved to another project in Jira, which the syncs keep) are
+// deleted from the store and the daily tables rebuilt. Exits with a
+// non-zero status if inconsistencies are found.
+//
+// ### report cycle-time [--start <category>] [--end <category>] [--period week|month|quarter] [--from <date>] [--to <date>] [--project <keys>] [--format table|csv|json]
+//
+// Computes the 50th, 85th and 95th percentiles of the lead times
+// (from creation to resolution) and cycle times (from the first
+// entry in the `--start` status category, default: `In Progress`,
+// to the last entry in the `--end` one, default: `Done`) of the
+// issues completed in [`--from`, `--to`) (dates as `YYYY-MM-DD`), by
+// project, issue type and period of completion (default: month).
+// `--project` restricts the report to a comma-separated list of
+// projects. See the `report` package for details.
+//
+// ### report flow-efficiency [--period week|month|quarter] [--from <date>] [--to <date>] [--project <keys>] [--format table|csv|json]
+//
+// Aggregates the flow efficiency of the issues resolved in
+// [`--from`, `--to`), by tribe and period of resolution (default:
+// month). The flow efficiency of an issue, stored in
+// `jira_issues_states`, is the ratio of the time it spent in active
+// statuses to the time it spent in active and waiting ones, statuses
+// being classified by the `FLOW_ACTIVE_STATUSES` and
+// `FLOW_WAITING_STATUSES` environment variables (comma-separated
+// status names) when syncing. See `report.ComputeFlowEfficiency`.
+//
+// ### report aging [--percentile <p>] [--stale-days <n>] [--category <category>] [--project <keys>] [--format table|json]
+//
+// Lists the issues in progress (unresolved, in the `--category`
+// status category, default: `In Progress`) which have been in their
+// current status for longer than the `--percentile` (default: 85) of
+// the historical times spent in it, and those not updated for more
+// than `--stale-days` days (default: 14). With `--project`, the
+// historical times are also computed from the issues of these
+// projects only. See `report.ComputeAging`.
+//
+// After each successful sync (including in the daemon), the sync
+// actions compute this report with `ALERTS_PERCENTILE` and
+// `ALERTS_STALE_DAYS` (default: 85 and 14) and store it in
+// `jira_aging_issues` for dashboards. If `ALERTS_WEBHOOK_URL` is
+// set, they also post it as JSON to the URL when it is not empty.
+//
+// ### forecast how-many --until <date> | when (--items <n> | --epic <key> | --version <name>) [--project <keys>] [--type <types>] [--tribe <tribes>] [--history <days>] [--runs <n>] [--format table|json]
+//
+// Forecasts deliveries with Monte Carlo simulations of the daily
+// throughput of the resolved issues over the `--history` days before
+// today (default: 90), restricted to comma-separated projects, issue
+// types and tribes. `how-many` forecasts the number of items done by
+// `--until` (`YYYY-MM-DD`, included), `when` the date by which
+// `--items` items, or the unresolved issues of the epic or fix
+// version, are done. Forecasts at 50, 85 and 95% confidence are
+// printed and inserted in `jira_forecasts` for dashboards. See the
+// `forecast` package for details.
+//
+// ### daemon
+//
+// Performs an incremental sync every `SYNC_INTERVAL` (default: 15m)
+// and serves Prometheus metrics on `METRICS_ADDR` (default: `:9090`)
+// at `/metrics`. The progress of the running (or last) sync is served
+// as JSON at `/status`.
+//
+// For the other sync actions, the metrics are pushed to the
+// Pushgateway specified by `PUSHGATEWAY_URL` at the end of the run,
+// if set.
+//
 // ### explore-raw-issue
 //
 // Displays the raw issue as fetched from Jira.
@@ -54,11 +192,19 @@ func main() {
 	if len(os.Args) < 2 {
 		usage()
 	}
+	if err := logging.Configure(); err != nil {
+		logging.Log().WithError(err).Fatal("error in `logging.Configure`")
+	}
+	logging.StartRun()
 
 	db := openDB()
 	defer db.Close()
-	store := store.NewPGStore(db)
-	m := mapping.Mapper{}
+	store := privacy.WrapStore(store.NewPGStore(db), privacy.ConfigFromEnv())
+	cfg, err := jira.SyncConfigFromEnv()
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `SyncConfigFromEnv`")
+	}
+	ctx := signalContext()
 
 	switch os.Args[1] {
 
@@ -66,18 +212,73 @@ func main() {
 		store.DropTables()
 		store.CreateTables()
 		c := client.NewAPIClient()
-		jira.PerformSync(c, store, poolSize, &m)
+		m, err := syncMapper(c, store)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `syncMapper`")
+		}
+		summary, err := jira.PerformSync(ctx, c, store, cfg, m)
+		if err == nil {
+			reportAging(store)
+		}
+		pushMetrics()
+		exitOnFailures(summary, err)
 
 	case "sync":
 		c := client.NewAPIClient()
-		jira.PerformIncrementalSync(c, store, poolSize, &m)
+		m, err := syncMapper(c, store)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `syncMapper`")
+		}
+		summary, err := jira.PerformIncrementalSync(ctx, c, store, cfg, m)
+		if err == nil {
+			reportAging(store)
+		}
+		pushMetrics()
+		exitOnFailures(summary, err)
 
 	case "sync-issue":
 		if len(os.Args) < 3 {
 			usage()
 		}
 		c := client.NewAPIClient()
-		jira.PerformSyncForIssueKey(c, store, os.Args[2], &m)
+		m, err := syncMapper(c, store)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `syncMapper`")
+		}
+		summary, err := jira.PerformSyncForIssueKey(ctx, c, store, os.Args[2], m)
+		if err == nil {
+			reportAging(store)
+		}
+		pushMetrics()
+		exitOnFailures(summary, err)
+
+	case "retry-failures":
+		c := client.NewAPIClient()
+		m, err := syncMapper(c, store)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `syncMapper`")
+		}
+		summary, err := jira.PerformRetryFailures(ctx, c, store, cfg, m)
+		if err == nil {
+			reportAging(store)
+		}
+		pushMetrics()
+		exitOnFailures(summary, err)
+
+	case "diff":
+		runDiff(store, os.Args[2:])
+
+	case "verify":
+		runVerify(store, os.Args[2:])
+
+	case "report":
+		runReport(store, os.Args[2:])
+
+	case "forecast":
+		runForecast(store, os.Args[2:])
+
+	case "daemon":
+		runDaemon(ctx, store, cfg)
 
 	case "explore-raw-issue":
 		if len(os.Args) < 3 {
@@ -106,6 +307,20 @@ Available actions:
   - reset
   - sync
   - sync-issue <issue-key>
+  - retry-failures
+  - diff [--jql <query> | --sample <n>] [--limit <n>] [--format text|json] [--cache <dir>]
+  - verify [--sample <n>] [--full] [--enqueue] [--delete] [--format text|json]
+  - report cycle-time [--start <category>] [--end <category>] [--period week|month|quarter]
+      [--from <date>] [--to <date>] [--project <keys>] [--format table|csv|json]
+  - report flow-efficiency [--period week|month|quarter] [--from <date>] [--to <date>] [--project <keys>]
+      [--format table|csv|json]
+  - report aging [--percentile <p>] [--stale-days <n>] [--category <category>] [--project <keys>]
+      [--format table|json]
+  - forecast how-many --until <date> [<filters>] [--history <days>] [--runs <n>] [--format table|json]
+  - forecast when (--items <n> | --epic <key> | --version <name>) [<filters>] [--history <days>]
+      [--runs <n>] [--format table|json]
+      (filters: [--project <keys>] [--type <types>] [--tribe <tribes>])
+  - daemon
   - issue-to-xml <issue-key>
   - explore-raw-issue <issue_key>
   - explore-custom-fields <issue-key>
@@ -114,13 +329,492 @@ Available actions:
 	os.Exit(1)
 }
 
+// runDiff parses the arguments of the `diff` action, performs the
+// diff and prints it.
+func runDiff(s store.Store, args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	jql := fs.String("jql", "", "JQL query selecting the issues to compare")
+	sample := fs.Int("sample", 100, "number of stored issues to compare, randomly selected (if no JQL query)")
+	limit := fs.Int("limit", 0, "maximum number of issues to compare from the JQL query (0 for all)")
+	format := fs.String("format", "text", "output format: text or json")
+	cache := fs.String("cache", "", "directory to cache fetched issues in")
+	fs.Parse(args)
+	if *format != "text" && *format != "json" {
+		usage()
+	}
+
+	var c jira.Client = client.NewAPIClient()
+	if *cache != "" {
+		cc, err := client.NewCacheClient(c, *cache)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `runDiff`")
+		}
+		c = cc
+	}
+	m, err := fetchMapper(c)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runDiff`")
+	}
+
+	var keys []string
+	if *jql != "" {
+		keys, err = jira.SearchIssueKeys(c, *jql, *limit)
+	} else {
+		keys, err = s.SampleIssueKeys(*sample)
+	}
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runDiff`")
+	}
+
+	r := jira.PerformDiff(c, s, m, keys)
+	write := r.WriteText
+	if *format == "json" {
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runDiff`")
+	}
+}
+
+// runVerify parses the arguments of the `verify` action, performs
+// the verification and prints its report.
+func runVerify(s store.Store, args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	sample := fs.Int("sample", 20, "number of stored issues to spot-check, randomly selected")
+	full := fs.Bool("full", false, "compare the issues of all months, not only of those whose counts differ")
+	enqueue := fs.Bool("enqueue", false, "enqueue missing and stale issues for `retry-failures`")
+	del := fs.Bool("delete", false, "delete orphaned issues from the store")
+	format := fs.String("format", "text", "output format: text or json")
+	fs.Parse(args)
+	if *format != "text" && *format != "json" {
+		usage()
+	}
+
+	c := client.NewAPIClient()
+	var m jira.Mapper
+	if *sample > 0 {
+		fm, err := fetchMapper(c)
+		if err != nil {
+			logging.Log().WithError(err).Fatal("error in `runVerify`")
+		}
+		m = fm
+	}
+	r := jira.PerformVerify(c, s, m, jira.VerifyOptions{
+		Sample:  *sample,
+		Full:    *full,
+		Enqueue: *enqueue,
+		Delete:  *del,
+	})
+	write := r.WriteText
+	if *format == "json" {
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runVerify`")
+	}
+	if !r.Consistent() {
+		os.Exit(1)
+	}
+}
+
+// runReport runs the report specified by the first argument.
+func runReport(s store.Store, args []string) {
+	if len(args) < 1 {
+		usage()
+	}
+	switch args[0] {
+	case "cycle-time":
+		runCycleTimeReport(s, args[1:])
+	case "aging":
+		runAgingReport(s, args[1:])
+	case "flow-efficiency":
+		runFlowEfficiencyReport(s, args[1:])
+	default:
+		usage()
+	}
+}
+
+// runCycleTimeReport parses the arguments of the `report cycle-time`
+// action, computes the report and prints it.
+func runCycleTimeReport(s store.Store, args []string) {
+	fs := flag.NewFlagSet("cycle-time", flag.ExitOnError)
+	start := fs.String("start", report.DefaultStartCategory, "status category starting the cycle")
+	end := fs.String("end", report.DefaultEndCategory, "status category ending the cycle")
+	period := fs.String("period", string(report.PeriodMonth), "period grouping the issues: week, month or quarter")
+	from := fs.String("from", "", "only report issues completed on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only report issues completed before this date (YYYY-MM-DD)")
+	projects := fs.String("project", "", "comma-separated projects to report (all if empty)")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "csv" && *format != "json" {
+		usage()
+	}
+
+	o := report.CycleTimeOptions{StartCategory: *start, EndCategory: *end}
+	var err error
+	if o.Period, err = report.ParsePeriod(*period); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runCycleTimeReport`")
+	}
+	if o.From, err = parseDate(*from); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runCycleTimeReport`")
+	}
+	if o.To, err = parseDate(*to); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runCycleTimeReport`")
+	}
+	if *projects != "" {
+		o.Projects = strings.Split(*projects, ",")
+	}
+
+	r, err := report.PerformCycleTime(s, o)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runCycleTimeReport`")
+	}
+	write := r.WriteTable
+	switch *format {
+	case "csv":
+		write = r.WriteCSV
+	case "json":
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runCycleTimeReport`")
+	}
+}
+
+// runFlowEfficiencyReport parses the arguments of the `report
+// flow-efficiency` action, computes the report and prints it.
+func runFlowEfficiencyReport(s store.Store, args []string) {
+	fs := flag.NewFlagSet("flow-efficiency", flag.ExitOnError)
+	period := fs.String("period", string(report.PeriodMonth), "period grouping the issues: week, month or quarter")
+	from := fs.String("from", "", "only report issues resolved on or after this date (YYYY-MM-DD)")
+	to := fs.String("to", "", "only report issues resolved before this date (YYYY-MM-DD)")
+	projects := fs.String("project", "", "comma-separated projects to report (all if empty)")
+	format := fs.String("format", "table", "output format: table, csv or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "csv" && *format != "json" {
+		usage()
+	}
+
+	var o report.FlowEfficiencyOptions
+	var err error
+	if o.Period, err = report.ParsePeriod(*period); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runFlowEfficiencyReport`")
+	}
+	if o.From, err = parseDate(*from); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runFlowEfficiencyReport`")
+	}
+	if o.To, err = parseDate(*to); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runFlowEfficiencyReport`")
+	}
+	if *projects != "" {
+		o.Projects = strings.Split(*projects, ",")
+	}
+
+	r, err := report.PerformFlowEfficiency(s, o)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runFlowEfficiencyReport`")
+	}
+	write := r.WriteTable
+	switch *format {
+	case "csv":
+		write = r.WriteCSV
+	case "json":
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runFlowEfficiencyReport`")
+	}
+}
+
+// runAgingReport parses the arguments of the `report aging` action,
+// computes the report and prints it.
+func runAgingReport(s store.Store, args []string) {
+	fs := flag.NewFlagSet("aging", flag.ExitOnError)
+	percentile := fs.Float64("percentile", report.DefaultAgingPercentile, "percentile of the historical times in a status above which an issue is aging")
+	staleDays := fs.Int("stale-days", report.DefaultStaleDays, "number of days without update after which an issue is stale")
+	category := fs.String("category", store.InProgressCategory, "status category of the work in progress")
+	projects := fs.String("project", "", "comma-separated projects to report (all if empty)")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args)
+	if *format != "table" && *format != "json" || *percentile <= 0 || *percentile > 100 || *staleDays < 1 {
+		usage()
+	}
+
+	o := report.AgingOptions{Category: *category, Percentile: *percentile, StaleDays: *staleDays}
+	if *projects != "" {
+		o.Projects = strings.Split(*projects, ",")
+	}
+	r, err := report.PerformAging(s, o)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runAgingReport`")
+	}
+	write := r.WriteTable
+	if *format == "json" {
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runAgingReport`")
+	}
+}
+
+// reportAging computes the aging report, replaces the alerts of
+// `jira_aging_issues` with it and posts it to `ALERTS_WEBHOOK_URL`,
+// if set and if the report is not empty. Errors are logged: alerts
+// don't fail the sync.
+func reportAging(s store.Store) {
+	var o report.AgingOptions
+	if v := os.Getenv("ALERTS_PERCENTILE"); v != "" {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p <= 0 || p > 100 {
+			logging.Log().Errorf("error in `reportAging`: invalid ALERTS_PERCENTILE `%s`", v)
+			return
+		}
+		o.Percentile = p
+	}
+	if v := os.Getenv("ALERTS_STALE_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			logging.Log().Errorf("error in `reportAging`: invalid ALERTS_STALE_DAYS `%s`", v)
+			return
+		}
+		o.StaleDays = n
+	}
+	r, err := report.PerformAging(s, o)
+	if err != nil {
+		logging.Log().WithError(err).Error("error in `reportAging`")
+		return
+	}
+	if err := s.ReplaceAgingIssues(r.Alerts()); err != nil {
+		logging.Log().WithError(err).Error("error in `reportAging`")
+	}
+	url := os.Getenv("ALERTS_WEBHOOK_URL")
+	if url == "" || r.Empty() {
+		return
+	}
+	if err := r.Post(url); err != nil {
+		logging.Log().WithError(err).Error("error in `reportAging`")
+		return
+	}
+	logging.Log().WithField("aging", len(r.Aging)).WithField("stale", len(r.Stale)).Info("Alerts posted")
+}
+
+// runForecast parses the arguments of the `forecast` action,
+// performs the forecast and prints it.
+func runForecast(s store.Store, args []string) {
+	if len(args) < 1 || (args[0] != forecast.KindHowMany && args[0] != forecast.KindWhen) {
+		usage()
+	}
+	kind := args[0]
+	fs := flag.NewFlagSet("forecast "+kind, flag.ExitOnError)
+	until := fs.String("until", "", "date by which done items are forecast (YYYY-MM-DD, how-many)")
+	items := fs.Int("items", 0, "number of items whose completion is forecast (when)")
+	epic := fs.String("epic", "", "epic whose unresolved issues' completion is forecast (when)")
+	version := fs.String("version", "", "fix version whose unresolved issues' completion is forecast (when)")
+	projects := fs.String("project", "", "comma-separated projects (all if empty)")
+	types := fs.String("type", "", "comma-separated issue types (all if empty)")
+	tribes := fs.String("tribe", "", "comma-separated tribes (all if empty)")
+	history := fs.Int("history", forecast.DefaultHistoryDays, "number of days of throughput history")
+	runs := fs.Int("runs", forecast.DefaultRuns, "number of simulation runs")
+	format := fs.String("format", "table", "output format: table or json")
+	fs.Parse(args[1:])
+	if *format != "table" && *format != "json" || *history < 1 || *runs < 1 {
+		usage()
+	}
+
+	o := forecast.Options{
+		Filter:      store.IssueFilter{Epic: *epic, FixVersion: *version},
+		HistoryDays: *history,
+		Runs:        *runs,
+	}
+	if *projects != "" {
+		o.Filter.Projects = strings.Split(*projects, ",")
+	}
+	if *types != "" {
+		o.Filter.Types = strings.Split(*types, ",")
+	}
+	if *tribes != "" {
+		o.Filter.Tribes = strings.Split(*tribes, ",")
+	}
+
+	var r forecast.Result
+	var err error
+	switch kind {
+	case forecast.KindHowMany:
+		if *until == "" {
+			usage()
+		}
+		var d time.Time
+		if d, err = parseDate(*until); err != nil {
+			logging.Log().WithError(err).Fatal("error in `runForecast`")
+		}
+		r, err = forecast.PerformHowMany(s, d, o)
+	case forecast.KindWhen:
+		if (*items > 0) == (*epic != "" || *version != "") {
+			usage()
+		}
+		r, err = forecast.PerformWhen(s, *items, o)
+	}
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `runForecast`")
+	}
+	write := r.WriteTable
+	if *format == "json" {
+		write = r.WriteJSON
+	}
+	if err := write(os.Stdout); err != nil {
+		logging.Log().WithError(err).Fatal("error in `runForecast`")
+	}
+}
+
+// syncMapper syncs the statuses of the store (see
+// `jira.PerformStatusesSync`) and returns a mapper resolving them.
+func syncMapper(c jira.Client, s store.Store) (*mapping.Mapper, error) {
+	ss, err := jira.PerformStatusesSync(c, s)
+	if err != nil {
+		return nil, err
+	}
+	return newMapper(c, ss)
+}
+
+// fetchMapper returns a mapper resolving the statuses fetched from
+// the client, without storing them.
+func fetchMapper(c jira.Client) (*mapping.Mapper, error) {
+	ss, err := jira.FetchStatuses(c)
+	if err != nil {
+		return nil, err
+	}
+	return newMapper(c, ss)
+}
+
+// newMapper returns a mapper resolving the statuses and using the
+// field schemas of the client, with the flow configuration of the
+// environment (see `mapping.FlowConfigFromEnv`).
+func newMapper(c jira.Client, statuses []store.Status) (*mapping.Mapper, error) {
+	cfg, err := mapping.FlowConfigFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("error in `newMapper`: %s", err)
+	}
+	schemas, err := c.GetFieldSchemas()
+	if err != nil {
+		return nil, fmt.Errorf("error in `newMapper`: %s", err)
+	}
+	return mapping.NewMapper(statuses, schemas).WithFlowConfig(cfg), nil
+}
+
+// parseDate parses a `YYYY-MM-DD` date in UTC, returning the zero
+// time for an empty string.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// runDaemon serves the metrics and performs an incremental sync
+// every sync interval, until `ctx` is cancelled.
+func runDaemon(ctx context.Context, s store.Store, cfg jira.SyncConfig) {
+	addr := os.Getenv("METRICS_ADDR")
+	if addr == "" {
+		addr = defaultMetricsAddr
+	}
+	interval := defaultSyncInterval
+	if v := os.Getenv("SYNC_INTERVAL"); v != "" {
+		var err error
+		if interval, err = time.ParseDuration(v); err != nil {
+			logging.Log().WithError(err).Fatalf("error in `runDaemon`: invalid SYNC_INTERVAL `%s`", v)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/status", serveStatus)
+	go func() {
+		logging.Log().WithError(http.ListenAndServe(addr, mux)).Fatal("error in `runDaemon`")
+	}()
+	logging.Log().WithField("addr", addr).Info("Serving /metrics and /status")
+
+	c := client.NewAPIClient()
+	for {
+		logging.StartRun()
+		// The Jira API or the store may be temporarily unavailable:
+		// the next tick tries again.
+		if m, err := syncMapper(c, s); err != nil {
+			logging.Log().WithError(err).Error("Skipping sync")
+		} else if _, err := jira.PerformIncrementalSync(ctx, c, s, cfg, m); err != nil {
+			logging.Log().WithError(err).Error("Sync stopped")
+		} else {
+			reportAging(s)
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// signalContext returns a context cancelled on SIGINT or SIGTERM, so
+// a running sync stops cleanly. A second signal exits immediately.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		logging.Log().WithField("signal", sig.String()).Warn("Stopping")
+		cancel()
+		<-sigs
+		os.Exit(1)
+	}()
+	return ctx
+}
+
+// serveStatus serves the ID and the progress of the running (or
+// last) sync run as JSON.
+func serveStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		RunID    string                 `json:"run_id"`
+		Progress *jira.ProgressSnapshot `json:"progress"`
+	}{RunID: logging.RunID()}
+	if p := jira.LastProgress(); p != nil {
+		s := p.Snapshot()
+		status.Progress = &s
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logging.Log().WithError(err).Error("error in `serveStatus`")
+	}
+}
+
+// exitOnFailures exits with a non-zero status if the sync run
+// summarized by `s` was stopped by `err`, or if issues failed.
+func exitOnFailures(s jira.ProgressSnapshot, err error) {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sync stopped after %d of %d issues: %s\n", s.Stored+s.Failed, s.Total, err)
+		os.Exit(1)
+	}
+	if s.Failed == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d issues failed to sync, see `jira_sync_failures` and run `retry-failures`\n", s.Failed, s.Total)
+	os.Exit(1)
+}
+
+// pushMetrics pushes the metrics of a one-shot run to the
+// Pushgateway, if configured.
+func pushMetrics() {
+	if err := metrics.PushFromEnv(); err != nil {
+		logging.Log().WithError(err).Error("error in `pushMetrics`")
+	}
+}
+
 func openDB() *sql.DB {
 	//connStr := os.Getenv("DB_URL")
 	connStr := "user=agilizer password=password dbname=agilizer sslmode=disable"
 	db, err := sql.Open("postgres", connStr)
 	db.SetMaxOpenConns(MaxOpenConns)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `openDB`: %s", err))
+		logging.Log().WithError(err).Fatal("error in `openDB`")
 	}
 	return db
 }
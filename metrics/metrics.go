@@ -0,0 +1,219 @@
+// Package metrics defines the Prometheus metrics exposed by the
+// sync, so syncs that stop working can be detected and alerted on.
+//
+// The metrics are registered in `Registry`. They are served over
+// HTTP by the `daemon` command (see `Handler`) and pushed to a
+// Pushgateway at the end of one-shot runs (see `PushFromEnv`).
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	namespace = "kaizenizer"
+	subsystem = "jira"
+
+	// PushJob is the job name used when pushing to a Pushgateway.
+	PushJob = "kaizenizer_source_jira"
+)
+
+// Error kinds, used as the `kind` label of `Errors`
+const (
+	ErrorKindSearch = "search"
+	ErrorKindFetch  = "fetch"
+	ErrorKindMap    = "map"
+	ErrorKindStore  = "store"
+	ErrorKindAPI    = "api"
+)
+
+// Registry is the registry of all the metrics of this package.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// IssuesFetched counts the issues fetched from the Jira API.
+	IssuesFetched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "issues_fetched_total",
+		Help:      "Number of issues fetched from the Jira API.",
+	})
+
+	// IssuesMapped counts the issues mapped to store records.
+	IssuesMapped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "issues_mapped_total",
+		Help:      "Number of issues mapped to store records.",
+	})
+
+	// IssuesStored counts the issues whose records were written to
+	// the store.
+	IssuesStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "issues_stored_total",
+		Help:      "Number of issues whose records were written to the store.",
+	})
+
+	// Errors counts the errors by kind (see the `ErrorKind...`
+	// constants).
+	Errors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "errors_total",
+		Help:      "Number of errors by kind.",
+	}, []string{"kind"})
+
+	// APIRequestDuration observes the latency of Jira API requests
+	// by endpoint (e.g. `search`, `issue`).
+	APIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "api_request_duration_seconds",
+		Help:      "Latency of Jira API requests by endpoint.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"endpoint"})
+
+	// RateLimitWaits counts the waits caused by Jira API rate
+	// limiting.
+	RateLimitWaits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "rate_limit_waits_total",
+		Help:      "Number of waits caused by Jira API rate limiting.",
+	})
+
+	// RateLimitWaitSeconds counts the time spent waiting because of
+	// Jira API rate limiting.
+	RateLimitWaitSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "rate_limit_wait_seconds_total",
+		Help:      "Time spent waiting because of Jira API rate limiting.",
+	})
+
+	// RateLimit is the current rate of the client-side rate limiter
+	// of the Jira API, which adapts to the rate limiting of the API.
+	RateLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "rate_limit_requests_per_second",
+		Help:      "Current rate of the client-side rate limiter of the Jira API.",
+	})
+
+	// ThrottleWaitSeconds counts the time spent waiting for the
+	// client-side rate limiter before sending requests.
+	ThrottleWaitSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "throttle_wait_seconds_total",
+		Help:      "Time spent waiting for the client-side rate limiter of the Jira API.",
+	})
+
+	// PoolQueueDepth is the number of issues found by the search
+	// and waiting for a fetch worker of the sync pipeline.
+	PoolQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "pool_queue_depth",
+		Help:      "Number of issues waiting for a fetch worker of the sync pipeline.",
+	})
+
+	// LastSuccessfulSync is the time of the end of the last
+	// successful sync, as a Unix timestamp.
+	LastSuccessfulSync = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "last_successful_sync_timestamp_seconds",
+		Help:      "Time of the end of the last successful sync.",
+	})
+
+	// HighWaterMarkLag is the time elapsed since the most recent
+	// `updated` time of the synchronized issues (see
+	// `ObserveHighWaterMark`), evaluated when the metrics are
+	// collected.
+	HighWaterMarkLag = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "high_water_mark_lag_seconds",
+		Help:      "Time elapsed since the most recent update time of synchronized issues.",
+	}, highWaterMarkLag)
+)
+
+var highWaterMark struct {
+	sync.Mutex
+	t time.Time
+}
+
+func init() {
+	Registry.MustRegister(
+		IssuesFetched,
+		IssuesMapped,
+		IssuesStored,
+		Errors,
+		APIRequestDuration,
+		RateLimitWaits,
+		RateLimitWaitSeconds,
+		RateLimit,
+		ThrottleWaitSeconds,
+		PoolQueueDepth,
+		LastSuccessfulSync,
+		HighWaterMarkLag,
+	)
+}
+
+// ObserveHighWaterMark records `t` as the high-water mark if it is
+// more recent than the current one. It should be called with the
+// `updated` time of each synchronized issue.
+func ObserveHighWaterMark(t time.Time) {
+	highWaterMark.Lock()
+	defer highWaterMark.Unlock()
+	if t.After(highWaterMark.t) {
+		highWaterMark.t = t
+	}
+}
+
+func highWaterMarkLag() float64 {
+	highWaterMark.Lock()
+	defer highWaterMark.Unlock()
+	if highWaterMark.t.IsZero() {
+		return 0
+	}
+	return time.Since(highWaterMark.t).Seconds()
+}
+
+// ObserveRateLimitWait records a wait of duration `d` caused by
+// rate limiting.
+func ObserveRateLimitWait(d time.Duration) {
+	RateLimitWaits.Inc()
+	RateLimitWaitSeconds.Add(d.Seconds())
+}
+
+// Handler returns an HTTP handler serving the metrics in the
+// Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// PushFromEnv pushes the metrics to the Pushgateway specified by
+// the `PUSHGATEWAY_URL` environment variable. It does nothing if
+// the variable is not set.
+func PushFromEnv() error {
+	url := os.Getenv("PUSHGATEWAY_URL")
+	if url == "" {
+		return nil
+	}
+	if err := push.New(url, PushJob).Gatherer(Registry).Push(); err != nil {
+		return fmt.Errorf("error pushing metrics to `%s`: %s", url, err)
+	}
+	return nil
+}
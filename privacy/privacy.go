@@ -0,0 +1,182 @@
+// Package privacy implements a privacy mode for the records
+// written to the store, so the output database can be shared
+// with a wider audience.
+//
+// When enabled, the privacy mode:
+//
+// - replaces person identifiers (account IDs) by a keyed HMAC of
+//   the identifier, so records of the same person can still be
+//   joined,
+// - drops persons' display names, emails and time zones,
+// - drops or truncates free-text fields (issue descriptions and
+//   comment bodies),
+// - scrubs emails and mentions (or any configured pattern) from
+//   the remaining text fields.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
+)
+
+// FreeTextMode specifies how free-text fields are processed.
+type FreeTextMode string
+
+// Available free-text modes
+const (
+	FreeTextKeep     FreeTextMode = "keep"
+	FreeTextDrop     FreeTextMode = "drop"
+	FreeTextTruncate FreeTextMode = "truncate"
+)
+
+// Redacted is the text replacing scrubbed parts of text fields.
+const Redacted = "[redacted]"
+
+// DefaultScrubPatterns are the patterns scrubbed from text fields
+// when no pattern is configured: emails and Jira mentions (e.g.
+// `[~accountid:5b10a2844c20165700ede21g]` or `[~jdoe]`).
+var DefaultScrubPatterns = []string{
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	`\[~[^\]]+\]`,
+}
+
+// Config is the configuration of the privacy mode.
+type Config struct {
+	// Key is the secret key used to compute the HMAC of person
+	// identifiers.
+	Key []byte
+
+	// FreeText specifies how free-text fields are processed.
+	FreeText FreeTextMode
+
+	// TruncateLength is the maximum length (in characters) of
+	// free-text fields when `FreeText` is `FreeTextTruncate`.
+	TruncateLength int
+
+	// ScrubPatterns are the patterns replaced by `Redacted` in
+	// text fields.
+	ScrubPatterns []*regexp.Regexp
+}
+
+// ConfigFromEnv returns the privacy configuration specified by the
+// following environment variables, or nil if the privacy mode is
+// not enabled (no `PRIVACY_HMAC_KEY`):
+//
+// - `PRIVACY_HMAC_KEY`: the HMAC secret key, enables the privacy
+//   mode
+// - `PRIVACY_FREE_TEXT`: `keep`, `drop` (default) or `truncate`
+// - `PRIVACY_TRUNCATE_LENGTH`: the length free-text fields are
+//   truncated to (default: 200)
+// - `PRIVACY_SCRUB_PATTERNS`: newline-separated regular expressions
+//   to scrub from text fields, replacing `DefaultScrubPatterns`
+func ConfigFromEnv() *Config {
+	key := os.Getenv("PRIVACY_HMAC_KEY")
+	if key == "" {
+		return nil
+	}
+
+	mode := FreeTextMode(os.Getenv("PRIVACY_FREE_TEXT"))
+	switch mode {
+	case "":
+		mode = FreeTextDrop
+	case FreeTextKeep, FreeTextDrop, FreeTextTruncate:
+	default:
+		logging.Log().Fatalf("error in `ConfigFromEnv`: invalid PRIVACY_FREE_TEXT `%s`", mode)
+	}
+
+	length := 200
+	if v := os.Getenv("PRIVACY_TRUNCATE_LENGTH"); v != "" {
+		var err error
+		if length, err = strconv.Atoi(v); err != nil || length < 0 {
+			logging.Log().Fatalf("error in `ConfigFromEnv`: invalid PRIVACY_TRUNCATE_LENGTH `%s`", v)
+		}
+	}
+
+	patterns := DefaultScrubPatterns
+	if v := os.Getenv("PRIVACY_SCRUB_PATTERNS"); v != "" {
+		patterns = strings.Split(v, "\n")
+	}
+	c, err := NewConfig([]byte(key), mode, length, patterns)
+	if err != nil {
+		logging.Log().WithError(err).Fatal("error in `ConfigFromEnv`")
+	}
+	return c
+}
+
+// NewConfig returns a `Config` compiling the specified scrub
+// patterns. Empty patterns are ignored.
+func NewConfig(key []byte, mode FreeTextMode, truncateLength int, scrubPatterns []string) (*Config, error) {
+	c := Config{
+		Key:            key,
+		FreeText:       mode,
+		TruncateLength: truncateLength,
+	}
+	for _, p := range scrubPatterns {
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scrub pattern `%s`: %s", p, err)
+		}
+		c.ScrubPatterns = append(c.ScrubPatterns, re)
+	}
+	return &c, nil
+}
+
+// Pseudonym returns the pseudonym of the specified person
+// identifier: the hex-encoded HMAC-SHA256 of the identifier using
+// the configured key.
+func (c *Config) Pseudonym(id string) string {
+	mac := hmac.New(sha256.New, c.Key)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// pseudonymPtr returns a pointer to the pseudonym of `id`, or nil
+// if `id` is nil.
+func (c *Config) pseudonymPtr(id *string) *string {
+	if id == nil {
+		return nil
+	}
+	p := c.Pseudonym(*id)
+	return &p
+}
+
+// FreeTextField scrubs a free-text field, then processes it
+// according to the configured mode. Scrubbing is done first so a
+// truncation can't cut a match in half and leave it unscrubbed.
+func (c *Config) FreeTextField(s *string) *string {
+	if s == nil || c.FreeText == FreeTextDrop {
+		return nil
+	}
+	t := c.Scrub(s)
+	if c.FreeText == FreeTextTruncate {
+		if r := []rune(*t); len(r) > c.TruncateLength {
+			truncated := string(r[:c.TruncateLength])
+			return &truncated
+		}
+	}
+	return t
+}
+
+// Scrub replaces the parts of `s` matching the scrub patterns by
+// `Redacted`. Returns nil if `s` is nil.
+func (c *Config) Scrub(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	r := *s
+	for _, re := range c.ScrubPatterns {
+		r = re.ReplaceAllString(r, Redacted)
+	}
+	return &r
+}
@@ -0,0 +1,153 @@
+package privacy_test
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/golib/matchers"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/privacy"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// recordingStore is a `store.Store` recording the records it
+// receives.
+type recordingStore struct {
+	store.Store
+	state  store.IssueState
+	events []store.IssueEvent
+	users  []store.User
+}
+
+func (s *recordingStore) ReplaceIssueStateAndEvents(k string, is store.IssueState, ies []store.IssueEvent, isps []store.IssueStatusPeriod) error {
+	s.state = is
+	s.events = ies
+	return nil
+}
+
+func (s *recordingStore) ReplaceIssues(rs []store.IssueRecords) error {
+	for _, r := range rs {
+		s.state = r.State
+		s.events = r.Events
+		s.users = r.Users
+	}
+	return nil
+}
+
+func (s *recordingStore) UpsertUsers(us []store.User) error {
+	s.users = us
+	return nil
+}
+
+func TestWrapStore_disabled(t *testing.T) {
+	s := &recordingStore{}
+	if privacy.WrapStore(s, nil) != store.Store(s) {
+		t.Errorf("expected store not to be wrapped when privacy mode is disabled")
+	}
+}
+
+func TestStore(t *testing.T) {
+	c, err := privacy.NewConfig([]byte("secret"), privacy.FreeTextTruncate, 20, privacy.DefaultScrubPatterns)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewConfig`: %s", err)
+	}
+	rs := &recordingStore{}
+	s := privacy.WrapStore(rs, c)
+
+	s.ReplaceIssueStateAndEvents("PJ-1", store.IssueState{
+		Key:          "PJ-1",
+		Summary:      strAddr("Contact jdoe@example.com"),
+		Description:  strAddr("Reported by [~accountid:id-jdoe] on the phone"),
+		Assignee:     strAddr("id-jdoe"),
+		AssigneeName: strAddr("John Doe"),
+	}, []store.IssueEvent{
+		store.IssueEvent{
+			EventKind:       "comment_added",
+			EventAuthor:     "id-jdoe",
+			EventAuthorName: strAddr("John Doe"),
+			CommentBody:     strAddr("Done"),
+		},
+		store.IssueEvent{EventKind: "created", EventAuthor: "N/A"},
+	}, nil)
+	s.UpsertUsers([]store.User{store.User{AccountID: "id-jdoe", DisplayName: strAddr("John Doe"), Email: strAddr("jdoe@example.com"), Active: true}})
+
+	pseudonym := c.Pseudonym("id-jdoe")
+	if pseudonym == "id-jdoe" || pseudonym != c.Pseudonym("id-jdoe") {
+		t.Errorf("expected pseudonym to be a stable hash, got `%s`", pseudonym)
+	}
+
+	matchers.MatchStringPtr(t, "state.Summary", strAddr("Contact [redacted]"), rs.state.Summary, "state")
+	matchers.MatchStringPtr(t, "state.Description", strAddr("Reported by [redacte"), rs.state.Description, "state")
+	matchers.MatchStringPtr(t, "state.Assignee", &pseudonym, rs.state.Assignee, "state")
+	matchers.MatchStringPtr(t, "state.AssigneeName", nil, rs.state.AssigneeName, "state")
+
+	matchers.MatchString(t, "event.EventAuthor", pseudonym, rs.events[0].EventAuthor, "event")
+	matchers.MatchStringPtr(t, "event.EventAuthorName", nil, rs.events[0].EventAuthorName, "event")
+	matchers.MatchStringPtr(t, "event.CommentBody", strAddr("Done"), rs.events[0].CommentBody, "event")
+	matchers.MatchString(t, "event.EventAuthor", "N/A", rs.events[1].EventAuthor, "event")
+
+	matchers.MatchString(t, "user.AccountID", pseudonym, rs.users[0].AccountID, "user")
+	matchers.MatchStringPtr(t, "user.DisplayName", nil, rs.users[0].DisplayName, "user")
+	matchers.MatchStringPtr(t, "user.Email", nil, rs.users[0].Email, "user")
+}
+
+func TestStore_ReplaceIssues(t *testing.T) {
+	c, err := privacy.NewConfig([]byte("secret"), privacy.FreeTextDrop, 0, privacy.DefaultScrubPatterns)
+	if err != nil {
+		t.Fatalf("unexpected error in `NewConfig`: %s", err)
+	}
+	rs := &recordingStore{}
+	s := privacy.WrapStore(rs, c)
+
+	s.ReplaceIssues([]store.IssueRecords{
+		store.IssueRecords{
+			Key: "PJ-1",
+			State: store.IssueState{
+				Key:          "PJ-1",
+				Description:  strAddr("Secret"),
+				Assignee:     strAddr("id-jdoe"),
+				AssigneeName: strAddr("John Doe"),
+			},
+			Events: []store.IssueEvent{
+				store.IssueEvent{EventKind: "comment_added", EventAuthor: "id-jdoe", CommentBody: strAddr("Secret")},
+			},
+			Users: []store.User{
+				store.User{AccountID: "id-jdoe", DisplayName: strAddr("John Doe")},
+			},
+		},
+	})
+
+	pseudonym := c.Pseudonym("id-jdoe")
+	matchers.MatchStringPtr(t, "state.Description", nil, rs.state.Description, "state")
+	matchers.MatchStringPtr(t, "state.Assignee", &pseudonym, rs.state.Assignee, "state")
+	matchers.MatchStringPtr(t, "state.AssigneeName", nil, rs.state.AssigneeName, "state")
+	matchers.MatchString(t, "event.EventAuthor", pseudonym, rs.events[0].EventAuthor, "event")
+	matchers.MatchStringPtr(t, "event.CommentBody", nil, rs.events[0].CommentBody, "event")
+	matchers.MatchString(t, "user.AccountID", pseudonym, rs.users[0].AccountID, "user")
+	matchers.MatchStringPtr(t, "user.DisplayName", nil, rs.users[0].DisplayName, "user")
+}
+
+func TestConfig_FreeTextField(t *testing.T) {
+	text := strAddr("Call me at jdoe@example.com")
+	tests := []struct {
+		mode     privacy.FreeTextMode
+		expected *string
+	}{
+		{privacy.FreeTextKeep, strAddr("Call me at [redacted]")},
+		{privacy.FreeTextDrop, nil},
+		{privacy.FreeTextTruncate, strAddr("Call me")},
+	}
+	for _, tt := range tests {
+		c, _ := privacy.NewConfig([]byte("secret"), tt.mode, 7, privacy.DefaultScrubPatterns)
+		matchers.MatchStringPtr(t, "FreeTextField", tt.expected, c.FreeTextField(text), tt.mode)
+	}
+}
+
+func TestNewConfig_invalidPattern(t *testing.T) {
+	if _, err := privacy.NewConfig([]byte("secret"), privacy.FreeTextKeep, 0, []string{"("}); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func strAddr(s string) *string {
+	return &s
+}
@@ -0,0 +1,109 @@
+package privacy
+
+import (
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Store wraps a `store.Store` to apply the privacy mode to the
+// records before they are written to the wrapped store.
+type Store struct {
+	store.Store
+	config *Config
+}
+
+// WrapStore returns the passed store wrapped in a privacy `Store`
+// using the specified configuration, or the passed store itself if
+// the configuration is nil (privacy mode disabled).
+func WrapStore(s store.Store, c *Config) store.Store {
+	if c == nil {
+		return s
+	}
+	return &Store{Store: s, config: c}
+}
+
+// ReplaceIssueStateAndEvents applies the privacy mode to the passed
+// state and events, then replaces them in the wrapped store.
+func (s *Store) ReplaceIssueStateAndEvents(k string, is store.IssueState, ies []store.IssueEvent, isps []store.IssueStatusPeriod) (err error) {
+	pis, pies := s.TransformIssueStateAndEvents(is, ies)
+	return s.Store.ReplaceIssueStateAndEvents(k, pis, pies, isps)
+}
+
+// TransformIssueStateAndEvents returns the passed state and events
+// as they are written to the wrapped store, with the privacy mode
+// applied. It is used to compare mapped records with the stored
+// ones (see `jira.PerformDiff`).
+func (s *Store) TransformIssueStateAndEvents(is store.IssueState, ies []store.IssueEvent) (store.IssueState, []store.IssueEvent) {
+	pies := make([]store.IssueEvent, 0, len(ies))
+	for _, ie := range ies {
+		pies = append(pies, s.config.issueEvent(ie))
+	}
+	return s.config.issueState(is), pies
+}
+
+// ReplaceIssues applies the privacy mode to the records of the
+// passed issues, then replaces them in the wrapped store.
+func (s *Store) ReplaceIssues(rs []store.IssueRecords) (err error) {
+	prs := make([]store.IssueRecords, 0, len(rs))
+	for _, r := range rs {
+		r.State, r.Events = s.TransformIssueStateAndEvents(r.State, r.Events)
+		r.Users = s.config.users(r.Users)
+		prs = append(prs, r)
+	}
+	return s.Store.ReplaceIssues(prs)
+}
+
+// UpsertUsers pseudonymizes the passed users, dropping their
+// personal data, then upserts them in the wrapped store.
+func (s *Store) UpsertUsers(us []store.User) (err error) {
+	return s.Store.UpsertUsers(s.config.users(us))
+}
+
+// UpsertSyncFailure scrubs the error of the passed failure, which
+// may contain parts of the issue, then upserts it in the wrapped
+// store.
+func (s *Store) UpsertSyncFailure(f store.SyncFailure) (err error) {
+	f.Error = *s.config.Scrub(&f.Error)
+	return s.Store.UpsertSyncFailure(f)
+}
+
+func (c *Config) users(us []store.User) []store.User {
+	pus := make([]store.User, 0, len(us))
+	for _, u := range us {
+		pus = append(pus, store.User{
+			AccountID: c.Pseudonym(u.AccountID),
+			Active:    u.Active,
+		})
+	}
+	return pus
+}
+
+func (c *Config) issueState(is store.IssueState) store.IssueState {
+	is.Summary = c.Scrub(is.Summary)
+	is.Description = c.FreeTextField(is.Description)
+	is.Reporter = c.pseudonymPtr(is.Reporter)
+	is.Assignee = c.pseudonymPtr(is.Assignee)
+	is.DeveloperBackend = c.pseudonymPtr(is.DeveloperBackend)
+	is.DeveloperFrontend = c.pseudonymPtr(is.DeveloperFrontend)
+	is.Reviewer = c.pseudonymPtr(is.Reviewer)
+	is.ProductOwner = c.pseudonymPtr(is.ProductOwner)
+	is.ReporterName = nil
+	is.AssigneeName = nil
+	is.DeveloperBackendName = nil
+	is.DeveloperFrontendName = nil
+	is.ReviewerName = nil
+	is.ProductOwnerName = nil
+	return is
+}
+
+func (c *Config) issueEvent(ie store.IssueEvent) store.IssueEvent {
+	if ie.EventAuthor != "N/A" {
+		ie.EventAuthor = c.Pseudonym(ie.EventAuthor)
+	}
+	ie.EventAuthorName = nil
+	ie.CommentBody = c.FreeTextField(ie.CommentBody)
+	ie.AssigneeChangeFrom = c.pseudonymPtr(ie.AssigneeChangeFrom)
+	ie.AssigneeChangeTo = c.pseudonymPtr(ie.AssigneeChangeTo)
+	ie.AssigneeChangeFromName = nil
+	ie.AssigneeChangeToName = nil
+	return ie
+}
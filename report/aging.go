@@ -0,0 +1,283 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Default options of the aging report.
+const (
+	DefaultAgingPercentile = 85
+	DefaultStaleDays       = 14
+)
+
+// webhookTimeout bounds the time to post a report to a webhook.
+const webhookTimeout = 30 * time.Second
+
+// AgingOptions configures the aging report.
+type AgingOptions struct {
+	// Category is the status category of the work in progress
+	// (default: `In Progress`).
+	Category string
+
+	// Percentile of the historical times spent in a status above
+	// which an issue in this status is aging (default: 85).
+	Percentile float64
+
+	// StaleDays is the number of days without update after which an
+	// issue in progress is stale (default: 14).
+	StaleDays int
+
+	// Projects of the issues (all projects if empty), both reported
+	// and used for the historical times.
+	Projects []string
+
+	// Now is the time the ages are computed at (default: now).
+	Now time.Time
+}
+
+// AgingReport is the result of `ComputeAging`.
+type AgingReport struct {
+	GeneratedAt time.Time    `json:"generated_at"`
+	Category    string       `json:"category"`
+	Percentile  float64      `json:"percentile"`
+	StaleDays   int          `json:"stale_days"`
+	Aging       []AgingIssue `json:"aging"`
+	Stale       []StaleIssue `json:"stale"`
+}
+
+// AgingIssue is an issue in progress which has been in its current
+// status (since `EnteredAt`) for `AgeDays` days, more than the
+// `ThresholdDays` given by the percentile of the historical times
+// spent in this status.
+type AgingIssue struct {
+	Key           string    `json:"key"`
+	Project       string    `json:"project"`
+	IssueType     string    `json:"issue_type"`
+	Summary       string    `json:"summary"`
+	Assignee      *string   `json:"assignee"`
+	Status        string    `json:"status"`
+	EnteredAt     time.Time `json:"entered_at"`
+	AgeDays       float64   `json:"age_days"`
+	ThresholdDays float64   `json:"threshold_days"`
+}
+
+// StaleIssue is an issue in progress which was not updated for
+// `IdleDays` days.
+type StaleIssue struct {
+	Key       string    `json:"key"`
+	Project   string    `json:"project"`
+	IssueType string    `json:"issue_type"`
+	Summary   string    `json:"summary"`
+	Assignee  *string   `json:"assignee"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	IdleDays  float64   `json:"idle_days"`
+}
+
+// PerformAging reads the issue timelines from the store and
+// computes the aging report.
+func PerformAging(s store.Store, o AgingOptions) (AgingReport, error) {
+	ts, err := s.GetIssueTimelines()
+	if err != nil {
+		return AgingReport{}, fmt.Errorf("error in `PerformAging`: %s", err)
+	}
+	return ComputeAging(ts, o), nil
+}
+
+// ComputeAging lists the aging and stale issues among the issues in
+// progress, i.e. unresolved issues whose current status is in the
+// work in progress category.
+//
+// The historical times spent in a status are the durations of the
+// completed stays of all issues in it, from their entry in the status
+// to their entry in the next one. Only the issues of the reported
+// projects are considered, since workflows and paces differ between
+// projects. An issue in progress is aging when it has been in its
+// current status for longer than the percentile of these times.
+// Statuses without history don't raise alerts.
+//
+// An issue in progress is stale when it was not updated for more
+// than the stale days.
+//
+// Issues are sorted by decreasing age (or idle time).
+func ComputeAging(ts []store.IssueTimeline, o AgingOptions) AgingReport {
+	o = o.withDefaults()
+	if len(o.Projects) > 0 {
+		var pts []store.IssueTimeline
+		for _, t := range ts {
+			if contains(o.Projects, t.Project) {
+				pts = append(pts, t)
+			}
+		}
+		ts = pts
+	}
+	history := make(map[string][]time.Duration)
+	for _, t := range ts {
+		for n := 1; n < len(t.Statuses); n++ {
+			prev := t.Statuses[n-1]
+			history[prev.Status] = append(history[prev.Status], t.Statuses[n].Time.Sub(prev.Time))
+		}
+	}
+	thresholds := make(map[string]time.Duration, len(history))
+	for status, ds := range history {
+		sort.Slice(ds, func(a, b int) bool { return ds[a] < ds[b] })
+		thresholds[status] = percentile(ds, o.Percentile)
+	}
+
+	r := AgingReport{GeneratedAt: o.Now, Category: o.Category, Percentile: o.Percentile, StaleDays: o.StaleDays}
+	for _, t := range ts {
+		n := len(t.Statuses)
+		if t.ResolvedAt != nil || n == 0 || !inCategory(t.Statuses[n-1], o.Category) {
+			continue
+		}
+		current := t.Statuses[n-1]
+		if threshold, ok := thresholds[current.Status]; ok && o.Now.Sub(current.Time) > threshold {
+			r.Aging = append(r.Aging, AgingIssue{
+				Key:           t.Key,
+				Project:       t.Project,
+				IssueType:     t.Type,
+				Summary:       t.Summary,
+				Assignee:      t.Assignee,
+				Status:        current.Status,
+				EnteredAt:     current.Time,
+				AgeDays:       days(o.Now.Sub(current.Time)),
+				ThresholdDays: days(threshold),
+			})
+		}
+		if idle := o.Now.Sub(t.UpdatedAt); idle > time.Duration(o.StaleDays)*24*time.Hour {
+			r.Stale = append(r.Stale, StaleIssue{
+				Key:       t.Key,
+				Project:   t.Project,
+				IssueType: t.Type,
+				Summary:   t.Summary,
+				Assignee:  t.Assignee,
+				Status:    current.Status,
+				UpdatedAt: t.UpdatedAt,
+				IdleDays:  days(idle),
+			})
+		}
+	}
+	sort.SliceStable(r.Aging, func(a, b int) bool { return r.Aging[a].EnteredAt.Before(r.Aging[b].EnteredAt) })
+	sort.SliceStable(r.Stale, func(a, b int) bool { return r.Stale[a].UpdatedAt.Before(r.Stale[b].UpdatedAt) })
+	return r
+}
+
+func (o AgingOptions) withDefaults() AgingOptions {
+	if o.Category == "" {
+		o.Category = store.InProgressCategory
+	}
+	if o.Percentile == 0 {
+		o.Percentile = DefaultAgingPercentile
+	}
+	if o.StaleDays == 0 {
+		o.StaleDays = DefaultStaleDays
+	}
+	if o.Now.IsZero() {
+		o.Now = time.Now()
+	}
+	return o
+}
+
+// Alerts returns the aging and stale issues of the report as
+// alerts, as stored in `jira_aging_issues` (see
+// `store.ReplaceAgingIssues`).
+func (r AgingReport) Alerts() []store.AgingAlert {
+	as := make([]store.AgingAlert, 0, len(r.Aging)+len(r.Stale))
+	for _, i := range r.Aging {
+		threshold := i.ThresholdDays
+		as = append(as, store.AgingAlert{
+			GeneratedAt:   r.GeneratedAt,
+			Alert:         store.AlertAging,
+			IssueKey:      i.Key,
+			Project:       i.Project,
+			Type:          i.IssueType,
+			Summary:       i.Summary,
+			Assignee:      i.Assignee,
+			Status:        i.Status,
+			Since:         i.EnteredAt,
+			Days:          i.AgeDays,
+			ThresholdDays: &threshold,
+		})
+	}
+	for _, i := range r.Stale {
+		as = append(as, store.AgingAlert{
+			GeneratedAt: r.GeneratedAt,
+			Alert:       store.AlertStale,
+			IssueKey:    i.Key,
+			Project:     i.Project,
+			Type:        i.IssueType,
+			Summary:     i.Summary,
+			Assignee:    i.Assignee,
+			Status:      i.Status,
+			Since:       i.UpdatedAt,
+			Days:        i.IdleDays,
+		})
+	}
+	return as
+}
+
+// Empty returns true if the report has no aging nor stale issues.
+func (r AgingReport) Empty() bool {
+	return len(r.Aging) == 0 && len(r.Stale) == 0
+}
+
+// WriteJSON writes the report as JSON.
+func (r AgingReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteTable writes the report as human readable tables, times
+// being in days.
+func (r AgingReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Issues in `%s` for longer than the %sth percentile of their status, in days\n\n", r.Category, csvDays(r.Percentile))
+	fmt.Fprintf(tw, "KEY\tTYPE\tSTATUS\tASSIGNEE\tAGE\tTHRESHOLD\tSUMMARY\t\n")
+	for _, i := range r.Aging {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			i.Key, i.IssueType, i.Status, assignee(i.Assignee), formatDays(i.AgeDays), formatDays(i.ThresholdDays), i.Summary)
+	}
+	fmt.Fprintf(tw, "\nIssues in `%s` not updated for more than %d days\n\n", r.Category, r.StaleDays)
+	fmt.Fprintf(tw, "KEY\tTYPE\tSTATUS\tASSIGNEE\tIDLE\tUPDATED AT\tSUMMARY\t\n")
+	for _, i := range r.Stale {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			i.Key, i.IssueType, i.Status, assignee(i.Assignee), formatDays(i.IdleDays), i.UpdatedAt.Format("2006-01-02"), i.Summary)
+	}
+	return tw.Flush()
+}
+
+// Post posts the report as JSON to the webhook URL, failing if the
+// response status is not 2xx.
+func (r AgingReport) Post(url string) error {
+	var body bytes.Buffer
+	if err := r.WriteJSON(&body); err != nil {
+		return fmt.Errorf("error in `Post`: %s", err)
+	}
+	c := &http.Client{Timeout: webhookTimeout}
+	res, err := c.Post(url, "application/json", &body)
+	if err != nil {
+		return fmt.Errorf("error in `Post`: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("error in `Post`: webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+func assignee(a *string) string {
+	if a == nil {
+		return "-"
+	}
+	return *a
+}
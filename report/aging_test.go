@@ -0,0 +1,151 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+func TestComputeAging(t *testing.T) {
+	// History: 1, 2, 3 and 4 days in `Doing`
+	var ts []store.IssueTimeline
+	for n, d := range []float64{1, 2, 3, 4} {
+		ts = append(ts, timeline(fmt.Sprintf("PJ-%d", n+1), "PJ", "Story", resolved(d+1),
+			0.0, "Open", "To Do", 1.0, "Doing", "In Progress", 1.0+d, "Closed", "Done"))
+	}
+	// Aging: in `Doing` for 5 days, updated 2 days ago
+	aging := timeline("PJ-5", "PJ", "Story", nil, 0.0, "Open", "To Do", 5.0, "Doing", "In Progress")
+	aging.Summary, aging.Assignee, aging.UpdatedAt = "Aging", str("bob"), day(8)
+	// Not aging, but stale
+	stale := timeline("PJ-6", "PJ", "Bug", nil, 0.0, "Open", "To Do", 8.0, "Doing", "In Progress")
+	stale.UpdatedAt = day(-2)
+	// Status without history, in another project
+	review := timeline("OT-1", "OT", "Story", nil, 0.0, "Open", "To Do", 0.0, "Review", "In Progress")
+	review.UpdatedAt = day(9)
+	// Not in progress
+	todo := timeline("PJ-7", "PJ", "Story", nil, 0.0, "Open", "To Do")
+	ts = append(ts, aging, stale, review, todo)
+
+	r := report.ComputeAging(ts, report.AgingOptions{StaleDays: 10, Now: day(10)})
+	if r.Category != "In Progress" || r.Percentile != 85 || r.StaleDays != 10 {
+		t.Errorf("unexpected options %+v", r)
+	}
+	if len(r.Aging) != 1 {
+		t.Fatalf("expected 1 aging issue, got %+v", r.Aging)
+	}
+	if a := r.Aging[0]; a.Key != "PJ-5" || a.Status != "Doing" || a.AgeDays != 5 || a.ThresholdDays != 4 || *a.Assignee != "bob" {
+		t.Errorf("unexpected aging issue %+v", a)
+	}
+	if len(r.Stale) != 1 || r.Stale[0].Key != "PJ-6" || r.Stale[0].IdleDays != 12 {
+		t.Errorf("unexpected stale issues %+v", r.Stale)
+	}
+
+	// A lower percentile
+	r = report.ComputeAging(ts, report.AgingOptions{Percentile: 50, Projects: []string{"PJ"}, Now: day(10)})
+	if len(r.Aging) != 1 || r.Aging[0].ThresholdDays != 2 {
+		t.Errorf("unexpected aging issues %+v", r.Aging)
+	}
+	if len(r.Stale) != 0 {
+		t.Errorf("expected no stale issue with the default stale days, got %+v", r.Stale)
+	}
+
+	// The history of other projects doesn't change the thresholds
+	slow := timeline("OT-2", "OT", "Story", resolved(21), 0.0, "Open", "To Do", 1.0, "Doing", "In Progress", 21.0, "Closed", "Done")
+	r = report.ComputeAging(append(ts, slow), report.AgingOptions{Percentile: 50, Projects: []string{"PJ"}, Now: day(10)})
+	if len(r.Aging) != 1 || r.Aging[0].ThresholdDays != 2 {
+		t.Errorf("unexpected aging issues with the history of another project %+v", r.Aging)
+	}
+}
+
+func TestAgingReport_Alerts(t *testing.T) {
+	r := report.AgingReport{
+		GeneratedAt: ref,
+		Aging:       []report.AgingIssue{{Key: "PJ-5", Project: "PJ", Status: "Doing", EnteredAt: day(5), AgeDays: 5, ThresholdDays: 4}},
+		Stale:       []report.StaleIssue{{Key: "PJ-6", Project: "PJ", Status: "Doing", Assignee: str("bob"), UpdatedAt: day(-2), IdleDays: 12}},
+	}
+
+	as := r.Alerts()
+	if len(as) != 2 {
+		t.Fatalf("expected 2 alerts, got %+v", as)
+	}
+	if a := as[0]; a.Alert != store.AlertAging || a.IssueKey != "PJ-5" || !a.Since.Equal(day(5)) || a.Days != 5 ||
+		a.ThresholdDays == nil || *a.ThresholdDays != 4 || !a.GeneratedAt.Equal(ref) {
+		t.Errorf("unexpected aging alert %+v", a)
+	}
+	if a := as[1]; a.Alert != store.AlertStale || a.IssueKey != "PJ-6" || !a.Since.Equal(day(-2)) || a.Days != 12 ||
+		a.ThresholdDays != nil || *a.Assignee != "bob" {
+		t.Errorf("unexpected stale alert %+v", a)
+	}
+}
+
+func TestPerformAging(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectGetIssueTimelines().WillReturn(nil)
+
+	r, err := report.PerformAging(s, report.AgingOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !r.Empty() {
+		t.Errorf("expected an empty report, got %+v", r)
+	}
+}
+
+func TestAgingReport_Write(t *testing.T) {
+	r := report.AgingReport{
+		Category:   "In Progress",
+		Percentile: 85,
+		StaleDays:  14,
+		Aging: []report.AgingIssue{{Key: "PJ-5", IssueType: "Story", Status: "Doing", Summary: "Aging",
+			AgeDays: 5, ThresholdDays: 4}},
+		Stale: []report.StaleIssue{{Key: "PJ-6", IssueType: "Bug", Status: "Doing", Assignee: str("bob"),
+			UpdatedAt: ref, IdleDays: 15.25}},
+	}
+
+	var table bytes.Buffer
+	if err := r.WriteTable(&table); err != nil {
+		t.Fatalf("unexpected error in `WriteTable`: %s", err)
+	}
+	for _, s := range []string{"85th percentile", "PJ-5", "4.0", "PJ-6", "bob", "15.2", "2020-03-02"} {
+		if !strings.Contains(table.String(), s) {
+			t.Errorf("expected `%s` in the table:\n%s", s, table.String())
+		}
+	}
+}
+
+func TestAgingReport_Post(t *testing.T) {
+	var received report.AgingReport
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("unexpected body: %s", err)
+		}
+	}))
+	defer srv.Close()
+
+	r := report.AgingReport{StaleDays: 14, Stale: []report.StaleIssue{{Key: "PJ-6"}}}
+	if err := r.Post(srv.URL); err != nil {
+		t.Fatalf("unexpected error in `Post`: %s", err)
+	}
+	if len(received.Stale) != 1 || received.Stale[0].Key != "PJ-6" {
+		t.Errorf("unexpected report received %+v", received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	if err := r.Post(failing.URL); err == nil {
+		t.Errorf("expected an error on a 500 response")
+	}
+}
@@ -0,0 +1,260 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Default status categories delimiting the cycle time.
+const (
+	DefaultStartCategory = "In Progress"
+	DefaultEndCategory   = "Done"
+)
+
+// CycleTimeOptions configures the cycle time report.
+type CycleTimeOptions struct {
+	// StartCategory and EndCategory are the status categories
+	// delimiting the cycle time (default: `In Progress` and `Done`).
+	StartCategory string
+	EndCategory   string
+
+	// Period by which issues are grouped, according to their
+	// completion time (default: month).
+	Period Period
+
+	// Only issues completed in [From, To) are reported. Zero times
+	// don't bound the range.
+	From time.Time
+	To   time.Time
+
+	// Projects of the reported issues (all projects if empty).
+	Projects []string
+}
+
+// CycleTimeReport is the result of `ComputeCycleTime`.
+type CycleTimeReport struct {
+	StartCategory string         `json:"start_category"`
+	EndCategory   string         `json:"end_category"`
+	Period        Period         `json:"period"`
+	Rows          []CycleTimeRow `json:"rows"`
+}
+
+// CycleTimeRow holds the lead and cycle times of the issues of a
+// project and type completed during a period.
+//
+// `Count` is the number of completed issues, whose lead times are
+// aggregated in `LeadTime`. `CycleTimeCount` is the number of those
+// which entered the start category, whose cycle times are
+// aggregated in `CycleTime` (nil if there are none).
+type CycleTimeRow struct {
+	Project        string       `json:"project"`
+	IssueType      string       `json:"issue_type"`
+	Period         string       `json:"period"`
+	PeriodStart    time.Time    `json:"period_start"`
+	Count          int          `json:"count"`
+	LeadTime       Percentiles  `json:"lead_time_days"`
+	CycleTimeCount int          `json:"cycle_time_count"`
+	CycleTime      *Percentiles `json:"cycle_time_days"`
+}
+
+// PerformCycleTime reads the issue timelines from the store and
+// computes the cycle time report.
+func PerformCycleTime(s store.Store, o CycleTimeOptions) (CycleTimeReport, error) {
+	ts, err := s.GetIssueTimelines()
+	if err != nil {
+		return CycleTimeReport{}, fmt.Errorf("error in `PerformCycleTime`: %s", err)
+	}
+	return ComputeCycleTime(ts, o), nil
+}
+
+// ComputeCycleTime computes the percentiles of the lead and cycle
+// times of the completed issues, by project, issue type and period.
+//
+// An issue is completed when it is resolved, or else when its
+// current status is in the end category (for workflows without
+// resolution). Its lead time runs from its creation to its
+// completion.
+//
+// Its cycle time runs from its first entry in the start category to
+// its last entry in the end category (its completion if it didn't
+// enter it). Issues which never entered the start category before
+// have no cycle time.
+//
+// Rows are sorted by project, issue type and period.
+func ComputeCycleTime(ts []store.IssueTimeline, o CycleTimeOptions) CycleTimeReport {
+	o = o.withDefaults()
+	type group struct {
+		project   string
+		issueType string
+		start     time.Time
+	}
+	leadTimes := make(map[group][]time.Duration)
+	cycleTimes := make(map[group][]time.Duration)
+	for _, t := range ts {
+		if len(o.Projects) > 0 && !contains(o.Projects, t.Project) {
+			continue
+		}
+		completedAt, ok := completion(t, o.EndCategory)
+		if !ok || (!o.From.IsZero() && completedAt.Before(o.From)) || (!o.To.IsZero() && !completedAt.Before(o.To)) {
+			continue
+		}
+		g := group{project: t.Project, issueType: t.Type, start: o.Period.Start(completedAt)}
+		leadTimes[g] = append(leadTimes[g], completedAt.Sub(t.CreatedAt))
+		if ct, ok := cycleTime(t, o.StartCategory, o.EndCategory, completedAt); ok {
+			cycleTimes[g] = append(cycleTimes[g], ct)
+		}
+	}
+
+	r := CycleTimeReport{StartCategory: o.StartCategory, EndCategory: o.EndCategory, Period: o.Period}
+	for g, lts := range leadTimes {
+		row := CycleTimeRow{
+			Project:     g.project,
+			IssueType:   g.issueType,
+			Period:      o.Period.Label(g.start),
+			PeriodStart: g.start,
+			Count:       len(lts),
+			LeadTime:    percentiles(lts),
+		}
+		if cts := cycleTimes[g]; len(cts) > 0 {
+			p := percentiles(cts)
+			row.CycleTimeCount = len(cts)
+			row.CycleTime = &p
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	sort.Slice(r.Rows, func(a, b int) bool {
+		ra, rb := r.Rows[a], r.Rows[b]
+		if ra.Project != rb.Project {
+			return ra.Project < rb.Project
+		}
+		if ra.IssueType != rb.IssueType {
+			return ra.IssueType < rb.IssueType
+		}
+		return ra.PeriodStart.Before(rb.PeriodStart)
+	})
+	return r
+}
+
+func (o CycleTimeOptions) withDefaults() CycleTimeOptions {
+	if o.StartCategory == "" {
+		o.StartCategory = DefaultStartCategory
+	}
+	if o.EndCategory == "" {
+		o.EndCategory = DefaultEndCategory
+	}
+	if o.Period == "" {
+		o.Period = PeriodMonth
+	}
+	return o
+}
+
+// completion returns the completion time of the issue, and false if
+// it is not completed.
+func completion(t store.IssueTimeline, endCategory string) (time.Time, bool) {
+	if t.ResolvedAt != nil {
+		return *t.ResolvedAt, true
+	}
+	if n := len(t.Statuses); n > 0 && inCategory(t.Statuses[n-1], endCategory) {
+		return lastEntry(t.Statuses, endCategory), true
+	}
+	return time.Time{}, false
+}
+
+// cycleTime returns the cycle time of the issue completed at
+// `completedAt`, and false if it never entered the start category
+// before its end.
+func cycleTime(t store.IssueTimeline, startCategory string, endCategory string, completedAt time.Time) (time.Duration, bool) {
+	end := lastEntry(t.Statuses, endCategory)
+	if end.IsZero() {
+		end = completedAt
+	}
+	for _, e := range t.Statuses {
+		if e.Time.After(end) {
+			break
+		}
+		if inCategory(e, startCategory) {
+			return end.Sub(e.Time), true
+		}
+	}
+	return 0, false
+}
+
+// lastEntry returns the time of the last entry in the category from
+// another one (or at creation), or the zero time if the statuses
+// never entered it.
+func lastEntry(ses []store.StatusEntry, category string) time.Time {
+	var at time.Time
+	for n, e := range ses {
+		if inCategory(e, category) && (n == 0 || !inCategory(ses[n-1], category)) {
+			at = e.Time
+		}
+	}
+	return at
+}
+
+func inCategory(e store.StatusEntry, category string) bool {
+	return e.Category != nil && *e.Category == category
+}
+
+// WriteJSON writes the report as JSON.
+func (r CycleTimeReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the rows of the report as CSV, with a header.
+// Cycle time percentiles are empty for rows without cycle times.
+func (r CycleTimeReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"project", "issue_type", "period", "count",
+		"lead_time_p50", "lead_time_p85", "lead_time_p95",
+		"cycle_time_count", "cycle_time_p50", "cycle_time_p85", "cycle_time_p95"})
+	for _, row := range r.Rows {
+		rec := []string{row.Project, row.IssueType, row.Period, strconv.Itoa(row.Count),
+			csvDays(row.LeadTime.P50), csvDays(row.LeadTime.P85), csvDays(row.LeadTime.P95),
+			strconv.Itoa(row.CycleTimeCount), "", "", ""}
+		if ct := row.CycleTime; ct != nil {
+			rec[8], rec[9], rec[10] = csvDays(ct.P50), csvDays(ct.P85), csvDays(ct.P95)
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the report as a human readable table, times
+// being in days.
+func (r CycleTimeReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Cycle time from `%s` to `%s` by %s, in days\n\n", r.StartCategory, r.EndCategory, r.Period)
+	fmt.Fprintf(tw, "PROJECT\tTYPE\tPERIOD\tCOUNT\tLEAD P50\tLEAD P85\tLEAD P95\tCYCLE COUNT\tCYCLE P50\tCYCLE P85\tCYCLE P95\t\n")
+	for _, row := range r.Rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%d\t",
+			row.Project, row.IssueType, row.Period, row.Count,
+			formatDays(row.LeadTime.P50), formatDays(row.LeadTime.P85), formatDays(row.LeadTime.P95),
+			row.CycleTimeCount)
+		if ct := row.CycleTime; ct != nil {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t\n", formatDays(ct.P50), formatDays(ct.P85), formatDays(ct.P95))
+		} else {
+			fmt.Fprintf(tw, "-\t-\t-\t\n")
+		}
+	}
+	return tw.Flush()
+}
+
+func formatDays(d float64) string {
+	return strconv.FormatFloat(d, 'f', 1, 64)
+}
+
+func csvDays(d float64) string {
+	return strconv.FormatFloat(d, 'f', -1, 64)
+}
@@ -0,0 +1,191 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+var ref = time.Date(2020, 3, 2, 9, 0, 0, 0, time.UTC)
+
+func day(n float64) time.Time {
+	return ref.Add(time.Duration(n * 24 * float64(time.Hour)))
+}
+
+func str(s string) *string { return &s }
+
+// timeline returns the timeline of an issue created at `ref` going
+// through the statuses, specified as (day, status, category)
+// triplets.
+func timeline(k string, project string, issueType string, resolved *time.Time, entries ...interface{}) store.IssueTimeline {
+	t := store.IssueTimeline{Key: k, Project: project, Type: issueType, CreatedAt: ref, ResolvedAt: resolved}
+	for n := 0; n < len(entries); n += 3 {
+		e := store.StatusEntry{Time: day(entries[n].(float64)), Status: entries[n+1].(string)}
+		if c := entries[n+2].(string); c != "" {
+			e.Category = str(c)
+		}
+		t.Statuses = append(t.Statuses, e)
+	}
+	return t
+}
+
+func resolved(n float64) *time.Time {
+	t := day(n)
+	return &t
+}
+
+func TestComputeCycleTime(t *testing.T) {
+	ts := []store.IssueTimeline{
+		// Lead time 10, cycle time 6
+		timeline("PJ-1", "PJ", "Story", resolved(10),
+			0.0, "Open", "To Do", 2.0, "Doing", "In Progress", 4.0, "Review", "In Progress", 8.0, "Closed", "Done"),
+		// Lead time 20, cycle time 10 (from the first start to the
+		// last entry in the end category)
+		timeline("PJ-2", "PJ", "Story", resolved(20),
+			0.0, "Open", "To Do", 5.0, "Doing", "In Progress", 6.0, "Closed", "Done",
+			8.0, "Doing", "In Progress", 15.0, "Closed", "Done"),
+		// Not resolved but in the end category: lead time 3, no
+		// cycle time (never in progress)
+		timeline("PJ-3", "PJ", "Story", nil,
+			0.0, "Open", "To Do", 3.0, "Won't do", "Done"),
+		// Not completed
+		timeline("PJ-4", "PJ", "Story", nil,
+			0.0, "Open", "To Do", 1.0, "Doing", "In Progress"),
+		// Another type and month
+		timeline("PJ-5", "PJ", "Bug", resolved(40),
+			0.0, "Open", "To Do", 39.0, "Doing", "In Progress", 40.0, "Closed", "Done"),
+		// Another project
+		timeline("OT-1", "OT", "Story", resolved(1),
+			0.0, "Open", "To Do", 1.0, "Closed", "Done"),
+	}
+
+	r := report.ComputeCycleTime(ts, report.CycleTimeOptions{})
+	if r.StartCategory != "In Progress" || r.EndCategory != "Done" || r.Period != report.PeriodMonth {
+		t.Errorf("expected the default options, got %+v", r)
+	}
+	if len(r.Rows) != 3 {
+		t.Fatalf("expected 3 rows, got %+v", r.Rows)
+	}
+
+	ot := r.Rows[0]
+	if ot.Project != "OT" || ot.Count != 1 || ot.CycleTime != nil || ot.LeadTime.P50 != 1 {
+		t.Errorf("unexpected row %+v", ot)
+	}
+
+	bug := r.Rows[1]
+	if bug.IssueType != "Bug" || bug.Period != "2020-04" || bug.Count != 1 || bug.CycleTime == nil || bug.CycleTime.P95 != 1 {
+		t.Errorf("unexpected row %+v", bug)
+	}
+
+	story := r.Rows[2]
+	if story.Project != "PJ" || story.IssueType != "Story" || story.Period != "2020-03" {
+		t.Fatalf("unexpected row %+v", story)
+	}
+	if story.Count != 3 || story.CycleTimeCount != 2 {
+		t.Errorf("expected 3 lead times and 2 cycle times, got %+v", story)
+	}
+	if lt := (report.Percentiles{P50: 10, P85: 20, P95: 20}); story.LeadTime != lt {
+		t.Errorf("expected lead times %+v, got %+v", lt, story.LeadTime)
+	}
+	if ct := (report.Percentiles{P50: 6, P85: 10, P95: 10}); story.CycleTime == nil || *story.CycleTime != ct {
+		t.Errorf("expected cycle times %+v, got %+v", ct, story.CycleTime)
+	}
+}
+
+func TestComputeCycleTime_options(t *testing.T) {
+	ts := []store.IssueTimeline{
+		timeline("PJ-1", "PJ", "Story", nil,
+			0.0, "Open", "To Do", 1.0, "Review", "Review", 3.5, "Shipped", "Shipped"),
+		timeline("PJ-2", "PJ", "Story", resolved(30),
+			0.0, "Open", "To Do", 29.0, "Review", "Review", 30.0, "Shipped", "Shipped"),
+		timeline("OT-1", "OT", "Story", resolved(1)),
+	}
+
+	r := report.ComputeCycleTime(ts, report.CycleTimeOptions{
+		StartCategory: "Review",
+		EndCategory:   "Shipped",
+		Period:        report.PeriodWeek,
+		From:          ref,
+		To:            day(7),
+		Projects:      []string{"PJ"},
+	})
+	if len(r.Rows) != 1 {
+		t.Fatalf("expected 1 row, got %+v", r.Rows)
+	}
+	row := r.Rows[0]
+	if row.Period != "2020-W10" || !row.PeriodStart.Equal(time.Date(2020, 3, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected period %s (%s)", row.Period, row.PeriodStart)
+	}
+	if row.Count != 1 || row.LeadTime.P50 != 3.5 || row.CycleTime == nil || row.CycleTime.P50 != 2.5 {
+		t.Errorf("unexpected row %+v", row)
+	}
+}
+
+func TestPerformCycleTime(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectGetIssueTimelines().WillReturn([]store.IssueTimeline{
+		timeline("PJ-1", "PJ", "Story", resolved(2), 0.0, "Open", "To Do", 1.0, "Doing", "In Progress"),
+	})
+
+	r, err := report.PerformCycleTime(s, report.CycleTimeOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// The issue didn't enter the end category: its cycle ends at
+	// its resolution.
+	if len(r.Rows) != 1 || r.Rows[0].CycleTime == nil || r.Rows[0].CycleTime.P50 != 1 {
+		t.Errorf("unexpected rows %+v", r.Rows)
+	}
+}
+
+func TestCycleTimeReport_Write(t *testing.T) {
+	r := report.CycleTimeReport{
+		StartCategory: "In Progress",
+		EndCategory:   "Done",
+		Period:        report.PeriodMonth,
+		Rows: []report.CycleTimeRow{
+			{Project: "PJ", IssueType: "Story", Period: "2020-03", Count: 3,
+				LeadTime: report.Percentiles{P50: 10, P85: 20.25, P95: 20.25}, CycleTimeCount: 2,
+				CycleTime: &report.Percentiles{P50: 6, P85: 10, P95: 10}},
+			{Project: "PJ", IssueType: "Bug", Period: "2020-04", Count: 1,
+				LeadTime: report.Percentiles{P50: 1, P85: 1, P95: 1}},
+		},
+	}
+
+	var csv bytes.Buffer
+	if err := r.WriteCSV(&csv); err != nil {
+		t.Fatalf("unexpected error in `WriteCSV`: %s", err)
+	}
+	expected := `project,issue_type,period,count,lead_time_p50,lead_time_p85,lead_time_p95,cycle_time_count,cycle_time_p50,cycle_time_p85,cycle_time_p95
+PJ,Story,2020-03,3,10,20.25,20.25,2,6,10,10
+PJ,Bug,2020-04,1,1,1,1,0,,,
+`
+	if csv.String() != expected {
+		t.Errorf("unexpected CSV:\n%s\nexpected:\n%s", csv.String(), expected)
+	}
+
+	var table bytes.Buffer
+	if err := r.WriteTable(&table); err != nil {
+		t.Fatalf("unexpected error in `WriteTable`: %s", err)
+	}
+	for _, s := range []string{"PROJECT", "2020-03", "20.2", "-"} {
+		if !strings.Contains(table.String(), s) {
+			t.Errorf("expected `%s` in the table:\n%s", s, table.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := r.WriteJSON(&js); err != nil {
+		t.Fatalf("unexpected error in `WriteJSON`: %s", err)
+	}
+	for _, s := range []string{`"lead_time_days"`, `"cycle_time_days": null`, `"p85": 20.25`} {
+		if !strings.Contains(js.String(), s) {
+			t.Errorf("expected `%s` in the JSON:\n%s", s, js.String())
+		}
+	}
+}
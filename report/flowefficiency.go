@@ -0,0 +1,200 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// FlowEfficiencyOptions configures the flow efficiency report.
+type FlowEfficiencyOptions struct {
+	// Period by which issues are grouped, according to their
+	// resolution time (default: month).
+	Period Period
+
+	// Only issues resolved in [From, To) are reported. Zero times
+	// don't bound the range.
+	From time.Time
+	To   time.Time
+
+	// Projects of the reported issues (all projects if empty).
+	Projects []string
+}
+
+// FlowEfficiencyReport is the result of `ComputeFlowEfficiency`.
+type FlowEfficiencyReport struct {
+	Period Period              `json:"period"`
+	Rows   []FlowEfficiencyRow `json:"rows"`
+}
+
+// FlowEfficiencyRow holds the flow efficiency of the issues of a
+// tribe (nil for issues without tribe) resolved during a period.
+//
+// `ActiveDays` and `WaitingDays` are the total times the `Count`
+// issues spent in active and waiting statuses. `FlowEfficiency` is
+// the ratio of their total active time to their total cycle time
+// (active and waiting), and `AverageFlowEfficiency` the average of
+// their flow efficiencies, both rounded to the thousandth.
+type FlowEfficiencyRow struct {
+	Tribe                 *string   `json:"tribe"`
+	Period                string    `json:"period"`
+	PeriodStart           time.Time `json:"period_start"`
+	Count                 int       `json:"count"`
+	ActiveDays            float64   `json:"active_days"`
+	WaitingDays           float64   `json:"waiting_days"`
+	FlowEfficiency        float64   `json:"flow_efficiency"`
+	AverageFlowEfficiency float64   `json:"average_flow_efficiency"`
+}
+
+// PerformFlowEfficiency reads the flow efficiencies of the issues
+// from the store and computes the flow efficiency report.
+func PerformFlowEfficiency(s store.Store, o FlowEfficiencyOptions) (FlowEfficiencyReport, error) {
+	fs, err := s.GetIssueFlowEfficiencies()
+	if err != nil {
+		return FlowEfficiencyReport{}, fmt.Errorf("error in `PerformFlowEfficiency`: %s", err)
+	}
+	return ComputeFlowEfficiency(fs, o), nil
+}
+
+// ComputeFlowEfficiency aggregates the flow efficiencies of the
+// resolved issues (see `store.IssueState.SetFlowEfficiency`) by
+// tribe and period of resolution. An issue with several tribes is
+// counted in the rows of each.
+//
+// Rows are sorted by tribe (issues without tribe last) and period.
+func ComputeFlowEfficiency(fs []store.IssueFlowEfficiency, o FlowEfficiencyOptions) FlowEfficiencyReport {
+	if o.Period == "" {
+		o.Period = PeriodMonth
+	}
+	type group struct {
+		tribe   string
+		noTribe bool
+		start   time.Time
+	}
+	type totals struct {
+		count      int
+		active     time.Duration
+		waiting    time.Duration
+		efficiency float64
+	}
+	groups := make(map[group]*totals)
+	for _, f := range fs {
+		if len(o.Projects) > 0 && !contains(o.Projects, f.Project) {
+			continue
+		}
+		if (!o.From.IsZero() && f.ResolvedAt.Before(o.From)) || (!o.To.IsZero() && !f.ResolvedAt.Before(o.To)) {
+			continue
+		}
+		gs := []group{{noTribe: true, start: o.Period.Start(f.ResolvedAt)}}
+		if len(f.Tribes) > 0 {
+			gs = gs[:0]
+			for _, tribe := range f.Tribes {
+				gs = append(gs, group{tribe: tribe, start: o.Period.Start(f.ResolvedAt)})
+			}
+		}
+		for _, g := range gs {
+			t, ok := groups[g]
+			if !ok {
+				t = &totals{}
+				groups[g] = t
+			}
+			t.count++
+			t.active += f.ActiveTime
+			t.waiting += f.WaitingTime
+			t.efficiency += f.FlowEfficiency
+		}
+	}
+
+	r := FlowEfficiencyReport{Period: o.Period}
+	for g, t := range groups {
+		row := FlowEfficiencyRow{
+			Period:                o.Period.Label(g.start),
+			PeriodStart:           g.start,
+			Count:                 t.count,
+			ActiveDays:            days(t.active),
+			WaitingDays:           days(t.waiting),
+			AverageFlowEfficiency: ratio(t.efficiency / float64(t.count)),
+		}
+		if !g.noTribe {
+			tribe := g.tribe
+			row.Tribe = &tribe
+		}
+		if total := t.active + t.waiting; total > 0 {
+			row.FlowEfficiency = ratio(float64(t.active) / float64(total))
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	sort.Slice(r.Rows, func(a, b int) bool {
+		ra, rb := r.Rows[a], r.Rows[b]
+		switch {
+		case ra.Tribe == nil && rb.Tribe != nil:
+			return false
+		case ra.Tribe != nil && rb.Tribe == nil:
+			return true
+		case ra.Tribe != nil && *ra.Tribe != *rb.Tribe:
+			return *ra.Tribe < *rb.Tribe
+		}
+		return ra.PeriodStart.Before(rb.PeriodStart)
+	})
+	return r
+}
+
+// ratio rounds the ratio to the thousandth.
+func ratio(r float64) float64 {
+	return math.Round(r*1000) / 1000
+}
+
+// WriteJSON writes the report as JSON.
+func (r FlowEfficiencyReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the rows of the report as CSV, with a header. The
+// tribe is empty for issues without tribe.
+func (r FlowEfficiencyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"tribe", "period", "count", "active_days", "waiting_days", "flow_efficiency", "average_flow_efficiency"})
+	for _, row := range r.Rows {
+		tribe := ""
+		if row.Tribe != nil {
+			tribe = *row.Tribe
+		}
+		cw.Write([]string{tribe, row.Period, strconv.Itoa(row.Count),
+			csvDays(row.ActiveDays), csvDays(row.WaitingDays),
+			csvDays(row.FlowEfficiency), csvDays(row.AverageFlowEfficiency)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the report as a human readable table, times
+// being in days and efficiencies in percent.
+func (r FlowEfficiencyReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Flow efficiency by tribe and %s\n\n", r.Period)
+	fmt.Fprintf(tw, "TRIBE\tPERIOD\tCOUNT\tACTIVE\tWAITING\tEFFICIENCY\tAVERAGE\t\n")
+	for _, row := range r.Rows {
+		tribe := "-"
+		if row.Tribe != nil {
+			tribe = *row.Tribe
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n",
+			tribe, row.Period, row.Count, formatDays(row.ActiveDays), formatDays(row.WaitingDays),
+			formatPercent(row.FlowEfficiency), formatPercent(row.AverageFlowEfficiency))
+	}
+	return tw.Flush()
+}
+
+func formatPercent(r float64) string {
+	return strconv.FormatFloat(r*100, 'f', 1, 64) + "%"
+}
@@ -0,0 +1,142 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// efficiency returns the flow efficiency of an issue resolved at
+// day `n`, active and waiting for the specified days.
+func efficiency(k string, project string, tribes []string, n float64, active float64, waiting float64) store.IssueFlowEfficiency {
+	a, w := time.Duration(active*24)*time.Hour, time.Duration(waiting*24)*time.Hour
+	return store.IssueFlowEfficiency{
+		Key:            k,
+		Project:        project,
+		Type:           "Story",
+		Tribes:         tribes,
+		ResolvedAt:     day(n),
+		ActiveTime:     a,
+		WaitingTime:    w,
+		FlowEfficiency: float64(a) / float64(a+w),
+	}
+}
+
+func TestComputeFlowEfficiency(t *testing.T) {
+	fs := []store.IssueFlowEfficiency{
+		efficiency("PJ-1", "PJ", []string{"Data"}, 1, 1, 3),
+		efficiency("PJ-2", "PJ", []string{"Data"}, 2, 3, 1),
+		efficiency("PJ-3", "PJ", []string{"Data"}, 2, 1, 0),
+		efficiency("PJ-4", "PJ", []string{"Data"}, 40, 1, 1),
+		efficiency("PJ-5", "PJ", nil, 2, 1, 1),
+		efficiency("PJ-6", "PJ", []string{"Apps"}, 2, 1, 1),
+		efficiency("OT-1", "OT", []string{"Apps"}, 2, 1, 1),
+	}
+
+	r := report.ComputeFlowEfficiency(fs, report.FlowEfficiencyOptions{})
+	if r.Period != report.PeriodMonth || len(r.Rows) != 4 {
+		t.Fatalf("expected 4 monthly rows, got %+v", r)
+	}
+	for n, want := range []struct {
+		tribe  string
+		period string
+		count  int
+	}{
+		{"Apps", "2020-03", 2},
+		{"Data", "2020-03", 3},
+		{"Data", "2020-04", 1},
+		{"", "2020-03", 1},
+	} {
+		row := r.Rows[n]
+		if (row.Tribe == nil) != (want.tribe == "") || (row.Tribe != nil && *row.Tribe != want.tribe) ||
+			row.Period != want.period || row.Count != want.count {
+			t.Errorf("unexpected row %d %+v, expected %+v", n, row, want)
+		}
+	}
+
+	data := r.Rows[1]
+	if data.ActiveDays != 5 || data.WaitingDays != 4 {
+		t.Errorf("expected 5 active and 4 waiting days, got %+v", data)
+	}
+	// 5 / 9 and (0.25 + 0.75 + 1) / 3
+	if data.FlowEfficiency != 0.556 || data.AverageFlowEfficiency != 0.667 {
+		t.Errorf("unexpected efficiencies %+v", data)
+	}
+
+	r = report.ComputeFlowEfficiency(fs, report.FlowEfficiencyOptions{
+		Period: report.PeriodQuarter, From: day(2), To: day(30), Projects: []string{"PJ"}})
+	if len(r.Rows) != 3 || r.Rows[1].Period != "2020-Q1" || r.Rows[1].Count != 2 {
+		t.Errorf("unexpected rows %+v", r.Rows)
+	}
+
+	// An issue with several tribes is counted in the rows of each
+	r = report.ComputeFlowEfficiency([]store.IssueFlowEfficiency{
+		efficiency("OT-2", "OT", []string{"Data", "Apps"}, 2, 1, 1),
+	}, report.FlowEfficiencyOptions{})
+	if len(r.Rows) != 2 || *r.Rows[0].Tribe != "Apps" || *r.Rows[1].Tribe != "Data" || r.Rows[1].Count != 1 {
+		t.Errorf("unexpected rows %+v", r.Rows)
+	}
+}
+
+func TestPerformFlowEfficiency(t *testing.T) {
+	s := storetest.NewFakeStore(t)
+	s.ExpectGetIssueFlowEfficiencies().WillReturn([]store.IssueFlowEfficiency{
+		efficiency("PJ-1", "PJ", []string{"Data"}, 1, 1, 3),
+	})
+
+	r, err := report.PerformFlowEfficiency(s, report.FlowEfficiencyOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(r.Rows) != 1 || r.Rows[0].FlowEfficiency != 0.25 {
+		t.Errorf("unexpected rows %+v", r.Rows)
+	}
+}
+
+func TestFlowEfficiencyReport_Write(t *testing.T) {
+	r := report.FlowEfficiencyReport{
+		Period: report.PeriodMonth,
+		Rows: []report.FlowEfficiencyRow{
+			{Tribe: str("Data"), Period: "2020-03", Count: 3, ActiveDays: 5, WaitingDays: 4,
+				FlowEfficiency: 0.556, AverageFlowEfficiency: 0.667},
+			{Period: "2020-03", Count: 1, ActiveDays: 1, WaitingDays: 1, FlowEfficiency: 0.5, AverageFlowEfficiency: 0.5},
+		},
+	}
+
+	var csv bytes.Buffer
+	if err := r.WriteCSV(&csv); err != nil {
+		t.Fatalf("unexpected error in `WriteCSV`: %s", err)
+	}
+	expected := `tribe,period,count,active_days,waiting_days,flow_efficiency,average_flow_efficiency
+Data,2020-03,3,5,4,0.556,0.667
+,2020-03,1,1,1,0.5,0.5
+`
+	if csv.String() != expected {
+		t.Errorf("unexpected CSV:\n%s\nexpected:\n%s", csv.String(), expected)
+	}
+
+	var table bytes.Buffer
+	if err := r.WriteTable(&table); err != nil {
+		t.Fatalf("unexpected error in `WriteTable`: %s", err)
+	}
+	for _, s := range []string{"TRIBE", "Data", "55.6%", "66.7%", "-"} {
+		if !strings.Contains(table.String(), s) {
+			t.Errorf("expected `%s` in the table:\n%s", s, table.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := r.WriteJSON(&js); err != nil {
+		t.Fatalf("unexpected error in `WriteJSON`: %s", err)
+	}
+	for _, s := range []string{`"tribe": null`, `"flow_efficiency": 0.556`} {
+		if !strings.Contains(js.String(), s) {
+			t.Errorf("expected `%s` in the JSON:\n%s", s, js.String())
+		}
+	}
+}
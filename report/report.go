@@ -0,0 +1,101 @@
+// Package report computes flow metrics (e.g. cycle times) from the
+// issue timelines read from the store, and writes them as a table,
+// CSV or JSON.
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Period is the period by which metrics are aggregated.
+type Period string
+
+// Periods by which metrics can be aggregated.
+const (
+	PeriodWeek    Period = "week"
+	PeriodMonth   Period = "month"
+	PeriodQuarter Period = "quarter"
+)
+
+// ParsePeriod returns the period named `s`.
+func ParsePeriod(s string) (Period, error) {
+	switch p := Period(s); p {
+	case PeriodWeek, PeriodMonth, PeriodQuarter:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown period `%s` (expected week, month or quarter)", s)
+}
+
+// Start returns the start of the period containing `t`, in UTC.
+// Weeks start on Monday.
+func (p Period) Start(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch p {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case PeriodQuarter:
+		return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Label returns the label of the period starting at `start` (e.g.
+// `2020-03`, `2020-W10` or `2020-Q1`).
+func (p Period) Label(start time.Time) string {
+	switch p {
+	case PeriodWeek:
+		y, w := start.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	case PeriodQuarter:
+		return fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())+2)/3)
+	}
+	return start.Format("2006-01")
+}
+
+// Percentiles are the 50th, 85th and 95th percentiles of durations,
+// in days.
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P85 float64 `json:"p85"`
+	P95 float64 `json:"p95"`
+}
+
+// percentiles returns the percentiles of the durations, computed
+// with the nearest-rank method and rounded to the hundredth of a
+// day. `ds` is sorted in place and must not be empty.
+func percentiles(ds []time.Duration) Percentiles {
+	sort.Slice(ds, func(a, b int) bool { return ds[a] < ds[b] })
+	return Percentiles{
+		P50: days(percentile(ds, 50)),
+		P85: days(percentile(ds, 85)),
+		P95: days(percentile(ds, 95)),
+	}
+}
+
+// percentile returns the `p`th percentile of the sorted durations
+// with the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// days converts the duration to days, rounded to the hundredth.
+func days(d time.Duration) float64 {
+	return math.Round(d.Hours()/24*100) / 100
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
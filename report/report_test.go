@@ -0,0 +1,41 @@
+package report_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/report"
+)
+
+func TestPeriod(t *testing.T) {
+	at := time.Date(2020, 5, 14, 17, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	for _, tc := range []struct {
+		period report.Period
+		start  time.Time
+		label  string
+	}{
+		{report.PeriodWeek, time.Date(2020, 5, 11, 0, 0, 0, 0, time.UTC), "2020-W20"},
+		{report.PeriodMonth, time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC), "2020-05"},
+		{report.PeriodQuarter, time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC), "2020-Q2"},
+	} {
+		p, err := report.ParsePeriod(string(tc.period))
+		if err != nil || p != tc.period {
+			t.Errorf("unexpected result of `ParsePeriod(%s)`: %s, %v", tc.period, p, err)
+		}
+		start := p.Start(at)
+		if !start.Equal(tc.start) {
+			t.Errorf("expected the %s to start at %s, got %s", p, tc.start, start)
+		}
+		if l := p.Label(start); l != tc.label {
+			t.Errorf("expected the %s label `%s`, got `%s`", p, tc.label, l)
+		}
+	}
+
+	// Sundays belong to the week started on the previous Monday.
+	if s := report.PeriodWeek.Start(time.Date(2020, 5, 17, 23, 0, 0, 0, time.UTC)); s.Day() != 11 {
+		t.Errorf("expected the week to start on May 11, got %s", s)
+	}
+	if _, err := report.ParsePeriod("year"); err == nil {
+		t.Errorf("expected an error for an unknown period")
+	}
+}
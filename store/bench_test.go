@@ -0,0 +1,95 @@
+package store_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// benchmarkStore returns a `PGStore` on the database of
+// `TEST_DB_URL` (see `testDB`), with the tables dropped and created
+// again.
+func benchmarkStore(b *testing.B) (*store.PGStore, func()) {
+	b.Helper()
+	db := testDB(b)
+	s := store.NewPGStore(db)
+	s.DropTables()
+	s.CreateTables()
+	return s, func() { db.Close() }
+}
+
+// benchmarkRecords returns the records of a synthetic issue with a
+// typical number of events (10) and status periods (4).
+func benchmarkRecords(n int) store.IssueRecords {
+	k := fmt.Sprintf("PJ-%d", n)
+	t := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(n) * time.Hour)
+	project, status, summary := "PJ", "Done", "Summary"
+	r := store.IssueRecords{
+		Key: k,
+		State: store.IssueState{
+			CreatedAt: t,
+			UpdatedAt: t.Add(72 * time.Hour),
+			Key:       k,
+			Project:   &project,
+			Status:    &status,
+			Summary:   &summary,
+			Labels:    []string{"label"},
+		},
+	}
+	for e := 0; e < 10; e++ {
+		body := "Comment"
+		r.Events = append(r.Events, store.IssueEvent{
+			EventTime:   t.Add(time.Duration(e) * time.Hour),
+			EventKind:   "comment_added",
+			EventAuthor: "user",
+			IssueKey:    k,
+			CommentBody: &body,
+		})
+	}
+	for p := 0; p < 4; p++ {
+		exited := t.Add(time.Duration(p+1) * 18 * time.Hour)
+		d := 18 * time.Hour
+		r.StatusPeriods = append(r.StatusPeriods, store.IssueStatusPeriod{
+			IssueKey:  k,
+			Status:    fmt.Sprintf("Status %d", p),
+			EnteredAt: t.Add(time.Duration(p) * 18 * time.Hour),
+			ExitedAt:  &exited,
+			Duration:  &d,
+		})
+	}
+	return r
+}
+
+// BenchmarkPGStore_ReplaceIssueStateAndEvents measures the write of
+// an issue's records, one transaction per issue.
+func BenchmarkPGStore_ReplaceIssueStateAndEvents(b *testing.B) {
+	s, done := benchmarkStore(b)
+	defer done()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		r := benchmarkRecords(n % 1000)
+		if err := s.ReplaceIssueStateAndEvents(r.Key, r.State, r.Events, r.StatusPeriods); err != nil {
+			b.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s", err)
+		}
+	}
+}
+
+// BenchmarkPGStore_ReplaceIssues measures the write of an issue's
+// records in batches of 20 issues (see `jira.SyncConfig`).
+func BenchmarkPGStore_ReplaceIssues(b *testing.B) {
+	s, done := benchmarkStore(b)
+	defer done()
+	const batchSize = 20
+	b.ResetTimer()
+	for n := 0; n < b.N; n += batchSize {
+		rs := make([]store.IssueRecords, 0, batchSize)
+		for i := n; i < n+batchSize && i < b.N; i++ {
+			rs = append(rs, benchmarkRecords(i%1000))
+		}
+		if err := s.ReplaceIssues(rs); err != nil {
+			b.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+		}
+	}
+}
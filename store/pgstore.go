@@ -2,11 +2,11 @@ package store
 
 import (
 	"database/sql"
-	"fmt"
-	"log"
 	"time"
 
-	_ "github.com/lib/pq" // PG engine for database/sql
+	"github.com/lib/pq" // PG engine for database/sql, also used for arrays
+
+	"github.com/rchampourlier/kaizenizer-source-jira/logging"
 )
 
 // PGStore implements the application's `Store` with a
@@ -22,14 +22,16 @@ func NewPGStore(db *sql.DB) *PGStore {
 	return &PGStore{db}
 }
 
-// ReplaceIssueStateAndEvents replace the existing state and
-// events records for the specified issue key, then inserts
-// the new state and events records.
+// ReplaceIssueStateAndEvents replace the existing state, events
+// and status periods records for the specified issue key, then
+// inserts the new records.
 //
 // The operations are performed atomically using a DB transaction.
-func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error) {
+func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent, isps []IssueStatusPeriod) (err error) {
 	tx, err := s.Begin()
-
+	if err != nil {
+		return err
+	}
 	defer func() {
 		switch err {
 		case nil:
@@ -48,10 +50,920 @@ func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []Issu
 	if err = insertIssueEvents(tx, ies, is); err != nil {
 		return
 	}
+	if err = insertIssueStatusPeriods(tx, isps); err != nil {
+		return
+	}
+
+	return
+}
+
+// ReplaceIssues replaces the existing state, events and status
+// periods records of the specified issues with the new ones, and
+// inserts or updates the users referenced by the issues (see
+// `UpsertUsers`).
+//
+// All issues are written in a single DB transaction: if any of them
+// fails, none is written.
+func (s *PGStore) ReplaceIssues(rs []IssueRecords) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	for _, r := range rs {
+		if err = dropAllForIssueKey(tx, r.Key); err != nil {
+			return
+		}
+		if err = insertIssueState(tx, r.State); err != nil {
+			return
+		}
+		if err = insertIssueEvents(tx, r.Events, r.State); err != nil {
+			return
+		}
+		if err = insertIssueStatusPeriods(tx, r.StatusPeriods); err != nil {
+			return
+		}
+		for _, u := range r.Users {
+			if err = upsertUser(tx, u); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// ReplaceStatuses replaces all the records of the `jira_statuses`
+// table with the specified statuses.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceStatuses(ss []Status) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_statuses;"); err != nil {
+		return
+	}
+	for _, st := range ss {
+		if err = insertStatus(tx, st); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// UpsertUsers inserts the specified users in the `jira_users`
+// table, or updates them if they already exist.
+//
+// The email and time zone of an existing user are kept if the
+// new values are unknown (e.g. hidden by the user's privacy
+// settings in the context the user was fetched).
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) UpsertUsers(us []User) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	for _, u := range us {
+		if err = upsertUser(tx, u); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// UpsertSyncFailure records the failure to synchronize an issue in
+// the `jira_sync_failures` table. If the issue already failed, the
+// failure is updated and its attempts count incremented.
+//
+// Failures are deleted when the issue's records are replaced (see
+// `ReplaceIssueStateAndEvents`).
+func (s *PGStore) UpsertSyncFailure(f SyncFailure) (err error) {
+	query := `
+	INSERT INTO jira_sync_failures (
+		issue_key,
+		stage,
+		error,
+		payload_ref,
+		run_id,
+		failed_at,
+		attempts
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, 1)
+	ON CONFLICT (issue_key) DO UPDATE SET
+		stage = EXCLUDED.stage,
+		error = EXCLUDED.error,
+		payload_ref = EXCLUDED.payload_ref,
+		run_id = EXCLUDED.run_id,
+		failed_at = EXCLUDED.failed_at,
+		attempts = jira_sync_failures.attempts + 1;
+	`
+	_, err = s.Exec(query, f.IssueKey, f.Stage, f.Error, f.PayloadRef, f.RunID, f.FailedAt)
+	return
+}
+
+// GetSyncFailures returns the failures recorded in the
+// `jira_sync_failures` table, ordered by issue key.
+func (s *PGStore) GetSyncFailures() (fs []SyncFailure, err error) {
+	query := `
+	SELECT issue_key, stage, error, payload_ref, run_id, failed_at, attempts
+	FROM jira_sync_failures
+	ORDER BY issue_key;
+	`
+	rows, err := s.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f SyncFailure
+		if err = rows.Scan(&f.IssueKey, &f.Stage, &f.Error, &f.PayloadRef, &f.RunID, &f.FailedAt, &f.Attempts); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, rows.Err()
+}
+
+// GetIssueStateAndEvents returns the state and events stored for
+// the specified issue key, events being sorted by time. The
+// returned state is nil if the issue is not in the store.
+//
+// Since times are stored without time zone, the returned times are
+// the stored wall clock times in UTC.
+func (s *PGStore) GetIssueStateAndEvents(k string) (is *IssueState, ies []IssueEvent, err error) {
+	query := `
+	SELECT
+		issue_created_at,
+		issue_updated_at,
+		issue_key,
+		issue_project,
+		issue_status,
+		issue_status_id,
+		issue_status_category,
+		issue_resolved_at,
+		issue_priority,
+		issue_summary,
+		issue_description,
+		issue_type,
+		issue_labels,
+		issue_reporter,
+		issue_reporter_name,
+		issue_assignee,
+		issue_assignee_name,
+		issue_developer_backend,
+		issue_developer_backend_name,
+		issue_developer_frontend,
+		issue_developer_frontend_name,
+		issue_reviewer,
+		issue_reviewer_name,
+		issue_product_owner,
+		issue_product_owner_name,
+		issue_bug_causes,
+		issue_epics,
+		issue_tribes,
+		issue_components,
+		issue_fix_versions,
+		issue_active_seconds,
+		issue_waiting_seconds,
+		issue_flow_efficiency
+	FROM jira_issues_states
+	WHERE issue_key = $1;
+	`
+	var st IssueState
+	var activeSeconds, waitingSeconds *int64
+	err = s.QueryRow(query, k).Scan(
+		&st.CreatedAt,
+		&st.UpdatedAt,
+		&st.Key,
+		&st.Project,
+		&st.Status,
+		&st.StatusID,
+		&st.StatusCategory,
+		&st.ResolvedAt,
+		&st.Priority,
+		&st.Summary,
+		&st.Description,
+		&st.Type,
+		pq.Array(&st.Labels),
+		&st.Reporter,
+		&st.ReporterName,
+		&st.Assignee,
+		&st.AssigneeName,
+		&st.DeveloperBackend,
+		&st.DeveloperBackendName,
+		&st.DeveloperFrontend,
+		&st.DeveloperFrontendName,
+		&st.Reviewer,
+		&st.ReviewerName,
+		&st.ProductOwner,
+		&st.ProductOwnerName,
+		pq.Array(&st.BugCauses),
+		pq.Array(&st.Epics),
+		pq.Array(&st.Tribes),
+		pq.Array(&st.Components),
+		pq.Array(&st.FixVersions),
+		&activeSeconds,
+		&waitingSeconds,
+		&st.FlowEfficiency,
+	)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil, nil
+	case err != nil:
+		return nil, nil, err
+	}
+	st.ActiveTime, st.WaitingTime = duration(activeSeconds), duration(waitingSeconds)
+
+	query = `
+	SELECT
+		event_time,
+		event_kind,
+		event_author,
+		event_author_name,
+		issue_key,
+		comment_body,
+		status_change_from,
+		status_change_to,
+		status_change_from_id,
+		status_change_to_id,
+		status_change_from_category,
+		status_change_to_category,
+		assignee_change_from,
+		assignee_change_to,
+		assignee_change_from_name,
+		assignee_change_to_name
+	FROM jira_issues_events
+	WHERE issue_key = $1
+	ORDER BY event_time, id;
+	`
+	rows, err := s.Query(query, k)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ie IssueEvent
+		err = rows.Scan(
+			&ie.EventTime,
+			&ie.EventKind,
+			&ie.EventAuthor,
+			&ie.EventAuthorName,
+			&ie.IssueKey,
+			&ie.CommentBody,
+			&ie.StatusChangeFrom,
+			&ie.StatusChangeTo,
+			&ie.StatusChangeFromID,
+			&ie.StatusChangeToID,
+			&ie.StatusChangeFromCategory,
+			&ie.StatusChangeToCategory,
+			&ie.AssigneeChangeFrom,
+			&ie.AssigneeChangeTo,
+			&ie.AssigneeChangeFromName,
+			&ie.AssigneeChangeToName,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		ies = append(ies, ie)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	return &st, ies, nil
+}
+
+// SampleIssueKeys returns the keys of `n` issues of the store,
+// randomly selected.
+func (s *PGStore) SampleIssueKeys(n int) (ks []string, err error) {
+	rows, err := s.Query("SELECT issue_key FROM jira_issues_states ORDER BY random() LIMIT $1;", n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k string
+		if err = rows.Scan(&k); err != nil {
+			return nil, err
+		}
+		ks = append(ks, k)
+	}
+	return ks, rows.Err()
+}
+
+// DeleteIssues deletes the state, events, status periods and sync
+// failure records of the specified issues, e.g. because they were
+// deleted or moved to another key in Jira.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) DeleteIssues(ks []string) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	for _, k := range ks {
+		if err = dropAllForIssueKey(tx, k); err != nil {
+			return
+		}
+	}
 
 	return
 }
 
+// CountIssuesByProjectAndMonth returns the number of stored issues
+// by project and month of creation, sorted by project and month.
+// Issues without project are ignored.
+func (s *PGStore) CountIssuesByProjectAndMonth() (cs []IssueCount, err error) {
+	query := `
+	SELECT issue_project, date_trunc('month', issue_created_at) AS month, COUNT(*)
+	FROM jira_issues_states
+	WHERE issue_project IS NOT NULL
+	GROUP BY issue_project, month
+	ORDER BY issue_project, month;
+	`
+	rows, err := s.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c IssueCount
+		if err = rows.Scan(&c.Project, &c.Month, &c.Count); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
+// GetIssuesUpdatedAt returns the `issue_updated_at` of the stored
+// issues of the project created in [from, to), indexed by issue key.
+func (s *PGStore) GetIssuesUpdatedAt(project string, from time.Time, to time.Time) (us map[string]time.Time, err error) {
+	query := `
+	SELECT issue_key, issue_updated_at
+	FROM jira_issues_states
+	WHERE issue_project = $1 AND issue_created_at >= $2 AND issue_created_at < $3;
+	`
+	rows, err := s.Query(query, project, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	us = make(map[string]time.Time)
+	for rows.Next() {
+		var k string
+		var u time.Time
+		if err = rows.Scan(&k, &u); err != nil {
+			return nil, err
+		}
+		us[k] = u
+	}
+	return us, rows.Err()
+}
+
+// GetIssueTimelines returns the timelines of the stored issues,
+// sorted by issue key, from the `status_changed` events of
+// `jira_issues_events` (issues without such events have no
+// statuses).
+func (s *PGStore) GetIssueTimelines() (ts []IssueTimeline, err error) {
+	query := `
+	SELECT
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_summary,
+		issue_assignee,
+		issue_created_at,
+		issue_updated_at,
+		issue_resolved_at,
+		event_kind,
+		event_time,
+		status_change_to,
+		status_change_to_category
+	FROM jira_issues_events
+	WHERE event_kind IN ('created', 'status_changed')
+	ORDER BY issue_key, event_time, id;
+	`
+	rows, err := s.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t IssueTimeline
+		var kind string
+		var e StatusEntry
+		var status *string
+		if err = rows.Scan(&t.Key, &t.Project, &t.Type, &t.Summary, &t.Assignee, &t.CreatedAt, &t.UpdatedAt, &t.ResolvedAt, &kind, &e.Time, &status, &e.Category); err != nil {
+			return nil, err
+		}
+		if len(ts) == 0 || ts[len(ts)-1].Key != t.Key {
+			ts = append(ts, t)
+		}
+		if kind == "status_changed" && status != nil {
+			e.Status = *status
+			last := &ts[len(ts)-1]
+			last.Statuses = append(last.Statuses, e)
+		}
+	}
+	return ts, rows.Err()
+}
+
+// RefreshCFDDaily recomputes the daily counts of `jira_cfd_daily`
+// from the `status_changed` events of `jira_issues_events`, for the
+// days from the day of `from` to the day of `to` (UTC).
+//
+// The last refreshed day is recomputed too, as well as the days
+// following it, so a failed refresh is caught up by the next one.
+// The whole table is rebuilt if `from` is the zero time or if the
+// table is empty. Only the counts of issues are stored: a status
+// without issues at the end of a day has no row for this day.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) RefreshCFDDaily(from time.Time, to time.Time) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if from, err = deleteDailyRowsFrom(tx, "jira_cfd_daily", from); err != nil {
+		return err
+	}
+
+	// The count of a status at the end of a day is the sum of the
+	// issues which entered it (+1) or exited it (-1) on that day and
+	// before, an issue being in the status of its last change
+	// until the next one.
+	query := `
+	WITH periods AS (
+		SELECT
+			issue_project,
+			status_change_to AS status,
+			status_change_to_category AS status_category,
+			event_time::date AS entered_on,
+			(LEAD(event_time) OVER (PARTITION BY issue_key ORDER BY event_time, id))::date AS exited_on
+		FROM jira_issues_events
+		WHERE event_kind = 'status_changed' AND status_change_to IS NOT NULL
+	),
+	deltas AS (
+		SELECT issue_project, status, status_category, day, SUM(delta) AS delta
+		FROM (
+			SELECT issue_project, status, status_category, entered_on AS day, 1 AS delta FROM periods
+			UNION ALL
+			SELECT issue_project, status, status_category, exited_on, -1 FROM periods WHERE exited_on IS NOT NULL
+		) d
+		GROUP BY issue_project, status, status_category, day
+	),
+	groups AS (
+		SELECT DISTINCT issue_project, status, status_category FROM deltas
+	),
+	days AS (
+		SELECT generate_series((SELECT MIN(day) FROM deltas), $2::date, interval '1 day')::date AS day
+	),
+	counts AS (
+		SELECT
+			days.day,
+			g.issue_project,
+			g.status,
+			g.status_category,
+			SUM(COALESCE(deltas.delta, 0)) OVER (
+				PARTITION BY g.issue_project, g.status, g.status_category ORDER BY days.day
+			) AS issue_count
+		FROM days
+		CROSS JOIN groups g
+		LEFT JOIN deltas
+			ON deltas.day = days.day
+			AND deltas.issue_project = g.issue_project
+			AND deltas.status = g.status
+			AND deltas.status_category IS NOT DISTINCT FROM g.status_category
+	)
+	INSERT INTO jira_cfd_daily (day, issue_project, status, status_category, issue_count)
+	SELECT day, issue_project, status, status_category, issue_count
+	FROM counts
+	WHERE day >= $1::date AND issue_count > 0;
+	`
+	_, err = tx.Exec(query, from, to)
+	return err
+}
+
+// RefreshFlowDaily recomputes the daily flow metrics of
+// `jira_flow_daily`, by project, type and tribe, for the days from
+// the day of `from` to the day of `to` (UTC), with the same
+// incremental semantics as `RefreshCFDDaily`.
+//
+// Arrivals and throughput are the issues created and resolved
+// during the day (from `jira_issues_states`). The WIP is the number
+// of issues whose status at the end of the day is in the
+// `InProgressCategory` (from the `status_changed` events of
+// `jira_issues_events`), and their average age is counted from their
+// first entry in this category. Issues are sliced by their current
+// project, type and tribe, an issue with several tribes being
+// counted in the slice of each. Slices without arrivals, throughput
+// or WIP during a day have no row for this day.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) RefreshFlowDaily(from time.Time, to time.Time) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if from, err = deleteDailyRowsFrom(tx, "jira_flow_daily", from); err != nil {
+		return err
+	}
+
+	query := `
+	WITH days AS (
+		SELECT generate_series(
+			GREATEST($1::date, COALESCE((SELECT MIN(issue_created_at)::date FROM jira_issues_states), $2::date)),
+			$2::date,
+			interval '1 day'
+		)::date AS day
+	),
+	changes AS (
+		SELECT
+			issue_key,
+			status_change_to_category AS category,
+			event_time AS entered_at,
+			LEAD(event_time) OVER (PARTITION BY issue_key ORDER BY event_time, id) AS exited_at
+		FROM jira_issues_events
+		WHERE event_kind = 'status_changed'
+	),
+	wip_periods AS (
+		SELECT
+			issue_key,
+			entered_at::date AS entered_on,
+			exited_at::date AS exited_on,
+			MIN(entered_at) OVER (PARTITION BY issue_key) AS started_at
+		FROM changes
+		WHERE category = $3
+	),
+	metrics AS (
+		SELECT issue_key, issue_created_at::date AS day, 1 AS arrival, 0 AS throughput, 0 AS wip, NULL::float AS age_days
+		FROM jira_issues_states
+		UNION ALL
+		SELECT issue_key, issue_resolved_at::date, 0, 1, 0, NULL
+		FROM jira_issues_states
+		WHERE issue_resolved_at IS NOT NULL
+		UNION ALL
+		SELECT w.issue_key, days.day, 0, 0, 1, (EXTRACT(EPOCH FROM (days.day + 1)::timestamp - w.started_at) / 86400)::float
+		FROM wip_periods w
+		JOIN days ON days.day >= w.entered_on AND (w.exited_on IS NULL OR days.day < w.exited_on)
+	)
+	INSERT INTO jira_flow_daily (day, issue_project, issue_type, issue_tribe, arrivals, throughput, wip, wip_average_age_days)
+	SELECT m.day, i.issue_project, i.issue_type, t.tribe, SUM(m.arrival), SUM(m.throughput), SUM(m.wip), AVG(m.age_days)
+	FROM metrics m
+	JOIN jira_issues_states i ON i.issue_key = m.issue_key
+	CROSS JOIN LATERAL unnest(CASE
+		WHEN cardinality(i.issue_tribes) > 0 THEN i.issue_tribes
+		ELSE ARRAY[NULL]::text[]
+	END) AS t(tribe)
+	WHERE m.day >= $1::date AND m.day <= $2::date
+	GROUP BY m.day, i.issue_project, i.issue_type, t.tribe;
+	`
+	_, err = tx.Exec(query, from, to, InProgressCategory)
+	return err
+}
+
+// GetFlowDaily returns the metrics of `jira_flow_daily` for the days
+// from the day of `from` to the day of `to` (UTC), sorted by day,
+// project, type and tribe.
+func (s *PGStore) GetFlowDaily(from time.Time, to time.Time) (fs []FlowCount, err error) {
+	query := `
+	SELECT day, issue_project, issue_type, issue_tribe, arrivals, throughput, wip, wip_average_age_days
+	FROM jira_flow_daily
+	WHERE day BETWEEN $1::date AND $2::date
+	ORDER BY day, issue_project, issue_type, issue_tribe;
+	`
+	rows, err := s.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f FlowCount
+		if err = rows.Scan(&f.Day, &f.Project, &f.Type, &f.Tribe, &f.Arrivals, &f.Throughput, &f.WIP, &f.WIPAverageAge); err != nil {
+			return nil, err
+		}
+		fs = append(fs, f)
+	}
+	return fs, rows.Err()
+}
+
+// GetThroughputDaily returns the numbers of issues of
+// `jira_issues_states` selected by the filter which were resolved
+// during each day from the day of `from` to the day of `to` (UTC),
+// sorted by day. Days without throughput have no row.
+//
+// Issues are counted once, whatever their number of tribes (see
+// `CountUnresolvedIssues` for the filter).
+func (s *PGStore) GetThroughputDaily(from time.Time, to time.Time, f IssueFilter) (ts []ThroughputCount, err error) {
+	query := `
+	SELECT issue_resolved_at::date AS day, COUNT(*)
+	FROM jira_issues_states
+	WHERE issue_resolved_at::date BETWEEN $1::date AND $2::date
+	AND (COALESCE(cardinality($3::text[]), 0) = 0 OR issue_project = ANY($3))
+	AND (COALESCE(cardinality($4::text[]), 0) = 0 OR issue_type = ANY($4))
+	AND (COALESCE(cardinality($5::text[]), 0) = 0 OR issue_tribes && $5::text[])
+	AND ($6 = '' OR issue_epics @> ARRAY[$6]::text[])
+	AND ($7 = '' OR issue_fix_versions @> ARRAY[$7]::text[])
+	GROUP BY day
+	ORDER BY day;
+	`
+	rows, err := s.Query(query, from, to, pq.Array(f.Projects), pq.Array(f.Types), pq.Array(f.Tribes), f.Epic, f.FixVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t ThroughputCount
+		if err = rows.Scan(&t.Day, &t.Throughput); err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+	return ts, rows.Err()
+}
+
+// GetIssueFlowEfficiencies returns the flow efficiencies of the
+// resolved issues of `jira_issues_states` which have one, ordered
+// by issue key.
+func (s *PGStore) GetIssueFlowEfficiencies() (fs []IssueFlowEfficiency, err error) {
+	query := `
+	SELECT
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_tribes,
+		issue_resolved_at,
+		issue_active_seconds,
+		issue_waiting_seconds,
+		issue_flow_efficiency
+	FROM jira_issues_states
+	WHERE issue_resolved_at IS NOT NULL
+	AND issue_flow_efficiency IS NOT NULL
+	ORDER BY issue_key;
+	`
+	rows, err := s.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var f IssueFlowEfficiency
+		var activeSeconds, waitingSeconds int64
+		if err = rows.Scan(&f.Key, &f.Project, &f.Type, pq.Array(&f.Tribes), &f.ResolvedAt, &activeSeconds, &waitingSeconds, &f.FlowEfficiency); err != nil {
+			return nil, err
+		}
+		f.ActiveTime = time.Duration(activeSeconds) * time.Second
+		f.WaitingTime = time.Duration(waitingSeconds) * time.Second
+		fs = append(fs, f)
+	}
+	return fs, rows.Err()
+}
+
+// CountUnresolvedIssues returns the number of issues of
+// `jira_issues_states` selected by the filter which are not resolved.
+//
+// Empty filter lists are sent as NULL arrays (`pq.Array` of a nil
+// slice), whose cardinality is NULL, hence the `COALESCE`.
+func (s *PGStore) CountUnresolvedIssues(f IssueFilter) (n int, err error) {
+	query := `
+	SELECT COUNT(*)
+	FROM jira_issues_states
+	WHERE issue_resolved_at IS NULL
+	AND (COALESCE(cardinality($1::text[]), 0) = 0 OR issue_project = ANY($1))
+	AND (COALESCE(cardinality($2::text[]), 0) = 0 OR issue_type = ANY($2))
+	AND (COALESCE(cardinality($3::text[]), 0) = 0 OR issue_tribes && $3::text[])
+	AND ($4 = '' OR issue_epics @> ARRAY[$4]::text[])
+	AND ($5 = '' OR issue_fix_versions @> ARRAY[$5]::text[]);
+	`
+	err = s.QueryRow(query, pq.Array(f.Projects), pq.Array(f.Types), pq.Array(f.Tribes), f.Epic, f.FixVersion).Scan(&n)
+	return n, err
+}
+
+// InsertForecasts inserts the forecasts in `jira_forecasts`.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) InsertForecasts(fs []Forecast) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	query := `
+	INSERT INTO jira_forecasts (
+		forecast_at,
+		kind,
+		issue_projects,
+		issue_types,
+		issue_tribes,
+		issue_epic,
+		issue_fix_version,
+		items,
+		until_date,
+		confidence,
+		forecast_items,
+		forecast_date
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);
+	`
+	for _, f := range fs {
+		_, err = tx.Exec(query,
+			f.ForecastAt,
+			f.Kind,
+			pq.Array(f.Filter.Projects),
+			pq.Array(f.Filter.Types),
+			pq.Array(f.Filter.Tribes),
+			nullString(f.Filter.Epic),
+			nullString(f.Filter.FixVersion),
+			f.Items,
+			f.Until,
+			f.Confidence,
+			f.ForecastItems,
+			f.ForecastDate,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceAgingIssues replaces all the records of the
+// `jira_aging_issues` table with the specified alerts, so it holds
+// the last aging report.
+//
+// The operations are performed atomically using a DB transaction.
+func (s *PGStore) ReplaceAgingIssues(as []AgingAlert) (err error) {
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		switch err {
+		case nil:
+			err = tx.Commit()
+		default:
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("DELETE FROM jira_aging_issues;"); err != nil {
+		return
+	}
+	query := `
+	INSERT INTO jira_aging_issues (
+		generated_at,
+		alert,
+		issue_key,
+		issue_project,
+		issue_type,
+		issue_summary,
+		issue_assignee,
+		status,
+		since,
+		days,
+		threshold_days
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
+	`
+	for _, a := range as {
+		_, err = tx.Exec(query,
+			a.GeneratedAt,
+			a.Alert,
+			a.IssueKey,
+			a.Project,
+			nullString(a.Type),
+			nullString(a.Summary),
+			a.Assignee,
+			a.Status,
+			a.Since,
+			a.Days,
+			a.ThresholdDays,
+		)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// nullString returns nil for an empty string, to store it as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// deleteDailyRowsFrom deletes the rows of the daily table from the
+// day to recompute, and returns this day: the day of `from`, or the
+// last day of the table if it is before, or the zero time if the
+// table is empty (so it is rebuilt).
+func deleteDailyRowsFrom(tx *sql.Tx, table string, from time.Time) (time.Time, error) {
+	var last sql.NullTime
+	if err := tx.QueryRow(`SELECT MAX(day) FROM ` + table + `;`).Scan(&last); err != nil {
+		return from, err
+	}
+	switch {
+	case !last.Valid:
+		from = time.Time{}
+	case last.Time.Before(from):
+		from = last.Time
+	}
+	_, err := tx.Exec(`DELETE FROM `+table+` WHERE day >= $1::date;`, from)
+	return from, err
+}
+
+// GetCFDDaily returns the counts of `jira_cfd_daily` for the days
+// from the day of `from` to the day of `to` (UTC), sorted by day,
+// project and status.
+func (s *PGStore) GetCFDDaily(from time.Time, to time.Time) (cs []CFDCount, err error) {
+	query := `
+	SELECT day, issue_project, status, status_category, issue_count
+	FROM jira_cfd_daily
+	WHERE day BETWEEN $1::date AND $2::date
+	ORDER BY day, issue_project, status, status_category;
+	`
+	rows, err := s.Query(query, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c CFDCount
+		if err = rows.Scan(&c.Day, &c.Project, &c.Status, &c.StatusCategory, &c.Count); err != nil {
+			return nil, err
+		}
+		cs = append(cs, c)
+	}
+	return cs, rows.Err()
+}
+
 // GetRestartFromUpdatedAt returns the `n`th value of `issue_updated_at` from
 // `jira_issues_states` in descending order.
 //
@@ -61,8 +973,10 @@ func (s *PGStore) ReplaceIssueStateAndEvents(k string, is IssueState, ies []Issu
 // several goroutines, a crash or error may have processed newer issues
 // that the one it crashed for. `n` should thus be taken at least to
 // the number of goroutines fetching issues, even better a multiple.
+//
+// The zero time is returned if the table is empty.
 func (s *PGStore) GetRestartFromUpdatedAt(n int) *time.Time {
-	var maxUpdatedAt time.Time
+	var maxUpdatedAt sql.NullTime
 	q := `
 	SELECT MIN(issue_updated_at)
 	FROM (
@@ -74,16 +988,18 @@ func (s *PGStore) GetRestartFromUpdatedAt(n int) *time.Time {
 	err := s.QueryRow(q, n).Scan(&maxUpdatedAt)
 	switch {
 	case err != nil:
-		log.Fatal(err)
+		logging.Phase(logging.PhaseStore).WithError(err).Fatal("error in `GetRestartFromUpdatedAt`")
 	default:
-		return &maxUpdatedAt
+		return &maxUpdatedAt.Time
 	}
 	return nil
 }
 
-// CreateTables creates the `jira_issues_events` and
-// `jira_issues_states` tables used by this
-// application.
+// CreateTables creates the `jira_issues_events`,
+// `jira_issues_states`, `jira_issue_status_periods`,
+// `jira_users`, `jira_statuses`, `jira_cfd_daily`,
+// `jira_flow_daily`, `jira_forecasts`, `jira_aging_issues` and
+// `jira_sync_failures` tables used by this application.
 func (s *PGStore) CreateTables() {
 	queries := []string{
 		`CREATE TABLE "jira_issues_states" (
@@ -93,74 +1009,204 @@ func (s *PGStore) CreateTables() {
 			"issue_updated_at" TIMESTAMP NOT NULL,
 			"issue_key" TEXT NOT NULL,
 			"issue_project" TEXT NOT NULL,
-			"issue_status" TEXT NOT NULL,
+			"issue_status" TEXT,
+			"issue_status_id" TEXT,
+			"issue_status_category" TEXT,
 			"issue_resolved_at" TIMESTAMP,
-			"issue_priority" TEXT NOT NULL,
+			"issue_priority" TEXT,
 			"issue_summary" TEXT NOT NULL,
 			"issue_description" TEXT,
 			"issue_type" TEXT NOT NULL,
-			"issue_labels" TEXT,
+			"issue_labels" TEXT[],
+			"issue_reporter" TEXT,
+			"issue_reporter_name" TEXT,
 			"issue_assignee" TEXT,
+			"issue_assignee_name" TEXT,
 			"issue_developer_backend" TEXT,
+			"issue_developer_backend_name" TEXT,
 			"issue_developer_frontend" TEXT,
+			"issue_developer_frontend_name" TEXT,
 			"issue_reviewer" TEXT,
+			"issue_reviewer_name" TEXT,
 			"issue_product_owner" TEXT,
-			"issue_bug_cause" TEXT,
-			"issue_epic" TEXT,
-			"issue_tribe" TEXT,
-			"issue_components" TEXT,
-			"issue_fix_versions" TEXT
+			"issue_product_owner_name" TEXT,
+			"issue_bug_causes" TEXT[],
+			"issue_epics" TEXT[],
+			"issue_tribes" TEXT[],
+			"issue_components" TEXT[],
+			"issue_fix_versions" TEXT[],
+			"issue_active_seconds" BIGINT,
+			"issue_waiting_seconds" BIGINT,
+			"issue_flow_efficiency" DOUBLE PRECISION
 		);`,
+		`CREATE INDEX "jira_issues_states_issue_labels_idx" ON "jira_issues_states" USING GIN ("issue_labels");`,
+		`CREATE INDEX "jira_issues_states_issue_components_idx" ON "jira_issues_states" USING GIN ("issue_components");`,
+		`CREATE INDEX "jira_issues_states_issue_fix_versions_idx" ON "jira_issues_states" USING GIN ("issue_fix_versions");`,
+		`CREATE INDEX "jira_issues_states_issue_bug_causes_idx" ON "jira_issues_states" USING GIN ("issue_bug_causes");`,
+		`CREATE INDEX "jira_issues_states_issue_epics_idx" ON "jira_issues_states" USING GIN ("issue_epics");`,
+		`CREATE INDEX "jira_issues_states_issue_tribes_idx" ON "jira_issues_states" USING GIN ("issue_tribes");`,
 		`CREATE TABLE "jira_issues_events" (
 			"id" serial primary key not null,
 			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
 			"event_time" TIMESTAMP NOT NULL,
 			"event_kind" TEXT NOT NULL,
 			"event_author" TEXT NOT NULL,
+			"event_author_name" TEXT,
 			"issue_created_at" TIMESTAMP NOT NULL,
 			"issue_updated_at" TIMESTAMP NOT NULL,
 			"issue_key" TEXT NOT NULL,
 			"issue_project" TEXT NOT NULL,
-			"issue_status" TEXT NOT NULL,
+			"issue_status" TEXT,
+			"issue_status_id" TEXT,
+			"issue_status_category" TEXT,
 			"issue_resolved_at" TIMESTAMP,
-			"issue_priority" TEXT NOT NULL,
+			"issue_priority" TEXT,
 			"issue_summary" TEXT NOT NULL,
 			"issue_description" TEXT,
 			"issue_type" TEXT NOT NULL,
-			"issue_labels" TEXT,
+			"issue_labels" TEXT[],
+			"issue_reporter" TEXT,
 			"issue_assignee" TEXT,
 			"issue_developer_backend" TEXT,
 			"issue_developer_frontend" TEXT,
 			"issue_reviewer" TEXT,
 			"issue_product_owner" TEXT,
-			"issue_bug_cause" TEXT,
-			"issue_epic" TEXT,
-			"issue_tribe" TEXT,
-			"issue_components" TEXT,
-			"issue_fix_versions" TEXT,
+			"issue_bug_causes" TEXT[],
+			"issue_epics" TEXT[],
+			"issue_tribes" TEXT[],
+			"issue_components" TEXT[],
+			"issue_fix_versions" TEXT[],
 			"comment_body" TEXT,
 			"status_change_from" TEXT,
 			"status_change_to" TEXT,
+			"status_change_from_id" TEXT,
+			"status_change_to_id" TEXT,
+			"status_change_from_category" TEXT,
+			"status_change_to_category" TEXT,
 			"assignee_change_from" TEXT,
-			"assignee_change_to" TEXT
+			"assignee_change_to" TEXT,
+			"assignee_change_from_name" TEXT,
+			"assignee_change_to_name" TEXT
+		);`,
+		`CREATE INDEX "jira_issues_events_issue_key_idx" ON "jira_issues_events" ("issue_key");`,
+		`CREATE TABLE "jira_issue_status_periods" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"issue_key" TEXT NOT NULL,
+			"status" TEXT NOT NULL,
+			"status_id" TEXT,
+			"status_category" TEXT,
+			"activity" TEXT,
+			"entered_at" TIMESTAMP NOT NULL,
+			"exited_at" TIMESTAMP,
+			"duration_seconds" BIGINT
+		);`,
+		`CREATE INDEX "jira_issue_status_periods_issue_key_idx" ON "jira_issue_status_periods" ("issue_key");`,
+		`CREATE TABLE "jira_users" (
+			"account_id" TEXT PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"updated_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"display_name" TEXT,
+			"email" TEXT,
+			"active" BOOLEAN NOT NULL,
+			"time_zone" TEXT
+		);`,
+		`CREATE TABLE "jira_statuses" (
+			"id" TEXT PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"name" TEXT NOT NULL,
+			"category" TEXT NOT NULL
+		);`,
+		`CREATE TABLE "jira_cfd_daily" (
+			"day" DATE NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"issue_project" TEXT NOT NULL,
+			"status" TEXT NOT NULL,
+			"status_category" TEXT,
+			"issue_count" INTEGER NOT NULL
+		);`,
+		`CREATE INDEX "jira_cfd_daily_day_idx" ON "jira_cfd_daily" ("day");`,
+		`CREATE TABLE "jira_flow_daily" (
+			"day" DATE NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"issue_project" TEXT NOT NULL,
+			"issue_type" TEXT NOT NULL,
+			"issue_tribe" TEXT,
+			"arrivals" INTEGER NOT NULL,
+			"throughput" INTEGER NOT NULL,
+			"wip" INTEGER NOT NULL,
+			"wip_average_age_days" DOUBLE PRECISION
+		);`,
+		`CREATE INDEX "jira_flow_daily_day_idx" ON "jira_flow_daily" ("day");`,
+		`CREATE TABLE "jira_forecasts" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"forecast_at" TIMESTAMP NOT NULL,
+			"kind" TEXT NOT NULL,
+			"issue_projects" TEXT[],
+			"issue_types" TEXT[],
+			"issue_tribes" TEXT[],
+			"issue_epic" TEXT,
+			"issue_fix_version" TEXT,
+			"items" INTEGER,
+			"until_date" DATE,
+			"confidence" INTEGER NOT NULL,
+			"forecast_items" INTEGER,
+			"forecast_date" DATE
+		);`,
+		`CREATE TABLE "jira_aging_issues" (
+			"id" SERIAL PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"generated_at" TIMESTAMP NOT NULL,
+			"alert" TEXT NOT NULL,
+			"issue_key" TEXT NOT NULL,
+			"issue_project" TEXT NOT NULL,
+			"issue_type" TEXT,
+			"issue_summary" TEXT,
+			"issue_assignee" TEXT,
+			"status" TEXT NOT NULL,
+			"since" TIMESTAMP NOT NULL,
+			"days" DOUBLE PRECISION NOT NULL,
+			"threshold_days" DOUBLE PRECISION
+		);`,
+		`CREATE TABLE "jira_sync_failures" (
+			"issue_key" TEXT PRIMARY KEY NOT NULL,
+			"inserted_at" TIMESTAMP(6) NOT NULL DEFAULT statement_timestamp(),
+			"stage" TEXT NOT NULL,
+			"error" TEXT NOT NULL,
+			"payload_ref" TEXT,
+			"run_id" TEXT NOT NULL,
+			"failed_at" TIMESTAMP NOT NULL,
+			"attempts" INTEGER NOT NULL
 		);`,
 	}
 	err := s.exec(queries)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `Reset`: %s", err))
+		logging.Phase(logging.PhaseStore).WithError(err).Fatal("error in `CreateTables`")
 	}
 }
 
 // DropTables drops the tables used by this source
-// (`jira_issues_events` and `jira_issues_states`)
+// (`jira_issues_events`, `jira_issues_states`,
+// `jira_issue_status_periods`, `jira_users`, `jira_statuses`,
+// `jira_cfd_daily`, `jira_flow_daily`, `jira_forecasts`,
+// `jira_aging_issues` and `jira_sync_failures`)
 func (s *PGStore) DropTables() {
 	queries := []string{
 		`DROP TABLE IF EXISTS "jira_issues_states";`,
 		`DROP TABLE IF EXISTS "jira_issues_events";`,
+		`DROP TABLE IF EXISTS "jira_issue_status_periods";`,
+		`DROP TABLE IF EXISTS "jira_users";`,
+		`DROP TABLE IF EXISTS "jira_statuses";`,
+		`DROP TABLE IF EXISTS "jira_cfd_daily";`,
+		`DROP TABLE IF EXISTS "jira_flow_daily";`,
+		`DROP TABLE IF EXISTS "jira_forecasts";`,
+		`DROP TABLE IF EXISTS "jira_aging_issues";`,
+		`DROP TABLE IF EXISTS "jira_sync_failures";`,
 	}
 	err := s.exec(queries)
 	if err != nil {
-		log.Fatalln(fmt.Errorf("error in `Drop()`: %s", err))
+		logging.Phase(logging.PhaseStore).WithError(err).Fatal("error in `DropTables`")
 	}
 }
 
@@ -184,34 +1230,44 @@ func insertIssueEvent(tx *sql.Tx, ie IssueEvent, is IssueState) (err error) {
 		event_time,
 		event_kind,
 		event_author,
+		event_author_name,
 		comment_body,
 		status_change_from,
 		status_change_to,
+		status_change_from_id,
+		status_change_to_id,
+		status_change_from_category,
+		status_change_to_category,
 		assignee_change_from,
 		assignee_change_to,
+		assignee_change_from_name,
+		assignee_change_to_name,
 		issue_key,
 		issue_created_at,
 		issue_updated_at,
 		issue_project,
 		issue_status,
+		issue_status_id,
+		issue_status_category,
 		issue_resolved_at,
 		issue_priority,
 		issue_summary,
 		issue_description,
 		issue_type,
 		issue_labels,
+		issue_reporter,
 		issue_assignee,
 		issue_developer_backend,
 		issue_developer_frontend,
 		issue_reviewer,
 		issue_product_owner,
-		issue_bug_cause,
-		issue_epic,
-		issue_tribe,
+		issue_bug_causes,
+		issue_epics,
+		issue_tribes,
 		issue_components,
 		issue_fix_versions
 	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29);
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38, $39);
 	`
 
 	_, err = tx.Exec(
@@ -219,32 +1275,42 @@ func insertIssueEvent(tx *sql.Tx, ie IssueEvent, is IssueState) (err error) {
 		ie.EventTime,
 		ie.EventKind,
 		ie.EventAuthor,
+		ie.EventAuthorName,
 		ie.CommentBody,
 		ie.StatusChangeFrom,
 		ie.StatusChangeTo,
+		ie.StatusChangeFromID,
+		ie.StatusChangeToID,
+		ie.StatusChangeFromCategory,
+		ie.StatusChangeToCategory,
 		ie.AssigneeChangeFrom,
 		ie.AssigneeChangeTo,
+		ie.AssigneeChangeFromName,
+		ie.AssigneeChangeToName,
 		ie.IssueKey,
 		is.CreatedAt,
 		is.UpdatedAt,
 		is.Project,
 		is.Status,
+		is.StatusID,
+		is.StatusCategory,
 		is.ResolvedAt,
 		is.Priority,
 		is.Summary,
 		is.Description,
 		is.Type,
-		is.Labels,
+		pq.Array(is.Labels),
+		is.Reporter,
 		is.Assignee,
 		is.DeveloperBackend,
 		is.DeveloperFrontend,
 		is.Reviewer,
 		is.ProductOwner,
-		is.BugCause,
-		is.Epic,
-		is.Tribe,
-		is.Components,
-		is.FixVersions,
+		pq.Array(is.BugCauses),
+		pq.Array(is.Epics),
+		pq.Array(is.Tribes),
+		pq.Array(is.Components),
+		pq.Array(is.FixVersions),
 	)
 	return
 }
@@ -259,24 +1325,36 @@ func insertIssueState(tx *sql.Tx, is IssueState) (err error) {
 		issue_key,
 		issue_project,
 		issue_status,
+		issue_status_id,
+		issue_status_category,
 		issue_resolved_at,
 		issue_priority,
 		issue_summary,
 		issue_description,
 		issue_type,
 		issue_labels,
+		issue_reporter,
+		issue_reporter_name,
 		issue_assignee,
+		issue_assignee_name,
 		issue_developer_backend,
+		issue_developer_backend_name,
 		issue_developer_frontend,
+		issue_developer_frontend_name,
 		issue_reviewer,
+		issue_reviewer_name,
 		issue_product_owner,
-		issue_bug_cause,
-		issue_epic,
-		issue_tribe,
+		issue_product_owner_name,
+		issue_bug_causes,
+		issue_epics,
+		issue_tribes,
 		issue_components,
-		issue_fix_versions
+		issue_fix_versions,
+		issue_active_seconds,
+		issue_waiting_seconds,
+		issue_flow_efficiency
 	)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21);
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33);
 	`
 	_, err = tx.Exec(
 		query,
@@ -285,34 +1363,155 @@ func insertIssueState(tx *sql.Tx, is IssueState) (err error) {
 		is.Key,
 		is.Project,
 		is.Status,
+		is.StatusID,
+		is.StatusCategory,
 		is.ResolvedAt,
 		is.Priority,
 		is.Summary,
 		is.Description,
 		is.Type,
-		is.Labels,
+		pq.Array(is.Labels),
+		is.Reporter,
+		is.ReporterName,
 		is.Assignee,
+		is.AssigneeName,
 		is.DeveloperBackend,
+		is.DeveloperBackendName,
 		is.DeveloperFrontend,
+		is.DeveloperFrontendName,
 		is.Reviewer,
+		is.ReviewerName,
 		is.ProductOwner,
-		is.BugCause,
-		is.Epic,
-		is.Tribe,
-		is.Components,
-		is.FixVersions,
+		is.ProductOwnerName,
+		pq.Array(is.BugCauses),
+		pq.Array(is.Epics),
+		pq.Array(is.Tribes),
+		pq.Array(is.Components),
+		pq.Array(is.FixVersions),
+		seconds(is.ActiveTime),
+		seconds(is.WaitingTime),
+		is.FlowEfficiency,
 	)
 	return
 }
 
-// dropAllForIssueKey drops all records from `jira_issues_states` and
-// `jira_issues_events` that match the specified issue key.
+// insertIssueStatusPeriods inserts the specified status periods in
+// the store within the specified transaction.
+func insertIssueStatusPeriods(tx *sql.Tx, isps []IssueStatusPeriod) (err error) {
+	for _, isp := range isps {
+		if err = insertIssueStatusPeriod(tx, isp); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+// insertIssueStatusPeriod inserts an issue status period in the store
+// through the specified transaction. The duration is stored in
+// seconds.
+func insertIssueStatusPeriod(tx *sql.Tx, isp IssueStatusPeriod) (err error) {
+	query := `
+	INSERT INTO jira_issue_status_periods (
+		issue_key,
+		status,
+		status_id,
+		status_category,
+		activity,
+		entered_at,
+		exited_at,
+		duration_seconds
+	)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
+	`
+	_, err = tx.Exec(
+		query,
+		isp.IssueKey,
+		isp.Status,
+		isp.StatusID,
+		isp.StatusCategory,
+		isp.Activity,
+		isp.EnteredAt,
+		isp.ExitedAt,
+		seconds(isp.Duration),
+	)
+	return
+}
+
+// seconds returns the duration in seconds, or nil for a nil
+// duration.
+func seconds(d *time.Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	n := int64(d.Seconds())
+	return &n
+}
+
+// duration returns the duration of `n` seconds, or nil if `n` is
+// nil.
+func duration(n *int64) *time.Duration {
+	if n == nil {
+		return nil
+	}
+	d := time.Duration(*n) * time.Second
+	return &d
+}
+
+// upsertUser inserts or updates a user in the store through the
+// specified transaction.
+func upsertUser(tx *sql.Tx, u User) (err error) {
+	query := `
+	INSERT INTO jira_users (
+		account_id,
+		display_name,
+		email,
+		active,
+		time_zone
+	)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (account_id) DO UPDATE SET
+		updated_at = statement_timestamp(),
+		display_name = EXCLUDED.display_name,
+		email = COALESCE(EXCLUDED.email, jira_users.email),
+		active = EXCLUDED.active,
+		time_zone = COALESCE(EXCLUDED.time_zone, jira_users.time_zone);
+	`
+	_, err = tx.Exec(query, u.AccountID, u.DisplayName, u.Email, u.Active, u.TimeZone)
+	return
+}
+
+// insertStatus inserts a status in the store through the specified
+// transaction.
+func insertStatus(tx *sql.Tx, st Status) (err error) {
+	query := `
+	INSERT INTO jira_statuses (
+		id,
+		name,
+		category
+	)
+	VALUES ($1, $2, $3);
+	`
+	_, err = tx.Exec(query, st.ID, st.Name, st.Category)
+	return
+}
+
+// dropAllForIssueKey drops all records from `jira_issues_states`,
+// `jira_issues_events`, `jira_issue_status_periods` and
+// `jira_sync_failures` that match the specified issue key.
 func dropAllForIssueKey(tx *sql.Tx, issueKey string) (err error) {
-	_, err = tx.Exec("DELETE FROM jira_issues_events WHERE issue_key = '" + issueKey + "';")
+	_, err = tx.Exec("DELETE FROM jira_issues_events WHERE issue_key = $1;", issueKey)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM jira_issue_status_periods WHERE issue_key = $1;", issueKey)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("DELETE FROM jira_issues_states WHERE issue_key = $1;", issueKey)
 	if err != nil {
 		return
 	}
-	_, err = tx.Exec("DELETE FROM jira_issues_states WHERE issue_key = '" + issueKey + "';")
+	_, err = tx.Exec("DELETE FROM jira_sync_failures WHERE issue_key = $1;", issueKey)
 	return
 }
 
@@ -0,0 +1,41 @@
+package store_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+// testDB opens the Postgres database of `TEST_DB_URL`, skipping the
+// test or benchmark if it is not set.
+//
+// NB: tests using it drop and create the tables of the database,
+// don't use a database with data to keep.
+func testDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+	url := os.Getenv("TEST_DB_URL")
+	if url == "" {
+		tb.Skip("TEST_DB_URL is not set")
+	}
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		tb.Fatalf("unexpected error in `Open`: %s", err)
+	}
+	return db
+}
+
+// TestPGStore_contract runs the store contract suite (see
+// `storetest.Run`) against a real Postgres database.
+func TestPGStore_contract(t *testing.T) {
+	db := testDB(t)
+	defer db.Close()
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s := store.NewPGStore(db)
+		s.DropTables()
+		s.CreateTables()
+		return s
+	})
+}
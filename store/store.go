@@ -7,51 +7,190 @@ import (
 
 // Store is an interface for the application's store
 type Store interface {
-	ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent) (err error)
+	ReplaceIssueStateAndEvents(k string, is IssueState, ies []IssueEvent, isps []IssueStatusPeriod) (err error)
+	ReplaceIssues(rs []IssueRecords) (err error)
+	ReplaceStatuses(ss []Status) (err error)
+	UpsertUsers(us []User) (err error)
+	UpsertSyncFailure(f SyncFailure) (err error)
+	GetSyncFailures() (fs []SyncFailure, err error)
+	GetIssueStateAndEvents(k string) (is *IssueState, ies []IssueEvent, err error)
+	SampleIssueKeys(n int) (ks []string, err error)
+	DeleteIssues(ks []string) (err error)
+	CountIssuesByProjectAndMonth() (cs []IssueCount, err error)
+	GetIssuesUpdatedAt(project string, from time.Time, to time.Time) (us map[string]time.Time, err error)
 	GetRestartFromUpdatedAt(n int) *time.Time
+	GetIssueTimelines() (ts []IssueTimeline, err error)
+	RefreshCFDDaily(from time.Time, to time.Time) (err error)
+	GetCFDDaily(from time.Time, to time.Time) (cs []CFDCount, err error)
+	RefreshFlowDaily(from time.Time, to time.Time) (err error)
+	GetFlowDaily(from time.Time, to time.Time) (fs []FlowCount, err error)
+	GetThroughputDaily(from time.Time, to time.Time, f IssueFilter) (ts []ThroughputCount, err error)
+	CountUnresolvedIssues(f IssueFilter) (n int, err error)
+	InsertForecasts(fs []Forecast) (err error)
+	ReplaceAgingIssues(as []AgingAlert) (err error)
+	GetIssueFlowEfficiencies() (fs []IssueFlowEfficiency, err error)
 	CreateTables()
 	DropTables()
 }
 
 // IssueState represents the state of an issue to be stored
 // in the DB.
+//
+// Multi-value fields (e.g. `Labels`, `Components`, `Tribes`) are
+// stored as arrays.
+//
+// Person fields (e.g. `Reporter`, `Assignee`) contain the
+// person's account ID (see `User`). The corresponding `...Name`
+// fields contain the person's display name, as a denormalized
+// convenience.
+//
+// `ActiveTime`, `WaitingTime` and `FlowEfficiency` are the flow
+// efficiency of the issue (see `SetFlowEfficiency`), nil if the
+// issue was in no active or waiting status.
 type IssueState struct {
-	CreatedAt         time.Time
-	UpdatedAt         time.Time
-	Key               string
-	Project           *string
-	Status            *string
-	ResolvedAt        *time.Time
-	Priority          *string
-	Summary           *string
-	Description       *string
-	Type              *string
-	Labels            *string
-	Reporter          *string
-	Assignee          *string
-	DeveloperBackend  *string
-	DeveloperFrontend *string
-	Reviewer          *string
-	ProductOwner      *string
-	BugCause          *string
-	Epic              *string
-	Tribe             *string
-	Components        *string
-	FixVersions       *string
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	Key                   string
+	Project               *string
+	Status                *string
+	StatusID              *string
+	StatusCategory        *string
+	ResolvedAt            *time.Time
+	Priority              *string
+	Summary               *string
+	Description           *string
+	Type                  *string
+	Labels                []string
+	Reporter              *string
+	ReporterName          *string
+	Assignee              *string
+	AssigneeName          *string
+	DeveloperBackend      *string
+	DeveloperBackendName  *string
+	DeveloperFrontend     *string
+	DeveloperFrontendName *string
+	Reviewer              *string
+	ReviewerName          *string
+	ProductOwner          *string
+	ProductOwnerName      *string
+	BugCauses             []string
+	Epics                 []string
+	Tribes                []string
+	Components            []string
+	FixVersions           []string
+	ActiveTime            *time.Duration
+	WaitingTime           *time.Duration
+	FlowEfficiency        *float64
+}
+
+// Activities of statuses, used to compute the flow efficiency of
+// issues. Statuses are classified as active (work is done on the
+// issue) or waiting (e.g. `Ready for Review`) by configuration (see
+// `mapping.FlowConfig`).
+const (
+	ActivityActive  = "active"
+	ActivityWaiting = "waiting"
+)
+
+// SetFlowEfficiency sets the active and waiting times of the issue,
+// the sums of the durations of its completed status periods by
+// activity (truncated to the second, as stored), and its flow
+// efficiency, the ratio of the active time to their total. They are
+// left nil if no completed period has an activity.
+func (is *IssueState) SetFlowEfficiency(isps []IssueStatusPeriod) {
+	var active, waiting time.Duration
+	classified := false
+	for _, isp := range isps {
+		if isp.Activity == nil || isp.Duration == nil {
+			continue
+		}
+		classified = true
+		switch *isp.Activity {
+		case ActivityActive:
+			active += *isp.Duration
+		case ActivityWaiting:
+			waiting += *isp.Duration
+		}
+	}
+	if !classified {
+		return
+	}
+	active, waiting = active.Truncate(time.Second), waiting.Truncate(time.Second)
+	is.ActiveTime, is.WaitingTime = &active, &waiting
+	if total := active + waiting; total > 0 {
+		e := float64(active) / float64(total)
+		is.FlowEfficiency = &e
+	}
 }
 
 // IssueEvent represents a change event on an issue to be stored
 // in the DB.
+//
+// As for `IssueState`, `EventAuthor` and the `AssigneeChange...`
+// fields contain account IDs, and the corresponding `...Name`
+// fields contain display names.
 type IssueEvent struct {
-	EventTime          time.Time
-	EventKind          string
-	EventAuthor        string
-	IssueKey           string
-	CommentBody        *string
-	StatusChangeFrom   *string
-	StatusChangeTo     *string
-	AssigneeChangeFrom *string
-	AssigneeChangeTo   *string
+	EventTime                time.Time
+	EventKind                string
+	EventAuthor              string
+	EventAuthorName          *string
+	IssueKey                 string
+	CommentBody              *string
+	StatusChangeFrom         *string
+	StatusChangeTo           *string
+	StatusChangeFromID       *string
+	StatusChangeToID         *string
+	StatusChangeFromCategory *string
+	StatusChangeToCategory   *string
+	AssigneeChangeFrom       *string
+	AssigneeChangeTo         *string
+	AssigneeChangeFromName   *string
+	AssigneeChangeToName     *string
+}
+
+// IssueStatusPeriod represents an interval of time during which
+// an issue stayed in a given status. It is derived from the
+// issue's `status_changed` events.
+//
+// `ExitedAt` and `Duration` are nil for the period of the
+// issue's current status. `Activity` is the activity of the status
+// (`ActivityActive` or `ActivityWaiting`), nil if not classified.
+type IssueStatusPeriod struct {
+	IssueKey       string
+	Status         string
+	StatusID       *string
+	StatusCategory *string
+	Activity       *string
+	EnteredAt      time.Time
+	ExitedAt       *time.Time
+	Duration       *time.Duration
+}
+
+// User represents a Jira user.
+//
+// Users are identified by their `AccountID` (Jira Cloud's
+// `accountId`). For Jira instances not providing account IDs
+// (e.g. Jira Server), the user's name is used instead.
+//
+// `Email` and `TimeZone` are nil when not visible to the
+// synchronizing user.
+type User struct {
+	AccountID   string
+	DisplayName *string
+	Email       *string
+	Active      bool
+	TimeZone    *string
+}
+
+// Status represents a Jira workflow status, as returned by the
+// `/status` endpoint of Jira API.
+//
+// `Category` is the name of the status category (e.g. "To Do",
+// "In Progress" or "Done").
+type Status struct {
+	ID       string
+	Name     string
+	Category string
 }
 
 func (ie IssueEvent) String() string {
@@ -77,6 +216,16 @@ func (ie IssueEvent) String() string {
 	return fmt.Sprintf("<IssueEvent:%s `%s` -> `%s`: time=%s author=%s issueKey=%s>", ie.EventKind, from, to, ie.EventTime, ie.EventAuthor, ie.IssueKey)
 }
 
+// IssueRecords contains all the records of an issue, as replaced
+// by `ReplaceIssues`.
+type IssueRecords struct {
+	Key           string
+	State         IssueState
+	Events        []IssueEvent
+	StatusPeriods []IssueStatusPeriod
+	Users         []User
+}
+
 // IssueEventsByTime implements sort.Interface for []IssueEvent based on
 // the EventTime field.
 type IssueEventsByTime []IssueEvent
@@ -84,3 +233,177 @@ type IssueEventsByTime []IssueEvent
 func (a IssueEventsByTime) Len() int           { return len(a) }
 func (a IssueEventsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a IssueEventsByTime) Less(i, j int) bool { return a[i].EventTime.Before(a[j].EventTime) }
+
+// Sync failure stages
+const (
+	SyncStageFetch = "fetch"
+	SyncStageMap   = "map"
+	SyncStageStore = "store"
+	// SyncStageVerify is for issues found missing or stale by the
+	// `verify` action and enqueued for re-sync.
+	SyncStageVerify = "verify"
+)
+
+// SyncFailure represents an issue which failed to be synchronized,
+// quarantined until it is synchronized successfully (e.g. with the
+// `retry-failures` action).
+//
+// `Stage` is the stage at which the sync failed (see the
+// `SyncStage...` constants). `PayloadRef` references the payload
+// which failed to be processed (the issue's API URL), if it could be
+// fetched.
+type SyncFailure struct {
+	IssueKey   string
+	Stage      string
+	Error      string
+	PayloadRef *string
+	RunID      string
+	FailedAt   time.Time
+	Attempts   int
+}
+
+// IssueTimeline is the history of the statuses of an issue, as
+// used by flow reports (see the `report` package).
+//
+// `Statuses` are the statuses entered by the issue sorted by time,
+// the first one being its status at creation.
+type IssueTimeline struct {
+	Key        string
+	Project    string
+	Type       string
+	Summary    string
+	Assignee   *string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ResolvedAt *time.Time
+	Statuses   []StatusEntry
+}
+
+// StatusEntry is the entry of an issue in a status. `Category` is
+// nil if the status category is unknown.
+type StatusEntry struct {
+	Time     time.Time
+	Status   string
+	Category *string
+}
+
+// CFDCount is the number of issues of a project in a status at the
+// end of a day (`Day` is the day at midnight, in UTC), as stored in
+// `jira_cfd_daily` to build cumulative flow diagrams.
+// `StatusCategory` is nil if the category of the status is unknown.
+type CFDCount struct {
+	Day            time.Time
+	Project        string
+	Status         string
+	StatusCategory *string
+	Count          int
+}
+
+// InProgressCategory is the status category of the issues in
+// progress, counted in the WIP of `FlowCount`.
+const InProgressCategory = "In Progress"
+
+// FlowCount holds the flow metrics of the issues of a project, type
+// and tribe during a day (`Day` is the day at midnight, in UTC), as
+// stored in `jira_flow_daily`.
+//
+// `Arrivals` and `Throughput` are the numbers of issues created and
+// resolved during the day. `WIP` is the number of issues in progress
+// at the end of the day, and `WIPAverageAge` their average age in
+// days since they were first in progress (nil without WIP).
+type FlowCount struct {
+	Day           time.Time
+	Project       string
+	Type          string
+	Tribe         *string
+	Arrivals      int
+	Throughput    int
+	WIP           int
+	WIPAverageAge *float64
+}
+
+// ThroughputCount is the number of issues resolved during a day
+// (`Day` is the day at midnight, in UTC). Unlike the throughput of `FlowCount`, an issue with several tribes
+// is counted once.
+type ThroughputCount struct {
+	Day        time.Time
+	Throughput int
+}
+
+// IssueFlowEfficiency is the flow efficiency of a resolved issue
+// (see `IssueState.SetFlowEfficiency`), as used by the flow
+// efficiency report (see the `report` package).
+type IssueFlowEfficiency struct {
+	Key            string
+	Project        string
+	Type           string
+	Tribes         []string
+	ResolvedAt     time.Time
+	ActiveTime     time.Duration
+	WaitingTime    time.Duration
+	FlowEfficiency float64
+}
+
+// IssueFilter selects issues by project, type, tribe, epic and fix
+// version. Empty criteria select any issue. An issue with several
+// tribes is selected if any of them is in `Tribes`.
+type IssueFilter struct {
+	Projects   []string
+	Types      []string
+	Tribes     []string
+	Epic       string
+	FixVersion string
+}
+
+// Forecast is a result of a delivery forecast (see the `forecast`
+// package), as stored in `jira_forecasts` for dashboards: with a
+// confidence of `Confidence` percent, `ForecastItems` issues
+// selected by `Filter` will be done by `Until` (`how-many`
+// forecasts), or `Items` issues will be done by `ForecastDate`
+// (`when` forecasts).
+type Forecast struct {
+	ForecastAt    time.Time
+	Kind          string
+	Filter        IssueFilter
+	Items         *int
+	Until         *time.Time
+	Confidence    int
+	ForecastItems *int
+	ForecastDate  *time.Time
+}
+
+// Aging alerts
+const (
+	AlertAging = "aging"
+	AlertStale = "stale"
+)
+
+// AgingAlert is an issue in progress reported by the aging report
+// (see `report.ComputeAging`), as stored in `jira_aging_issues` for
+// dashboards. An issue both aging and stale has an alert of each.
+//
+// For an `aging` alert, the issue has been in `Status` since `Since`,
+// for `Days` days, more than `ThresholdDays`. For a `stale` one, it
+// was last updated at `Since`, `Days` days ago, and `ThresholdDays`
+// is nil.
+type AgingAlert struct {
+	GeneratedAt   time.Time
+	Alert         string
+	IssueKey      string
+	Project       string
+	Type          string
+	Summary       string
+	Assignee      *string
+	Status        string
+	Since         time.Time
+	Days          float64
+	ThresholdDays *float64
+}
+
+// IssueCount is the number of issues of a project created during a
+// month (`Month` is the first day of the month, in UTC).
+type IssueCount struct {
+	Project string
+	Month   time.Time
+	Count   int
+}
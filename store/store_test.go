@@ -2,10 +2,12 @@ package store_test
 
 import (
 	"database/sql/driver"
+	"fmt"
 	"sort"
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/rchampourlier/kaizenizer-source-jira/store"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 )
@@ -25,6 +27,32 @@ func TestStore_IssueEventsByTime_sort(t *testing.T) {
 	}
 }
 
+func TestIssueState_SetFlowEfficiency(t *testing.T) {
+	period := func(activity string, d time.Duration) store.IssueStatusPeriod {
+		isp := store.IssueStatusPeriod{Duration: &d}
+		if activity != "" {
+			isp.Activity = &activity
+		}
+		return isp
+	}
+
+	var is store.IssueState
+	is.SetFlowEfficiency([]store.IssueStatusPeriod{period("", time.Hour), {Activity: stringAddr(store.ActivityActive)}})
+	if is.ActiveTime != nil || is.WaitingTime != nil || is.FlowEfficiency != nil {
+		t.Errorf("expected no flow efficiency without completed classified periods, got %+v", is)
+	}
+
+	is.SetFlowEfficiency([]store.IssueStatusPeriod{
+		period("", 10*time.Hour),
+		period(store.ActivityActive, time.Hour+500*time.Millisecond),
+		period(store.ActivityWaiting, 2*time.Hour),
+		period(store.ActivityActive, 2*time.Hour),
+	})
+	if *is.ActiveTime != 3*time.Hour || *is.WaitingTime != 2*time.Hour || *is.FlowEfficiency != 0.6 {
+		t.Errorf("unexpected flow efficiency %s, %s, %f", *is.ActiveTime, *is.WaitingTime, *is.FlowEfficiency)
+	}
+}
+
 func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -37,10 +65,16 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	mock.ExpectBegin()
 
 	// expect drop state and events
-	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = 'key'").
+	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = \\$1").WithArgs("key").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	mock.ExpectExec("DELETE FROM jira_issues_states WHERE issue_key = 'key'").
+	mock.ExpectExec("DELETE FROM jira_issue_status_periods WHERE issue_key = \\$1").WithArgs("key").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("DELETE FROM jira_issues_states WHERE issue_key = \\$1").WithArgs("key").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("DELETE FROM jira_sync_failures WHERE issue_key = \\$1").WithArgs("key").
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// expect insert state
@@ -50,59 +84,92 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 		"key",
 		"project",
 		"status",
+		"status_id",
+		"status_category",
 		anyTime{},
 		"priority",
 		"summary",
 		"description",
 		"type",
-		"labels",
+		`{"label1","label2"}`,
+		"reporter",
+		"reporter_name",
 		"assignee",
+		"assignee_name",
 		"developer_backend",
+		"developer_backend_name",
 		"developer_frontend",
+		"developer_frontend_name",
 		"reviewer",
+		"reviewer_name",
 		"product_owner",
-		"bug_cause",
-		"epic",
-		"tribe",
-		"components",
-		"fix_versions",
+		"product_owner_name",
+		`{"bug cause"}`,
+		`{"epic"}`,
+		`{"tribe 1","tribe 2"}`,
+		`{"component"}`,
+		"{}",
+		int64(5400),
+		int64(1800),
+		0.75,
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectExec("INSERT INTO jira_issues_events").WithArgs(
 		anyTime{},
 		"kind",
 		"author",
+		"author_name",
 		"comment",
 		"status_from",
 		"status_to",
+		"status_from_id",
+		"status_to_id",
+		"status_from_category",
+		"status_to_category",
 		"assignee_from",
 		"assignee_to",
+		"assignee_from_name",
+		"assignee_to_name",
 		"key",
 		anyTime{},
 		anyTime{},
 		"project",
 		"status",
+		"status_id",
+		"status_category",
 		anyTime{},
 		"priority",
 		"summary",
 		"description",
 		"type",
-		"labels",
+		`{"label1","label2"}`,
+		"reporter",
 		"assignee",
 		"developer_backend",
 		"developer_frontend",
 		"reviewer",
 		"product_owner",
-		"bug_cause",
-		"epic",
-		"tribe",
-		"components",
-		"fix_versions",
+		`{"bug cause"}`,
+		`{"epic"}`,
+		`{"tribe 1","tribe 2"}`,
+		`{"component"}`,
+		"{}",
+	).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectExec("INSERT INTO jira_issue_status_periods").WithArgs(
+		"key",
+		"status",
+		"status_id",
+		"category",
+		"active",
+		anyTime{},
+		anyTime{},
+		int64(3600),
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
 	mock.ExpectCommit()
 
-	err = s.ReplaceIssueStateAndEvents("key", mockIssueState(), []store.IssueEvent{mockIssueEvent()})
+	err = s.ReplaceIssueStateAndEvents("key", mockIssueState(), []store.IssueEvent{mockIssueEvent()}, []store.IssueStatusPeriod{mockIssueStatusPeriod()})
 	if err != nil {
 		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s\n", err)
 	}
@@ -113,6 +180,727 @@ func TestPGStore_ReplaceIssueStateAndEvents(t *testing.T) {
 	}
 }
 
+func TestPGStore_ReplaceIssues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		for _, table := range []string{"jira_issues_events", "jira_issue_status_periods", "jira_issues_states", "jira_sync_failures"} {
+			mock.ExpectExec("DELETE FROM " + table + " WHERE issue_key = \\$1").WithArgs(k).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+		mock.ExpectExec("INSERT INTO jira_issues_states").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		if k == "PJ-1" {
+			mock.ExpectExec("INSERT INTO jira_users .* ON CONFLICT \\(account_id\\) DO UPDATE").
+				WithArgs("account_id", nil, nil, true, nil).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+	}
+	mock.ExpectCommit()
+
+	err = s.ReplaceIssues([]store.IssueRecords{
+		store.IssueRecords{
+			Key:   "PJ-1",
+			State: store.IssueState{Key: "PJ-1"},
+			Users: []store.User{store.User{AccountID: "account_id", Active: true}},
+		},
+		store.IssueRecords{Key: "PJ-2", State: store.IssueState{Key: "PJ-2"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceIssues_rollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_issues_events WHERE issue_key = \\$1").WithArgs("PJ-1").
+		WillReturnError(fmt.Errorf("connection lost"))
+	mock.ExpectRollback()
+
+	err = s.ReplaceIssues([]store.IssueRecords{
+		store.IssueRecords{Key: "PJ-1", State: store.IssueState{Key: "PJ-1"}},
+	})
+	if err == nil {
+		t.Errorf("expected an error from `ReplaceIssues`")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceIssues_beginError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin().WillReturnError(fmt.Errorf("too many connections"))
+
+	err = s.ReplaceIssues([]store.IssueRecords{
+		store.IssueRecords{Key: "PJ-1", State: store.IssueState{Key: "PJ-1"}},
+	})
+	if err == nil {
+		t.Errorf("expected an error from `ReplaceIssues`")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceStatuses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_statuses").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_statuses").
+		WithArgs("1", "Open", "To Do").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_statuses").
+		WithArgs("3", "In Progress", "In Progress").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceStatuses([]store.Status{
+		store.Status{ID: "1", Name: "Open", Category: "To Do"},
+		store.Status{ID: "3", Name: "In Progress", Category: "In Progress"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceStatuses`: %s\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_UpsertUsers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO jira_users .* ON CONFLICT \\(account_id\\) DO UPDATE").
+		WithArgs("account_id", "John Doe", nil, true, "Europe/Paris").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.UpsertUsers([]store.User{
+		store.User{
+			AccountID:   "account_id",
+			DisplayName: stringAddr("John Doe"),
+			Active:      true,
+			TimeZone:    stringAddr("Europe/Paris"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `UpsertUsers`: %s\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_UpsertSyncFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	failedAt := time.Now()
+	mock.ExpectExec("INSERT INTO jira_sync_failures .* ON CONFLICT \\(issue_key\\) DO UPDATE").
+		WithArgs("PJ-1", store.SyncStageMap, "failed to parse time", "https://jira/rest/api/2/issue/1", "run", failedAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = s.UpsertSyncFailure(store.SyncFailure{
+		IssueKey:   "PJ-1",
+		Stage:      store.SyncStageMap,
+		Error:      "failed to parse time",
+		PayloadRef: stringAddr("https://jira/rest/api/2/issue/1"),
+		RunID:      "run",
+		FailedAt:   failedAt,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `UpsertSyncFailure`: %s\n", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetSyncFailures(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	failedAt := time.Now()
+	rows := sqlmock.NewRows([]string{"issue_key", "stage", "error", "payload_ref", "run_id", "failed_at", "attempts"}).
+		AddRow("PJ-1", store.SyncStageFetch, "timeout", nil, "run", failedAt, 2)
+	mock.ExpectQuery("SELECT .* FROM jira_sync_failures").WillReturnRows(rows)
+
+	fs, err := s.GetSyncFailures()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetSyncFailures`: %s\n", err)
+	}
+	expected := []store.SyncFailure{
+		store.SyncFailure{IssueKey: "PJ-1", Stage: store.SyncStageFetch, Error: "timeout", RunID: "run", FailedAt: failedAt, Attempts: 2},
+	}
+	if len(fs) != 1 || fs[0] != expected[0] {
+		t.Errorf("unexpected result `%v`, expected `%v`\n", fs, expected)
+	}
+}
+
+func TestPGStore_GetIssueStateAndEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	createdAt := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	stateColumns := []string{
+		"issue_created_at", "issue_updated_at", "issue_key", "issue_project",
+		"issue_status", "issue_status_id", "issue_status_category",
+		"issue_resolved_at", "issue_priority", "issue_summary",
+		"issue_description", "issue_type", "issue_labels", "issue_reporter",
+		"issue_reporter_name", "issue_assignee", "issue_assignee_name",
+		"issue_developer_backend", "issue_developer_backend_name",
+		"issue_developer_frontend", "issue_developer_frontend_name",
+		"issue_reviewer", "issue_reviewer_name", "issue_product_owner",
+		"issue_product_owner_name", "issue_bug_causes", "issue_epics",
+		"issue_tribes", "issue_components", "issue_fix_versions",
+		"issue_active_seconds", "issue_waiting_seconds", "issue_flow_efficiency",
+	}
+	stateRows := sqlmock.NewRows(stateColumns).AddRow(
+		createdAt, createdAt, "PJ-1", "Project",
+		"Done", "3", "Done",
+		nil, "High", "Summary",
+		nil, "Bug", []byte("{a,b}"), "reporter",
+		nil, nil, nil,
+		nil, nil,
+		nil, nil,
+		nil, nil, nil,
+		nil, nil, []byte("{PJ-0}"),
+		[]byte("{Data,Growth}"), []byte("{}"), []byte("{1.0}"),
+		int64(5400), int64(1800), 0.75,
+	)
+	mock.ExpectQuery("SELECT .* FROM jira_issues_states WHERE issue_key = \\$1").
+		WithArgs("PJ-1").
+		WillReturnRows(stateRows)
+	eventColumns := []string{
+		"event_time", "event_kind", "event_author", "event_author_name",
+		"issue_key", "comment_body", "status_change_from", "status_change_to",
+		"status_change_from_id", "status_change_to_id",
+		"status_change_from_category", "status_change_to_category",
+		"assignee_change_from", "assignee_change_to",
+		"assignee_change_from_name", "assignee_change_to_name",
+	}
+	eventRows := sqlmock.NewRows(eventColumns).AddRow(
+		createdAt, "created", "reporter", nil,
+		"PJ-1", nil, nil, nil,
+		nil, nil,
+		nil, nil,
+		nil, nil,
+		nil, nil,
+	)
+	mock.ExpectQuery("SELECT .* FROM jira_issues_events WHERE issue_key = \\$1").
+		WithArgs("PJ-1").
+		WillReturnRows(eventRows)
+
+	is, ies, err := s.GetIssueStateAndEvents("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueStateAndEvents`: %s\n", err)
+	}
+	if is == nil || is.Key != "PJ-1" || *is.Priority != "High" || is.Description != nil {
+		t.Errorf("unexpected state `%v`\n", is)
+	}
+	if len(is.Labels) != 2 || len(is.Components) != 0 || len(is.FixVersions) != 1 ||
+		is.BugCauses != nil || len(is.Epics) != 1 || len(is.Tribes) != 2 {
+		t.Errorf("unexpected arrays in state `%v`\n", is)
+	}
+	if is.ActiveTime == nil || *is.ActiveTime != 90*time.Minute || is.WaitingTime == nil || *is.WaitingTime != 30*time.Minute ||
+		is.FlowEfficiency == nil || *is.FlowEfficiency != 0.75 {
+		t.Errorf("unexpected flow efficiency in state `%v`\n", is)
+	}
+	if len(ies) != 1 || ies[0].EventKind != "created" || ies[0].EventAuthor != "reporter" {
+		t.Errorf("unexpected events `%v`\n", ies)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expections: %s", err)
+	}
+}
+
+func TestPGStore_GetIssueStateAndEvents_missing(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectQuery("SELECT .* FROM jira_issues_states").
+		WithArgs("PJ-1").
+		WillReturnRows(sqlmock.NewRows([]string{"issue_created_at"}))
+
+	is, ies, err := s.GetIssueStateAndEvents("PJ-1")
+	if err != nil || is != nil || ies != nil {
+		t.Errorf("unexpected result `%v`, `%v`, `%v`\n", is, ies, err)
+	}
+}
+
+func TestPGStore_SampleIssueKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	rows := sqlmock.NewRows([]string{"issue_key"}).AddRow("PJ-1").AddRow("PJ-2")
+	mock.ExpectQuery("SELECT issue_key FROM jira_issues_states ORDER BY random\\(\\) LIMIT \\$1").
+		WithArgs(2).
+		WillReturnRows(rows)
+
+	ks, err := s.SampleIssueKeys(2)
+	if err != nil {
+		t.Fatalf("unexpected error in `SampleIssueKeys`: %s\n", err)
+	}
+	if len(ks) != 2 || ks[0] != "PJ-1" || ks[1] != "PJ-2" {
+		t.Errorf("unexpected result `%v`\n", ks)
+	}
+}
+
+func TestPGStore_DeleteIssues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	mock.ExpectBegin()
+	for _, k := range []string{"PJ-1", "PJ-2"} {
+		for _, table := range []string{"jira_issues_events", "jira_issue_status_periods", "jira_issues_states", "jira_sync_failures"} {
+			mock.ExpectExec("DELETE FROM " + table + " WHERE issue_key = \\$1").WithArgs(k).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+		}
+	}
+	mock.ExpectCommit()
+
+	if err := s.DeleteIssues([]string{"PJ-1", "PJ-2"}); err != nil {
+		t.Fatalf("unexpected error in `DeleteIssues`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_CountIssuesByProjectAndMonth(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	month := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"issue_project", "month", "count"}).
+		AddRow("Project", month, 3)
+	mock.ExpectQuery("SELECT issue_project, date_trunc\\('month', issue_created_at\\) AS month, COUNT\\(\\*\\) FROM jira_issues_states").
+		WillReturnRows(rows)
+
+	cs, err := s.CountIssuesByProjectAndMonth()
+	if err != nil {
+		t.Fatalf("unexpected error in `CountIssuesByProjectAndMonth`: %s\n", err)
+	}
+	expected := store.IssueCount{Project: "Project", Month: month, Count: 3}
+	if len(cs) != 1 || cs[0] != expected {
+		t.Errorf("unexpected result `%v`, expected `%v`\n", cs, expected)
+	}
+}
+
+func TestPGStore_GetIssuesUpdatedAt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	updatedAt := time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"issue_key", "issue_updated_at"}).
+		AddRow("PJ-1", updatedAt)
+	mock.ExpectQuery("SELECT issue_key, issue_updated_at FROM jira_issues_states").
+		WithArgs("Project", from, to).
+		WillReturnRows(rows)
+
+	us, err := s.GetIssuesUpdatedAt("Project", from, to)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssuesUpdatedAt`: %s\n", err)
+	}
+	if len(us) != 1 || us["PJ-1"] != updatedAt {
+		t.Errorf("unexpected result `%v`\n", us)
+	}
+}
+
+func TestPGStore_GetIssueTimelines(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	created := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	resolved := created.Add(48 * time.Hour)
+	rows := sqlmock.NewRows([]string{"issue_key", "issue_project", "issue_type", "issue_summary", "issue_assignee",
+		"issue_created_at", "issue_updated_at", "issue_resolved_at",
+		"event_kind", "event_time", "status_change_to", "status_change_to_category"}).
+		AddRow("PJ-1", "PJ", "Story", "Summary", "bob", created, resolved, resolved, "created", created, nil, nil).
+		AddRow("PJ-1", "PJ", "Story", "Summary", "bob", created, resolved, resolved, "status_changed", created, "Open", "To Do").
+		AddRow("PJ-1", "PJ", "Story", "Summary", "bob", created, resolved, resolved, "status_changed", resolved, "Closed", nil).
+		AddRow("PJ-2", "PJ", "Bug", "Other", nil, created, created, nil, "created", created, nil, nil)
+	mock.ExpectQuery("SELECT (.+) FROM jira_issues_events WHERE event_kind IN \\('created', 'status_changed'\\) ORDER BY issue_key, event_time, id").
+		WillReturnRows(rows)
+
+	ts, err := s.GetIssueTimelines()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueTimelines`: %s\n", err)
+	}
+	if len(ts) != 2 || ts[0].Key != "PJ-1" || ts[1].Key != "PJ-2" {
+		t.Fatalf("unexpected timelines `%v`\n", ts)
+	}
+	if ts[0].ResolvedAt == nil || !ts[0].ResolvedAt.Equal(resolved) || ts[1].ResolvedAt != nil || ts[1].Type != "Bug" {
+		t.Errorf("unexpected timelines `%v`\n", ts)
+	}
+	if ts[0].Summary != "Summary" || ts[0].Assignee == nil || *ts[0].Assignee != "bob" || !ts[0].UpdatedAt.Equal(resolved) || ts[1].Assignee != nil {
+		t.Errorf("unexpected timelines `%v`\n", ts)
+	}
+	ses := ts[0].Statuses
+	if len(ses) != 2 || ses[0].Status != "Open" || *ses[0].Category != "To Do" || ses[1].Status != "Closed" || ses[1].Category != nil || !ses[1].Time.Equal(resolved) {
+		t.Errorf("unexpected statuses `%v`\n", ses)
+	}
+	if len(ts[1].Statuses) != 0 {
+		t.Errorf("expected no statuses, got `%v`\n", ts[1].Statuses)
+	}
+}
+
+func TestPGStore_RefreshCFDDaily(t *testing.T) {
+	from := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 3, 12, 9, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name         string
+		last         interface{}
+		expectedFrom time.Time
+	}{
+		{"incremental", time.Date(2020, 3, 11, 0, 0, 0, 0, time.UTC), from},
+		{"catch up", time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"empty table", nil, time.Time{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			s := store.NewPGStore(db)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT MAX\\(day\\) FROM jira_cfd_daily").
+				WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(tc.last))
+			mock.ExpectExec("DELETE FROM jira_cfd_daily WHERE day >= \\$1::date").
+				WithArgs(tc.expectedFrom).
+				WillReturnResult(sqlmock.NewResult(0, 10))
+			mock.ExpectExec("WITH periods AS (.+) INSERT INTO jira_cfd_daily").
+				WithArgs(tc.expectedFrom, to).
+				WillReturnResult(sqlmock.NewResult(0, 10))
+			mock.ExpectCommit()
+
+			if err := s.RefreshCFDDaily(from, to); err != nil {
+				t.Fatalf("unexpected error in `RefreshCFDDaily`: %s\n", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestPGStore_GetCFDDaily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	day := time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"day", "issue_project", "status", "status_category", "issue_count"}).
+		AddRow(day, "PJ", "Open", "To Do", 3).
+		AddRow(day, "PJ", "Unknown", nil, 1)
+	mock.ExpectQuery("SELECT day, issue_project, status, status_category, issue_count FROM jira_cfd_daily").
+		WithArgs(day, day).
+		WillReturnRows(rows)
+
+	cs, err := s.GetCFDDaily(day, day)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetCFDDaily`: %s\n", err)
+	}
+	if len(cs) != 2 || cs[0].Count != 3 || *cs[0].StatusCategory != "To Do" || cs[1].StatusCategory != nil {
+		t.Errorf("unexpected result `%v`\n", cs)
+	}
+}
+
+func TestPGStore_RefreshFlowDaily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	from := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 3, 12, 9, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT MAX\\(day\\) FROM jira_flow_daily").
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(time.Date(2020, 3, 11, 0, 0, 0, 0, time.UTC)))
+	mock.ExpectExec("DELETE FROM jira_flow_daily WHERE day >= \\$1::date").
+		WithArgs(from).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("WITH days AS (.+) INSERT INTO jira_flow_daily").
+		WithArgs(from, to, store.InProgressCategory).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectCommit()
+
+	if err := s.RefreshFlowDaily(from, to); err != nil {
+		t.Fatalf("unexpected error in `RefreshFlowDaily`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetFlowDaily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	day := time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"day", "issue_project", "issue_type", "issue_tribe", "arrivals", "throughput", "wip", "wip_average_age_days"}).
+		AddRow(day, "PJ", "Story", "Data", 2, 1, 3, 4.5).
+		AddRow(day, "PJ", "Bug", nil, 1, 0, 0, nil)
+	mock.ExpectQuery("SELECT day, issue_project, issue_type, issue_tribe, arrivals, throughput, wip, wip_average_age_days FROM jira_flow_daily").
+		WithArgs(day, day).
+		WillReturnRows(rows)
+
+	fs, err := s.GetFlowDaily(day, day)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetFlowDaily`: %s\n", err)
+	}
+	if len(fs) != 2 || *fs[0].Tribe != "Data" || fs[0].WIP != 3 || *fs[0].WIPAverageAge != 4.5 || fs[1].Tribe != nil || fs[1].WIPAverageAge != nil {
+		t.Errorf("unexpected result `%v`\n", fs)
+	}
+}
+
+func TestPGStore_GetThroughputDaily(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	from, to := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 3, 10, 0, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"day", "count"}).
+		AddRow(from, 2).
+		AddRow(to, 1)
+	// Counted from `jira_issues_states`, not from the rows of
+	// `jira_flow_daily` (one per tribe)
+	mock.ExpectQuery("SELECT issue_resolved_at::date AS day, COUNT\\(\\*\\) FROM jira_issues_states "+
+		"WHERE issue_resolved_at::date BETWEEN \\$1::date AND \\$2::date "+
+		"AND \\(COALESCE\\(cardinality\\(\\$3::text\\[\\]\\), 0\\) = 0 OR issue_project = ANY\\(\\$3\\)\\) .* GROUP BY day").
+		WithArgs(from, to, pq.Array([]string{"PJ"}), pq.Array([]string(nil)), pq.Array([]string{"Data"}), "", "").
+		WillReturnRows(rows)
+
+	ts, err := s.GetThroughputDaily(from, to, store.IssueFilter{Projects: []string{"PJ"}, Tribes: []string{"Data"}})
+	if err != nil {
+		t.Fatalf("unexpected error in `GetThroughputDaily`: %s\n", err)
+	}
+	if len(ts) != 2 || !ts[0].Day.Equal(from) || ts[0].Throughput != 2 || ts[1].Throughput != 1 {
+		t.Errorf("unexpected result `%v`\n", ts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_GetIssueFlowEfficiencies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	resolved := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"issue_key", "issue_project", "issue_type", "issue_tribes", "issue_resolved_at",
+		"issue_active_seconds", "issue_waiting_seconds", "issue_flow_efficiency"}).
+		AddRow("PJ-1", "PJ", "Story", []byte("{Data,Growth}"), resolved, int64(3600), int64(10800), 0.25).
+		AddRow("PJ-2", "PJ", "Bug", nil, resolved, int64(0), int64(0), 0.0)
+	mock.ExpectQuery("SELECT (.+) FROM jira_issues_states WHERE issue_resolved_at IS NOT NULL AND issue_flow_efficiency IS NOT NULL ORDER BY issue_key").
+		WillReturnRows(rows)
+
+	fs, err := s.GetIssueFlowEfficiencies()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueFlowEfficiencies`: %s\n", err)
+	}
+	if len(fs) != 2 {
+		t.Fatalf("unexpected flow efficiencies `%v`\n", fs)
+	}
+	if f := fs[0]; f.Key != "PJ-1" || len(f.Tribes) != 2 || f.Tribes[1] != "Growth" || f.ActiveTime != time.Hour || f.WaitingTime != 3*time.Hour || f.FlowEfficiency != 0.25 {
+		t.Errorf("unexpected flow efficiency `%v`\n", f)
+	}
+	if fs[1].Tribes != nil {
+		t.Errorf("expected no tribe, got `%v`\n", fs[1])
+	}
+}
+
+func TestPGStore_CountUnresolvedIssues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	// The nil types and tribes are sent as NULL arrays, which must
+	// not filter out all issues
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM jira_issues_states WHERE issue_resolved_at IS NULL "+
+		"AND \\(COALESCE\\(cardinality\\(\\$1::text\\[\\]\\), 0\\) = 0 OR issue_project = ANY\\(\\$1\\)\\) "+
+		"AND \\(COALESCE\\(cardinality\\(\\$2::text\\[\\]\\), 0\\) = 0 OR issue_type = ANY\\(\\$2\\)\\) "+
+		"AND \\(COALESCE\\(cardinality\\(\\$3::text\\[\\]\\), 0\\) = 0 OR issue_tribes && \\$3::text\\[\\]\\) "+
+		".* issue_fix_versions @> ARRAY\\[\\$5\\]::text\\[\\]").
+		WithArgs(pq.Array([]string{"PJ"}), pq.Array([]string(nil)), pq.Array([]string(nil)), "PJ-10", "").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+
+	n, err := s.CountUnresolvedIssues(store.IssueFilter{Projects: []string{"PJ"}, Epic: "PJ-10"})
+	if err != nil {
+		t.Fatalf("unexpected error in `CountUnresolvedIssues`: %s\n", err)
+	}
+	if n != 7 {
+		t.Errorf("unexpected result %d, expected 7\n", n)
+	}
+}
+
+func TestPGStore_InsertForecasts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	at := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	until := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	items := 12
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO jira_forecasts").
+		WithArgs(at, "how-many", pq.Array([]string{"PJ"}), pq.Array([]string(nil)), pq.Array([]string{"Data"}), nil, "1.0",
+			nil, &until, 85, &items, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.InsertForecasts([]store.Forecast{{
+		ForecastAt:    at,
+		Kind:          "how-many",
+		Filter:        store.IssueFilter{Projects: []string{"PJ"}, Tribes: []string{"Data"}, FixVersion: "1.0"},
+		Until:         &until,
+		Confidence:    85,
+		ForecastItems: &items,
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error in `InsertForecasts`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPGStore_ReplaceAgingIssues(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	s := store.NewPGStore(db)
+
+	at := time.Date(2020, 3, 10, 9, 0, 0, 0, time.UTC)
+	since := time.Date(2020, 3, 5, 9, 0, 0, 0, time.UTC)
+	threshold := 4.0
+	assignee := "bob"
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM jira_aging_issues").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_aging_issues").
+		WithArgs(at, store.AlertAging, "PJ-5", "PJ", "Story", "Aging", &assignee, "Doing", since, 5.0, &threshold).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO jira_aging_issues").
+		WithArgs(at, store.AlertStale, "PJ-6", "PJ", nil, nil, nil, "Doing", since, 5.0, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = s.ReplaceAgingIssues([]store.AgingAlert{
+		{GeneratedAt: at, Alert: store.AlertAging, IssueKey: "PJ-5", Project: "PJ", Type: "Story", Summary: "Aging",
+			Assignee: &assignee, Status: "Doing", Since: since, Days: 5, ThresholdDays: &threshold},
+		{GeneratedAt: at, Alert: store.AlertStale, IssueKey: "PJ-6", Project: "PJ", Status: "Doing", Since: since, Days: 5},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error in `ReplaceAgingIssues`: %s\n", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestPGStore_GetRestartFromUpdatedAt(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -135,6 +923,27 @@ func TestPGStore_GetRestartFromUpdatedAt(t *testing.T) {
 	}
 }
 
+func TestPGStore_GetRestartFromUpdatedAt_empty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	s := store.NewPGStore(db)
+
+	rows := sqlmock.NewRows([]string{"max"}).
+		AddRow(nil)
+
+	mock.ExpectQuery("SELECT MIN\\(issue_updated_at\\)").
+		WillReturnRows(rows)
+
+	r := s.GetRestartFromUpdatedAt(10)
+	if r == nil || !r.IsZero() {
+		t.Errorf("unexpected result `%v`, expected the zero time\n", r)
+	}
+}
+
 func TestPGStore_CreateTables(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -144,8 +953,44 @@ func TestPGStore_CreateTables(t *testing.T) {
 
 	mock.ExpectExec("CREATE TABLE \"jira_issues_states\"").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_labels_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_components_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_fix_versions_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_bug_causes_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_epics_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_states_issue_tribes_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("CREATE TABLE \"jira_issues_events\"").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issues_events_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_issue_status_periods\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_issue_status_periods_issue_key_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_users\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_statuses\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_cfd_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_cfd_daily_day_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_flow_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE INDEX \"jira_flow_daily_day_idx\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_forecasts\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_aging_issues\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("CREATE TABLE \"jira_sync_failures\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	s := store.NewPGStore(db)
 	s.CreateTables()
@@ -162,49 +1007,98 @@ func TestPGStore_Drop(t *testing.T) {
 		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issues_events\"").
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_issue_status_periods\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_users\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_statuses\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_cfd_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_flow_daily\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_forecasts\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_aging_issues\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DROP TABLE IF EXISTS \"jira_sync_failures\"").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	s := store.NewPGStore(db)
 	s.DropTables()
 }
 
 func mockIssueState() store.IssueState {
+	active, waiting, efficiency := 90*time.Minute, 30*time.Minute, 0.75
 	return store.IssueState{
-		CreatedAt:         time.Now(),
-		UpdatedAt:         time.Now(),
-		Key:               "key",
-		Project:           stringAddr("project"),
-		Status:            stringAddr("status"),
-		ResolvedAt:        timeAddr(time.Now()),
-		Priority:          stringAddr("priority"),
-		Summary:           stringAddr("summary"),
-		Description:       stringAddr("description"),
-		Type:              stringAddr("type"),
-		Labels:            stringAddr("labels"),
-		Reporter:          stringAddr("reporter"),
-		Assignee:          stringAddr("assignee"),
-		DeveloperBackend:  stringAddr("developer_backend"),
-		DeveloperFrontend: stringAddr("developer_frontend"),
-		Reviewer:          stringAddr("reviewer"),
-		ProductOwner:      stringAddr("product_owner"),
-		BugCause:          stringAddr("bug_cause"),
-		Epic:              stringAddr("epic"),
-		Tribe:             stringAddr("tribe"),
-		Components:        stringAddr("components"),
-		FixVersions:       stringAddr("fix_versions"),
+		CreatedAt:             time.Now(),
+		UpdatedAt:             time.Now(),
+		Key:                   "key",
+		Project:               stringAddr("project"),
+		Status:                stringAddr("status"),
+		StatusID:              stringAddr("status_id"),
+		StatusCategory:        stringAddr("status_category"),
+		ResolvedAt:            timeAddr(time.Now()),
+		Priority:              stringAddr("priority"),
+		Summary:               stringAddr("summary"),
+		Description:           stringAddr("description"),
+		Type:                  stringAddr("type"),
+		Labels:                []string{"label1", "label2"},
+		Reporter:              stringAddr("reporter"),
+		ReporterName:          stringAddr("reporter_name"),
+		Assignee:              stringAddr("assignee"),
+		AssigneeName:          stringAddr("assignee_name"),
+		DeveloperBackend:      stringAddr("developer_backend"),
+		DeveloperBackendName:  stringAddr("developer_backend_name"),
+		DeveloperFrontend:     stringAddr("developer_frontend"),
+		DeveloperFrontendName: stringAddr("developer_frontend_name"),
+		Reviewer:              stringAddr("reviewer"),
+		ReviewerName:          stringAddr("reviewer_name"),
+		ProductOwner:          stringAddr("product_owner"),
+		ProductOwnerName:      stringAddr("product_owner_name"),
+		BugCauses:             []string{"bug cause"},
+		Epics:                 []string{"epic"},
+		Tribes:                []string{"tribe 1", "tribe 2"},
+		Components:            []string{"component"},
+		FixVersions:           []string{},
+		ActiveTime:            &active,
+		WaitingTime:           &waiting,
+		FlowEfficiency:        &efficiency,
 	}
 }
 
 func mockIssueEvent() store.IssueEvent {
 	return store.IssueEvent{
-		EventTime:          time.Now(),
-		EventKind:          "kind",
-		EventAuthor:        "author",
-		IssueKey:           "key",
-		CommentBody:        stringAddr("comment"),
-		StatusChangeFrom:   stringAddr("status_from"),
-		StatusChangeTo:     stringAddr("status_to"),
-		AssigneeChangeFrom: stringAddr("assignee_from"),
-		AssigneeChangeTo:   stringAddr("assignee_to"),
+		EventTime:                time.Now(),
+		EventKind:                "kind",
+		EventAuthor:              "author",
+		EventAuthorName:          stringAddr("author_name"),
+		IssueKey:                 "key",
+		CommentBody:              stringAddr("comment"),
+		StatusChangeFrom:         stringAddr("status_from"),
+		StatusChangeTo:           stringAddr("status_to"),
+		StatusChangeFromID:       stringAddr("status_from_id"),
+		StatusChangeToID:         stringAddr("status_to_id"),
+		StatusChangeFromCategory: stringAddr("status_from_category"),
+		StatusChangeToCategory:   stringAddr("status_to_category"),
+		AssigneeChangeFrom:       stringAddr("assignee_from"),
+		AssigneeChangeTo:         stringAddr("assignee_to"),
+		AssigneeChangeFromName:   stringAddr("assignee_from_name"),
+		AssigneeChangeToName:     stringAddr("assignee_to_name"),
+	}
+}
+
+func mockIssueStatusPeriod() store.IssueStatusPeriod {
+	duration := time.Hour
+	return store.IssueStatusPeriod{
+		IssueKey:       "key",
+		Status:         "status",
+		StatusID:       stringAddr("status_id"),
+		StatusCategory: stringAddr("category"),
+		Activity:       stringAddr("active"),
+		EnteredAt:      time.Now().Add(-duration),
+		ExitedAt:       timeAddr(time.Now()),
+		Duration:       &duration,
 	}
 }
 
@@ -0,0 +1,887 @@
+package storetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/expect"
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// FakeStore is a programmable fake of `store.Store`. Its responses
+// are set with expectations (e.g. `ExpectGetSyncFailures()`), which
+// are unordered unless marked with `InOrder` (see the `expect`
+// package).
+//
+// The records received by `ReplaceIssueStateAndEvents` and
+// `ReplaceIssues` are checked against the `With...` arguments of the
+// expectations. Unexpected calls and mismatching arguments are
+// reported as errors of the test, and expectations which were not
+// met are reported when the test completes.
+//
+// To check the records written by a sync rather than the calls, use
+// `MemoryStore`.
+type FakeStore struct {
+	t testing.TB
+	*expect.Set
+}
+
+// NewFakeStore returns a `FakeStore` without expectations,
+// reporting to `t`.
+func NewFakeStore(t testing.TB) *FakeStore {
+	return &FakeStore{t: t, Set: expect.NewSet(t)}
+}
+
+// ReplaceIssueStateAndEvents checks the records against the
+// expectation for the issue key, and returns its error.
+func (s *FakeStore) ReplaceIssueStateAndEvents(k string, is store.IssueState, ies []store.IssueEvent, isps []store.IssueStatusPeriod) error {
+	e, _ := s.Call("ReplaceIssueStateAndEvents", k).(*ExpectedReplaceIssueStateAndEvents)
+	if e == nil {
+		return expect.Unexpected("ReplaceIssueStateAndEvents")
+	}
+	e.check(s.t, is, ies, isps)
+	return e.err
+}
+
+// ReplaceIssues checks the records of each issue as
+// `ReplaceIssueStateAndEvents` does.
+//
+// As the store writes a batch atomically, if the expectation of any
+// issue of a batch returns an error, no expectation is met and an
+// error is returned, so the issues can be written one by one.
+func (s *FakeStore) ReplaceIssues(rs []store.IssueRecords) error {
+	if len(rs) > 1 {
+		for _, r := range rs {
+			if e, _ := s.Peek("ReplaceIssueStateAndEvents", r.Key).(*ExpectedReplaceIssueStateAndEvents); e != nil && e.err != nil {
+				return fmt.Errorf("batch failed on issue `%s`: %s", r.Key, e.err)
+			}
+		}
+	}
+	for _, r := range rs {
+		if err := s.ReplaceIssueStateAndEvents(r.Key, r.State, r.Events, r.StatusPeriods); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceStatuses checks the statuses against the expectation and
+// returns its error.
+func (s *FakeStore) ReplaceStatuses(ss []store.Status) error {
+	e, _ := s.Call("ReplaceStatuses", ss).(*ExpectedReplaceStatuses)
+	if e == nil {
+		return expect.Unexpected("ReplaceStatuses")
+	}
+	if len(ss) != len(e.statuses) {
+		s.t.Errorf("`ReplaceStatuses` received %d statuses but %d were expected (%v)", len(ss), len(e.statuses), ss)
+		return e.err
+	}
+	for n := range ss {
+		if ss[n] != e.statuses[n] {
+			s.t.Errorf("`ReplaceStatuses` received status[%d]=`%v` but `%v` was expected", n, ss[n], e.statuses[n])
+		}
+	}
+	return e.err
+}
+
+// UpsertUsers returns the error of the expectation.
+func (s *FakeStore) UpsertUsers(us []store.User) error {
+	e, _ := s.Call("UpsertUsers", us).(*ExpectedError)
+	if e == nil {
+		return expect.Unexpected("UpsertUsers")
+	}
+	return e.err
+}
+
+// UpsertSyncFailure checks the failure against the expectation for
+// the issue key, and returns its error.
+func (s *FakeStore) UpsertSyncFailure(f store.SyncFailure) error {
+	e, _ := s.Call("UpsertSyncFailure", f.IssueKey, f.Stage).(*ExpectedUpsertSyncFailure)
+	if e == nil {
+		return expect.Unexpected("UpsertSyncFailure")
+	}
+	if f.Error == "" {
+		s.t.Errorf("`UpsertSyncFailure` received a failure without error for `%s`", f.IssueKey)
+	}
+	return e.err
+}
+
+// GetSyncFailures returns the failures of the expectation.
+func (s *FakeStore) GetSyncFailures() ([]store.SyncFailure, error) {
+	e, _ := s.Call("GetSyncFailures").(*ExpectedGetSyncFailures)
+	if e == nil {
+		return nil, expect.Unexpected("GetSyncFailures")
+	}
+	return e.failures, e.err
+}
+
+// GetIssueStateAndEvents returns the state and events of the
+// expectation for the issue key.
+func (s *FakeStore) GetIssueStateAndEvents(k string) (*store.IssueState, []store.IssueEvent, error) {
+	e, _ := s.Call("GetIssueStateAndEvents", k).(*ExpectedGetIssueStateAndEvents)
+	if e == nil {
+		return nil, nil, expect.Unexpected("GetIssueStateAndEvents")
+	}
+	return e.state, e.events, nil
+}
+
+// SampleIssueKeys returns the keys of the expectation.
+func (s *FakeStore) SampleIssueKeys(n int) ([]string, error) {
+	e, _ := s.Call("SampleIssueKeys", n).(*ExpectedSampleIssueKeys)
+	if e == nil {
+		return nil, expect.Unexpected("SampleIssueKeys")
+	}
+	return e.issueKeys, nil
+}
+
+// DeleteIssues checks the keys against the expectation and returns
+// its error.
+func (s *FakeStore) DeleteIssues(ks []string) error {
+	e, _ := s.Call("DeleteIssues", ks).(*ExpectedError)
+	if e == nil {
+		return expect.Unexpected("DeleteIssues")
+	}
+	return e.err
+}
+
+// CountIssuesByProjectAndMonth returns the counts of the
+// expectation.
+func (s *FakeStore) CountIssuesByProjectAndMonth() ([]store.IssueCount, error) {
+	e, _ := s.Call("CountIssuesByProjectAndMonth").(*ExpectedCountIssuesByProjectAndMonth)
+	if e == nil {
+		return nil, expect.Unexpected("CountIssuesByProjectAndMonth")
+	}
+	return e.counts, nil
+}
+
+// GetIssuesUpdatedAt returns the update times of the expectation
+// for the project and start of the period.
+func (s *FakeStore) GetIssuesUpdatedAt(project string, from time.Time, to time.Time) (map[string]time.Time, error) {
+	e, _ := s.Call("GetIssuesUpdatedAt", project, from, to).(*ExpectedGetIssuesUpdatedAt)
+	if e == nil {
+		return nil, expect.Unexpected("GetIssuesUpdatedAt")
+	}
+	return e.updates, nil
+}
+
+// GetRestartFromUpdatedAt returns the time of the expectation.
+func (s *FakeStore) GetRestartFromUpdatedAt(n int) *time.Time {
+	e, _ := s.Call("GetRestartFromUpdatedAt", n).(*ExpectedGetRestartFromUpdatedAt)
+	if e == nil {
+		return &time.Time{}
+	}
+	return &e.t
+}
+
+// GetIssueTimelines returns the timelines of the expectation.
+func (s *FakeStore) GetIssueTimelines() ([]store.IssueTimeline, error) {
+	e, _ := s.Call("GetIssueTimelines").(*ExpectedGetIssueTimelines)
+	if e == nil {
+		return nil, expect.Unexpected("GetIssueTimelines")
+	}
+	return e.timelines, nil
+}
+
+// RefreshCFDDaily returns the error of the expectation for the
+// start of the refresh.
+func (s *FakeStore) RefreshCFDDaily(from time.Time, to time.Time) error {
+	e, _ := s.Call("RefreshCFDDaily", from, to).(*ExpectedRefreshCFDDaily)
+	if e == nil {
+		return expect.Unexpected("RefreshCFDDaily")
+	}
+	return e.err
+}
+
+// GetCFDDaily returns the counts of the expectation.
+func (s *FakeStore) GetCFDDaily(from time.Time, to time.Time) ([]store.CFDCount, error) {
+	e, _ := s.Call("GetCFDDaily", from, to).(*ExpectedGetCFDDaily)
+	if e == nil {
+		return nil, expect.Unexpected("GetCFDDaily")
+	}
+	return e.counts, nil
+}
+
+// RefreshFlowDaily returns the error of the expectation for the
+// start of the refresh.
+func (s *FakeStore) RefreshFlowDaily(from time.Time, to time.Time) error {
+	e, _ := s.Call("RefreshFlowDaily", from, to).(*ExpectedRefreshFlowDaily)
+	if e == nil {
+		return expect.Unexpected("RefreshFlowDaily")
+	}
+	return e.err
+}
+
+// GetFlowDaily returns the metrics of the expectation.
+func (s *FakeStore) GetFlowDaily(from time.Time, to time.Time) ([]store.FlowCount, error) {
+	e, _ := s.Call("GetFlowDaily", from, to).(*ExpectedGetFlowDaily)
+	if e == nil {
+		return nil, expect.Unexpected("GetFlowDaily")
+	}
+	return e.counts, nil
+}
+
+// GetThroughputDaily returns the throughput of the expectation.
+func (s *FakeStore) GetThroughputDaily(from time.Time, to time.Time, f store.IssueFilter) ([]store.ThroughputCount, error) {
+	e, _ := s.Call("GetThroughputDaily", from, to, f).(*ExpectedGetThroughputDaily)
+	if e == nil {
+		return nil, expect.Unexpected("GetThroughputDaily")
+	}
+	return e.counts, nil
+}
+
+// GetIssueFlowEfficiencies returns the flow efficiencies of the
+// expectation.
+func (s *FakeStore) GetIssueFlowEfficiencies() ([]store.IssueFlowEfficiency, error) {
+	e, _ := s.Call("GetIssueFlowEfficiencies").(*ExpectedGetIssueFlowEfficiencies)
+	if e == nil {
+		return nil, expect.Unexpected("GetIssueFlowEfficiencies")
+	}
+	return e.efficiencies, nil
+}
+
+// CountUnresolvedIssues returns the count of the expectation.
+func (s *FakeStore) CountUnresolvedIssues(f store.IssueFilter) (int, error) {
+	e, _ := s.Call("CountUnresolvedIssues", f).(*ExpectedCountUnresolvedIssues)
+	if e == nil {
+		return 0, expect.Unexpected("CountUnresolvedIssues")
+	}
+	return e.n, e.err
+}
+
+// InsertForecasts records the forecasts in the expectation and
+// returns its error.
+func (s *FakeStore) InsertForecasts(fs []store.Forecast) error {
+	e, _ := s.Call("InsertForecasts", fs).(*ExpectedInsertForecasts)
+	if e == nil {
+		return expect.Unexpected("InsertForecasts")
+	}
+	e.Forecasts = append(e.Forecasts, fs...)
+	return e.err
+}
+
+// ReplaceAgingIssues records the alerts in the expectation and
+// returns its error.
+func (s *FakeStore) ReplaceAgingIssues(as []store.AgingAlert) error {
+	e, _ := s.Call("ReplaceAgingIssues", as).(*ExpectedReplaceAgingIssues)
+	if e == nil {
+		return expect.Unexpected("ReplaceAgingIssues")
+	}
+	e.Alerts = as
+	return e.err
+}
+
+// CreateTables only records the call, which must be expected.
+func (s *FakeStore) CreateTables() {
+	s.Call("CreateTables")
+}
+
+// DropTables only records the call, which must be expected.
+func (s *FakeStore) DropTables() {
+	s.Call("DropTables")
+}
+
+// ============
+// Expectations
+// ============
+
+// ExpectedReplaceIssueStateAndEvents is an expectation for
+// `ReplaceIssueStateAndEvents`, also met by the issues of
+// `ReplaceIssues`.
+type ExpectedReplaceIssueStateAndEvents struct {
+	*expect.Expectation
+	issueKey           string
+	issueState         *store.IssueState
+	issueEvents        []*store.IssueEvent
+	issueStatusPeriods []*store.IssueStatusPeriod
+	err                error
+}
+
+// ExpectReplaceIssueStateAndEvents sets an expectation of the
+// replace of an issue's records. Use `WithIssueKey` to specify the
+// issue, and the other `With...` methods to check the records.
+func (s *FakeStore) ExpectReplaceIssueStateAndEvents() *ExpectedReplaceIssueStateAndEvents {
+	e := &ExpectedReplaceIssueStateAndEvents{}
+	e.Expectation = expect.New("ReplaceIssueStateAndEvents", "", func(args []interface{}) bool {
+		return args[0] == e.issueKey
+	})
+	s.Add(e)
+	return e
+}
+
+func (e *ExpectedReplaceIssueStateAndEvents) String() string {
+	return fmt.Sprintf("ReplaceIssueStateAndEvents(`%s`)", e.issueKey)
+}
+
+// WithIssueKey sets the key of the issue.
+func (e *ExpectedReplaceIssueStateAndEvents) WithIssueKey(ik string) *ExpectedReplaceIssueStateAndEvents {
+	e.issueKey = ik
+	return e
+}
+
+// WithIssueState sets the expected state. Only its times, key and
+// the non-nil `Project`, `ResolvedAt`, `Summary` and `Description`
+// are checked.
+func (e *ExpectedReplaceIssueStateAndEvents) WithIssueState(is *store.IssueState) *ExpectedReplaceIssueStateAndEvents {
+	e.issueState = is
+	return e
+}
+
+// WithIssueEvents sets the expected events. Their time, kind,
+// author, issue key and the non-nil comment, status and assignee
+// changes are checked.
+func (e *ExpectedReplaceIssueStateAndEvents) WithIssueEvents(ies []*store.IssueEvent) *ExpectedReplaceIssueStateAndEvents {
+	e.issueEvents = ies
+	return e
+}
+
+// WithIssueStatusPeriods sets the expected status periods. Only
+// their count is checked.
+func (e *ExpectedReplaceIssueStateAndEvents) WithIssueStatusPeriods(isps []*store.IssueStatusPeriod) *ExpectedReplaceIssueStateAndEvents {
+	e.issueStatusPeriods = isps
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedReplaceIssueStateAndEvents) WillReturnError(err error) *ExpectedReplaceIssueStateAndEvents {
+	e.err = err
+	return e
+}
+
+// check reports the differences between the received records and
+// the expected ones.
+func (e *ExpectedReplaceIssueStateAndEvents) check(t testing.TB, is store.IssueState, ies []store.IssueEvent, isps []store.IssueStatusPeriod) {
+	t.Helper()
+	errorf := func(format string, args ...interface{}) {
+		t.Errorf("`%s` received "+format, append([]interface{}{e}, args...)...)
+	}
+	if eis := e.issueState; eis != nil {
+		if !is.CreatedAt.Equal(eis.CreatedAt) {
+			errorf("state.CreatedAt=`%v` but `%v` was expected", is.CreatedAt, eis.CreatedAt)
+		}
+		if !is.UpdatedAt.Equal(eis.UpdatedAt) {
+			errorf("state.UpdatedAt=`%v` but `%v` was expected", is.UpdatedAt, eis.UpdatedAt)
+		}
+		if is.Key != eis.Key {
+			errorf("state.Key=`%v` but `%v` was expected", is.Key, eis.Key)
+		}
+		checkString(errorf, "state.Project", is.Project, eis.Project)
+		checkString(errorf, "state.Summary", is.Summary, eis.Summary)
+		checkString(errorf, "state.Description", is.Description, eis.Description)
+		if eis.ResolvedAt != nil && (is.ResolvedAt == nil || !is.ResolvedAt.Equal(*eis.ResolvedAt)) {
+			errorf("state.ResolvedAt=`%v` but `%v` was expected", is.ResolvedAt, *eis.ResolvedAt)
+		}
+	}
+
+	if len(ies) != len(e.issueEvents) {
+		errorf("%d events but %d were expected (%v)", len(ies), len(e.issueEvents), ies)
+	} else {
+		for n, ie := range ies {
+			eie := e.issueEvents[n]
+			if !timesAlmostEqual(ie.EventTime, eie.EventTime) {
+				errorf("event[%d].EventTime=`%v` but `%v` was expected", n, ie.EventTime, eie.EventTime)
+			}
+			if ie.EventKind != eie.EventKind || ie.EventAuthor != eie.EventAuthor || ie.IssueKey != eie.IssueKey {
+				errorf("event[%d]=`%s` but `%s` was expected", n, ie, *eie)
+			}
+			field := func(f string) string { return fmt.Sprintf("event[%d].%s", n, f) }
+			checkString(errorf, field("CommentBody"), ie.CommentBody, eie.CommentBody)
+			checkString(errorf, field("StatusChangeFrom"), ie.StatusChangeFrom, eie.StatusChangeFrom)
+			checkString(errorf, field("StatusChangeTo"), ie.StatusChangeTo, eie.StatusChangeTo)
+			checkString(errorf, field("AssigneeChangeFrom"), ie.AssigneeChangeFrom, eie.AssigneeChangeFrom)
+			checkString(errorf, field("AssigneeChangeTo"), ie.AssigneeChangeTo, eie.AssigneeChangeTo)
+		}
+	}
+
+	if len(isps) != len(e.issueStatusPeriods) {
+		errorf("%d status periods but %d were expected (%v)", len(isps), len(e.issueStatusPeriods), isps)
+	}
+}
+
+// checkString reports a difference if the expected value is not nil
+// and the received one differs.
+func checkString(errorf func(string, ...interface{}), field string, v *string, expected *string) {
+	switch {
+	case expected == nil:
+	case v == nil:
+		errorf("%s=nil but `%s` was expected", field, *expected)
+	case *v != *expected:
+		errorf("%s=`%s` but `%s` was expected", field, *v, *expected)
+	}
+}
+
+func timesAlmostEqual(t1 time.Time, t2 time.Time) bool {
+	d := t1.Sub(t2)
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+// ExpectedError is an expectation of a method only returning an
+// error (e.g. `UpsertUsers`).
+type ExpectedError struct {
+	*expect.Expectation
+	err error
+}
+
+// ExpectUpsertUsers sets an expectation of an `UpsertUsers` call.
+func (s *FakeStore) ExpectUpsertUsers() *ExpectedError {
+	e := &ExpectedError{Expectation: expect.New("UpsertUsers", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// ExpectCreateTables sets an expectation of a `CreateTables` call.
+func (s *FakeStore) ExpectCreateTables() *expect.Expectation {
+	e := expect.New("CreateTables", "", nil)
+	s.Add(e)
+	return e
+}
+
+// ExpectDropTables sets an expectation of a `DropTables` call.
+func (s *FakeStore) ExpectDropTables() *expect.Expectation {
+	e := expect.New("DropTables", "", nil)
+	s.Add(e)
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedError) WillReturnError(err error) *ExpectedError {
+	e.err = err
+	return e
+}
+
+// ExpectedReplaceStatuses is an expectation for `ReplaceStatuses`.
+type ExpectedReplaceStatuses struct {
+	*expect.Expectation
+	statuses []store.Status
+	err      error
+}
+
+// ExpectReplaceStatuses sets an expectation of a `ReplaceStatuses`
+// call.
+func (s *FakeStore) ExpectReplaceStatuses() *ExpectedReplaceStatuses {
+	e := &ExpectedReplaceStatuses{Expectation: expect.New("ReplaceStatuses", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WithStatuses sets the expected statuses.
+func (e *ExpectedReplaceStatuses) WithStatuses(ss []store.Status) *ExpectedReplaceStatuses {
+	e.statuses = ss
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedReplaceStatuses) WillReturnError(err error) *ExpectedReplaceStatuses {
+	e.err = err
+	return e
+}
+
+// ExpectedGetRestartFromUpdatedAt is an expectation for
+// `GetRestartFromUpdatedAt`.
+type ExpectedGetRestartFromUpdatedAt struct {
+	*expect.Expectation
+	t time.Time
+}
+
+// ExpectGetRestartFromUpdatedAt sets an expectation of a
+// `GetRestartFromUpdatedAt` call.
+func (s *FakeStore) ExpectGetRestartFromUpdatedAt() *ExpectedGetRestartFromUpdatedAt {
+	e := &ExpectedGetRestartFromUpdatedAt{Expectation: expect.New("GetRestartFromUpdatedAt", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the time to return.
+func (e *ExpectedGetRestartFromUpdatedAt) WillReturn(t time.Time) *ExpectedGetRestartFromUpdatedAt {
+	e.t = t
+	return e
+}
+
+// ExpectedUpsertSyncFailure is an expectation for
+// `UpsertSyncFailure`.
+type ExpectedUpsertSyncFailure struct {
+	*expect.Expectation
+	issueKey string
+	stage    string
+	err      error
+}
+
+// ExpectUpsertSyncFailure sets an expectation of the failure of an
+// issue. Use `WithIssueKey` and `WithStage` to specify the failure.
+func (s *FakeStore) ExpectUpsertSyncFailure() *ExpectedUpsertSyncFailure {
+	e := &ExpectedUpsertSyncFailure{}
+	e.Expectation = expect.New("UpsertSyncFailure", "", func(args []interface{}) bool {
+		return args[0] == e.issueKey && (e.stage == "" || args[1] == e.stage)
+	})
+	s.Add(e)
+	return e
+}
+
+func (e *ExpectedUpsertSyncFailure) String() string {
+	return fmt.Sprintf("UpsertSyncFailure(`%s`, `%s`)", e.issueKey, e.stage)
+}
+
+// WithIssueKey sets the key of the failed issue.
+func (e *ExpectedUpsertSyncFailure) WithIssueKey(ik string) *ExpectedUpsertSyncFailure {
+	e.issueKey = ik
+	return e
+}
+
+// WithStage sets the stage of the failure.
+func (e *ExpectedUpsertSyncFailure) WithStage(stage string) *ExpectedUpsertSyncFailure {
+	e.stage = stage
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedUpsertSyncFailure) WillReturnError(err error) *ExpectedUpsertSyncFailure {
+	e.err = err
+	return e
+}
+
+// ExpectedGetSyncFailures is an expectation for `GetSyncFailures`.
+type ExpectedGetSyncFailures struct {
+	*expect.Expectation
+	failures []store.SyncFailure
+	err      error
+}
+
+// ExpectGetSyncFailures sets an expectation of a `GetSyncFailures`
+// call.
+func (s *FakeStore) ExpectGetSyncFailures() *ExpectedGetSyncFailures {
+	e := &ExpectedGetSyncFailures{Expectation: expect.New("GetSyncFailures", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the failures to return.
+func (e *ExpectedGetSyncFailures) WillReturn(fs []store.SyncFailure) *ExpectedGetSyncFailures {
+	e.failures = fs
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedGetSyncFailures) WillReturnError(err error) *ExpectedGetSyncFailures {
+	e.err = err
+	return e
+}
+
+// ExpectedGetIssueStateAndEvents is an expectation for
+// `GetIssueStateAndEvents`.
+type ExpectedGetIssueStateAndEvents struct {
+	*expect.Expectation
+	state  *store.IssueState
+	events []store.IssueEvent
+}
+
+// ExpectGetIssueStateAndEvents sets an expectation of a
+// `GetIssueStateAndEvents` call for the issue key.
+func (s *FakeStore) ExpectGetIssueStateAndEvents(ik string) *ExpectedGetIssueStateAndEvents {
+	e := &ExpectedGetIssueStateAndEvents{Expectation: expect.New("GetIssueStateAndEvents", fmt.Sprintf("`%s`", ik), func(args []interface{}) bool {
+		return args[0] == ik
+	})}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the state and events to return. A nil state is
+// for an issue which is not in the store.
+func (e *ExpectedGetIssueStateAndEvents) WillReturn(is *store.IssueState, ies []store.IssueEvent) *ExpectedGetIssueStateAndEvents {
+	e.state = is
+	e.events = ies
+	return e
+}
+
+// ExpectedSampleIssueKeys is an expectation for `SampleIssueKeys`.
+type ExpectedSampleIssueKeys struct {
+	*expect.Expectation
+	issueKeys []string
+}
+
+// ExpectSampleIssueKeys sets an expectation of a `SampleIssueKeys`
+// call.
+func (s *FakeStore) ExpectSampleIssueKeys() *ExpectedSampleIssueKeys {
+	e := &ExpectedSampleIssueKeys{Expectation: expect.New("SampleIssueKeys", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the keys to return.
+func (e *ExpectedSampleIssueKeys) WillReturn(ks []string) *ExpectedSampleIssueKeys {
+	e.issueKeys = ks
+	return e
+}
+
+// ExpectDeleteIssues sets an expectation of a `DeleteIssues` call
+// with the specified keys, in order.
+func (s *FakeStore) ExpectDeleteIssues(ks []string) *ExpectedError {
+	e := &ExpectedError{Expectation: expect.New("DeleteIssues", fmt.Sprintf("%v", ks), func(args []interface{}) bool {
+		return fmt.Sprint(args[0]) == fmt.Sprint(ks)
+	})}
+	s.Add(e)
+	return e
+}
+
+// ExpectedCountIssuesByProjectAndMonth is an expectation for
+// `CountIssuesByProjectAndMonth`.
+type ExpectedCountIssuesByProjectAndMonth struct {
+	*expect.Expectation
+	counts []store.IssueCount
+}
+
+// ExpectCountIssuesByProjectAndMonth sets an expectation of a
+// `CountIssuesByProjectAndMonth` call.
+func (s *FakeStore) ExpectCountIssuesByProjectAndMonth() *ExpectedCountIssuesByProjectAndMonth {
+	e := &ExpectedCountIssuesByProjectAndMonth{Expectation: expect.New("CountIssuesByProjectAndMonth", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the counts to return.
+func (e *ExpectedCountIssuesByProjectAndMonth) WillReturn(cs []store.IssueCount) *ExpectedCountIssuesByProjectAndMonth {
+	e.counts = cs
+	return e
+}
+
+// ExpectedGetIssuesUpdatedAt is an expectation for
+// `GetIssuesUpdatedAt`.
+type ExpectedGetIssuesUpdatedAt struct {
+	*expect.Expectation
+	updates map[string]time.Time
+}
+
+// ExpectGetIssuesUpdatedAt sets an expectation of a
+// `GetIssuesUpdatedAt` call for the project period starting at
+// `from`.
+func (s *FakeStore) ExpectGetIssuesUpdatedAt(project string, from time.Time) *ExpectedGetIssuesUpdatedAt {
+	e := &ExpectedGetIssuesUpdatedAt{Expectation: expect.New("GetIssuesUpdatedAt", fmt.Sprintf("`%s`, %s", project, from), func(args []interface{}) bool {
+		return args[0] == project && args[1].(time.Time).Equal(from)
+	})}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the update times to return, indexed by issue key.
+func (e *ExpectedGetIssuesUpdatedAt) WillReturn(us map[string]time.Time) *ExpectedGetIssuesUpdatedAt {
+	e.updates = us
+	return e
+}
+
+// ExpectedGetIssueTimelines is an expectation for
+// `GetIssueTimelines`.
+type ExpectedGetIssueTimelines struct {
+	*expect.Expectation
+	timelines []store.IssueTimeline
+}
+
+// ExpectGetIssueTimelines sets an expectation of a
+// `GetIssueTimelines` call.
+func (s *FakeStore) ExpectGetIssueTimelines() *ExpectedGetIssueTimelines {
+	e := &ExpectedGetIssueTimelines{Expectation: expect.New("GetIssueTimelines", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the timelines to return.
+func (e *ExpectedGetIssueTimelines) WillReturn(ts []store.IssueTimeline) *ExpectedGetIssueTimelines {
+	e.timelines = ts
+	return e
+}
+
+// ExpectedRefreshCFDDaily is an expectation for `RefreshCFDDaily`.
+type ExpectedRefreshCFDDaily struct {
+	*expect.Expectation
+	err error
+}
+
+// ExpectRefreshCFDDaily sets an expectation of a `RefreshCFDDaily`
+// call starting at `from` (the zero time for a whole rebuild), up to
+// any time.
+func (s *FakeStore) ExpectRefreshCFDDaily(from time.Time) *ExpectedRefreshCFDDaily {
+	e := &ExpectedRefreshCFDDaily{Expectation: expect.New("RefreshCFDDaily", from.String(), func(args []interface{}) bool {
+		return args[0].(time.Time).Equal(from)
+	})}
+	s.Add(e)
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedRefreshCFDDaily) WillReturnError(err error) *ExpectedRefreshCFDDaily {
+	e.err = err
+	return e
+}
+
+// ExpectedGetCFDDaily is an expectation for `GetCFDDaily`.
+type ExpectedGetCFDDaily struct {
+	*expect.Expectation
+	counts []store.CFDCount
+}
+
+// ExpectGetCFDDaily sets an expectation of a `GetCFDDaily` call.
+func (s *FakeStore) ExpectGetCFDDaily() *ExpectedGetCFDDaily {
+	e := &ExpectedGetCFDDaily{Expectation: expect.New("GetCFDDaily", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the counts to return.
+func (e *ExpectedGetCFDDaily) WillReturn(cs []store.CFDCount) *ExpectedGetCFDDaily {
+	e.counts = cs
+	return e
+}
+
+// ExpectedRefreshFlowDaily is an expectation for `RefreshFlowDaily`.
+type ExpectedRefreshFlowDaily struct {
+	*expect.Expectation
+	err error
+}
+
+// ExpectRefreshFlowDaily sets an expectation of a `RefreshFlowDaily`
+// call starting at `from` (the zero time for a whole rebuild), up to
+// any time.
+func (s *FakeStore) ExpectRefreshFlowDaily(from time.Time) *ExpectedRefreshFlowDaily {
+	e := &ExpectedRefreshFlowDaily{Expectation: expect.New("RefreshFlowDaily", from.String(), func(args []interface{}) bool {
+		return args[0].(time.Time).Equal(from)
+	})}
+	s.Add(e)
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedRefreshFlowDaily) WillReturnError(err error) *ExpectedRefreshFlowDaily {
+	e.err = err
+	return e
+}
+
+// ExpectedGetFlowDaily is an expectation for `GetFlowDaily`.
+type ExpectedGetFlowDaily struct {
+	*expect.Expectation
+	counts []store.FlowCount
+}
+
+// ExpectGetFlowDaily sets an expectation of a `GetFlowDaily` call.
+func (s *FakeStore) ExpectGetFlowDaily() *ExpectedGetFlowDaily {
+	e := &ExpectedGetFlowDaily{Expectation: expect.New("GetFlowDaily", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the metrics to return.
+func (e *ExpectedGetFlowDaily) WillReturn(fs []store.FlowCount) *ExpectedGetFlowDaily {
+	e.counts = fs
+	return e
+}
+
+// ExpectedGetThroughputDaily is an expectation for
+// `GetThroughputDaily`.
+type ExpectedGetThroughputDaily struct {
+	*expect.Expectation
+	counts []store.ThroughputCount
+}
+
+// ExpectGetThroughputDaily sets an expectation of a
+// `GetThroughputDaily` call with any filter.
+func (s *FakeStore) ExpectGetThroughputDaily() *ExpectedGetThroughputDaily {
+	e := &ExpectedGetThroughputDaily{Expectation: expect.New("GetThroughputDaily", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the throughput to return.
+func (e *ExpectedGetThroughputDaily) WillReturn(ts []store.ThroughputCount) *ExpectedGetThroughputDaily {
+	e.counts = ts
+	return e
+}
+
+// ExpectedGetIssueFlowEfficiencies is an expectation for
+// `GetIssueFlowEfficiencies`.
+type ExpectedGetIssueFlowEfficiencies struct {
+	*expect.Expectation
+	efficiencies []store.IssueFlowEfficiency
+}
+
+// ExpectGetIssueFlowEfficiencies sets an expectation of a
+// `GetIssueFlowEfficiencies` call.
+func (s *FakeStore) ExpectGetIssueFlowEfficiencies() *ExpectedGetIssueFlowEfficiencies {
+	e := &ExpectedGetIssueFlowEfficiencies{Expectation: expect.New("GetIssueFlowEfficiencies", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the flow efficiencies to return.
+func (e *ExpectedGetIssueFlowEfficiencies) WillReturn(fs []store.IssueFlowEfficiency) *ExpectedGetIssueFlowEfficiencies {
+	e.efficiencies = fs
+	return e
+}
+
+// ExpectedCountUnresolvedIssues is an expectation for
+// `CountUnresolvedIssues`.
+type ExpectedCountUnresolvedIssues struct {
+	*expect.Expectation
+	n   int
+	err error
+}
+
+// ExpectCountUnresolvedIssues sets an expectation of a
+// `CountUnresolvedIssues` call with any filter.
+func (s *FakeStore) ExpectCountUnresolvedIssues() *ExpectedCountUnresolvedIssues {
+	e := &ExpectedCountUnresolvedIssues{Expectation: expect.New("CountUnresolvedIssues", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturn sets the count to return.
+func (e *ExpectedCountUnresolvedIssues) WillReturn(n int) *ExpectedCountUnresolvedIssues {
+	e.n = n
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedCountUnresolvedIssues) WillReturnError(err error) *ExpectedCountUnresolvedIssues {
+	e.err = err
+	return e
+}
+
+// ExpectedInsertForecasts is an expectation for `InsertForecasts`.
+// The inserted forecasts are recorded in `Forecasts`.
+type ExpectedInsertForecasts struct {
+	*expect.Expectation
+	Forecasts []store.Forecast
+	err       error
+}
+
+// ExpectInsertForecasts sets an expectation of an `InsertForecasts`
+// call.
+func (s *FakeStore) ExpectInsertForecasts() *ExpectedInsertForecasts {
+	e := &ExpectedInsertForecasts{Expectation: expect.New("InsertForecasts", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedInsertForecasts) WillReturnError(err error) *ExpectedInsertForecasts {
+	e.err = err
+	return e
+}
+
+// ExpectedReplaceAgingIssues is an expectation for
+// `ReplaceAgingIssues`. The alerts are recorded in `Alerts`.
+type ExpectedReplaceAgingIssues struct {
+	*expect.Expectation
+	Alerts []store.AgingAlert
+	err    error
+}
+
+// ExpectReplaceAgingIssues sets an expectation of a
+// `ReplaceAgingIssues` call.
+func (s *FakeStore) ExpectReplaceAgingIssues() *ExpectedReplaceAgingIssues {
+	e := &ExpectedReplaceAgingIssues{Expectation: expect.New("ReplaceAgingIssues", "", nil)}
+	s.Add(e)
+	return e
+}
+
+// WillReturnError sets the error to return.
+func (e *ExpectedReplaceAgingIssues) WillReturnError(err error) *ExpectedReplaceAgingIssues {
+	e.err = err
+	return e
+}
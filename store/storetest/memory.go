@@ -0,0 +1,724 @@
+package storetest
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// MemoryStore implements `store.Store` in memory, with the semantics
+// of `store.PGStore` (verified by running the contract suite, see
+// `Run`). It is safe for concurrent use.
+//
+// Besides the methods of `store.Store`, it provides accessors to the
+// records which can't be read through the interface (e.g. `Users`).
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]store.IssueRecords
+	users     map[string]store.User
+	statuses  []store.Status
+	failures  map[string]store.SyncFailure
+	cfd       []store.CFDCount
+	flow      []store.FlowCount
+	forecasts []store.Forecast
+	aging     []store.AgingAlert
+}
+
+// NewMemoryStore returns an empty `MemoryStore`.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.DropTables()
+	return s
+}
+
+// ReplaceIssueStateAndEvents replaces the records of the specified
+// issue and deletes its sync failure.
+func (s *MemoryStore) ReplaceIssueStateAndEvents(k string, is store.IssueState, ies []store.IssueEvent, isps []store.IssueStatusPeriod) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replace(store.IssueRecords{Key: k, State: is, Events: ies, StatusPeriods: isps})
+	return nil
+}
+
+// ReplaceIssues replaces the records of the specified issues, and
+// inserts or updates the users referenced by the issues.
+func (s *MemoryStore) ReplaceIssues(rs []store.IssueRecords) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range rs {
+		s.replace(r)
+		for _, u := range r.Users {
+			s.upsertUser(u)
+		}
+	}
+	return nil
+}
+
+// replace replaces the records of an issue. Events are sorted by
+// time, keeping the insertion order of events at the same time. The
+// caller must hold the lock.
+func (s *MemoryStore) replace(r store.IssueRecords) {
+	ies := append([]store.IssueEvent(nil), r.Events...)
+	sort.Stable(store.IssueEventsByTime(ies))
+	s.records[r.Key] = store.IssueRecords{
+		Key:           r.Key,
+		State:         r.State,
+		Events:        ies,
+		StatusPeriods: append([]store.IssueStatusPeriod(nil), r.StatusPeriods...),
+	}
+	delete(s.failures, r.Key)
+}
+
+// ReplaceStatuses replaces all the statuses.
+func (s *MemoryStore) ReplaceStatuses(ss []store.Status) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses = append([]store.Status(nil), ss...)
+	return nil
+}
+
+// UpsertUsers inserts or updates the specified users, keeping the
+// email and time zone of existing users if the new values are
+// unknown.
+func (s *MemoryStore) UpsertUsers(us []store.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range us {
+		s.upsertUser(u)
+	}
+	return nil
+}
+
+// upsertUser inserts or updates a user. The caller must hold the
+// lock.
+func (s *MemoryStore) upsertUser(u store.User) {
+	if prev, ok := s.users[u.AccountID]; ok {
+		if u.Email == nil {
+			u.Email = prev.Email
+		}
+		if u.TimeZone == nil {
+			u.TimeZone = prev.TimeZone
+		}
+	}
+	s.users[u.AccountID] = u
+}
+
+// UpsertSyncFailure records the failure, incrementing the attempts
+// count of an issue which already failed.
+func (s *MemoryStore) UpsertSyncFailure(f store.SyncFailure) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.Attempts = s.failures[f.IssueKey].Attempts + 1
+	s.failures[f.IssueKey] = f
+	return nil
+}
+
+// GetSyncFailures returns the failures, ordered by issue key.
+func (s *MemoryStore) GetSyncFailures() ([]store.SyncFailure, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs []store.SyncFailure
+	for _, f := range s.failures {
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(a, b int) bool { return fs[a].IssueKey < fs[b].IssueKey })
+	return fs, nil
+}
+
+// GetIssueStateAndEvents returns the state and events of the issue,
+// events being sorted by time. The returned state is nil if the
+// issue is not in the store.
+func (s *MemoryStore) GetIssueStateAndEvents(k string) (*store.IssueState, []store.IssueEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[k]
+	if !ok {
+		return nil, nil, nil
+	}
+	is := r.State
+	return &is, append([]store.IssueEvent(nil), r.Events...), nil
+}
+
+// SampleIssueKeys returns the keys of `n` issues, randomly selected.
+func (s *MemoryStore) SampleIssueKeys(n int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ks []string
+	for k := range s.records {
+		ks = append(ks, k)
+	}
+	rand.Shuffle(len(ks), func(a, b int) { ks[a], ks[b] = ks[b], ks[a] })
+	if n < len(ks) {
+		ks = ks[:n]
+	}
+	return ks, nil
+}
+
+// DeleteIssues deletes the records and sync failures of the
+// specified issues.
+func (s *MemoryStore) DeleteIssues(ks []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range ks {
+		delete(s.records, k)
+		delete(s.failures, k)
+	}
+	return nil
+}
+
+// CountIssuesByProjectAndMonth returns the number of issues by
+// project and month of creation, sorted by project and month.
+// Issues without project are ignored.
+func (s *MemoryStore) CountIssuesByProjectAndMonth() ([]store.IssueCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[store.IssueCount]int)
+	for _, r := range s.records {
+		if r.State.Project == nil {
+			continue
+		}
+		t := r.State.CreatedAt
+		c := store.IssueCount{Project: *r.State.Project, Month: time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)}
+		counts[c]++
+	}
+	var cs []store.IssueCount
+	for c, n := range counts {
+		c.Count = n
+		cs = append(cs, c)
+	}
+	sort.Slice(cs, func(a, b int) bool {
+		if cs[a].Project != cs[b].Project {
+			return cs[a].Project < cs[b].Project
+		}
+		return cs[a].Month.Before(cs[b].Month)
+	})
+	return cs, nil
+}
+
+// GetIssuesUpdatedAt returns the update time of the issues of the
+// project created in [from, to), indexed by issue key.
+func (s *MemoryStore) GetIssuesUpdatedAt(project string, from time.Time, to time.Time) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	us := make(map[string]time.Time)
+	for k, r := range s.records {
+		st := r.State
+		if st.Project == nil || *st.Project != project || st.CreatedAt.Before(from) || !st.CreatedAt.Before(to) {
+			continue
+		}
+		us[k] = st.UpdatedAt
+	}
+	return us, nil
+}
+
+// GetRestartFromUpdatedAt returns the `n`th most recent update time
+// of the issues (the oldest one if there are less than `n` issues),
+// or the zero time if the store is empty.
+func (s *MemoryStore) GetRestartFromUpdatedAt(n int) *time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ts []time.Time
+	for _, r := range s.records {
+		ts = append(ts, r.State.UpdatedAt)
+	}
+	if len(ts) == 0 {
+		return &time.Time{}
+	}
+	sort.Slice(ts, func(a, b int) bool { return ts[a].After(ts[b]) })
+	if n > len(ts) {
+		n = len(ts)
+	}
+	return &ts[n-1]
+}
+
+// GetIssueTimelines returns the timelines of the issues, sorted by
+// issue key, from their `status_changed` events.
+func (s *MemoryStore) GetIssueTimelines() ([]store.IssueTimeline, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ts := make([]store.IssueTimeline, 0, len(s.records))
+	for _, r := range s.records {
+		st := r.State
+		t := store.IssueTimeline{Key: r.Key, Assignee: st.Assignee, CreatedAt: st.CreatedAt, UpdatedAt: st.UpdatedAt, ResolvedAt: st.ResolvedAt}
+		if st.Summary != nil {
+			t.Summary = *st.Summary
+		}
+		if st.Project != nil {
+			t.Project = *st.Project
+		}
+		if st.Type != nil {
+			t.Type = *st.Type
+		}
+		for _, ie := range r.Events {
+			if ie.EventKind == "status_changed" && ie.StatusChangeTo != nil {
+				t.Statuses = append(t.Statuses, store.StatusEntry{Time: ie.EventTime, Status: *ie.StatusChangeTo, Category: ie.StatusChangeToCategory})
+			}
+		}
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(a, b int) bool { return ts[a].Key < ts[b].Key })
+	return ts, nil
+}
+
+// RefreshCFDDaily recomputes the daily counts of issues by project
+// and status with the semantics of `store.PGStore`.
+func (s *MemoryStore) RefreshCFDDaily(from time.Time, to time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.cfd) == 0 {
+		from = time.Time{}
+	} else {
+		from = refreshFrom(from, s.cfd[len(s.cfd)-1].Day)
+	}
+
+	type group struct {
+		project  string
+		status   string
+		category string
+		known    bool
+	}
+	deltas := make(map[group]map[time.Time]int)
+	var first time.Time
+	add := func(g group, d time.Time, delta int) {
+		if deltas[g] == nil {
+			deltas[g] = make(map[time.Time]int)
+		}
+		deltas[g][d] += delta
+		if first.IsZero() || d.Before(first) {
+			first = d
+		}
+	}
+	for _, r := range s.records {
+		var prev *group
+		for _, ie := range r.Events {
+			if ie.EventKind != "status_changed" || ie.StatusChangeTo == nil {
+				continue
+			}
+			if prev != nil {
+				add(*prev, day(ie.EventTime), -1)
+			}
+			g := group{status: *ie.StatusChangeTo}
+			if r.State.Project != nil {
+				g.project = *r.State.Project
+			}
+			if c := ie.StatusChangeToCategory; c != nil {
+				g.category, g.known = *c, true
+			}
+			add(g, day(ie.EventTime), 1)
+			prev = &g
+		}
+	}
+
+	cfd := s.cfd[:0]
+	for _, c := range s.cfd {
+		if c.Day.Before(from) {
+			cfd = append(cfd, c)
+		}
+	}
+	for g, ds := range deltas {
+		n := 0
+		for d := first; !d.After(day(to)); d = d.AddDate(0, 0, 1) {
+			n += ds[d]
+			if n <= 0 || d.Before(from) {
+				continue
+			}
+			c := store.CFDCount{Day: d, Project: g.project, Status: g.status, Count: n}
+			if g.known {
+				c.StatusCategory = str(g.category)
+			}
+			cfd = append(cfd, c)
+		}
+	}
+	sort.SliceStable(cfd, func(a, b int) bool { return cfdLess(cfd[a], cfd[b]) })
+	s.cfd = cfd
+	return nil
+}
+
+// GetCFDDaily returns the counts for the days from the day of `from`
+// to the day of `to`, sorted by day, project and status.
+func (s *MemoryStore) GetCFDDaily(from time.Time, to time.Time) ([]store.CFDCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var cs []store.CFDCount
+	for _, c := range s.cfd {
+		if !c.Day.Before(day(from)) && !c.Day.After(day(to)) {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+// RefreshFlowDaily recomputes the daily flow metrics by project,
+// type and tribe with the semantics of `store.PGStore`.
+func (s *MemoryStore) RefreshFlowDaily(from time.Time, to time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.flow) == 0 {
+		from = time.Time{}
+	} else {
+		from = refreshFrom(from, s.flow[len(s.flow)-1].Day)
+	}
+	last := day(to)
+
+	type slice struct {
+		project   string
+		issueType string
+		tribe     string
+		hasTribe  bool
+	}
+	type metrics struct {
+		store.FlowCount
+		ages float64
+	}
+	ms := make(map[time.Time]map[slice]*metrics)
+	get := func(d time.Time, sl slice) *metrics {
+		if ms[d] == nil {
+			ms[d] = make(map[slice]*metrics)
+		}
+		if ms[d][sl] == nil {
+			ms[d][sl] = &metrics{}
+		}
+		return ms[d][sl]
+	}
+	inRange := func(d time.Time) bool { return !d.Before(from) && !d.After(last) }
+
+	for _, r := range s.records {
+		st := r.State
+		var base slice
+		if st.Project != nil {
+			base.project = *st.Project
+		}
+		if st.Type != nil {
+			base.issueType = *st.Type
+		}
+		// An issue with several tribes is counted in the slice of each
+		slices := []slice{base}
+		if len(st.Tribes) > 0 {
+			slices = slices[:0]
+			for _, t := range st.Tribes {
+				sl := base
+				sl.tribe, sl.hasTribe = t, true
+				slices = append(slices, sl)
+			}
+		}
+		for _, sl := range slices {
+			if d := day(st.CreatedAt); inRange(d) {
+				get(d, sl).Arrivals++
+			}
+			if st.ResolvedAt != nil {
+				if d := day(*st.ResolvedAt); inRange(d) {
+					get(d, sl).Throughput++
+				}
+			}
+		}
+
+		var changes []store.IssueEvent
+		for _, ie := range r.Events {
+			if ie.EventKind == "status_changed" {
+				changes = append(changes, ie)
+			}
+		}
+		var started time.Time
+		for n, ie := range changes {
+			if ie.StatusChangeToCategory == nil || *ie.StatusChangeToCategory != store.InProgressCategory {
+				continue
+			}
+			if started.IsZero() {
+				started = ie.EventTime
+			}
+			end := last.AddDate(0, 0, 1)
+			if n+1 < len(changes) {
+				end = day(changes[n+1].EventTime)
+			}
+			for d := day(ie.EventTime); d.Before(end); d = d.AddDate(0, 0, 1) {
+				if !inRange(d) {
+					continue
+				}
+				for _, sl := range slices {
+					m := get(d, sl)
+					m.WIP++
+					m.ages += d.AddDate(0, 0, 1).Sub(started).Hours() / 24
+				}
+			}
+		}
+	}
+
+	flow := s.flow[:0]
+	for _, f := range s.flow {
+		if f.Day.Before(from) {
+			flow = append(flow, f)
+		}
+	}
+	for d, sms := range ms {
+		for sl, m := range sms {
+			f := m.FlowCount
+			f.Day, f.Project, f.Type = d, sl.project, sl.issueType
+			if sl.hasTribe {
+				f.Tribe = str(sl.tribe)
+			}
+			if f.WIP > 0 {
+				age := m.ages / float64(f.WIP)
+				f.WIPAverageAge = &age
+			}
+			flow = append(flow, f)
+		}
+	}
+	sort.Slice(flow, func(a, b int) bool { return flowLess(flow[a], flow[b]) })
+	s.flow = flow
+	return nil
+}
+
+// GetFlowDaily returns the metrics for the days from the day of
+// `from` to the day of `to`, sorted by day, project, type and tribe.
+func (s *MemoryStore) GetFlowDaily(from time.Time, to time.Time) ([]store.FlowCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs []store.FlowCount
+	for _, f := range s.flow {
+		if !f.Day.Before(day(from)) && !f.Day.After(day(to)) {
+			fs = append(fs, f)
+		}
+	}
+	return fs, nil
+}
+
+// GetThroughputDaily returns the numbers of issues selected by the
+// filter resolved during each day from the day of `from` to the day
+// of `to`, sorted by day.
+func (s *MemoryStore) GetThroughputDaily(from time.Time, to time.Time, f store.IssueFilter) ([]store.ThroughputCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[time.Time]int)
+	for _, r := range s.records {
+		st := r.State
+		if st.ResolvedAt == nil || !selects(f, st) {
+			continue
+		}
+		if d := day(*st.ResolvedAt); !d.Before(day(from)) && !d.After(day(to)) {
+			counts[d]++
+		}
+	}
+	var ts []store.ThroughputCount
+	for d, n := range counts {
+		ts = append(ts, store.ThroughputCount{Day: d, Throughput: n})
+	}
+	sort.Slice(ts, func(a, b int) bool { return ts[a].Day.Before(ts[b].Day) })
+	return ts, nil
+}
+
+// GetIssueFlowEfficiencies returns the flow efficiencies of the
+// resolved issues which have one, sorted by key.
+func (s *MemoryStore) GetIssueFlowEfficiencies() ([]store.IssueFlowEfficiency, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs []store.IssueFlowEfficiency
+	for _, r := range s.records {
+		st := r.State
+		if st.ResolvedAt == nil || st.FlowEfficiency == nil {
+			continue
+		}
+		f := store.IssueFlowEfficiency{Key: r.Key, Tribes: st.Tribes, ResolvedAt: *st.ResolvedAt, FlowEfficiency: *st.FlowEfficiency}
+		if st.Project != nil {
+			f.Project = *st.Project
+		}
+		if st.Type != nil {
+			f.Type = *st.Type
+		}
+		if st.ActiveTime != nil {
+			f.ActiveTime = *st.ActiveTime
+		}
+		if st.WaitingTime != nil {
+			f.WaitingTime = *st.WaitingTime
+		}
+		fs = append(fs, f)
+	}
+	sort.Slice(fs, func(a, b int) bool { return fs[a].Key < fs[b].Key })
+	return fs, nil
+}
+
+// CountUnresolvedIssues returns the number of unresolved issues
+// selected by the filter.
+func (s *MemoryStore) CountUnresolvedIssues(f store.IssueFilter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.records {
+		st := r.State
+		if st.ResolvedAt == nil && selects(f, st) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// InsertForecasts appends the forecasts to those of the store.
+func (s *MemoryStore) InsertForecasts(fs []store.Forecast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forecasts = append(s.forecasts, fs...)
+	return nil
+}
+
+// ReplaceAgingIssues replaces the aging alerts of the store.
+func (s *MemoryStore) ReplaceAgingIssues(as []store.AgingAlert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aging = append([]store.AgingAlert(nil), as...)
+	return nil
+}
+
+// selects returns true if the filter selects the issue.
+func selects(f store.IssueFilter, st store.IssueState) bool {
+	return matches(f.Projects, st.Project) && matches(f.Types, st.Type) && overlaps(f.Tribes, st.Tribes) &&
+		(f.Epic == "" || contains(st.Epics, f.Epic)) &&
+		(f.FixVersion == "" || contains(st.FixVersions, f.FixVersion))
+}
+
+// matches returns true if `ss` is empty or contains the value.
+func matches(ss []string, v *string) bool {
+	return len(ss) == 0 || (v != nil && contains(ss, *v))
+}
+
+// overlaps returns true if `ss` is empty or has a value in common
+// with `vs`.
+func overlaps(ss []string, vs []string) bool {
+	if len(ss) == 0 {
+		return true
+	}
+	for _, v := range vs {
+		if contains(ss, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshFrom returns the day from which a daily table whose last
+// day is `last` is recomputed: the day of `from`, or `last` if it
+// is before.
+func refreshFrom(from time.Time, last time.Time) time.Time {
+	if from = day(from); last.Before(from) {
+		return last
+	}
+	return from
+}
+
+// flowLess orders the metrics by day, project, type and tribe,
+// unknown tribes last.
+func flowLess(a, b store.FlowCount) bool {
+	switch {
+	case !a.Day.Equal(b.Day):
+		return a.Day.Before(b.Day)
+	case a.Project != b.Project:
+		return a.Project < b.Project
+	case a.Type != b.Type:
+		return a.Type < b.Type
+	case a.Tribe == nil || b.Tribe == nil:
+		return b.Tribe == nil && a.Tribe != nil
+	}
+	return *a.Tribe < *b.Tribe
+}
+
+// cfdLess orders the counts by day, project, status and status
+// category, unknown categories last.
+func cfdLess(a, b store.CFDCount) bool {
+	switch {
+	case !a.Day.Equal(b.Day):
+		return a.Day.Before(b.Day)
+	case a.Project != b.Project:
+		return a.Project < b.Project
+	case a.Status != b.Status:
+		return a.Status < b.Status
+	case a.StatusCategory == nil || b.StatusCategory == nil:
+		return b.StatusCategory == nil && a.StatusCategory != nil
+	}
+	return *a.StatusCategory < *b.StatusCategory
+}
+
+// day returns the day of `t` at midnight, in UTC.
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// CreateTables does nothing: the store is ready once created.
+func (s *MemoryStore) CreateTables() {}
+
+// DropTables deletes all the records.
+func (s *MemoryStore) DropTables() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = make(map[string]store.IssueRecords)
+	s.users = make(map[string]store.User)
+	s.statuses = nil
+	s.failures = make(map[string]store.SyncFailure)
+	s.cfd = nil
+	s.flow = nil
+	s.forecasts = nil
+	s.aging = nil
+}
+
+// Records returns the records of the issue (without users), and
+// false if the issue is not in the store.
+func (s *MemoryStore) Records(k string) (store.IssueRecords, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[k]
+	return r, ok
+}
+
+// Keys returns the keys of the issues, sorted.
+func (s *MemoryStore) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ks := make([]string, 0, len(s.records))
+	for k := range s.records {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// Users returns the users, indexed by account ID.
+func (s *MemoryStore) Users() map[string]store.User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	us := make(map[string]store.User, len(s.users))
+	for k, u := range s.users {
+		us[k] = u
+	}
+	return us
+}
+
+// Statuses returns the statuses.
+func (s *MemoryStore) Statuses() []store.Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Status(nil), s.statuses...)
+}
+
+// Forecasts returns the inserted forecasts, in insertion order.
+func (s *MemoryStore) Forecasts() []store.Forecast {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.Forecast(nil), s.forecasts...)
+}
+
+// AgingIssues returns the aging alerts of the last replace.
+func (s *MemoryStore) AgingIssues() []store.AgingAlert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]store.AgingAlert(nil), s.aging...)
+}
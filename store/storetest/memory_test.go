@@ -0,0 +1,36 @@
+package storetest_test
+
+import (
+	"testing"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+	"github.com/rchampourlier/kaizenizer-source-jira/store/storetest"
+)
+
+func TestMemoryStore_contract(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return storetest.NewMemoryStore()
+	})
+}
+
+func TestMemoryStore_UpsertUsers(t *testing.T) {
+	s := storetest.NewMemoryStore()
+	email, name := "alice@example.com", "Alice"
+	must(t, s.UpsertUsers([]store.User{{AccountID: "alice", Email: &email, Active: true}}))
+	must(t, s.UpsertUsers([]store.User{{AccountID: "alice", DisplayName: &name}}))
+
+	u := s.Users()["alice"]
+	if u.Email == nil || *u.Email != email {
+		t.Errorf("expected the email to be kept, got %v", u.Email)
+	}
+	if u.DisplayName == nil || *u.DisplayName != name || u.Active {
+		t.Errorf("expected the user to be updated, got %+v", u)
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,893 @@
+// Package storetest provides a contract test suite for the
+// implementations of `store.Store`, and `MemoryStore`, an in-memory
+// implementation for tests.
+//
+// An implementation runs the suite from its tests with `Run`, passing
+// a function returning an empty store:
+//
+//	func TestPGStore_contract(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store {
+//			s := store.NewPGStore(db)
+//			s.DropTables()
+//			s.CreateTables()
+//			return s
+//		})
+//	}
+package storetest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rchampourlier/kaizenizer-source-jira/store"
+)
+
+// Run runs the contract suite against the stores returned by
+// `newStore`, which must return an empty store with its tables
+// created. Each test of the suite gets a new store.
+//
+// The suite covers the replace semantics of issue records and their
+// idempotency, the ordering of events, the handling of null values,
+// the restart point of incremental syncs, sync failures, the queries
+// used by `verify` and reports, the daily counts of cumulative flow
+// diagrams, the daily flow metrics and throughput and concurrent replaces of
+// different issues.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"ReplaceIssueStateAndEvents", testReplaceIssueStateAndEvents},
+		{"ReplaceIssues", testReplaceIssues},
+		{"Idempotency", testIdempotency},
+		{"EventsOrder", testEventsOrder},
+		{"NullValues", testNullValues},
+		{"GetIssueStateAndEvents_unknown", testGetUnknownIssue},
+		{"GetRestartFromUpdatedAt", testGetRestartFromUpdatedAt},
+		{"SyncFailures", testSyncFailures},
+		{"Queries", testQueries},
+		{"DeleteIssues", testDeleteIssues},
+		{"GetIssueTimelines", testGetIssueTimelines},
+		{"CFDDaily", testCFDDaily},
+		{"FlowDaily", testFlowDaily},
+		{"ThroughputDaily", testThroughputDaily},
+		{"CountUnresolvedIssues", testCountUnresolvedIssues},
+		{"GetIssueFlowEfficiencies", testGetIssueFlowEfficiencies},
+		{"ConcurrentReplaces", testConcurrentReplaces},
+		{"DropTables", testDropTables},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// ref is the reference time of the records of the suite. Times are
+// in UTC and rounded to the second, as any store is expected to keep
+// them.
+var ref = time.Date(2020, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func str(s string) *string { return &s }
+
+// records returns the records of an issue of the project, created
+// at `ref + created` and updated at `ref + updated` (in hours), with
+// all fields set and three events.
+func records(k string, project string, created int, updated int) store.IssueRecords {
+	createdAt := ref.Add(time.Duration(created) * time.Hour)
+	updatedAt := ref.Add(time.Duration(updated) * time.Hour)
+	resolvedAt := updatedAt
+	st := store.IssueState{
+		CreatedAt:             createdAt,
+		UpdatedAt:             updatedAt,
+		Key:                   k,
+		Project:               str(project),
+		Status:                str("Done"),
+		StatusID:              str("10001"),
+		StatusCategory:        str("Done"),
+		ResolvedAt:            &resolvedAt,
+		Priority:              str("Medium"),
+		Summary:               str("Summary of " + k),
+		Description:           str("Description of " + k),
+		Type:                  str("Story"),
+		Labels:                []string{"a", "b"},
+		Reporter:              str("alice"),
+		ReporterName:          str("Alice"),
+		Assignee:              str("bob"),
+		AssigneeName:          str("Bob"),
+		DeveloperBackend:      str("bob"),
+		DeveloperBackendName:  str("Bob"),
+		DeveloperFrontend:     str("carol"),
+		DeveloperFrontendName: str("Carol"),
+		Reviewer:              str("dave"),
+		ReviewerName:          str("Dave"),
+		ProductOwner:          str("erin"),
+		ProductOwnerName:      str("Erin"),
+		BugCauses:             []string{"Regression", "Missing test"},
+		Epics:                 []string{"PJ-0"},
+		Tribes:                []string{"Data"},
+		Components:            []string{"api"},
+		FixVersions:           []string{"1.0", "1.1"},
+	}
+	exited := createdAt.Add(time.Hour)
+	d := time.Hour
+	r := store.IssueRecords{
+		Key:   k,
+		State: st,
+		Events: []store.IssueEvent{
+			{EventTime: createdAt, EventKind: "created", EventAuthor: "alice", EventAuthorName: str("Alice"), IssueKey: k},
+			{EventTime: exited, EventKind: "status_changed", EventAuthor: "bob", EventAuthorName: str("Bob"), IssueKey: k,
+				StatusChangeFrom: str("Open"), StatusChangeTo: str("Done"), StatusChangeFromID: str("1"), StatusChangeToID: str("10001"),
+				StatusChangeFromCategory: str("To Do"), StatusChangeToCategory: str("Done")},
+			{EventTime: updatedAt, EventKind: "comment_added", EventAuthor: "bob", IssueKey: k, CommentBody: str("Comment on " + k)},
+		},
+		StatusPeriods: []store.IssueStatusPeriod{
+			{IssueKey: k, Status: "Open", StatusID: str("1"), StatusCategory: str("To Do"), Activity: str(store.ActivityWaiting),
+				EnteredAt: createdAt, ExitedAt: &exited, Duration: &d},
+			{IssueKey: k, Status: "Done", StatusID: str("10001"), StatusCategory: str("Done"), EnteredAt: exited},
+		},
+		Users: []store.User{
+			{AccountID: "alice", DisplayName: str("Alice"), Active: true},
+			{AccountID: "bob", DisplayName: str("Bob"), Active: true},
+		},
+	}
+	r.State.SetFlowEfficiency(r.StatusPeriods)
+	return r
+}
+
+func replace(t *testing.T, s store.Store, r store.IssueRecords) {
+	t.Helper()
+	if err := s.ReplaceIssueStateAndEvents(r.Key, r.State, r.Events, r.StatusPeriods); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssueStateAndEvents`: %s", err)
+	}
+}
+
+// assertStored checks the state and events stored for the issue are
+// those of the records.
+func assertStored(t *testing.T, s store.Store, r store.IssueRecords) {
+	t.Helper()
+	is, ies, err := s.GetIssueStateAndEvents(r.Key)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueStateAndEvents`: %s", err)
+	}
+	if is == nil {
+		t.Fatalf("expected `%s` to be stored", r.Key)
+	}
+	if got, want := normalizeState(*is), normalizeState(r.State); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected state for `%s`:\n got  %+v\n want %+v", r.Key, got, want)
+	}
+	want := append([]store.IssueEvent(nil), r.Events...)
+	sort.Stable(store.IssueEventsByTime(want))
+	if len(ies) != len(want) {
+		t.Fatalf("expected %d events for `%s`, got %d: %v", len(want), r.Key, len(ies), ies)
+	}
+	for n := range ies {
+		if got, want := normalizeEvent(ies[n]), normalizeEvent(want[n]); !reflect.DeepEqual(got, want) {
+			t.Errorf("unexpected event %d for `%s`:\n got  %+v\n want %+v", n, r.Key, got, want)
+		}
+	}
+}
+
+// normalizeState returns the state with its times in UTC and its
+// empty arrays nil, stores being free to return nil or empty arrays.
+func normalizeState(is store.IssueState) store.IssueState {
+	is.CreatedAt = is.CreatedAt.UTC()
+	is.UpdatedAt = is.UpdatedAt.UTC()
+	if is.ResolvedAt != nil {
+		t := is.ResolvedAt.UTC()
+		is.ResolvedAt = &t
+	}
+	for _, a := range []*[]string{&is.Labels, &is.Components, &is.FixVersions} {
+		if len(*a) == 0 {
+			*a = nil
+		}
+	}
+	return is
+}
+
+func normalizeEvent(ie store.IssueEvent) store.IssueEvent {
+	ie.EventTime = ie.EventTime.UTC()
+	return ie
+}
+
+func testReplaceIssueStateAndEvents(t *testing.T, s store.Store) {
+	r := records("PJ-1", "PJ", 0, 2)
+	other := records("PJ-2", "PJ", 0, 3)
+	replace(t, s, r)
+	replace(t, s, other)
+	assertStored(t, s, r)
+
+	// Replacing with fewer events and a changed state
+	r2 := records("PJ-1", "PJ", 0, 5)
+	r2.State.Status = str("In Progress")
+	r2.State.ResolvedAt = nil
+	r2.Events = r2.Events[:1]
+	r2.StatusPeriods = r2.StatusPeriods[:1]
+	replace(t, s, r2)
+	assertStored(t, s, r2)
+	assertStored(t, s, other)
+}
+
+func testReplaceIssues(t *testing.T, s store.Store) {
+	rs := []store.IssueRecords{records("PJ-1", "PJ", 0, 2), records("PJ-2", "PJ", 1, 3)}
+	if err := s.ReplaceIssues(rs); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+	for _, r := range rs {
+		assertStored(t, s, r)
+	}
+
+	r := records("PJ-2", "PJ", 1, 4)
+	r.Events = nil
+	if err := s.ReplaceIssues([]store.IssueRecords{r}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+	assertStored(t, s, rs[0])
+	assertStored(t, s, r)
+
+	// Issues in the same batch (the last records win)
+	last := records("PJ-3", "PJ", 2, 6)
+	if err := s.ReplaceIssues([]store.IssueRecords{records("PJ-3", "PJ", 2, 5), last}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+	assertStored(t, s, last)
+}
+
+func testIdempotency(t *testing.T, s store.Store) {
+	r := records("PJ-1", "PJ", 0, 2)
+	for n := 0; n < 3; n++ {
+		replace(t, s, r)
+		if err := s.ReplaceIssues([]store.IssueRecords{r}); err != nil {
+			t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+		}
+		if err := s.UpsertUsers(r.Users); err != nil {
+			t.Fatalf("unexpected error in `UpsertUsers`: %s", err)
+		}
+		if err := s.ReplaceStatuses([]store.Status{{ID: "1", Name: "Open", Category: "To Do"}}); err != nil {
+			t.Fatalf("unexpected error in `ReplaceStatuses`: %s", err)
+		}
+	}
+	assertStored(t, s, r)
+	ks, err := s.SampleIssueKeys(10)
+	if err != nil {
+		t.Fatalf("unexpected error in `SampleIssueKeys`: %s", err)
+	}
+	if len(ks) != 1 {
+		t.Errorf("expected a single issue, got %v", ks)
+	}
+}
+
+func testEventsOrder(t *testing.T, s store.Store) {
+	r := records("PJ-1", "PJ", 0, 10)
+	t0 := ref.Add(time.Hour)
+	r.Events = []store.IssueEvent{
+		{EventTime: t0.Add(2 * time.Hour), EventKind: "comment_added", EventAuthor: "alice", IssueKey: "PJ-1", CommentBody: str("third")},
+		{EventTime: t0, EventKind: "comment_added", EventAuthor: "alice", IssueKey: "PJ-1", CommentBody: str("first")},
+		{EventTime: t0.Add(time.Hour), EventKind: "comment_added", EventAuthor: "alice", IssueKey: "PJ-1", CommentBody: str("second (a)")},
+		{EventTime: t0.Add(time.Hour), EventKind: "comment_added", EventAuthor: "alice", IssueKey: "PJ-1", CommentBody: str("second (b)")},
+	}
+	replace(t, s, r)
+	_, ies, err := s.GetIssueStateAndEvents("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueStateAndEvents`: %s", err)
+	}
+	var bodies []string
+	for _, ie := range ies {
+		bodies = append(bodies, *ie.CommentBody)
+	}
+	if want := []string{"first", "second (a)", "second (b)", "third"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("expected events sorted by time then insertion %v, got %v", want, bodies)
+	}
+}
+
+func testNullValues(t *testing.T, s store.Store) {
+	// Only the fields required by all stores are set: issues may
+	// have no status or priority (e.g. when the priority field is
+	// disabled).
+	r := store.IssueRecords{
+		Key: "PJ-1",
+		State: store.IssueState{
+			CreatedAt:  ref,
+			UpdatedAt:  ref,
+			Key:        "PJ-1",
+			Project:    str("PJ"),
+			Summary:    str(""),
+			Type:       str("Bug"),
+			Components: []string{},
+		},
+		Events: []store.IssueEvent{
+			{EventTime: ref, EventKind: "created", EventAuthor: "", IssueKey: "PJ-1"},
+		},
+		StatusPeriods: []store.IssueStatusPeriod{
+			{IssueKey: "PJ-1", Status: "Open", EnteredAt: ref},
+		},
+	}
+	replace(t, s, r)
+	assertStored(t, s, r)
+
+	is, ies, _ := s.GetIssueStateAndEvents("PJ-1")
+	if is.ResolvedAt != nil || is.Assignee != nil || is.Description != nil || is.Epics != nil || is.Status != nil || is.Priority != nil {
+		t.Errorf("expected unset fields to be nil, got %+v", is)
+	}
+	if *is.Summary != "" {
+		t.Errorf("expected empty summary to be kept as empty, got `%s`", *is.Summary)
+	}
+	if ies[0].CommentBody != nil || ies[0].StatusChangeFrom != nil || ies[0].EventAuthorName != nil {
+		t.Errorf("expected unset event fields to be nil, got %+v", ies[0])
+	}
+
+	u := store.User{AccountID: "alice", Active: true}
+	if err := s.UpsertUsers([]store.User{u}); err != nil {
+		t.Fatalf("unexpected error in `UpsertUsers`: %s", err)
+	}
+}
+
+func testGetUnknownIssue(t *testing.T, s store.Store) {
+	is, ies, err := s.GetIssueStateAndEvents("PJ-404")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueStateAndEvents`: %s", err)
+	}
+	if is != nil || len(ies) != 0 {
+		t.Errorf("expected no state and events for an unknown issue, got %v %v", is, ies)
+	}
+}
+
+func testGetRestartFromUpdatedAt(t *testing.T, s store.Store) {
+	if r := s.GetRestartFromUpdatedAt(3); r == nil || !r.IsZero() {
+		t.Errorf("expected the zero time for an empty store, got %v", r)
+	}
+	for n := 1; n <= 5; n++ {
+		replace(t, s, records(fmt.Sprintf("PJ-%d", n), "PJ", 0, n))
+	}
+	for _, tt := range []struct {
+		n       int
+		updated int
+	}{
+		{1, 5},
+		{3, 3},
+		{5, 1},
+		{10, 1},
+	} {
+		want := ref.Add(time.Duration(tt.updated) * time.Hour)
+		if r := s.GetRestartFromUpdatedAt(tt.n); r == nil || !r.Equal(want) {
+			t.Errorf("expected restart from `%s` for n=%d, got %v", want, tt.n, r)
+		}
+	}
+
+	// The restart point follows replaced issues.
+	replace(t, s, records("PJ-1", "PJ", 0, 8))
+	if r := s.GetRestartFromUpdatedAt(1); !r.Equal(ref.Add(8 * time.Hour)) {
+		t.Errorf("expected restart from the replaced issue, got %v", r)
+	}
+}
+
+func testSyncFailures(t *testing.T, s store.Store) {
+	fs, err := s.GetSyncFailures()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetSyncFailures`: %s", err)
+	}
+	if len(fs) != 0 {
+		t.Fatalf("expected no failures, got %v", fs)
+	}
+
+	payloadRef := str("rest/api/2/issue/PJ-2")
+	for _, f := range []store.SyncFailure{
+		{IssueKey: "PJ-2", Stage: store.SyncStageFetch, Error: "timeout", RunID: "run-1", FailedAt: ref},
+		{IssueKey: "PJ-1", Stage: store.SyncStageMap, Error: "invalid", RunID: "run-1", FailedAt: ref},
+		{IssueKey: "PJ-2", Stage: store.SyncStageStore, Error: "conflict", PayloadRef: payloadRef, RunID: "run-2", FailedAt: ref.Add(time.Hour)},
+	} {
+		if err := s.UpsertSyncFailure(f); err != nil {
+			t.Fatalf("unexpected error in `UpsertSyncFailure`: %s", err)
+		}
+	}
+	fs, err = s.GetSyncFailures()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetSyncFailures`: %s", err)
+	}
+	if len(fs) != 2 || fs[0].IssueKey != "PJ-1" || fs[1].IssueKey != "PJ-2" {
+		t.Fatalf("expected failures of PJ-1 and PJ-2 sorted by key, got %v", fs)
+	}
+	if f := fs[0]; f.Attempts != 1 || f.Stage != store.SyncStageMap || f.PayloadRef != nil {
+		t.Errorf("unexpected failure %+v", f)
+	}
+	if f := fs[1]; f.Attempts != 2 || f.Stage != store.SyncStageStore || f.Error != "conflict" || f.RunID != "run-2" ||
+		f.PayloadRef == nil || *f.PayloadRef != *payloadRef || !f.FailedAt.Equal(ref.Add(time.Hour)) {
+		t.Errorf("expected the last failure with 2 attempts, got %+v", f)
+	}
+
+	// Replacing the issue's records deletes its failure.
+	replace(t, s, records("PJ-1", "PJ", 0, 1))
+	if err := s.ReplaceIssues([]store.IssueRecords{records("PJ-2", "PJ", 0, 1)}); err != nil {
+		t.Fatalf("unexpected error in `ReplaceIssues`: %s", err)
+	}
+	fs, _ = s.GetSyncFailures()
+	if len(fs) != 0 {
+		t.Errorf("expected failures to be deleted by replaces, got %v", fs)
+	}
+}
+
+func testQueries(t *testing.T, s store.Store) {
+	march := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, r := range []store.IssueRecords{
+		records("PJ-1", "PJ", 0, 1),
+		records("PJ-2", "PJ", 24, 25),
+		records("PJ-3", "PJ", 24*31, 24*31+1), // April
+		records("OT-1", "OT", 0, 2),
+	} {
+		replace(t, s, r)
+	}
+
+	us, err := s.GetIssuesUpdatedAt("PJ", march, april)
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssuesUpdatedAt`: %s", err)
+	}
+	if len(us) != 2 || !us["PJ-1"].Equal(ref.Add(time.Hour)) || !us["PJ-2"].Equal(ref.Add(25*time.Hour)) {
+		t.Errorf("unexpected issues updated at %v", us)
+	}
+
+	cs, err := s.CountIssuesByProjectAndMonth()
+	if err != nil {
+		t.Fatalf("unexpected error in `CountIssuesByProjectAndMonth`: %s", err)
+	}
+	want := []store.IssueCount{{Project: "OT", Month: march, Count: 1}, {Project: "PJ", Month: march, Count: 2}, {Project: "PJ", Month: april, Count: 1}}
+	if len(cs) != len(want) {
+		t.Fatalf("expected counts %v, got %v", want, cs)
+	}
+	for n := range cs {
+		if cs[n].Project != want[n].Project || !cs[n].Month.Equal(want[n].Month) || cs[n].Count != want[n].Count {
+			t.Errorf("expected count %v, got %v", want[n], cs[n])
+		}
+	}
+
+	ks, err := s.SampleIssueKeys(2)
+	if err != nil {
+		t.Fatalf("unexpected error in `SampleIssueKeys`: %s", err)
+	}
+	seen := make(map[string]bool)
+	for _, k := range ks {
+		if seen[k] || (k != "PJ-1" && k != "PJ-2" && k != "PJ-3" && k != "OT-1") {
+			t.Errorf("unexpected sampled keys %v", ks)
+		}
+		seen[k] = true
+	}
+	if len(ks) != 2 {
+		t.Errorf("expected 2 sampled keys, got %v", ks)
+	}
+}
+
+func testDeleteIssues(t *testing.T, s store.Store) {
+	for _, r := range []store.IssueRecords{
+		records("PJ-2", "PJ", 0, 1),
+		records("PJ-1", "PJ", 0, 2),
+		records("OT-1", "OT", 0, 3),
+	} {
+		replace(t, s, r)
+	}
+	f := store.SyncFailure{IssueKey: "PJ-2", Stage: store.SyncStageFetch, Error: "timeout", RunID: "run-1", FailedAt: ref}
+	if err := s.UpsertSyncFailure(f); err != nil {
+		t.Fatalf("unexpected error in `UpsertSyncFailure`: %s", err)
+	}
+
+	if err := s.DeleteIssues([]string{"PJ-2", "PJ-404"}); err != nil {
+		t.Fatalf("unexpected error in `DeleteIssues`: %s", err)
+	}
+	for _, k := range []string{"PJ-1", "OT-1"} {
+		if is, _, _ := s.GetIssueStateAndEvents(k); is == nil {
+			t.Errorf("expected issue `%s` to be kept", k)
+		}
+	}
+	if is, ies, _ := s.GetIssueStateAndEvents("PJ-2"); is != nil || len(ies) != 0 {
+		t.Errorf("expected no state and events for a deleted issue, got %v %v", is, ies)
+	}
+	if fs, _ := s.GetSyncFailures(); len(fs) != 0 {
+		t.Errorf("expected the failure of the deleted issue to be deleted, got %v", fs)
+	}
+}
+
+func testGetIssueTimelines(t *testing.T, s store.Store) {
+	r := records("PJ-2", "PJ", 0, 5)
+	r.Events = append(r.Events, store.IssueEvent{EventTime: ref, EventKind: "status_changed", EventAuthor: "alice", IssueKey: "PJ-2",
+		StatusChangeTo: str("Open"), StatusChangeToID: str("1"), StatusChangeToCategory: str("To Do")})
+	replace(t, s, r)
+	open := records("PJ-1", "PJ", 1, 1)
+	open.State.ResolvedAt = nil
+	open.State.Type = str("Bug")
+	open.Events = []store.IssueEvent{
+		{EventTime: ref.Add(time.Hour), EventKind: "created", EventAuthor: "alice", IssueKey: "PJ-1"},
+		{EventTime: ref.Add(time.Hour), EventKind: "status_changed", EventAuthor: "alice", IssueKey: "PJ-1", StatusChangeTo: str("Unknown")},
+	}
+	replace(t, s, open)
+
+	ts, err := s.GetIssueTimelines()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueTimelines`: %s", err)
+	}
+	if len(ts) != 2 || ts[0].Key != "PJ-1" || ts[1].Key != "PJ-2" {
+		t.Fatalf("expected the timelines of PJ-1 and PJ-2 sorted by key, got %+v", ts)
+	}
+
+	pj1 := ts[0]
+	if pj1.Project != "PJ" || pj1.Type != "Bug" || !pj1.CreatedAt.Equal(ref.Add(time.Hour)) || pj1.ResolvedAt != nil {
+		t.Errorf("unexpected timeline %+v", pj1)
+	}
+	if len(pj1.Statuses) != 1 || pj1.Statuses[0].Status != "Unknown" || pj1.Statuses[0].Category != nil {
+		t.Errorf("expected a status without category, got %+v", pj1.Statuses)
+	}
+
+	pj2 := ts[1]
+	if pj2.Type != "Story" || pj2.Summary != "Summary of PJ-2" || pj2.Assignee == nil || *pj2.Assignee != "bob" ||
+		!pj2.UpdatedAt.Equal(ref.Add(5*time.Hour)) || pj2.ResolvedAt == nil || !pj2.ResolvedAt.Equal(ref.Add(5*time.Hour)) {
+		t.Errorf("unexpected timeline %+v", pj2)
+	}
+	if len(pj2.Statuses) != 2 {
+		t.Fatalf("expected 2 statuses, got %+v", pj2.Statuses)
+	}
+	for n, want := range []struct {
+		status   string
+		category string
+		at       time.Time
+	}{
+		{"Open", "To Do", ref},
+		{"Done", "Done", ref.Add(time.Hour)},
+	} {
+		e := pj2.Statuses[n]
+		if e.Status != want.status || e.Category == nil || *e.Category != want.category || !e.Time.Equal(want.at) {
+			t.Errorf("expected status %d `%s` (%s) at %s, got %+v", n, want.status, want.category, want.at, e)
+		}
+	}
+}
+
+// statusRecords returns the records of an issue created at `ref`
+// going through the statuses, specified as (day, status, category)
+// triplets, an empty category being unknown.
+func statusRecords(k string, project string, changes ...interface{}) store.IssueRecords {
+	r := records(k, project, 0, 0)
+	r.Events = r.Events[:1]
+	for n := 0; n < len(changes); n += 3 {
+		ie := store.IssueEvent{EventTime: ref.AddDate(0, 0, changes[n].(int)), EventKind: "status_changed", EventAuthor: "alice", IssueKey: k,
+			StatusChangeTo: str(changes[n+1].(string))}
+		if c := changes[n+2].(string); c != "" {
+			ie.StatusChangeToCategory = str(c)
+		}
+		r.Events = append(r.Events, ie)
+	}
+	return r
+}
+
+func testCFDDaily(t *testing.T, s store.Store) {
+	replace(t, s, statusRecords("PJ-1", "PJ", 0, "Open", "To Do", 1, "Doing", "In Progress", 3, "Closed", "Done"))
+	// Entering and exiting a status on the same day doesn't count.
+	replace(t, s, statusRecords("PJ-2", "PJ", 1, "Open", "To Do", 1, "Closed", "Done"))
+	replace(t, s, statusRecords("OT-1", "OT", 2, "Triage", ""))
+
+	day := func(n int) time.Time { return time.Date(2020, 3, 1+n, 0, 0, 0, 0, time.UTC) }
+	type count struct {
+		day      int
+		project  string
+		status   string
+		category string
+		count    int
+	}
+	check := func(from int, to int, expected []count) {
+		t.Helper()
+		cs, err := s.GetCFDDaily(day(from), day(to).Add(12*time.Hour))
+		if err != nil {
+			t.Fatalf("unexpected error in `GetCFDDaily`: %s", err)
+		}
+		var got []count
+		for _, c := range cs {
+			cat := ""
+			if c.StatusCategory != nil {
+				cat = *c.StatusCategory
+			}
+			got = append(got, count{int(c.Day.Sub(day(0)).Hours() / 24), c.Project, c.Status, cat, c.Count})
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected counts\n%v\nexpected\n%v", got, expected)
+		}
+	}
+
+	if err := s.RefreshCFDDaily(time.Time{}, day(4).Add(20*time.Hour)); err != nil {
+		t.Fatalf("unexpected error in `RefreshCFDDaily`: %s", err)
+	}
+	check(0, 10, []count{
+		{0, "PJ", "Open", "To Do", 1},
+		{1, "PJ", "Closed", "Done", 1},
+		{1, "PJ", "Doing", "In Progress", 1},
+		{2, "OT", "Triage", "", 1},
+		{2, "PJ", "Closed", "Done", 1},
+		{2, "PJ", "Doing", "In Progress", 1},
+		{3, "OT", "Triage", "", 1},
+		{3, "PJ", "Closed", "Done", 2},
+		{4, "OT", "Triage", "", 1},
+		{4, "PJ", "Closed", "Done", 2},
+	})
+
+	// An incremental refresh recomputes the days from `from`, and
+	// the days following the last refreshed day.
+	replace(t, s, statusRecords("PJ-1", "PJ", 0, "Open", "To Do", 1, "Doing", "In Progress", 5, "Closed", "Done"))
+	replace(t, s, statusRecords("PJ-3", "PJ", 0, "Open", "To Do"))
+	if err := s.RefreshCFDDaily(day(5).Add(time.Hour), day(6)); err != nil {
+		t.Fatalf("unexpected error in `RefreshCFDDaily`: %s", err)
+	}
+	check(3, 6, []count{
+		{3, "OT", "Triage", "", 1},
+		{3, "PJ", "Closed", "Done", 2},
+		{4, "OT", "Triage", "", 1},
+		{4, "PJ", "Closed", "Done", 1},
+		{4, "PJ", "Doing", "In Progress", 1},
+		{4, "PJ", "Open", "To Do", 1},
+		{5, "OT", "Triage", "", 1},
+		{5, "PJ", "Closed", "Done", 2},
+		{5, "PJ", "Open", "To Do", 1},
+		{6, "OT", "Triage", "", 1},
+		{6, "PJ", "Closed", "Done", 2},
+		{6, "PJ", "Open", "To Do", 1},
+	})
+}
+
+func testFlowDaily(t *testing.T, s store.Store) {
+	at := func(n int) *time.Time {
+		d := ref.AddDate(0, 0, n)
+		return &d
+	}
+	issue := func(k string, issueType string, tribes []string, created int, resolved *time.Time, changes ...interface{}) store.IssueRecords {
+		r := statusRecords(k, "PJ", changes...)
+		r.State.Type, r.State.Tribes = str(issueType), tribes
+		r.State.CreatedAt, r.State.ResolvedAt = *at(created), resolved
+		return r
+	}
+	replace(t, s, issue("PJ-1", "Story", []string{"Data"}, 0, at(4),
+		0, "Open", "To Do", 1, "Doing", "In Progress", 2, "Review", "In Progress", 4, "Closed", "Done"))
+	replace(t, s, issue("PJ-2", "Story", []string{"Data"}, 1, nil,
+		1, "Open", "To Do", 2, "Doing", "In Progress"))
+	replace(t, s, issue("PJ-3", "Bug", nil, 2, at(2),
+		2, "Open", "To Do", 2, "Closed", "Done"))
+	// Counted in the slice of each of its tribes
+	replace(t, s, issue("PJ-4", "Bug", []string{"Growth", "Data"}, 3, nil,
+		3, "Open", "To Do"))
+
+	day := func(n int) time.Time { return time.Date(2020, 3, 1+n, 0, 0, 0, 0, time.UTC) }
+	type metrics struct {
+		day        int
+		issueType  string
+		tribe      string
+		arrivals   int
+		throughput int
+		wip        int
+		age        float64
+	}
+	check := func(from int, to int, expected []metrics) {
+		t.Helper()
+		fs, err := s.GetFlowDaily(day(from), day(to))
+		if err != nil {
+			t.Fatalf("unexpected error in `GetFlowDaily`: %s", err)
+		}
+		var got []metrics
+		for _, f := range fs {
+			m := metrics{int(f.Day.Sub(day(0)).Hours() / 24), f.Type, "", f.Arrivals, f.Throughput, f.WIP, -1}
+			if f.Tribe != nil {
+				m.tribe = *f.Tribe
+			}
+			if f.WIPAverageAge != nil {
+				m.age = *f.WIPAverageAge
+			}
+			if f.Project != "PJ" || (f.WIP > 0) != (f.WIPAverageAge != nil) {
+				t.Errorf("unexpected metrics %+v", f)
+			}
+			got = append(got, m)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("unexpected metrics\n%v\nexpected\n%v", got, expected)
+		}
+	}
+
+	// Ages are counted at the end of the day, from the first entry
+	// in progress (at 09:00).
+	if err := s.RefreshFlowDaily(time.Time{}, day(5).Add(20*time.Hour)); err != nil {
+		t.Fatalf("unexpected error in `RefreshFlowDaily`: %s", err)
+	}
+	check(0, 10, []metrics{
+		{0, "Story", "Data", 1, 0, 0, -1},
+		{1, "Story", "Data", 1, 0, 1, 0.625},
+		{2, "Bug", "", 1, 1, 0, -1},
+		{2, "Story", "Data", 0, 0, 2, 1.125},
+		{3, "Bug", "Data", 1, 0, 0, -1},
+		{3, "Bug", "Growth", 1, 0, 0, -1},
+		{3, "Story", "Data", 0, 0, 2, 2.125},
+		{4, "Story", "Data", 0, 1, 1, 2.625},
+		{5, "Story", "Data", 0, 0, 1, 3.625},
+	})
+
+	replace(t, s, issue("PJ-2", "Story", []string{"Data"}, 1, at(5),
+		1, "Open", "To Do", 2, "Doing", "In Progress", 5, "Closed", "Done"))
+	if err := s.RefreshFlowDaily(day(6), day(6)); err != nil {
+		t.Fatalf("unexpected error in `RefreshFlowDaily`: %s", err)
+	}
+	check(4, 6, []metrics{
+		{4, "Story", "Data", 0, 1, 1, 2.625},
+		{5, "Story", "Data", 0, 1, 0, -1},
+	})
+}
+
+func testThroughputDaily(t *testing.T, s store.Store) {
+	// Resolved at `ref + resolved` (in days), unresolved if negative
+	issue := func(k string, project string, issueType string, tribes []string, resolved int) store.IssueRecords {
+		r := records(k, project, 0, 1)
+		r.State.Type, r.State.Tribes = str(issueType), tribes
+		r.State.ResolvedAt = nil
+		if resolved >= 0 {
+			d := ref.AddDate(0, 0, resolved)
+			r.State.ResolvedAt = &d
+		}
+		return r
+	}
+	// Counted once, whatever its number of tribes
+	replace(t, s, issue("PJ-1", "PJ", "Story", []string{"Growth", "Data"}, 1))
+	replace(t, s, issue("PJ-2", "PJ", "Bug", nil, 1))
+	replace(t, s, issue("PJ-3", "PJ", "Story", []string{"Data"}, 3))
+	replace(t, s, issue("PJ-4", "PJ", "Story", []string{"Data"}, -1))
+	replace(t, s, issue("OT-1", "OT", "Story", []string{"Growth"}, 5))
+
+	day := func(n int) time.Time { return time.Date(2020, 3, 1+n, 0, 0, 0, 0, time.UTC) }
+	for _, tc := range []struct {
+		name     string
+		from, to int
+		filter   store.IssueFilter
+		expected map[int]int
+	}{
+		{"all", 0, 10, store.IssueFilter{}, map[int]int{1: 2, 3: 1, 5: 1}},
+		{"days", 2, 4, store.IssueFilter{}, map[int]int{3: 1}},
+		{"projects", 0, 10, store.IssueFilter{Projects: []string{"PJ"}}, map[int]int{1: 2, 3: 1}},
+		{"types", 0, 10, store.IssueFilter{Types: []string{"Bug"}}, map[int]int{1: 1}},
+		{"tribes", 0, 10, store.IssueFilter{Tribes: []string{"Data", "Growth"}}, map[int]int{1: 1, 3: 1, 5: 1}},
+		{"none", 0, 10, store.IssueFilter{Projects: []string{"XX"}}, map[int]int{}},
+	} {
+		ts, err := s.GetThroughputDaily(day(tc.from), day(tc.to), tc.filter)
+		if err != nil {
+			t.Fatalf("unexpected error in `GetThroughputDaily`: %s", err)
+		}
+		got := make(map[int]int)
+		for n, c := range ts {
+			if n > 0 && !ts[n-1].Day.Before(c.Day) {
+				t.Errorf("%s: unexpected order of %v", tc.name, ts)
+			}
+			got[int(c.Day.Sub(day(0)).Hours()/24)] = c.Throughput
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%s: expected throughput %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func testCountUnresolvedIssues(t *testing.T, s store.Store) {
+	// Resolved
+	replace(t, s, records("PJ-1", "PJ", 0, 1))
+	for _, r := range []store.IssueRecords{
+		records("PJ-2", "PJ", 0, 1),
+		records("PJ-3", "PJ", 0, 1),
+		records("OT-1", "OT", 0, 1),
+	} {
+		r.State.ResolvedAt = nil
+		if r.Key == "OT-1" {
+			r.State.Tribes = []string{"Growth", "Data"}
+		}
+		if r.Key == "PJ-3" {
+			r.State.Type = str("Bug")
+			r.State.Epics = nil
+			r.State.Tribes = nil
+			r.State.FixVersions = []string{"2.0"}
+		}
+		replace(t, s, r)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		filter store.IssueFilter
+		count  int
+	}{
+		{"all", store.IssueFilter{}, 3},
+		{"projects", store.IssueFilter{Projects: []string{"PJ", "XX"}}, 2},
+		{"types", store.IssueFilter{Projects: []string{"PJ"}, Types: []string{"Bug"}}, 1},
+		{"tribes", store.IssueFilter{Tribes: []string{"Data"}}, 2},
+		{"any tribe", store.IssueFilter{Tribes: []string{"Growth", "XX"}}, 1},
+		{"epic", store.IssueFilter{Epic: "PJ-0"}, 2},
+		{"fix version", store.IssueFilter{FixVersion: "1.1"}, 2},
+		{"none", store.IssueFilter{Projects: []string{"OT"}, FixVersion: "2.0"}, 0},
+	} {
+		n, err := s.CountUnresolvedIssues(tc.filter)
+		if err != nil {
+			t.Fatalf("unexpected error in `CountUnresolvedIssues`: %s", err)
+		}
+		if n != tc.count {
+			t.Errorf("%s: expected %d unresolved issues, got %d", tc.name, tc.count, n)
+		}
+	}
+}
+
+func testGetIssueFlowEfficiencies(t *testing.T, s store.Store) {
+	// Waiting for 1 hour (see `records`)
+	replace(t, s, records("PJ-2", "PJ", 0, 2))
+	r := records("PJ-1", "PJ", 0, 5)
+	active, waiting, efficiency := 3*time.Hour, time.Hour, 0.75
+	r.State.ActiveTime, r.State.WaitingTime, r.State.FlowEfficiency = &active, &waiting, &efficiency
+	replace(t, s, r)
+	assertStored(t, s, r)
+	// Not resolved
+	unresolved := records("PJ-3", "PJ", 0, 1)
+	unresolved.State.ResolvedAt = nil
+	replace(t, s, unresolved)
+	// Without efficiency
+	none := records("PJ-4", "PJ", 0, 1)
+	none.State.ActiveTime, none.State.WaitingTime, none.State.FlowEfficiency = nil, nil, nil
+	replace(t, s, none)
+
+	fs, err := s.GetIssueFlowEfficiencies()
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueFlowEfficiencies`: %s", err)
+	}
+	if len(fs) != 2 || fs[0].Key != "PJ-1" || fs[1].Key != "PJ-2" {
+		t.Fatalf("expected the efficiencies of PJ-1 and PJ-2, got %+v", fs)
+	}
+	f := fs[0]
+	if f.Project != "PJ" || f.Type != "Story" || !reflect.DeepEqual(f.Tribes, []string{"Data"}) || !f.ResolvedAt.Equal(ref.Add(5*time.Hour)) {
+		t.Errorf("unexpected issue %+v", f)
+	}
+	if f.ActiveTime != active || f.WaitingTime != waiting || f.FlowEfficiency != efficiency {
+		t.Errorf("unexpected efficiency %+v", f)
+	}
+	if f := fs[1]; f.ActiveTime != 0 || f.WaitingTime != time.Hour || f.FlowEfficiency != 0 {
+		t.Errorf("unexpected efficiency %+v", f)
+	}
+}
+
+func testConcurrentReplaces(t *testing.T, s store.Store) {
+	const workers, keys = 8, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*keys*2)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for n := 0; n < keys; n++ {
+				// Each key is replaced twice, the second time with
+				// `ReplaceIssues`.
+				r := records(fmt.Sprintf("W%d-%d", w, n), "PJ", 0, n)
+				errs <- s.ReplaceIssueStateAndEvents(r.Key, r.State, r.Events, r.StatusPeriods)
+				r = records(r.Key, "PJ", 0, n+1)
+				errs <- s.ReplaceIssues([]store.IssueRecords{r})
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unexpected error in concurrent replace: %s", err)
+		}
+	}
+	for w := 0; w < workers; w++ {
+		for n := 0; n < keys; n++ {
+			assertStored(t, s, records(fmt.Sprintf("W%d-%d", w, n), "PJ", 0, n+1))
+		}
+	}
+}
+
+func testDropTables(t *testing.T, s store.Store) {
+	replace(t, s, records("PJ-1", "PJ", 0, 1))
+	s.DropTables()
+	s.CreateTables()
+	is, _, err := s.GetIssueStateAndEvents("PJ-1")
+	if err != nil {
+		t.Fatalf("unexpected error in `GetIssueStateAndEvents`: %s", err)
+	}
+	if is != nil {
+		t.Errorf("expected no issue after `DropTables`, got %+v", is)
+	}
+}